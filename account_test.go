@@ -12,6 +12,7 @@ import (
 	"github.com/google/go-cmp/cmp"
 	"github.com/google/go-cmp/cmp/cmpopts"
 	"github.com/google/uuid"
+	"github.com/nguyenanhhao221/go-jwt/internal/auth"
 	"github.com/nguyenanhhao221/go-jwt/settings"
 )
 
@@ -25,15 +26,16 @@ func TestAccountCI(t *testing.T) {
 	if err != nil {
 		t.Fatal(err)
 	}
-	server := &APIServer{store: store}
+	server := NewAPIServer("", store)
 	var createAccountResponse struct {
 		ID uuid.UUID `json:"id"`
 	}
+	var createdNumber int64
 	mockUser := &CreateAccountRequest{
 		FirstName: "Test User First Name",
 		LastName:  "Test User Last Name",
 		Email:     "Test@email.com",
-		Password:  "TestPassword",
+		Password:  "Correct-Horse-42",
 	}
 
 	t.Run("CreateAccount", func(t *testing.T) {
@@ -99,18 +101,22 @@ func TestAccountCI(t *testing.T) {
 			ID:        accountId,
 			FirstName: mockUser.FirstName,
 			LastName:  mockUser.LastName,
+			Email:     mockUser.Email,
 			Number:    responseUser.Number,
 			Balance:   0,
+			Role:      auth.RoleCustomer,
 		}
 
 		if cmp.Equal(expectCreatedUser, responseUser, cmpopts.IgnoreFields(Account{}, "CreatedAt")) == false {
 			t.Errorf("expected create user %v but got %v", expectCreatedUser, responseUser)
 		}
+		createdNumber = responseUser.Number
 	})
 	t.Run("UpdateTestAccount", func(t *testing.T) {
 		accountId := createAccountResponse.ID
-		mockUpdateAccount := Account{FirstName: "Update Test First Name", LastName: "Update Test Last Name", ID: accountId, Number: 0, Balance: 0}
-		reqBodyJSON, err := json.Marshal(mockUpdateAccount)
+		// The owner can't change the balance and the number, only an admin can
+		mockUpdateAccount := Account{FirstName: "Update Test First Name", LastName: "Update Test Last Name", Email: mockUser.Email, ID: accountId, Number: createdNumber, Balance: 0, Role: auth.RoleCustomer}
+		reqBodyJSON, err := json.Marshal(Account{FirstName: mockUpdateAccount.FirstName, LastName: mockUpdateAccount.LastName, Number: createdNumber + 1, Balance: 1000000})
 		if err != nil {
 			t.Fatal(err)
 		}
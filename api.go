@@ -1,10 +1,14 @@
 package main
 
 import (
+	"database/sql"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
+	"strings"
+	"time"
 
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/chi/v5/middleware"
@@ -12,33 +16,79 @@ import (
 	"github.com/go-playground/validator/v10"
 	"github.com/google/uuid"
 	"github.com/nguyenanhhao221/go-jwt/internal/auth"
+	"github.com/nguyenanhhao221/go-jwt/internal/mail"
+	"github.com/nguyenanhhao221/go-jwt/internal/notify"
+	"github.com/nguyenanhhao221/go-jwt/internal/password"
 	"github.com/nguyenanhhao221/go-jwt/settings"
 	"github.com/nguyenanhhao221/go-jwt/util"
 )
 
 type APIServer struct {
-	listenAdd string
-	store     Storage
+	listenAdd   string
+	store       Storage
+	revocations *RevocationList
+	mailer      mail.Sender
+	// notifier Tell accounts about their activity, e.g. suspicious sign ins
+	notifier notify.Notifier
+	// passwordPolicy The rules new passwords must follow
+	passwordPolicy password.Policy
+	// hashing Bound the concurrent password hashes, as they are slow on purpose
+	hashing *password.Pool
+	// dpopProofs The DPoP proofs sent recently with access tokens, so they can't be replayed
+	dpopProofs *dpopReplayCache
+	// dpopIssuanceProofs The DPoP proofs sent recently to the endpoints issuing tokens
+	dpopIssuanceProofs *dpopReplayCache
 }
 
 func NewAPIServer(listenAdd string, store Storage) *APIServer {
+	mailer, err := mail.NewSenderFromEnv()
+	if err != nil {
+		log.Fatalf("Invalid mail configuration %v", err)
+	}
+	notifier, err := notify.NewNotifierFromEnv(mailer)
+	if err != nil {
+		log.Fatalf("Invalid notifier configuration %v", err)
+	}
+	passwordPolicy, err := password.PolicyFromEnv()
+	if err != nil {
+		log.Fatalf("Invalid password policy %v", err)
+	}
+	hashing, err := password.PoolFromEnv()
+	if err != nil {
+		log.Fatalf("Invalid password hashing configuration %v", err)
+	}
 	return &APIServer{
-		listenAdd: listenAdd,
-		store:     store,
+		listenAdd:          listenAdd,
+		store:              store,
+		revocations:        NewRevocationList(store),
+		mailer:             mailer,
+		notifier:           notifier,
+		passwordPolicy:     passwordPolicy,
+		hashing:            hashing,
+		dpopProofs:         newDPoPReplayCache(false),
+		dpopIssuanceProofs: newDPoPReplayCache(true),
 	}
 }
 
 func (s *APIServer) Run() {
 	// start a router
 	log.Println("Starting to run server")
+	if err := s.revocations.Load(); err != nil {
+		log.Fatalf("Error: Failed to load the revocation list %v", err)
+	}
+	go s.revocations.Watch()
+	if _, ok := issuerURL(); !ok {
+		log.Printf("JWT_ISSUER %q isn't the public URL of the server, OpenID Connect is turned off", auth.Issuer())
+	}
+
 	router := chi.NewRouter()
 
 	// Allow cors
 	router.Use(cors.Handler(cors.Options{
 		AllowedOrigins:   []string{"https://*", "http://*"},
 		AllowedMethods:   []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"},
-		AllowedHeaders:   []string{"Accept", "Authorization", "Content-Type", "X-CSRF-Token"},
-		ExposedHeaders:   []string{"Link"},
+		AllowedHeaders:   []string{"Accept", "Authorization", "Content-Type", "X-CSRF-Token", "DPoP"},
+		ExposedHeaders:   []string{"Link", "WWW-Authenticate"},
 		AllowCredentials: false,
 		MaxAge:           300, // Maximum value not ignored by any of major browsers
 	}))
@@ -51,6 +101,16 @@ func (s *APIServer) Run() {
 	router.Get("/", func(w http.ResponseWriter, r *http.Request) {
 		WriteJSON(w, 200, "Hello World")
 	})
+	// The JWKS is served outside of /v1 as clients look it up at a well known location
+	router.Get(settings.AppSettings.JWKS_Route, s.handleJWKS)
+	router.Post(settings.AppSettings.Introspect_Route, s.handleIntrospect)
+	router.Post(settings.AppSettings.Token_Route, s.withDPoPProof(s.handleToken))
+	router.Get(settings.AppSettings.Authorize_Route, s.handleAuthorize)
+	router.Post(settings.AppSettings.Authorize_Route, s.handleAuthorize)
+	router.Get(settings.AppSettings.OpenID_Configuration_Route, s.handleOpenIDConfiguration)
+	userInfoHandler := s.withJWTAuth(withPermission(s.handleUserInfo, permission{scope: auth.ScopeOpenID}))
+	router.Get(settings.AppSettings.UserInfo_Route, userInfoHandler)
+	router.Post(settings.AppSettings.UserInfo_Route, userInfoHandler)
 	// Add router handler for v1
 	v1Router := chi.NewRouter()
 
@@ -59,13 +119,52 @@ func (s *APIServer) Run() {
 
 	// Handlers
 	v1Router.Get(settings.AppSettings.Check_Health, s.handlerReadiness)
-	v1Router.Get(settings.AppSettings.Account_Route, withJWTAuth(s.handleAccount))
-	v1Router.Get(settings.AppSettings.All_Account_Route, s.handleGetAllAccount)
-	v1Router.Put(settings.AppSettings.Account_Route, s.handleAccount)
-	v1Router.Delete(settings.AppSettings.Account_Route, s.handleAccount)
+	v1Router.Get(settings.AppSettings.Account_Route, s.withJWTAuth(withPermission(s.withVerifiedEmail(settings.AppSettings.Account_Route, s.handleAccount), permission{
+		owner: true, roles: []auth.Role{auth.RoleSupport, auth.RoleAdmin}, scope: auth.ScopeAccountsRead,
+	})))
+	v1Router.Get(settings.AppSettings.All_Account_Route, s.withJWTAuth(withPermission(s.withVerifiedEmail(settings.AppSettings.All_Account_Route, s.handleGetAllAccount), permission{
+		roles: []auth.Role{auth.RoleAdmin}, scope: auth.ScopeAccountsRead,
+	})))
+	v1Router.Put(settings.AppSettings.Account_Route, s.withJWTAuth(withPermission(s.withVerifiedEmail(settings.AppSettings.Account_Route, s.handleAccount), permission{
+		owner: true, roles: []auth.Role{auth.RoleAdmin}, scope: auth.ScopeAccountsWrite,
+	})))
+	v1Router.Delete(settings.AppSettings.Account_Route, s.withJWTAuth(withPermission(s.withVerifiedEmail(settings.AppSettings.Account_Route, s.handleAccount), permission{
+		owner: true, roles: []auth.Role{auth.RoleAdmin}, scope: auth.ScopeAccountsWrite,
+	})))
+	v1Router.Get(settings.AppSettings.Sign_In_History_Route, s.withJWTAuth(withPermission(s.withVerifiedEmail(settings.AppSettings.Sign_In_History_Route, s.handleGetSignInHistory), permission{
+		owner: true, roles: []auth.Role{auth.RoleSupport, auth.RoleAdmin}, scope: auth.ScopeAccountsRead,
+	})))
+	v1Router.Put(settings.AppSettings.Account_Role_Route, s.withJWTAuth(withRole(s.withVerifiedEmail(settings.AppSettings.Account_Role_Route, s.handleUpdateAccountRole), auth.RoleAdmin)))
 	v1Router.Post(settings.AppSettings.Create_Account_Route, s.handleCreateAccount)
-	v1Router.Post(settings.AppSettings.SignIn_Account_Route, s.handleSignIn)
-	v1Router.Post(settings.AppSettings.Transfer_Route, withJWTAuth(s.handleTransfer))
+	v1Router.Post(settings.AppSettings.SignIn_Account_Route, s.withDPoPProof(s.handleSignIn))
+	v1Router.Post(settings.AppSettings.SignIn_MFA_Route, s.withDPoPProof(s.handleSignInMFA))
+	v1Router.Post(settings.AppSettings.Passwordless_Route, s.handleRequestPasswordless)
+	v1Router.Post(settings.AppSettings.Passwordless_Confirm_Route, s.withDPoPProof(s.handleConfirmPasswordless))
+	v1Router.Post(settings.AppSettings.MFA_TOTP_Route, s.withJWTAuth(withPermission(s.withVerifiedEmail(settings.AppSettings.MFA_TOTP_Route, s.handleEnrollTOTP), permission{})))
+	v1Router.Delete(settings.AppSettings.MFA_TOTP_Route, s.withJWTAuth(withPermission(s.withVerifiedEmail(settings.AppSettings.MFA_TOTP_Route, s.handleDisableTOTP), permission{})))
+	v1Router.Post(settings.AppSettings.MFA_TOTP_Verify_Route, s.withJWTAuth(withPermission(s.withVerifiedEmail(settings.AppSettings.MFA_TOTP_Verify_Route, s.handleVerifyTOTP), permission{})))
+	v1Router.Post(settings.AppSettings.Email_Verify_Route, s.withJWTAuth(withPermission(s.handleResendEmailVerification, permission{})))
+	v1Router.Post(settings.AppSettings.Email_Verify_Confirm_Route, s.handleConfirmEmailVerification)
+	v1Router.Get(settings.AppSettings.API_Keys_Route, s.withJWTAuth(withPermission(s.handleGetAPIKeys, permission{})))
+	v1Router.Post(settings.AppSettings.API_Keys_Route, s.withJWTAuth(withPermission(s.withVerifiedEmail(settings.AppSettings.API_Keys_Route, s.handleCreateAPIKey), permission{})))
+	v1Router.Delete(settings.AppSettings.API_Key_Route, s.withJWTAuth(withPermission(s.handleRevokeAPIKey, permission{})))
+	v1Router.Get(settings.AppSettings.Sessions_Route, s.withJWTAuth(withPermission(s.handleGetSessions, permission{})))
+	v1Router.Delete(settings.AppSettings.Session_Route, s.withJWTAuth(withPermission(s.handleRevokeSession, permission{})))
+	v1Router.Post(settings.AppSettings.Password_Reset_Route, s.handleRequestPasswordReset)
+	v1Router.Post(settings.AppSettings.Password_Reset_Confirm_Route, s.handleConfirmPasswordReset)
+	v1Router.Post(settings.AppSettings.Refresh_Token_Route, s.withDPoPProof(s.handleRefreshToken))
+	v1Router.Post(settings.AppSettings.SignOut_Route, s.withJWTAuth(withPermission(s.handleSignOut, permission{})))
+	v1Router.Post(settings.AppSettings.SignOut_All_Route, s.withJWTAuth(withPermission(s.handleSignOutAll, permission{})))
+	v1Router.Post(settings.AppSettings.Reauthenticate_Route, s.withJWTAuth(withPermission(s.handleReauthenticate, permission{})))
+	v1Router.Post(settings.AppSettings.Transfer_Route, s.withJWTAuth(withPermission(
+		s.withVerifiedEmail(settings.AppSettings.Transfer_Route, withStepUp(s.handleTransfer, transferNeedsStepUp)),
+		permission{scope: auth.ScopeTransfersWrite},
+	)))
+	v1Router.Get(settings.AppSettings.Admin_Keys_Route, s.withJWTAuth(withRole(s.handleGetSigningKeys, auth.RoleAdmin)))
+	v1Router.Post(settings.AppSettings.Admin_Keys_Route, s.withJWTAuth(withRole(s.handleAddSigningKey, auth.RoleAdmin)))
+	v1Router.Post(settings.AppSettings.Admin_Key_Promote_Route, s.withJWTAuth(withRole(s.handlePromoteSigningKey, auth.RoleAdmin)))
+	v1Router.Delete(settings.AppSettings.Admin_Key_Route, s.withJWTAuth(withRole(s.handleRetireSigningKey, auth.RoleAdmin)))
+	v1Router.Get(settings.AppSettings.Admin_Metrics_Route, s.withJWTAuth(withRole(s.handleMetrics, auth.RoleAdmin)))
 
 	// Start the server
 	server := &http.Server{
@@ -86,6 +185,17 @@ func (s *APIServer) handlerReadiness(w http.ResponseWriter, r *http.Request) {
 	WriteJSON(w, http.StatusOK, Ready{Status: "alive"})
 }
 
+// handleJWKS Publish the public keys so other services can verify our tokens without sharing a secret
+func (s *APIServer) handleJWKS(w http.ResponseWriter, r *http.Request) {
+	keySet, err := auth.CurrentKeySet()
+	if err != nil {
+		WriteErrorJson(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	w.Header().Set("Cache-Control", "public, max-age=300")
+	WriteJSON(w, http.StatusOK, keySet.PublicJWKS())
+}
+
 func (s *APIServer) handleGetAllAccount(w http.ResponseWriter, r *http.Request) {
 	if allAccounts, err := s.store.GetAllAccounts(); err != nil {
 		WriteErrorJson(w, http.StatusInternalServerError, err.Error())
@@ -133,23 +243,23 @@ func (s *APIServer) handleCreateAccount(w http.ResponseWriter, r *http.Request)
 	}
 
 	validate := validator.New(validator.WithRequiredStructEnabled())
-	type IError struct {
-		Field string `json:"field"`
-		Tag   string `json:"tag"`
-		Value string `json:"value"`
-	}
-	var errors []*IError
+	var validationErrors []*IError
 	if err := validate.Struct(createAccountReq); err != nil {
 		for _, err := range err.(validator.ValidationErrors) {
 			var el IError
 			el.Field = err.Field()
 			el.Tag = err.Tag()
 			el.Value = err.Param()
-			errors = append(errors, &el)
+			validationErrors = append(validationErrors, &el)
 		}
-		// Convert errors to a JSON string
-		errorsJSON, _ := json.Marshal(errors)
-		WriteErrorJson(w, http.StatusBadRequest, string(errorsJSON))
+	}
+	if createAccountReq.Password != "" {
+		validationErrors = append(validationErrors, s.checkPasswordPolicy(
+			createAccountReq.Password, createAccountReq.Email, createAccountReq.FirstName, createAccountReq.LastName,
+		)...)
+	}
+	if len(validationErrors) > 0 {
+		writeValidationErrors(w, validationErrors)
 		return
 	}
 	newAccount := NewAccount(createAccountReq.FirstName, createAccountReq.LastName, createAccountReq.Email, createAccountReq.Password)
@@ -159,11 +269,25 @@ func (s *APIServer) handleCreateAccount(w http.ResponseWriter, r *http.Request)
 		return
 
 	}
-	if id, err := s.store.CreateAccount(newAccount); err != nil {
+	var id uuid.UUID
+	// CreateAccount hashes the password, so it runs in a slot of the hashing pool
+	err := s.hashing.Do(r.Context(), func() error {
+		var err error
+		id, err = s.store.CreateAccount(newAccount)
+		return err
+	})
+	if errors.Is(err, password.ErrOverloaded) {
+		writeHashingOverloaded(w)
+		return
+	}
+	if err != nil {
 		log.Printf("Error while creating account %v", err)
 		WriteErrorJson(w, http.StatusInternalServerError, err.Error())
 		return
 	} else {
+		if err := s.sendEmailVerification(r, id, newAccount.Email, newAccount.FirstName); err != nil {
+			log.Printf("Failed to send the verification email of account %v: %v", id, err)
+		}
 		type createAccountRes struct {
 			ID uuid.UUID `json:"id"`
 		}
@@ -180,6 +304,16 @@ func (s *APIServer) handleUpdateAccount(w http.ResponseWriter, r *http.Request,
 		WriteErrorJson(w, http.StatusForbidden, err.Error())
 		return
 	}
+	// Only an admin can change the balance and the number, the owner keeps the current ones
+	if claims, _ := claimsFromContext(r.Context()); claims == nil || claims.EffectiveRole() != auth.RoleAdmin {
+		account, err := s.store.GetAccountById(accountId)
+		if err != nil {
+			WriteErrorJson(w, http.StatusNotFound, err.Error())
+			return
+		}
+		updateAccountReq.Number = account.Number
+		updateAccountReq.Balance = account.Balance
+	}
 	if err := s.store.UpdateAccountById(updateAccountReq, accountId); err != nil {
 		WriteErrorJson(w, http.StatusNotFound, err.Error())
 		return
@@ -189,6 +323,39 @@ func (s *APIServer) handleUpdateAccount(w http.ResponseWriter, r *http.Request,
 	}
 }
 
+// handleUpdateAccountRole Change the role of an account. The account is signed out of every session,
+// so a removed role can't be used anymore with an access token issued before
+func (s *APIServer) handleUpdateAccountRole(w http.ResponseWriter, r *http.Request) {
+	accountId, err := util.GetIdFromRequest(r)
+	if err != nil {
+		WriteErrorJson(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	type UpdateRoleReqBody struct {
+		Role string `json:"role"`
+	}
+	updateRoleReqBody := new(UpdateRoleReqBody)
+	if err := json.NewDecoder(r.Body).Decode(updateRoleReqBody); err != nil {
+		WriteErrorJson(w, http.StatusForbidden, err.Error())
+		return
+	}
+	role, err := auth.ParseRole(updateRoleReqBody.Role)
+	if err != nil {
+		WriteErrorJson(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if err := s.store.UpdateAccountRole(accountId, role); err != nil {
+		WriteErrorJson(w, http.StatusNotFound, err.Error())
+		return
+	}
+	if err := s.revocations.RevokeAccount(accountId); err != nil {
+		WriteErrorJson(w, http.StatusInternalServerError, fmt.Sprintf("Failed to revoke tokens %v", err))
+		return
+	}
+	WriteJSON(w, http.StatusNoContent, nil)
+}
+
 func (s *APIServer) handleDeleteAccount(w http.ResponseWriter, r *http.Request, accountId uuid.UUID) {
 	if err := s.store.DeleteAccountById(accountId); err != nil {
 		WriteErrorJson(w, http.StatusNotFound, err.Error())
@@ -201,6 +368,8 @@ func (s *APIServer) handleSignIn(w http.ResponseWriter, r *http.Request) {
 	type SignInReqBody struct {
 		Email    string `json:"email" validate:"required,email"`
 		Password string `json:"password" validate:"required"`
+		// Device A label for the session, e.g. "Work laptop", derived from the User-Agent when not set
+		Device string `json:"device" validate:"max=100"`
 	}
 	signInReqBody := new(SignInReqBody)
 	if err := json.NewDecoder(r.Body).Decode(signInReqBody); err != nil {
@@ -208,34 +377,321 @@ func (s *APIServer) handleSignIn(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if account, err := s.store.GetAccountByEmail(signInReqBody.Email); err != nil {
+	account, err := s.checkPassword(r, signInReqBody.Email, signInReqBody.Password)
+	var lockedErr *signInLockedError
+	if errors.As(err, &lockedErr) {
+		writeTooManyAttempts(w, lockedErr)
+		return
+	}
+	if errors.Is(err, errWrongCredentials) {
+		WriteErrorJson(w, http.StatusUnauthorized, "Wrong email or password")
+		return
+	}
+	if errors.Is(err, password.ErrOverloaded) {
+		writeHashingOverloaded(w)
+		return
+	}
+	if err != nil {
+		WriteErrorJson(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	s.signInAccount(w, r, account.ID, account.Role, []string{auth.AMRPassword}, signInReqBody.Device)
+}
+
+// signInAccount Answer with the tokens of a new session once the first factor of the account was checked, amr tells which one.
+// With two-factor authentication the first factor only gets a MFA token instead, see handleSignInMFA
+func (s *APIServer) signInAccount(w http.ResponseWriter, r *http.Request, accountId uuid.UUID, role auth.Role, amr []string, device string) {
+	if totpEnabled, err := s.totpEnabled(accountId); err != nil {
 		WriteErrorJson(w, http.StatusInternalServerError, err.Error())
 		return
+	} else if totpEnabled {
+		mfaToken, err := auth.CreateMFAToken(accountId, amr...)
+		if err != nil {
+			WriteErrorJson(w, http.StatusInternalServerError, fmt.Sprintf("Failed to create MFA token %v", err))
+			return
+		}
+		WriteJSON(w, http.StatusOK, MFAChallengeResponse{
+			MFARequired: true,
+			MFAToken:    mfaToken,
+			ExpiresIn:   int64(auth.MFATokenTTL().Seconds()),
+		})
+		return
+	}
+	session, err := s.startSession(r, accountId, device)
+	if err != nil {
+		WriteErrorJson(w, http.StatusInternalServerError, fmt.Sprintf("Failed to start session %v", err))
+		return
+	}
+	s.completeSignIn(r, accountId)
+	grant := tokenGrant{
+		accountId: accountId,
+		role:      role,
+		authTime:  time.Now(),
+		amr:       amr,
+		familyId:  session.ID,
+		sessionId: session.ID,
+		dpopJKT:   dpopJKTFromContext(r.Context()),
+	}
+	if tokenRes, err := s.issueTokens(grant, nil); err != nil {
+		WriteErrorJson(w, http.StatusInternalServerError, fmt.Sprintf("Failed to create JWT token %v", err))
+		return
 	} else {
-		isPasswordMatch := util.CheckPasswordHash(signInReqBody.Password, account.Password)
-		if !isPasswordMatch {
-			WriteErrorJson(w, http.StatusUnauthorized, "Wrong email or password")
+		setAccessTokenCookie(w, tokenRes)
+		WriteJSON(w, http.StatusOK, tokenRes)
+	}
+}
+
+func (s *APIServer) handleRefreshToken(w http.ResponseWriter, r *http.Request) {
+	type RefreshTokenReqBody struct {
+		RefreshToken string `json:"refreshToken" validate:"required"`
+	}
+	refreshTokenReqBody := new(RefreshTokenReqBody)
+	if err := json.NewDecoder(r.Body).Decode(refreshTokenReqBody); err != nil {
+		WriteErrorJson(w, http.StatusForbidden, err.Error())
+		return
+	}
+	if refreshTokenReqBody.RefreshToken == "" {
+		WriteErrorJson(w, http.StatusBadRequest, "Missing refresh token")
+		return
+	}
+
+	storedToken, err := s.store.GetRefreshTokenByHash(auth.HashRefreshToken(refreshTokenReqBody.RefreshToken))
+	if err != nil {
+		log.Printf("Failed to get refresh token %v", err)
+		WriteErrorJson(w, http.StatusUnauthorized, "Invalid refresh token")
+		return
+	}
+	// The refresh tokens of OAuth clients are rotated at the token endpoint, where the client is authenticated
+	if storedToken.ClientID != "" {
+		WriteErrorJson(w, http.StatusUnauthorized, fmt.Sprintf("Refresh tokens issued to an OAuth client must be sent to %v with grant_type=refresh_token", settings.AppSettings.Token_Route))
+		return
+	}
+	if storedToken.UsedAt != nil || storedToken.RevokedAt != nil {
+		s.revokeReusedRefreshToken(w, storedToken)
+		return
+	}
+	if time.Now().UTC().After(storedToken.ExpiresAt) {
+		WriteErrorJson(w, http.StatusUnauthorized, "Refresh token expired")
+		return
+	}
+	// A refresh token bound to a DPoP key needs a proof of the same key, an unbound one gets bound by a proof
+	dpopJKT := dpopJKTFromContext(r.Context())
+	if storedToken.DPoPJKT != "" && storedToken.DPoPJKT != dpopJKT {
+		WriteErrorJson(w, http.StatusUnauthorized, "The refresh token is bound to another DPoP key")
+		return
+	}
+
+	// Read the account again so a role change is picked up by the new access token
+	account, err := s.store.GetAccountById(storedToken.AccountID)
+	if err != nil {
+		WriteErrorJson(w, http.StatusUnauthorized, "Invalid refresh token")
+		return
+	}
+	// The refresh tokens of a sign in keep belonging to its session
+	var sessionId uuid.UUID
+	if session, err := s.store.GetSession(storedToken.FamilyID); err == nil {
+		sessionId = session.ID
+	} else if !errors.Is(err, sql.ErrNoRows) {
+		WriteErrorJson(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	// The new tokens keep the scopes of the refresh token, so they can't be refreshed into more access
+	tokenRes, err := s.issueTokens(tokenGrant{
+		accountId: account.ID,
+		role:      account.Role,
+		scopes:    strings.Fields(storedToken.Scope),
+		authTime:  storedToken.authTime(),
+		amr:       storedToken.AMR,
+		sessionId: sessionId,
+		dpopJKT:   dpopJKT,
+	}, storedToken)
+	if errors.Is(err, ErrRefreshTokenReused) {
+		s.revokeReusedRefreshToken(w, storedToken)
+		return
+	}
+	if err != nil {
+		WriteErrorJson(w, http.StatusInternalServerError, fmt.Sprintf("Failed to create JWT token %v", err))
+		return
+	}
+	setAccessTokenCookie(w, tokenRes)
+	WriteJSON(w, http.StatusOK, tokenRes)
+}
+
+func (s *APIServer) revokeReusedRefreshToken(w http.ResponseWriter, reusedToken *RefreshToken) {
+	log.Printf("Refresh token reuse detected for account %v, revoking token family %v", reusedToken.AccountID, reusedToken.FamilyID)
+	if err := s.store.RevokeRefreshTokenFamily(reusedToken.FamilyID); err != nil {
+		log.Printf("Failed to revoke refresh token family %v", err)
+	}
+	WriteErrorJson(w, http.StatusUnauthorized, "Invalid refresh token")
+}
+
+// handleSignOut Revoke the access token used for this request, and the session it belongs to with its refresh tokens.
+// Tokens without a session, e.g. from OAuth clients, can send the refresh token in the body to revoke it too,
+// otherwise it stays usable until it expires
+func (s *APIServer) handleSignOut(w http.ResponseWriter, r *http.Request) {
+	claims, _ := claimsFromContext(r.Context())
+	type SignOutReqBody struct {
+		RefreshToken string `json:"refreshToken"`
+	}
+	signOutReqBody := new(SignOutReqBody)
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(signOutReqBody); err != nil {
+			WriteErrorJson(w, http.StatusForbidden, err.Error())
 			return
 		}
-		if jwtToken, err := auth.CreateJWT(account.ID); err != nil {
-			WriteErrorJson(w, http.StatusInternalServerError, fmt.Sprintf("Failed to create JWT token %v", err))
+	}
+
+	if err := s.revocations.RevokeToken(claims); err != nil {
+		WriteErrorJson(w, http.StatusInternalServerError, fmt.Sprintf("Failed to revoke token %v", err))
+		return
+	}
+	if sessionId, err := uuid.Parse(claims.SessionID); err == nil {
+		if err := s.revocations.RevokeSession(claims.ID, sessionId); err != nil && !errors.Is(err, sql.ErrNoRows) {
+			WriteErrorJson(w, http.StatusInternalServerError, fmt.Sprintf("Failed to revoke session %v", err))
 			return
-		} else {
-			type TokenRes struct {
-				Token string `json:"token"`
+		}
+	}
+	if signOutReqBody.RefreshToken != "" {
+		storedToken, err := s.store.GetRefreshTokenByHash(auth.HashRefreshToken(signOutReqBody.RefreshToken))
+		if err == nil && storedToken.AccountID == claims.ID {
+			if err := s.store.RevokeRefreshTokenFamily(storedToken.FamilyID); err != nil {
+				WriteErrorJson(w, http.StatusInternalServerError, fmt.Sprintf("Failed to revoke refresh token %v", err))
+				return
 			}
-			WriteJSON(w, http.StatusOK, TokenRes{Token: jwtToken})
 		}
+	}
+	clearAccessTokenCookie(w)
+	WriteJSON(w, http.StatusNoContent, nil)
+}
+
+// handleSignOutAll Revoke every access token and refresh token of the account, to lock down a compromised account
+func (s *APIServer) handleSignOutAll(w http.ResponseWriter, r *http.Request) {
+	claims, _ := claimsFromContext(r.Context())
+	if err := s.revocations.RevokeAccount(claims.ID); err != nil {
+		WriteErrorJson(w, http.StatusInternalServerError, fmt.Sprintf("Failed to revoke tokens %v", err))
 		return
 	}
+	clearAccessTokenCookie(w)
+	WriteJSON(w, http.StatusNoContent, nil)
 }
 
-func (s *APIServer) handleTransfer(w http.ResponseWriter, r *http.Request) {
-	type TransferBalanceBody struct {
-		Number  int64 `json:"toAccount"`
-		Balance int64 `json:"balance"`
+// setAccessTokenCookie Store the access token in an HttpOnly cookie when cookie auth is enabled,
+// the token is still returned in the body for the other clients
+func setAccessTokenCookie(w http.ResponseWriter, tokenRes *TokenResponse) {
+	// A token bound to a DPoP key is useless without the proof the browser would not send with the cookie
+	if !cookieAuthEnabled() || tokenRes.TokenType == auth.DPoPTokenType {
+		return
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     accessTokenCookieName,
+		Value:    tokenRes.Token,
+		Path:     "/",
+		MaxAge:   int(tokenRes.ExpiresIn),
+		HttpOnly: true,
+		Secure:   true,
+		SameSite: http.SameSiteStrictMode,
+	})
+}
+
+func clearAccessTokenCookie(w http.ResponseWriter) {
+	if !cookieAuthEnabled() {
+		return
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     accessTokenCookieName,
+		Value:    "",
+		Path:     "/",
+		MaxAge:   -1,
+		HttpOnly: true,
+		Secure:   true,
+		SameSite: http.SameSiteStrictMode,
+	})
+}
+
+// tokenGrant Who a token pair is issued to and what it allows
+type tokenGrant struct {
+	accountId uuid.UUID
+	role      auth.Role
+	// clientId and scopes are set when the tokens are issued to an OAuth client on behalf of the account,
+	// the access token is then limited to the scopes
+	clientId string
+	scopes   []string
+	// familyId The family of the refresh token when a new family is started, a random one is used when empty
+	familyId uuid.UUID
+	// authTime and amr tell when and how the account entered its credentials, for step-up authentication.
+	// They are kept by the refresh tokens so refreshing doesn't make the authentication look recent
+	authTime time.Time
+	amr      []string
+	// sessionId The session the tokens belong to when the account signed in directly, see startSession
+	sessionId uuid.UUID
+	// dpopJKT The thumbprint of the DPoP key the tokens are bound to, empty for bearer tokens
+	dpopJKT string
+}
+
+// issueTokens Create an access token and a refresh token for the grant.
+// When previous is nil a new token family is started (sign in), otherwise previous is rotated into the new refresh token
+func (s *APIServer) issueTokens(grant tokenGrant, previous *RefreshToken) (*TokenResponse, error) {
+	claims := &auth.CustomJWTClaims{
+		ID:           grant.accountId,
+		Role:         grant.role,
+		ClientID:     grant.clientId,
+		Scope:        auth.FormatScopes(grant.scopes),
+		AuthTime:     unixOrZero(grant.authTime),
+		AMR:          grant.amr,
+		Confirmation: auth.NewConfirmation(grant.dpopJKT),
+	}
+	if grant.sessionId != uuid.Nil {
+		claims.SessionID = grant.sessionId.String()
 	}
-	transferBalanceReq := new(TransferBalanceBody)
+	jwtToken, err := auth.SignAccessToken(claims)
+	if err != nil {
+		return nil, err
+	}
+	if grant.sessionId != uuid.Nil {
+		if err := s.store.TouchSession(grant.sessionId, claims.Id, time.Now().UTC()); err != nil {
+			return nil, err
+		}
+	}
+	refreshToken, refreshTokenHash, err := auth.NewRefreshToken()
+	if err != nil {
+		return nil, err
+	}
+
+	if previous == nil {
+		familyId := grant.familyId
+		if familyId == uuid.Nil {
+			familyId = uuid.New()
+		}
+		newRefreshToken := NewRefreshToken(grant, familyId, refreshTokenHash, auth.RefreshTokenTTL())
+		if err := s.store.CreateRefreshToken(newRefreshToken); err != nil {
+			return nil, err
+		}
+	} else {
+		newRefreshToken := NewRefreshToken(grant, previous.FamilyID, refreshTokenHash, auth.RefreshTokenTTL())
+		if err := s.store.RotateRefreshToken(previous.ID, newRefreshToken); err != nil {
+			return nil, err
+		}
+	}
+
+	return &TokenResponse{
+		Token:        jwtToken,
+		TokenType:    tokenType(claims),
+		RefreshToken: refreshToken,
+		ExpiresIn:    int64(auth.AccessTokenTTL().Seconds()),
+		Scope:        auth.FormatScopes(grant.scopes),
+	}, nil
+}
+
+// tokenType The token_type of an access token, DPoP when it is bound to a DPoP key
+func tokenType(claims *auth.CustomJWTClaims) string {
+	if claims.DPoPJKT() != "" {
+		return auth.DPoPTokenType
+	}
+	return "Bearer"
+}
+
+func (s *APIServer) handleTransfer(w http.ResponseWriter, r *http.Request) {
+	transferBalanceReq := new(TransferRequest)
 	if err := json.NewDecoder(r.Body).Decode(transferBalanceReq); err != nil {
 		WriteErrorJson(w, http.StatusForbidden, err.Error())
 	}
@@ -0,0 +1,204 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+	"github.com/nguyenanhhao221/go-jwt/internal/auth"
+)
+
+// maxAPIKeysPerAccount How many active API keys an account can have at once
+const maxAPIKeysPerAccount = 20
+
+// apiKeyUsageInterval The last use of an API key is only recorded once in this interval, to spare a write on every request
+const apiKeyUsageInterval = time.Minute
+
+// apiKeyScopes The scopes an API key can be granted, the OpenID Connect ones only make sense for OAuth clients
+var apiKeyScopes = []string{auth.ScopeAccountsRead, auth.ScopeAccountsWrite, auth.ScopeTransfersWrite}
+
+// APIKeyResponse An API key as listed to its owner, the key itself is only in CreateAPIKeyResponse
+type APIKeyResponse struct {
+	ID         uuid.UUID  `json:"id"`
+	Name       string     `json:"name"`
+	Prefix     string     `json:"prefix"`
+	Scopes     []string   `json:"scopes"`
+	CreatedAt  time.Time  `json:"createdAt"`
+	ExpiresAt  *time.Time `json:"expiresAt,omitempty"`
+	LastUsedAt *time.Time `json:"lastUsedAt,omitempty"`
+}
+
+// CreateAPIKeyResponse The new API key, shown only once
+type CreateAPIKeyResponse struct {
+	APIKeyResponse
+	Key string `json:"key"`
+}
+
+func newAPIKeyResponse(apiKey *APIKey) APIKeyResponse {
+	scopes, _ := auth.ParseScopes(apiKey.Scope)
+	return APIKeyResponse{
+		ID:         apiKey.ID,
+		Name:       apiKey.Name,
+		Prefix:     apiKey.Prefix,
+		Scopes:     scopes,
+		CreatedAt:  apiKey.CreatedAt,
+		ExpiresAt:  apiKey.ExpiresAt,
+		LastUsedAt: apiKey.LastUsedAt,
+	}
+}
+
+// authenticateAPIKey Return the claims of the account owning the API key, limited to the scopes of the key.
+// The claims have no auth_time, so an API key never passes a step-up check
+func (s *APIServer) authenticateAPIKey(key string) (*auth.CustomJWTClaims, error) {
+	prefix, err := auth.ParseAPIKey(key)
+	if err != nil {
+		return nil, err
+	}
+	apiKey, err := s.store.GetAPIKeyByPrefix(prefix)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, auth.ErrInvalidAPIKey
+	}
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now().UTC()
+	if !auth.CheckAPIKey(key, apiKey.KeyHash) || !apiKey.Active(now) {
+		return nil, auth.ErrInvalidAPIKey
+	}
+	// The role is read on every use, a key can't keep a role its account lost
+	account, err := s.store.GetAccountById(apiKey.AccountID)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.store.TouchAPIKey(apiKey.ID, now, now.Add(-apiKeyUsageInterval)); err != nil {
+		log.Printf("Failed to record the use of API key %v: %v", apiKey.ID, err)
+	}
+
+	claims := &auth.CustomJWTClaims{ID: account.ID, Role: account.Role, Scope: apiKey.Scope}
+	claims.Subject = account.ID.String()
+	claims.Id = apiKey.ID.String()
+	return claims, nil
+}
+
+// handleCreateAPIKey Create an API key for the account of the token, the key is only shown in this response
+func (s *APIServer) handleCreateAPIKey(w http.ResponseWriter, r *http.Request) {
+	type CreateAPIKeyReqBody struct {
+		Name   string   `json:"name" validate:"required,max=100"`
+		Scopes []string `json:"scopes" validate:"required,min=1"`
+		// ExpiresAt When the key stops working, it never expires when not set
+		ExpiresAt *time.Time `json:"expiresAt"`
+	}
+	createAPIKeyReqBody := new(CreateAPIKeyReqBody)
+	if err := json.NewDecoder(r.Body).Decode(createAPIKeyReqBody); err != nil {
+		WriteErrorJson(w, http.StatusForbidden, err.Error())
+		return
+	}
+	if createAPIKeyReqBody.Name == "" || len(createAPIKeyReqBody.Name) > 100 {
+		WriteErrorJson(w, http.StatusBadRequest, "A name of at most 100 characters is required")
+		return
+	}
+	if len(createAPIKeyReqBody.Scopes) == 0 {
+		WriteErrorJson(w, http.StatusBadRequest, "At least one scope is required")
+		return
+	}
+	for _, scope := range createAPIKeyReqBody.Scopes {
+		if !containsString(apiKeyScopes, scope) {
+			WriteErrorJson(w, http.StatusBadRequest, fmt.Sprintf("Scope %q can't be granted to an API key", scope))
+			return
+		}
+	}
+	if createAPIKeyReqBody.ExpiresAt != nil && !createAPIKeyReqBody.ExpiresAt.After(time.Now()) {
+		WriteErrorJson(w, http.StatusBadRequest, "expiresAt must be in the future")
+		return
+	}
+	claims, _ := claimsFromContext(r.Context())
+
+	apiKeys, err := s.store.GetAPIKeys(claims.ID)
+	if err != nil {
+		WriteErrorJson(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	activeKeys := 0
+	for _, apiKey := range apiKeys {
+		if apiKey.Active(time.Now()) {
+			activeKeys++
+		}
+	}
+	if activeKeys >= maxAPIKeysPerAccount {
+		WriteErrorJson(w, http.StatusConflict, fmt.Sprintf("An account can have at most %d API keys", maxAPIKeysPerAccount))
+		return
+	}
+
+	key, prefix, keyHash, err := auth.NewAPIKey()
+	if err != nil {
+		WriteErrorJson(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	apiKey := &APIKey{
+		ID:        uuid.New(),
+		AccountID: claims.ID,
+		Name:      createAPIKeyReqBody.Name,
+		Prefix:    prefix,
+		KeyHash:   keyHash,
+		Scope:     auth.FormatScopes(createAPIKeyReqBody.Scopes),
+		CreatedAt: time.Now().UTC(),
+		ExpiresAt: createAPIKeyReqBody.ExpiresAt,
+	}
+	if err := s.store.CreateAPIKey(apiKey); err != nil {
+		WriteErrorJson(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	emitAuditEvent(AuditEvent{
+		Type:      auditAPIKeyCreated,
+		AccountID: auditAccount(claims.ID),
+		IP:        clientIP(r),
+		Details:   fmt.Sprintf("API key %v with scopes %q", apiKey.ID, apiKey.Scope),
+	})
+	w.Header().Set("Cache-Control", "no-store")
+	WriteJSON(w, http.StatusCreated, CreateAPIKeyResponse{APIKeyResponse: newAPIKeyResponse(apiKey), Key: key})
+}
+
+// handleGetAPIKeys List the API keys of the account of the token which aren't revoked
+func (s *APIServer) handleGetAPIKeys(w http.ResponseWriter, r *http.Request) {
+	claims, _ := claimsFromContext(r.Context())
+	apiKeys, err := s.store.GetAPIKeys(claims.ID)
+	if err != nil {
+		WriteErrorJson(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	apiKeyResponses := make([]APIKeyResponse, 0, len(apiKeys))
+	for i := range apiKeys {
+		apiKeyResponses = append(apiKeyResponses, newAPIKeyResponse(&apiKeys[i]))
+	}
+	WriteJSON(w, http.StatusOK, apiKeyResponses)
+}
+
+// handleRevokeAPIKey Revoke an API key of the account of the token, it stops working right away
+func (s *APIServer) handleRevokeAPIKey(w http.ResponseWriter, r *http.Request) {
+	keyId, err := uuid.Parse(chi.URLParam(r, "keyId"))
+	if err != nil {
+		WriteErrorJson(w, http.StatusBadRequest, fmt.Sprintf("Invalid key id in request: %v", err))
+		return
+	}
+	claims, _ := claimsFromContext(r.Context())
+	if err := s.store.RevokeAPIKey(claims.ID, keyId); errors.Is(err, sql.ErrNoRows) {
+		WriteErrorJson(w, http.StatusNotFound, "API key not found")
+		return
+	} else if err != nil {
+		WriteErrorJson(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	emitAuditEvent(AuditEvent{
+		Type:      auditAPIKeyRevoked,
+		AccountID: auditAccount(claims.ID),
+		IP:        clientIP(r),
+		Details:   fmt.Sprintf("API key %v", keyId),
+	})
+	WriteJSON(w, http.StatusNoContent, nil)
+}
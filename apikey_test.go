@@ -0,0 +1,128 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/nguyenanhhao221/go-jwt/internal/auth"
+	"github.com/nguyenanhhao221/go-jwt/settings"
+)
+
+func TestAPIKeyCI(t *testing.T) {
+	store, err := NewPostgresStore()
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := NewAPIServer("", store)
+	mockUser := NewAccount("API Key First Name", "API Key Last Name", "APIKey@email.com", "TestPassword")
+	accountId, err := store.CreateAccount(mockUser)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.DeleteAccountById(accountId)
+	tokenRes, err := server.issueTokens(tokenGrant{accountId: accountId, role: auth.RoleCustomer}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	request := func(handler http.HandlerFunc, method, route string, body any, credential string, required permission) *httptest.ResponseRecorder {
+		reqBodyJSON, err := json.Marshal(body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req, err := http.NewRequest(method, route, bytes.NewBuffer(reqBodyJSON))
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("Authorization", "Bearer "+credential)
+		rr := httptest.NewRecorder()
+		server.withJWTAuth(withPermission(handler, required)).ServeHTTP(rr, req)
+		return rr
+	}
+	// whoAmI Answer with the account of the claims, to check what an API key authenticates as
+	whoAmI := func(w http.ResponseWriter, r *http.Request) {
+		claims, _ := claimsFromContext(r.Context())
+		WriteJSON(w, http.StatusOK, claims.ID)
+	}
+
+	var created CreateAPIKeyResponse
+	t.Run("Create", func(t *testing.T) {
+		rr := request(server.handleCreateAPIKey, http.MethodPost, settings.AppSettings.API_Keys_Route, map[string]any{"name": "backup script", "scopes": []string{auth.ScopeOpenID}}, tokenRes.Token, permission{})
+		if rr.Code != http.StatusBadRequest {
+			t.Errorf("expected the openid scope to be refused but got %d", rr.Code)
+		}
+
+		rr = request(server.handleCreateAPIKey, http.MethodPost, settings.AppSettings.API_Keys_Route, map[string]any{"name": "backup script", "scopes": []string{auth.ScopeAccountsRead}}, tokenRes.Token, permission{})
+		if rr.Code != http.StatusCreated {
+			t.Fatalf("expected status code %d but got %d", http.StatusCreated, rr.Code)
+		}
+		if err := json.Unmarshal(rr.Body.Bytes(), &created); err != nil {
+			t.Fatal(err)
+		}
+		if !auth.IsAPIKey(created.Key) || created.Prefix == "" {
+			t.Errorf("expected a new API key but got %v", created)
+		}
+	})
+
+	t.Run("Authenticate", func(t *testing.T) {
+		rr := request(whoAmI, http.MethodGet, settings.AppSettings.Account_Route, nil, created.Key, permission{scope: auth.ScopeAccountsRead})
+		if rr.Code != http.StatusOK {
+			t.Fatalf("expected status code %d but got %d", http.StatusOK, rr.Code)
+		}
+		if rr.Body.String() != `"`+accountId.String()+`"`+"\n" {
+			t.Errorf("expected the API key to authenticate as its account but got %v", rr.Body.String())
+		}
+		if rr := request(whoAmI, http.MethodPost, settings.AppSettings.Transfer_Route, nil, created.Key, permission{scope: auth.ScopeTransfersWrite}); rr.Code != http.StatusForbidden {
+			t.Errorf("expected a scope the key wasn't granted to be refused but got %d", rr.Code)
+		}
+		// An API key can't manage API keys
+		if rr := request(server.handleCreateAPIKey, http.MethodPost, settings.AppSettings.API_Keys_Route, map[string]any{"name": "other", "scopes": []string{auth.ScopeAccountsRead}}, created.Key, permission{}); rr.Code != http.StatusForbidden {
+			t.Errorf("expected an API key to be refused on the API key routes but got %d", rr.Code)
+		}
+		if rr := request(whoAmI, http.MethodGet, settings.AppSettings.Account_Route, nil, created.Key+"x", permission{scope: auth.ScopeAccountsRead}); rr.Code != http.StatusUnauthorized {
+			t.Errorf("expected a wrong key to be refused but got %d", rr.Code)
+		}
+	})
+
+	t.Run("List", func(t *testing.T) {
+		rr := request(server.handleGetAPIKeys, http.MethodGet, settings.AppSettings.API_Keys_Route, nil, tokenRes.Token, permission{})
+		if rr.Code != http.StatusOK {
+			t.Fatalf("expected status code %d but got %d", http.StatusOK, rr.Code)
+		}
+		var apiKeys []map[string]any
+		if err := json.Unmarshal(rr.Body.Bytes(), &apiKeys); err != nil {
+			t.Fatal(err)
+		}
+		if len(apiKeys) != 1 || apiKeys[0]["prefix"] != created.Prefix || apiKeys[0]["lastUsedAt"] == nil {
+			t.Errorf("expected the used key but got %v", apiKeys)
+		}
+		if _, hasKey := apiKeys[0]["key"]; hasKey {
+			t.Error("expected the key itself not to be listed")
+		}
+	})
+
+	t.Run("Revoke", func(t *testing.T) {
+		req, err := http.NewRequest(http.MethodDelete, settings.AppSettings.API_Key_Route, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		rctx := chi.NewRouteContext()
+		rctx.URLParams.Add("keyId", created.ID.String())
+		req = req.WithContext(context.WithValue(req.Context(), chi.RouteCtxKey, rctx))
+		req.Header.Set("Authorization", "Bearer "+tokenRes.Token)
+		rr := httptest.NewRecorder()
+		server.withJWTAuth(withPermission(server.handleRevokeAPIKey, permission{})).ServeHTTP(rr, req)
+		if rr.Code != http.StatusNoContent {
+			t.Fatalf("expected status code %d but got %d", http.StatusNoContent, rr.Code)
+		}
+
+		if rr := request(whoAmI, http.MethodGet, settings.AppSettings.Account_Route, nil, created.Key, permission{scope: auth.ScopeAccountsRead}); rr.Code != http.StatusUnauthorized {
+			t.Errorf("expected a revoked key to be refused but got %d", rr.Code)
+		}
+	})
+}
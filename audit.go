@@ -0,0 +1,49 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Types of audit events
+const (
+	auditSignInLocked     = "sign_in.locked"
+	auditPasswordReset    = "password.reset"
+	auditEmailVerified    = "email.verified"
+	auditAPIKeyCreated    = "api_key.created"
+	auditAPIKeyRevoked    = "api_key.revoked"
+	auditSessionRevoked   = "session.revoked"
+	auditSignInSuspicious = "sign_in.suspicious"
+)
+
+// AuditEvent A security relevant event, written to the log as a JSON line so it can be collected apart from the other logs
+type AuditEvent struct {
+	Type      string     `json:"type"`
+	AccountID *uuid.UUID `json:"accountId,omitempty"`
+	IP        string     `json:"ip,omitempty"`
+	Details   string     `json:"details,omitempty"`
+	Time      time.Time  `json:"time"`
+}
+
+// auditAccount The account of an audit event, nil when unknown
+func auditAccount(accountId uuid.UUID) *uuid.UUID {
+	if accountId == uuid.Nil {
+		return nil
+	}
+	return &accountId
+}
+
+func emitAuditEvent(event AuditEvent) {
+	if event.Time.IsZero() {
+		event.Time = time.Now().UTC()
+	}
+	eventJSON, err := json.Marshal(event)
+	if err != nil {
+		log.Printf("Failed to marshal audit event %v: %v", event.Type, err)
+		return
+	}
+	log.Printf("audit: %s", eventJSON)
+}
@@ -0,0 +1,384 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"html/template"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/nguyenanhhao221/go-jwt/internal/auth"
+	"github.com/nguyenanhhao221/go-jwt/internal/password"
+)
+
+// authorizationCodeTTL How long an authorization code can be exchanged, RFC 6749 recommends at most 10 minutes
+const authorizationCodeTTL = 2 * time.Minute
+
+// Actions posted by the buttons of the authorization page, any other one denies the request
+const (
+	authorizeActionSignIn  = "sign_in"
+	authorizeActionApprove = "approve"
+)
+
+// authorizeError An error of the authorization request sent back to the client through its redirect_uri (RFC 6749 section 4.1.2.1)
+type authorizeError struct {
+	code        string
+	description string
+}
+
+func (e *authorizeError) Error() string {
+	return e.description
+}
+
+// authorizationRequest A validated request to the authorization endpoint
+type authorizationRequest struct {
+	client        *OAuthClient
+	redirectURI   string
+	state         string
+	scopes        []string
+	codeChallenge string
+	nonce         string
+}
+
+// validateRedirectURI Check a redirect URI can be registered for a client.
+// It must be absolute without fragment, and use https unless it points to the loopback interface
+// or uses a private scheme, as native apps do (RFC 8252)
+func validateRedirectURI(redirectURI string) error {
+	u, err := url.Parse(redirectURI)
+	if err != nil || u.Scheme == "" || u.Fragment != "" {
+		return fmt.Errorf("invalid redirect URI %q, it must be absolute without fragment", redirectURI)
+	}
+	if u.Scheme == "http" && u.Hostname() != "localhost" && u.Hostname() != "127.0.0.1" && u.Hostname() != "::1" {
+		return fmt.Errorf("invalid redirect URI %q, http is only allowed for the loopback interface", redirectURI)
+	}
+	return nil
+}
+
+// parseAuthorizationRequest Validate the parameters of the authorization request.
+// When the client or the redirect_uri can't be trusted the request is nil, and the error must be shown to the user
+// instead of redirecting (RFC 6749 section 4.1.2.1). Otherwise an *authorizeError is sent to the redirect_uri
+func (s *APIServer) parseAuthorizationRequest(r *http.Request) (*authorizationRequest, error) {
+	clientId := r.FormValue("client_id")
+	if clientId == "" {
+		return nil, errors.New("Missing client_id")
+	}
+	client, err := s.store.GetOAuthClient(clientId)
+	if err != nil {
+		log.Printf("Failed to get OAuth client %v: %v", clientId, err)
+		return nil, errors.New("Unknown client")
+	}
+
+	// The redirect_uri is compared exactly with the registered ones, it can only be left out when one is registered
+	redirectURI := r.FormValue("redirect_uri")
+	if redirectURI == "" && len(client.RedirectURIs) == 1 {
+		redirectURI = client.RedirectURIs[0]
+	}
+	if !containsString(client.RedirectURIs, redirectURI) {
+		return nil, errors.New("Invalid redirect_uri")
+	}
+
+	req := &authorizationRequest{
+		client:        client,
+		redirectURI:   redirectURI,
+		state:         r.FormValue("state"),
+		codeChallenge: r.FormValue("code_challenge"),
+		nonce:         r.FormValue("nonce"),
+	}
+	if responseType := r.FormValue("response_type"); responseType != "code" {
+		return req, &authorizeError{"unsupported_response_type", fmt.Sprintf("Unsupported response_type %q", responseType)}
+	}
+	// PKCE is required from every client, not only the public ones
+	if err := auth.ValidateCodeChallenge(req.codeChallenge, r.FormValue("code_challenge_method")); err != nil {
+		return req, &authorizeError{"invalid_request", "A S256 code_challenge is required"}
+	}
+	if req.scopes, err = client.requestedScopes(r.FormValue("scope")); err != nil {
+		return req, &authorizeError{"invalid_scope", err.Error()}
+	}
+	if _, ok := issuerURL(); !ok && containsString(req.scopes, auth.ScopeOpenID) {
+		return req, &authorizeError{"invalid_scope", errOpenIDIssuerNotURL.Error()}
+	}
+	return req, nil
+}
+
+// redirect Send the user back to the client with the parameters of the response, and the state of the request
+func (req *authorizationRequest) redirect(w http.ResponseWriter, r *http.Request, params url.Values) {
+	// The redirect URI was validated when the client was registered
+	u, _ := url.Parse(req.redirectURI)
+	query := u.Query()
+	for key, values := range params {
+		query[key] = values
+	}
+	if req.state != "" {
+		query.Set("state", req.state)
+	}
+	u.RawQuery = query.Encode()
+	http.Redirect(w, r, u.String(), http.StatusFound)
+}
+
+func (req *authorizationRequest) redirectError(w http.ResponseWriter, r *http.Request, err *authorizeError) {
+	req.redirect(w, r, url.Values{"error": {err.code}, "error_description": {err.description}})
+}
+
+// handleAuthorize The OAuth authorization endpoint (RFC 6749 section 3.1).
+// GET shows the sign in page for the client. Once signed in with a POST the account is asked to approve the scopes,
+// unless it already granted them to the client, then redirected back to the client with an authorization code
+// the client exchanges at the token endpoint
+func (s *APIServer) handleAuthorize(w http.ResponseWriter, r *http.Request) {
+	// The page asks for a password, it must not be cached or framed by another site
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("X-Frame-Options", "DENY")
+
+	req, err := s.parseAuthorizationRequest(r)
+	if req == nil {
+		renderAuthorizePage(w, http.StatusBadRequest, authorizePage{Error: err.Error()})
+		return
+	}
+	var authorizeErr *authorizeError
+	if errors.As(err, &authorizeErr) {
+		req.redirectError(w, r, authorizeErr)
+		return
+	}
+
+	if r.Method != http.MethodPost {
+		renderAuthorizePage(w, http.StatusOK, newAuthorizePage(r, req, ""))
+		return
+	}
+	action := r.PostFormValue("action")
+	if action != authorizeActionSignIn && action != authorizeActionApprove {
+		req.redirectError(w, r, &authorizeError{"access_denied", "The account denied the request"})
+		return
+	}
+
+	now := time.Now().UTC()
+	var accountId uuid.UUID
+	var amr []string
+	if consentToken := r.PostFormValue("consent_token"); consentToken != "" {
+		// The account signed in already and approves the scopes
+		claims, err := auth.ValidateConsentToken(consentToken, req.client.ID)
+		if err != nil || action != authorizeActionApprove {
+			renderAuthorizePage(w, http.StatusUnauthorized, newAuthorizePage(r, req, "Your sign in expired, please start again"))
+			return
+		}
+		accountId, amr = claims.ID, claims.AMR
+		if err := s.saveOAuthConsent(accountId, req.client.ID, req.scopes, now); err != nil {
+			log.Printf("Failed to save the consent of account %v to client %v: %v", accountId, req.client.ID, err)
+			req.redirectError(w, r, &authorizeError{"server_error", "Failed to save the consent"})
+			return
+		}
+	} else {
+		var ok bool
+		if accountId, amr, ok = s.authenticateAuthorizeForm(w, r, req); !ok {
+			return
+		}
+		s.completeSignIn(r, accountId)
+
+		granted, err := s.consentGranted(accountId, req.client.ID, req.scopes)
+		if err != nil {
+			log.Printf("Failed to get the consent of account %v to client %v: %v", accountId, req.client.ID, err)
+			req.redirectError(w, r, &authorizeError{"server_error", "Failed to sign in"})
+			return
+		}
+		if !granted {
+			page := newAuthorizePage(r, req, "")
+			if page.ConsentToken, err = auth.CreateConsentToken(accountId, req.client.ID, amr); err != nil {
+				req.redirectError(w, r, &authorizeError{"server_error", "Failed to sign in"})
+				return
+			}
+			renderAuthorizePage(w, http.StatusOK, page)
+			return
+		}
+	}
+
+	code, codeHash, err := auth.NewAuthorizationCode()
+	if err == nil {
+		err = s.store.CreateAuthorizationCode(&AuthorizationCode{
+			CodeHash:      codeHash,
+			ClientID:      req.client.ID,
+			AccountID:     accountId,
+			RedirectURI:   req.redirectURI,
+			Scope:         auth.FormatScopes(req.scopes),
+			CodeChallenge: req.codeChallenge,
+			Nonce:         req.nonce,
+			AMR:           amr,
+			ExpiresAt:     now.Add(authorizationCodeTTL),
+			CreatedAt:     now,
+		})
+	}
+	if err != nil {
+		log.Printf("Failed to create the authorization code %v", err)
+		req.redirectError(w, r, &authorizeError{"server_error", "Failed to create the authorization code"})
+		return
+	}
+	log.Printf("Account %v authorized client %v", accountId, req.client.ID)
+	req.redirect(w, r, url.Values{"code": {code}})
+}
+
+// consentGranted Report if the account already granted every scope to the client
+func (s *APIServer) consentGranted(accountId uuid.UUID, clientId string, scopes []string) (bool, error) {
+	consent, err := s.store.GetOAuthConsent(accountId, clientId)
+	if errors.Is(err, sql.ErrNoRows) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	granted := strings.Fields(consent.Scope)
+	for _, scope := range scopes {
+		if !containsString(granted, scope) {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// saveOAuthConsent Add the scopes to the ones the account already granted to the client
+func (s *APIServer) saveOAuthConsent(accountId uuid.UUID, clientId string, scopes []string, now time.Time) error {
+	consent, err := s.store.GetOAuthConsent(accountId, clientId)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return err
+	}
+	var granted []string
+	if consent != nil {
+		granted = strings.Fields(consent.Scope)
+	}
+	for _, scope := range scopes {
+		if !containsString(granted, scope) {
+			granted = append(granted, scope)
+		}
+	}
+	return s.store.SaveOAuthConsent(&OAuthConsent{
+		AccountID: accountId,
+		ClientID:  clientId,
+		Scope:     auth.FormatScopes(granted),
+		GrantedAt: now,
+	})
+}
+
+// authenticateAuthorizeForm Sign the account in from the authorization page.
+// Accounts with two-factor authentication first post their password, which renders the page again asking for a code,
+// then post the code with the MFA token of the password step. The authentication methods used are returned with the account,
+// when false is returned the response was written
+func (s *APIServer) authenticateAuthorizeForm(w http.ResponseWriter, r *http.Request, req *authorizationRequest) (uuid.UUID, []string, bool) {
+	if mfaToken := r.PostFormValue("mfa_token"); mfaToken != "" {
+		claims, err := auth.ValidateMFAToken(mfaToken)
+		if err != nil {
+			renderAuthorizePage(w, http.StatusUnauthorized, newAuthorizePage(r, req, "Your sign in expired, please start again"))
+			return uuid.Nil, nil, false
+		}
+		var lockedErr *signInLockedError
+		if err := s.verifySecondFactor(r, claims.ID, r.PostFormValue("code")); errors.As(err, &lockedErr) {
+			setRetryAfter(w, lockedErr)
+			renderAuthorizePage(w, http.StatusTooManyRequests, newAuthorizePage(r, req, "Too many failed attempts, try again later"))
+			return uuid.Nil, nil, false
+		} else if err != nil {
+			page := newAuthorizePage(r, req, "Wrong two-factor code")
+			page.MFAToken = mfaToken
+			renderAuthorizePage(w, http.StatusUnauthorized, page)
+			return uuid.Nil, nil, false
+		}
+		return claims.ID, []string{auth.AMRPassword, auth.AMROTP}, true
+	}
+
+	account, err := s.checkPassword(r, r.PostFormValue("email"), r.PostFormValue("password"))
+	var lockedErr *signInLockedError
+	if errors.As(err, &lockedErr) {
+		setRetryAfter(w, lockedErr)
+		renderAuthorizePage(w, http.StatusTooManyRequests, newAuthorizePage(r, req, "Too many failed attempts, try again later"))
+		return uuid.Nil, nil, false
+	}
+	if errors.Is(err, password.ErrOverloaded) {
+		w.Header().Set("Retry-After", strconv.Itoa(hashingRetryAfter))
+		renderAuthorizePage(w, http.StatusServiceUnavailable, newAuthorizePage(r, req, "The server is busy, try again later"))
+		return uuid.Nil, nil, false
+	}
+	if err != nil {
+		renderAuthorizePage(w, http.StatusUnauthorized, newAuthorizePage(r, req, "Wrong email or password"))
+		return uuid.Nil, nil, false
+	}
+	totpEnabled, err := s.totpEnabled(account.ID)
+	if err != nil {
+		req.redirectError(w, r, &authorizeError{"server_error", "Failed to sign in"})
+		return uuid.Nil, nil, false
+	}
+	if totpEnabled {
+		page := newAuthorizePage(r, req, "")
+		if page.MFAToken, err = auth.CreateMFAToken(account.ID); err != nil {
+			req.redirectError(w, r, &authorizeError{"server_error", "Failed to sign in"})
+			return uuid.Nil, nil, false
+		}
+		renderAuthorizePage(w, http.StatusOK, page)
+		return uuid.Nil, nil, false
+	}
+	return account.ID, []string{auth.AMRPassword}, true
+}
+
+// authorizePage The data of the authorization page template
+type authorizePage struct {
+	Error      string
+	ClientName string
+	Scopes     []string
+	// MFAToken Set when the password was right and the page asks for the two-factor code
+	MFAToken string
+	// ConsentToken Set when the account signed in and the page asks it to approve the scopes
+	ConsentToken string
+	// Params The parameters of the authorization request, posted back with the form
+	Params url.Values
+}
+
+func newAuthorizePage(r *http.Request, req *authorizationRequest, errorMessage string) authorizePage {
+	params := url.Values{}
+	for _, key := range []string{"client_id", "redirect_uri", "response_type", "state", "scope", "code_challenge", "code_challenge_method", "nonce"} {
+		if value := r.FormValue(key); value != "" {
+			params.Set(key, value)
+		}
+	}
+	return authorizePage{
+		Error:      errorMessage,
+		ClientName: req.client.Name,
+		Scopes:     req.scopes,
+		Params:     params,
+	}
+}
+
+var authorizeTemplate = template.Must(template.New("authorize").Parse(`<!DOCTYPE html>
+<html>
+<head><meta charset="utf-8"><title>Sign in</title></head>
+<body>
+{{if .ClientName}}
+<h1>Sign in to {{.ClientName}}</h1>
+{{if .Error}}<p role="alert">{{.Error}}</p>{{end}}
+<form method="post">
+{{range $key, $values := .Params}}{{range $values}}<input type="hidden" name="{{$key}}" value="{{.}}">{{end}}{{end}}
+{{if .ConsentToken}}<input type="hidden" name="consent_token" value="{{.ConsentToken}}">
+{{if .Scopes}}<p>{{.ClientName}} is asking to:</p>
+<ul>{{range .Scopes}}<li>{{.}}</li>{{end}}</ul>{{else}}<p>{{.ClientName}} is asking to know who you are</p>{{end}}
+<button type="submit" name="action" value="approve">Allow</button>
+<button type="submit" name="action" value="deny" formnovalidate>Deny</button>
+{{else}}{{if .MFAToken}}<input type="hidden" name="mfa_token" value="{{.MFAToken}}">
+<label>Two-factor code <input type="text" name="code" autocomplete="one-time-code"></label>
+{{else}}<label>Email <input type="email" name="email" autocomplete="username"></label>
+<label>Password <input type="password" name="password" autocomplete="current-password"></label>{{end}}
+<button type="submit" name="action" value="sign_in">Sign in</button>
+<button type="submit" name="action" value="deny" formnovalidate>Cancel</button>
+{{end}}</form>
+{{else}}
+<h1>Invalid request</h1>
+<p role="alert">{{.Error}}</p>
+{{end}}
+</body>
+</html>
+`))
+
+func renderAuthorizePage(w http.ResponseWriter, status int, page authorizePage) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(status)
+	if err := authorizeTemplate.Execute(w, page); err != nil {
+		log.Printf("Failed to render the authorization page %v", err)
+	}
+}
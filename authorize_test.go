@@ -0,0 +1,228 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/nguyenanhhao221/go-jwt/internal/auth"
+	"github.com/nguyenanhhao221/go-jwt/settings"
+)
+
+func TestValidateRedirectURI(t *testing.T) {
+	for redirectURI, valid := range map[string]bool{
+		"https://app.example.com/callback": true,
+		"http://localhost:3000/callback":   true,
+		"com.example.app:/callback":        true,
+		"http://app.example.com/callback":  false,
+		"https://app.example.com/#token":   false,
+		"/callback":                        false,
+	} {
+		if err := validateRedirectURI(redirectURI); (err == nil) != valid {
+			t.Errorf("expected %q valid to be %v but got %v", redirectURI, valid, err)
+		}
+	}
+}
+
+func TestAuthorizationCodeCI(t *testing.T) {
+	store, err := NewPostgresStore()
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := NewAPIServer("", store)
+	redirectURI := "https://app.example.com/callback"
+	client := &OAuthClient{
+		ID:            uuid.NewString(),
+		Name:          "Test App",
+		AllowedScopes: []string{auth.ScopeAccountsRead},
+		RedirectURIs:  []string{redirectURI},
+		Public:        true,
+		CreatedAt:     time.Now().UTC(),
+	}
+	if err := store.CreateOAuthClient(client); err != nil {
+		t.Fatal(err)
+	}
+	mockUser := NewAccount("Authorize First Name", "Authorize Last Name", "Authorize@email.com", "TestPassword")
+	accountId, err := store.CreateAccount(mockUser)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.DeleteAccountById(accountId)
+
+	verifier := strings.Repeat("v", 43)
+	authorizeParams := url.Values{
+		"client_id":             {client.ID},
+		"redirect_uri":          {redirectURI},
+		"response_type":         {"code"},
+		"state":                 {"xyz"},
+		"code_challenge":        {auth.S256CodeChallenge(verifier)},
+		"code_challenge_method": {auth.PKCEMethodS256},
+	}
+	authorize := func(form url.Values) *httptest.ResponseRecorder {
+		req, err := http.NewRequest(http.MethodPost, settings.AppSettings.Authorize_Route, strings.NewReader(form.Encode()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rr := httptest.NewRecorder()
+		http.HandlerFunc(server.handleAuthorize).ServeHTTP(rr, req)
+		return rr
+	}
+	signIn := func() *httptest.ResponseRecorder {
+		form := url.Values{"action": {"sign_in"}, "email": {mockUser.Email}, "password": {"TestPassword"}}
+		for key, values := range authorizeParams {
+			form[key] = values
+		}
+		return authorize(form)
+	}
+	codeFromRedirect := func(rr *httptest.ResponseRecorder) string {
+		if rr.Code != http.StatusFound {
+			t.Fatalf("expected status code %d but got %d: %s", http.StatusFound, rr.Code, rr.Body.String())
+		}
+		location, err := url.Parse(rr.Header().Get("Location"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if location.Query().Get("state") != "xyz" || location.Query().Get("code") == "" {
+			t.Fatalf("expected a redirect with the code and state but got %v", location)
+		}
+		return location.Query().Get("code")
+	}
+	// approve Sign in and, when asked, approve the scopes
+	approve := func() string {
+		rr := signIn()
+		if rr.Code != http.StatusOK {
+			return codeFromRedirect(rr)
+		}
+		match := regexp.MustCompile(`name="consent_token" value="([^"]+)"`).FindStringSubmatch(rr.Body.String())
+		if match == nil {
+			t.Fatalf("expected the page to ask for consent but got %s", rr.Body.String())
+		}
+		form := url.Values{"action": {"approve"}, "consent_token": {match[1]}}
+		for key, values := range authorizeParams {
+			form[key] = values
+		}
+		return codeFromRedirect(authorize(form))
+	}
+	requestToken := func(form url.Values) (*httptest.ResponseRecorder, OAuthTokenResponse) {
+		form.Set("client_id", client.ID)
+		req, err := http.NewRequest(http.MethodPost, settings.AppSettings.Token_Route, strings.NewReader(form.Encode()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rr := httptest.NewRecorder()
+		http.HandlerFunc(server.handleToken).ServeHTTP(rr, req)
+		var tokenRes OAuthTokenResponse
+		if rr.Code == http.StatusOK {
+			if err := json.Unmarshal(rr.Body.Bytes(), &tokenRes); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return rr, tokenRes
+	}
+
+	t.Run("InvalidRedirectURI", func(t *testing.T) {
+		form := url.Values{"client_id": {client.ID}, "redirect_uri": {"https://evil.example.com/callback"}, "response_type": {"code"}}
+		rr := authorize(form)
+		if rr.Code != http.StatusBadRequest || rr.Header().Get("Location") != "" {
+			t.Errorf("expected the error to be shown without redirect but got %d to %q", rr.Code, rr.Header().Get("Location"))
+		}
+	})
+
+	t.Run("MissingCodeChallenge", func(t *testing.T) {
+		form := url.Values{"client_id": {client.ID}, "redirect_uri": {redirectURI}, "response_type": {"code"}}
+		rr := authorize(form)
+		location, _ := url.Parse(rr.Header().Get("Location"))
+		if rr.Code != http.StatusFound || location.Query().Get("error") != "invalid_request" {
+			t.Errorf("expected a redirect with invalid_request but got %d to %v", rr.Code, location)
+		}
+	})
+
+	t.Run("Consent", func(t *testing.T) {
+		if rr := signIn(); rr.Code != http.StatusOK || !strings.Contains(rr.Body.String(), auth.ScopeAccountsRead) {
+			t.Fatalf("expected the page to ask for the scopes on the first sign in but got %d: %s", rr.Code, rr.Body.String())
+		}
+		approve()
+		// The scopes are granted, the next sign in goes straight back to the client
+		codeFromRedirect(signIn())
+	})
+
+	t.Run("ExchangeCode", func(t *testing.T) {
+		code := approve()
+		exchange := url.Values{"grant_type": {"authorization_code"}, "code": {code}, "redirect_uri": {redirectURI}, "code_verifier": {verifier}}
+		rr, tokenRes := requestToken(exchange)
+		if rr.Code != http.StatusOK {
+			t.Fatalf("expected status code %d but got %d: %s", http.StatusOK, rr.Code, rr.Body.String())
+		}
+		token, err := auth.ValidateJWT(tokenRes.AccessToken)
+		if err != nil {
+			t.Fatal(err)
+		}
+		claims := token.Claims.(*auth.CustomJWTClaims)
+		if claims.ID != accountId || claims.ClientID != client.ID || claims.Scope != auth.ScopeAccountsRead {
+			t.Errorf("expected a token of account %v for client %v but got %v", accountId, client.ID, claims)
+		}
+
+		rr, refreshed := requestToken(url.Values{"grant_type": {"refresh_token"}, "refresh_token": {tokenRes.RefreshToken}})
+		if rr.Code != http.StatusOK || refreshed.Scope != auth.ScopeAccountsRead {
+			t.Fatalf("expected the refresh token to keep the scope but got %d: %s", rr.Code, rr.Body.String())
+		}
+
+		// The code can only be exchanged once, a second exchange revokes the refresh tokens issued for it
+		if rr, _ := requestToken(exchange); rr.Code != http.StatusBadRequest {
+			t.Errorf("expected status code %d but got %d", http.StatusBadRequest, rr.Code)
+		}
+		if rr, _ := requestToken(url.Values{"grant_type": {"refresh_token"}, "refresh_token": {refreshed.RefreshToken}}); rr.Code != http.StatusBadRequest {
+			t.Errorf("expected the refresh token to be revoked but got %d", rr.Code)
+		}
+	})
+
+	t.Run("ClientRefreshTokenOnAccountRoute", func(t *testing.T) {
+		code := approve()
+		rr, tokenRes := requestToken(url.Values{"grant_type": {"authorization_code"}, "code": {code}, "redirect_uri": {redirectURI}, "code_verifier": {verifier}})
+		if rr.Code != http.StatusOK {
+			t.Fatalf("expected status code %d but got %d: %s", http.StatusOK, rr.Code, rr.Body.String())
+		}
+		// The account route doesn't authenticate the client, the refresh token of a client is refused there
+		reqBodyJSON, err := json.Marshal(map[string]string{"refreshToken": tokenRes.RefreshToken})
+		if err != nil {
+			t.Fatal(err)
+		}
+		req, err := http.NewRequest(http.MethodPost, settings.AppSettings.Refresh_Token_Route, bytes.NewBuffer(reqBodyJSON))
+		if err != nil {
+			t.Fatal(err)
+		}
+		accountRR := httptest.NewRecorder()
+		http.HandlerFunc(server.handleRefreshToken).ServeHTTP(accountRR, req)
+		if accountRR.Code != http.StatusUnauthorized {
+			t.Errorf("expected status code %d but got %d", http.StatusUnauthorized, accountRR.Code)
+		}
+		// It still works at the token endpoint
+		if rr, _ := requestToken(url.Values{"grant_type": {"refresh_token"}, "refresh_token": {tokenRes.RefreshToken}}); rr.Code != http.StatusOK {
+			t.Errorf("expected the refresh token to still work at the token endpoint but got %d: %s", rr.Code, rr.Body.String())
+		}
+	})
+
+	t.Run("WrongCodeVerifier", func(t *testing.T) {
+		code := approve()
+		rr, _ := requestToken(url.Values{"grant_type": {"authorization_code"}, "code": {code}, "redirect_uri": {redirectURI}, "code_verifier": {strings.Repeat("w", 43)}})
+		if rr.Code != http.StatusBadRequest {
+			t.Errorf("expected status code %d but got %d", http.StatusBadRequest, rr.Code)
+		}
+	})
+
+	t.Run("PublicClientCredentials", func(t *testing.T) {
+		rr, _ := requestToken(url.Values{"grant_type": {"client_credentials"}})
+		if rr.Code != http.StatusBadRequest {
+			t.Errorf("expected status code %d but got %d", http.StatusBadRequest, rr.Code)
+		}
+	})
+}
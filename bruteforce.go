@@ -0,0 +1,263 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Sign in throttling. After the free failures every new failure locks sign in for twice as long as the previous one,
+// up to maxSignInLockout. The count starts again once there was no failure for signInFailureWindow.
+// The client IP gets more free failures than an account, as many users can share an IP.
+// The failures of an account are counted on its email, so an unknown email is locked the same way and can't be told apart
+const (
+	signInFailureWindow        = time.Hour
+	accountFreeSignInFailures  = 5
+	clientIPFreeSignInFailures = 20
+	signInBackoffBase          = time.Second
+	maxSignInLockout           = 15 * time.Minute
+)
+
+var errWrongCredentials = errors.New("wrong email or password")
+
+// signInLockedError Returned while sign in is locked for the account or the client IP
+type signInLockedError struct {
+	retryAfter time.Duration
+}
+
+func (e *signInLockedError) Error() string {
+	return fmt.Sprintf("too many failed sign in attempts, retry in %v", e.retryAfter)
+}
+
+// signInBackoff How long sign in is locked after the failures in a row
+func signInBackoff(failures, freeFailures int) time.Duration {
+	if failures <= freeFailures {
+		return 0
+	}
+	// Past 2^20 seconds the lockout is capped anyway, stop there so the shift can't overflow
+	if exponent := failures - freeFailures - 1; exponent < 20 {
+		if backoff := signInBackoffBase << exponent; backoff < maxSignInLockout {
+			return backoff
+		}
+	}
+	return maxSignInLockout
+}
+
+// clientIP The IP of the client, the RealIP middleware already replaced RemoteAddr with X-Real-IP or X-Forwarded-For
+func clientIP(r *http.Request) string {
+	if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+		return host
+	}
+	return r.RemoteAddr
+}
+
+// signInThrottleKey A key failures are counted on, with the amount of free failures
+type signInThrottleKey struct {
+	key          string
+	freeFailures int
+}
+
+// emailThrottleKey The key the failures of the email are counted on, whether it belongs to an account or not
+func emailThrottleKey(email string) string {
+	return "email:" + strings.ToLower(strings.TrimSpace(email))
+}
+
+func signInThrottleKeys(r *http.Request, email string) []signInThrottleKey {
+	return []signInThrottleKey{
+		{"ip:" + clientIP(r), clientIPFreeSignInFailures},
+		{emailThrottleKey(email), accountFreeSignInFailures},
+	}
+}
+
+// checkSignInLockout Return a *signInLockedError while sign in is locked for the email or the client IP
+func (s *APIServer) checkSignInLockout(r *http.Request, email string) error {
+	var keys []string
+	for _, throttleKey := range signInThrottleKeys(r, email) {
+		keys = append(keys, throttleKey.key)
+	}
+	lockedUntil, err := s.store.GetSignInLockout(keys)
+	if err != nil {
+		return err
+	}
+	if retryAfter := time.Until(lockedUntil); retryAfter > 0 {
+		return &signInLockedError{retryAfter: retryAfter}
+	}
+	return nil
+}
+
+// signInThrottle A sign in attempt counted as a failure, for the email and the client IP, before its credentials are checked.
+// It must be ended with fail, succeed or cancel once they are
+type signInThrottle struct {
+	server *APIServer
+	r      *http.Request
+	// accountId The account of the email, uuid.Nil when it is unknown
+	accountId uuid.UUID
+	email     string
+	// failures The count each throttle key got with this attempt
+	failures map[signInThrottleKey]int
+}
+
+// startSignInThrottle Return a *signInLockedError while sign in is locked for the email or the client IP,
+// otherwise count the attempt as a failure and lock sign in when there were too many.
+// Counting before the credentials are checked stops concurrent attempts from all getting in before the first failure is recorded
+func (s *APIServer) startSignInThrottle(r *http.Request, accountId uuid.UUID, email string) (*signInThrottle, error) {
+	if err := s.checkSignInLockout(r, email); err != nil {
+		return nil, err
+	}
+	throttle := &signInThrottle{server: s, r: r, accountId: accountId, email: email, failures: map[signInThrottleKey]int{}}
+	now := time.Now().UTC()
+	for _, throttleKey := range signInThrottleKeys(r, email) {
+		freeFailures := throttleKey.freeFailures
+		failures, lockedUntil, err := s.store.CountSignInAttempt(throttleKey.key, now, now.Add(-signInFailureWindow), func(failures int) time.Duration {
+			return signInBackoff(failures, freeFailures)
+		})
+		if err != nil {
+			throttle.cancel()
+			if errors.Is(err, ErrSignInLocked) {
+				// Locked by a concurrent attempt since the check
+				return nil, &signInLockedError{retryAfter: time.Until(lockedUntil)}
+			}
+			return nil, err
+		}
+		throttle.failures[throttleKey] = failures
+	}
+	return throttle, nil
+}
+
+// fail The credentials were wrong, the attempt stays counted. An audit event is emitted when a lockout starts
+func (t *signInThrottle) fail() {
+	for throttleKey, failures := range t.failures {
+		if failures == throttleKey.freeFailures+1 {
+			emitAuditEvent(AuditEvent{
+				Type:      auditSignInLocked,
+				AccountID: auditAccount(t.accountId),
+				IP:        clientIP(t.r),
+				Details:   fmt.Sprintf("%s locked after %d failed attempts", throttleKey.key, failures),
+			})
+		}
+	}
+}
+
+// succeed The credentials were right, the failures of the email are forgotten and the attempt isn't counted for the client IP
+func (t *signInThrottle) succeed() {
+	t.server.clearSignInFailures(t.email)
+	for throttleKey, failures := range t.failures {
+		if strings.HasPrefix(throttleKey.key, "ip:") {
+			t.forgive(throttleKey, failures)
+		}
+	}
+}
+
+// cancel The credentials couldn't be checked, e.g. the hashing pool was overloaded, which isn't a failure of the client
+func (t *signInThrottle) cancel() {
+	for throttleKey, failures := range t.failures {
+		t.forgive(throttleKey, failures)
+	}
+}
+
+func (t *signInThrottle) forgive(throttleKey signInThrottleKey, failures int) {
+	if err := t.server.store.ForgiveSignInAttempt(throttleKey.key, failures); err != nil {
+		log.Printf("Failed to take back the sign in attempt of %v: %v", throttleKey.key, err)
+	}
+}
+
+// recordSignInSuccess Forget the failures of the account's email, for the sign ins which only know the account
+func (s *APIServer) recordSignInSuccess(accountId uuid.UUID) {
+	account, err := s.store.GetAccountById(accountId)
+	if err != nil {
+		log.Printf("Failed to clear sign in failures of account %v: %v", accountId, err)
+		return
+	}
+	s.clearSignInFailures(account.Email)
+}
+
+// clearSignInFailures Forget the failures of the email. The ones of the client IP are kept,
+// or an attacker could reset them by signing in to its own account
+func (s *APIServer) clearSignInFailures(email string) {
+	if err := s.store.ClearSignInFailures(emailThrottleKey(email)); err != nil {
+		log.Printf("Failed to clear sign in failures of %v: %v", email, err)
+	}
+}
+
+// checkPassword Authenticate the account with its email and password, throttled per email and client IP.
+// errWrongCredentials is returned for an unknown email or a wrong password, a *signInLockedError while locked,
+// and password.ErrOverloaded when the hashing pool is overloaded
+func (s *APIServer) checkPassword(r *http.Request, email, password string) (*Account, error) {
+	account, err := s.store.GetAccountByEmail(email)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return nil, err
+	}
+	accountId := uuid.Nil
+	if account != nil && err == nil {
+		accountId = account.ID
+	}
+	throttle, err := s.startSignInThrottle(r, accountId, email)
+	var lockedErr *signInLockedError
+	if errors.As(err, &lockedErr) {
+		s.recordSignInAttempt(r, accountId, signInLocked)
+		return nil, err
+	} else if err != nil {
+		return nil, err
+	}
+	if accountId == uuid.Nil {
+		// Spend the time of a verification anyway, so an unknown email can't be told apart from a wrong password
+		if err := s.hashing.VerifyUnknown(r.Context(), password); err != nil {
+			throttle.cancel()
+			return nil, err
+		}
+		throttle.fail()
+		return nil, errWrongCredentials
+	}
+	// An overloaded hashing pool isn't a failure of the client, it isn't counted
+	match, err := s.hashing.Verify(r.Context(), password, account.Password)
+	if err != nil {
+		throttle.cancel()
+		return nil, err
+	}
+	if !match {
+		throttle.fail()
+		s.recordSignInAttempt(r, accountId, signInWrongPassword)
+		return nil, errWrongCredentials
+	}
+	throttle.succeed()
+	s.rehashPasswordIfNeeded(r, account, password)
+	return account, nil
+}
+
+// rehashPasswordIfNeeded Upgrade the stored hash when it was created with outdated hashing parameters,
+// the plain password is only known right after it was checked. A failure is logged, the old hash still works
+func (s *APIServer) rehashPasswordIfNeeded(r *http.Request, account *Account, password string) {
+	if !s.hashing.NeedsRehash(account.Password) {
+		return
+	}
+	passwordHash, err := s.hashing.Hash(r.Context(), password)
+	if err != nil {
+		log.Printf("Failed to rehash the password of account %v: %v", account.ID, err)
+		return
+	}
+	if err := s.store.UpdateAccountPassword(account.ID, passwordHash); err != nil {
+		log.Printf("Failed to store the rehashed password of account %v: %v", account.ID, err)
+		return
+	}
+	account.Password = passwordHash
+	log.Printf("Upgraded the password hash of account %v", account.ID)
+}
+
+// writeTooManyAttempts Answer 429 with the seconds to wait in Retry-After
+func writeTooManyAttempts(w http.ResponseWriter, lockedErr *signInLockedError) {
+	setRetryAfter(w, lockedErr)
+	WriteErrorJson(w, http.StatusTooManyRequests, "Too many failed attempts, try again later")
+}
+
+func setRetryAfter(w http.ResponseWriter, lockedErr *signInLockedError) {
+	w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(lockedErr.retryAfter.Seconds()))))
+}
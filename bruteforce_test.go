@@ -0,0 +1,166 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/nguyenanhhao221/go-jwt/settings"
+)
+
+func TestSignInBackoff(t *testing.T) {
+	tests := []struct {
+		failures int
+		expected time.Duration
+	}{
+		{accountFreeSignInFailures, 0},
+		{accountFreeSignInFailures + 1, signInBackoffBase},
+		{accountFreeSignInFailures + 2, 2 * signInBackoffBase},
+		{accountFreeSignInFailures + 4, 8 * signInBackoffBase},
+		{accountFreeSignInFailures + 100, maxSignInLockout},
+	}
+	for _, test := range tests {
+		if backoff := signInBackoff(test.failures, accountFreeSignInFailures); backoff != test.expected {
+			t.Errorf("expected a backoff of %v after %d failures but got %v", test.expected, test.failures, backoff)
+		}
+	}
+}
+
+func TestSignInLockoutCI(t *testing.T) {
+	store, err := NewPostgresStore()
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := NewAPIServer("", store)
+	mockUser := NewAccount("Lockout First Name", "Lockout Last Name", "Lockout@email.com", "TestPassword")
+	accountId, err := store.CreateAccount(mockUser)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.DeleteAccountById(accountId)
+	remoteAddr := "192.0.2.14:1234"
+	defer store.ClearSignInFailures("ip:192.0.2.14")
+	defer store.ClearSignInFailures(emailThrottleKey(mockUser.Email))
+
+	signIn := func(password string) *httptest.ResponseRecorder {
+		reqBodyJSON, err := json.Marshal(map[string]string{"email": mockUser.Email, "password": password})
+		if err != nil {
+			t.Fatal(err)
+		}
+		req, err := http.NewRequest(http.MethodPost, settings.AppSettings.SignIn_Account_Route, bytes.NewBuffer(reqBodyJSON))
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.RemoteAddr = remoteAddr
+		rr := httptest.NewRecorder()
+		http.HandlerFunc(server.handleSignIn).ServeHTTP(rr, req)
+		return rr
+	}
+
+	for i := 0; i <= accountFreeSignInFailures; i++ {
+		if rr := signIn("WrongPassword"); rr.Code != http.StatusUnauthorized {
+			t.Fatalf("expected status code %d for failure %d but got %d", http.StatusUnauthorized, i+1, rr.Code)
+		}
+	}
+	// Even the right password is refused while locked
+	rr := signIn("TestPassword")
+	if rr.Code != http.StatusTooManyRequests {
+		t.Fatalf("expected status code %d but got %d", http.StatusTooManyRequests, rr.Code)
+	}
+	if rr.Header().Get("Retry-After") == "" {
+		t.Error("expected a Retry-After header")
+	}
+
+	time.Sleep(signInBackoffBase + 100*time.Millisecond)
+	if rr := signIn("TestPassword"); rr.Code != http.StatusOK {
+		t.Errorf("expected the lockout to end after the backoff but got %d", rr.Code)
+	}
+}
+
+// TestSignInLockoutUnknownEmailCI An unknown email must be locked like the email of an account, or the lockout would tell it exists
+func TestSignInLockoutUnknownEmailCI(t *testing.T) {
+	store, err := NewPostgresStore()
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := NewAPIServer("", store)
+	mockUser := NewAccount("Known Lockout First Name", "Known Lockout Last Name", "KnownLockout@email.com", "TestPassword")
+	accountId, err := store.CreateAccount(mockUser)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.DeleteAccountById(accountId)
+	unknownEmail := "UnknownLockout@email.com"
+	defer store.ClearSignInFailures("ip:192.0.2.16")
+	defer store.ClearSignInFailures("ip:192.0.2.17")
+	defer store.ClearSignInFailures(emailThrottleKey(mockUser.Email))
+	defer store.ClearSignInFailures(emailThrottleKey(unknownEmail))
+
+	signIn := func(email, remoteAddr string) int {
+		reqBodyJSON, _ := json.Marshal(map[string]string{"email": email, "password": "WrongPassword"})
+		req := httptest.NewRequest(http.MethodPost, settings.AppSettings.SignIn_Account_Route, bytes.NewBuffer(reqBodyJSON))
+		req.RemoteAddr = remoteAddr
+		rr := httptest.NewRecorder()
+		http.HandlerFunc(server.handleSignIn).ServeHTTP(rr, req)
+		return rr.Code
+	}
+
+	for i := 0; i <= accountFreeSignInFailures+1; i++ {
+		known := signIn(mockUser.Email, "192.0.2.16:1234")
+		unknown := signIn(unknownEmail, "192.0.2.17:1234")
+		if known != unknown {
+			t.Errorf("expected the same status code for attempt %d but got %d for a known email and %d for an unknown one", i+1, known, unknown)
+		}
+	}
+	if code := signIn(unknownEmail, "192.0.2.17:1234"); code != http.StatusTooManyRequests {
+		t.Errorf("expected the unknown email to be locked with %d but got %d", http.StatusTooManyRequests, code)
+	}
+}
+
+func TestSignInLockoutConcurrentCI(t *testing.T) {
+	store, err := NewPostgresStore()
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := NewAPIServer("", store)
+	mockUser := NewAccount("Concurrent Lockout First Name", "Concurrent Lockout Last Name", "ConcurrentLockout@email.com", "TestPassword")
+	accountId, err := store.CreateAccount(mockUser)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.DeleteAccountById(accountId)
+	defer store.ClearSignInFailures("ip:192.0.2.15")
+	defer store.ClearSignInFailures(emailThrottleKey(mockUser.Email))
+
+	// Every attempt starts before any failure is known, only the free ones and the one starting the lockout get to check the password
+	attempts := 3 * accountFreeSignInFailures
+	codes := make(chan int, attempts)
+	var wg sync.WaitGroup
+	for i := 0; i < attempts; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			reqBodyJSON, _ := json.Marshal(map[string]string{"email": mockUser.Email, "password": "WrongPassword"})
+			req := httptest.NewRequest(http.MethodPost, settings.AppSettings.SignIn_Account_Route, bytes.NewBuffer(reqBodyJSON))
+			req.RemoteAddr = "192.0.2.15:1234"
+			rr := httptest.NewRecorder()
+			http.HandlerFunc(server.handleSignIn).ServeHTTP(rr, req)
+			codes <- rr.Code
+		}()
+	}
+	wg.Wait()
+	close(codes)
+	checked := 0
+	for code := range codes {
+		if code == http.StatusUnauthorized {
+			checked++
+		}
+	}
+	if checked > accountFreeSignInFailures+1 {
+		t.Errorf("expected at most %d passwords to be checked but got %d", accountFreeSignInFailures+1, checked)
+	}
+}
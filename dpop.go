@@ -0,0 +1,190 @@
+package main
+
+import (
+	"container/heap"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nguyenanhhao221/go-jwt/internal/auth"
+)
+
+const dpopJKTContextKey contextKey = "dpop_jkt"
+
+// dpopJKTFromContext The thumbprint of the DPoP key proven by withDPoPProof, empty when the request had no proof
+func dpopJKTFromContext(ctx context.Context) string {
+	jkt, _ := ctx.Value(dpopJKTContextKey).(string)
+	return jkt
+}
+
+var (
+	errDPoPProofReplayed = fmt.Errorf("%w: jti already used", auth.ErrInvalidDPoPProof)
+	// errDPoPReplayCacheFull The proof can't be remembered, accepting it would let it be replayed
+	errDPoPReplayCacheFull = fmt.Errorf("%w: too many recent proofs", auth.ErrInvalidDPoPProof)
+	errDPoPKeyMismatch     = fmt.Errorf("%w: signed with another key than the one the token is bound to", auth.ErrInvalidDPoPProof)
+	errDPoPTokenUnbound    = errors.New("the DPoP scheme can only be used with a token bound to a DPoP key")
+	errDPoPSchemeMissing   = errors.New("the token is bound to a DPoP key and must be sent with the DPoP scheme")
+)
+
+// maxDPoPReplayCacheEntries Bound the memory of a replay cache. Proofs can be made with any key at the endpoints issuing tokens,
+// without a bound a flood of them would grow the cache until the server runs out of memory
+const maxDPoPReplayCacheEntries = 100_000
+
+// dpopReplayCache The jti of the DPoP proofs accepted recently, a proof is only accepted once.
+// A jti is kept until its proof is too old to be accepted anyway, see auth.DPoPProofMaxAge.
+// The cache lives in the memory of each instance, behind a load balancer a proof can be replayed once on every other instance
+type dpopReplayCache struct {
+	mu   sync.Mutex
+	seen map[string]time.Time
+	// expiries The keys of seen ordered by expiry, so expired proofs are pruned without scanning the whole cache
+	expiries   dpopExpiryHeap
+	maxEntries int
+	// evict When the cache is full, forget the proofs closest to their expiry instead of refusing new ones
+	evict bool
+}
+
+// newDPoPReplayCache The cache for the proofs sent with access tokens refuses new proofs when it is full, only the holders
+// of bound tokens can fill it. The cache for the endpoints issuing tokens is open to anyone, so it evicts the oldest proofs
+// instead: a proof replayed there still needs the credentials of the grant, e.g. a refresh token which can only be used once
+func newDPoPReplayCache(evict bool) *dpopReplayCache {
+	return &dpopReplayCache{seen: map[string]time.Time{}, maxEntries: maxDPoPReplayCacheEntries, evict: evict}
+}
+
+// use Remember the jti of a proof signed by the key of the thumbprint. errDPoPProofReplayed is returned if it was already used,
+// and errDPoPReplayCacheFull when a cache which doesn't evict is full of proofs that can still be used
+func (c *dpopReplayCache) use(jkt, jti string, expiresAt, now time.Time) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for len(c.expiries) > 0 && now.After(c.expiries[0].expiresAt) {
+		c.forget(heap.Pop(&c.expiries).(dpopExpiry))
+	}
+	key := jkt + "." + jti
+	if _, ok := c.seen[key]; ok {
+		return errDPoPProofReplayed
+	}
+	if len(c.seen) >= c.maxEntries {
+		if !c.evict {
+			return errDPoPReplayCacheFull
+		}
+		c.forget(heap.Pop(&c.expiries).(dpopExpiry))
+	}
+	c.seen[key] = expiresAt
+	heap.Push(&c.expiries, dpopExpiry{key: key, expiresAt: expiresAt})
+	return nil
+}
+
+// forget Remove a proof popped from the expiries
+func (c *dpopReplayCache) forget(expiry dpopExpiry) {
+	if expiresAt, ok := c.seen[expiry.key]; ok && expiresAt.Equal(expiry.expiresAt) {
+		delete(c.seen, expiry.key)
+	}
+}
+
+type dpopExpiry struct {
+	key       string
+	expiresAt time.Time
+}
+
+// dpopExpiryHeap A container/heap of the proofs, the first one expires first
+type dpopExpiryHeap []dpopExpiry
+
+func (h dpopExpiryHeap) Len() int           { return len(h) }
+func (h dpopExpiryHeap) Less(i, j int) bool { return h[i].expiresAt.Before(h[j].expiresAt) }
+func (h dpopExpiryHeap) Swap(i, j int)      { h[i], h[j] = h[j], h[i] }
+func (h *dpopExpiryHeap) Push(x any)        { *h = append(*h, x.(dpopExpiry)) }
+func (h *dpopExpiryHeap) Pop() any {
+	old := *h
+	expiry := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return expiry
+}
+
+// verifyDPoPProof Check the proof in the DPoP header was made for this request and wasn't used before.
+// accessToken is the token the proof is sent with, empty at the endpoints issuing tokens. Both kinds of proofs are kept
+// in their own cache, so a flood of proofs at the endpoints issuing tokens can't refuse the proofs of bound tokens.
+// Nil is returned without error when the request has no proof
+func (s *APIServer) verifyDPoPProof(r *http.Request, accessToken string) (*auth.DPoPProof, error) {
+	proofs := r.Header.Values("DPoP")
+	if len(proofs) == 0 {
+		return nil, nil
+	}
+	if len(proofs) > 1 {
+		return nil, fmt.Errorf("%w: only one proof can be sent", auth.ErrInvalidDPoPProof)
+	}
+	now := time.Now()
+	proof, err := auth.VerifyDPoPProof(proofs[0], r.Method, publicBaseURL(r)+r.URL.Path, accessToken, now)
+	if err != nil {
+		return nil, err
+	}
+	cache := s.dpopProofs
+	if accessToken == "" {
+		cache = s.dpopIssuanceProofs
+	}
+	if err := cache.use(proof.JKT, proof.JTI, proof.IssuedAt.Add(auth.DPoPProofMaxAge()+auth.Leeway()), now); err != nil {
+		return nil, err
+	}
+	return proof, nil
+}
+
+// withDPoPProof Middleware for the endpoints issuing tokens, a valid DPoP proof binds the issued tokens to its key.
+// Requests without a proof get bearer tokens, see dpopJKTFromContext
+func (s *APIServer) withDPoPProof(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		proof, err := s.verifyDPoPProof(r, "")
+		if err != nil {
+			log.Printf("Invalid DPoP proof: %v", err)
+			WriteOAuthError(w, http.StatusBadRequest, "invalid_dpop_proof", "Invalid DPoP proof")
+			return
+		}
+		if proof == nil {
+			next(w, r)
+			return
+		}
+		next(w, r.WithContext(context.WithValue(r.Context(), dpopJKTContextKey, proof.JKT)))
+	}
+}
+
+// usesDPoPScheme Report if the access token was sent with the DPoP scheme of the Authorization header
+func usesDPoPScheme(r *http.Request) bool {
+	scheme, _, _ := strings.Cut(r.Header.Get("Authorization"), " ")
+	return strings.EqualFold(scheme, auth.DPoPTokenType)
+}
+
+// checkDPoPBinding Check a token bound to a DPoP key is sent with the DPoP scheme and a proof of that key (RFC 9449 section 7.1).
+// Bearer tokens must not be sent with the DPoP scheme
+func (s *APIServer) checkDPoPBinding(r *http.Request, accessToken string, claims *auth.CustomJWTClaims) error {
+	jkt := claims.DPoPJKT()
+	if jkt == "" {
+		if usesDPoPScheme(r) {
+			return errDPoPTokenUnbound
+		}
+		return nil
+	}
+	if !usesDPoPScheme(r) {
+		return errDPoPSchemeMissing
+	}
+	proof, err := s.verifyDPoPProof(r, accessToken)
+	if err != nil {
+		return err
+	}
+	if proof == nil {
+		return fmt.Errorf("%w: missing DPoP header", auth.ErrInvalidDPoPProof)
+	}
+	if proof.JKT != jkt {
+		return errDPoPKeyMismatch
+	}
+	return nil
+}
+
+// writeDPoPChallenge Answer with a RFC 9449 WWW-Authenticate challenge, telling the algorithms proofs can be signed with
+func writeDPoPChallenge(w http.ResponseWriter, statusCode int, errorCode, description string) {
+	challenge := fmt.Sprintf("%s realm=%q, error=%q, error_description=%q, algs=%q",
+		auth.DPoPTokenType, authRealm, errorCode, description, strings.Join(auth.DPoPSigningAlgs(), " "))
+	w.Header().Set("WWW-Authenticate", challenge)
+	WriteErrorJson(w, statusCode, description)
+}
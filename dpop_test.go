@@ -0,0 +1,229 @@
+package main
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt"
+	"github.com/google/uuid"
+	"github.com/nguyenanhhao221/go-jwt/internal/auth"
+)
+
+const testDPoPIssuer = "https://auth.example.com"
+
+// newDPoPProof Sign a DPoP proof for the request with the key, ath is set when an access token is given
+func newDPoPProof(t *testing.T, privateKey crypto.PrivateKey, method, uri, accessToken string) string {
+	t.Helper()
+	key, err := auth.NewSigningKey("", privateKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	jwk, err := key.PublicJWK()
+	if err != nil {
+		t.Fatal(err)
+	}
+	claims := jwt.MapClaims{"jti": uuid.NewString(), "htm": method, "htu": uri, "iat": time.Now().Unix()}
+	if accessToken != "" {
+		claims["ath"] = auth.AccessTokenHash(accessToken)
+	}
+	token := jwt.NewWithClaims(key.Method, claims)
+	token.Header["typ"] = "dpop+jwt"
+	token.Header["jwk"] = jwk
+	proof, err := token.SignedString(privateKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return proof
+}
+
+func dpopThumbprint(t *testing.T, privateKey crypto.PrivateKey) string {
+	t.Helper()
+	key, err := auth.NewSigningKey("", privateKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	jkt, err := key.Thumbprint()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return jkt
+}
+
+func TestDPoPMiddleware(t *testing.T) {
+	auth.SetKeySet(auth.NewKeySet(auth.NewHMACSigningKey("test", []byte("test-secret"))))
+	t.Cleanup(func() { auth.SetKeySet(nil) })
+	t.Setenv("JWT_ISSUER", testDPoPIssuer)
+	server := NewAPIServer("", nil)
+
+	dpopKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	accountId := uuid.New()
+	boundToken, err := auth.SignJWT(&auth.CustomJWTClaims{ID: accountId, Confirmation: auth.NewConfirmation(dpopThumbprint(t, dpopKey))})
+	if err != nil {
+		t.Fatal(err)
+	}
+	bearerToken, err := auth.CreateJWT(accountId, auth.RoleCustomer)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	const path = "/v1/account/sessions"
+	handler := server.withJWTAuth(func(w http.ResponseWriter, r *http.Request) {
+		WriteJSON(w, http.StatusOK, nil)
+	})
+	call := func(authorization, proof string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, testDPoPIssuer+path, nil)
+		req.Header.Set("Authorization", authorization)
+		if proof != "" {
+			req.Header.Set("DPoP", proof)
+		}
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		return rr
+	}
+
+	t.Run("BoundTokenWithProof", func(t *testing.T) {
+		proof := newDPoPProof(t, dpopKey, http.MethodGet, testDPoPIssuer+path, boundToken)
+		if rr := call("DPoP "+boundToken, proof); rr.Code != http.StatusOK {
+			t.Fatalf("expected status code %d but got %d", http.StatusOK, rr.Code)
+		}
+		rr := call("DPoP "+boundToken, proof)
+		if rr.Code != http.StatusUnauthorized {
+			t.Errorf("expected a replayed proof to be refused with %d but got %d", http.StatusUnauthorized, rr.Code)
+		}
+		if challenge := rr.Header().Get("WWW-Authenticate"); !strings.HasPrefix(challenge, `DPoP realm="go-jwt", error="invalid_dpop_proof"`) {
+			t.Errorf("expected a DPoP challenge but got %s", challenge)
+		}
+	})
+
+	t.Run("IssuanceFlood", func(t *testing.T) {
+		// Proofs flooding the endpoints issuing tokens must not refuse the proofs of bound tokens
+		server.dpopIssuanceProofs.maxEntries = 2
+		server.dpopProofs.maxEntries = 2
+		t.Cleanup(func() {
+			server.dpopIssuanceProofs.maxEntries = maxDPoPReplayCacheEntries
+			server.dpopProofs.maxEntries = maxDPoPReplayCacheEntries
+		})
+		for i := 0; i < 4; i++ {
+			req := httptest.NewRequest(http.MethodPost, testDPoPIssuer+"/oauth/token", nil)
+			req.Header.Set("DPoP", newDPoPProof(t, otherKey, http.MethodPost, testDPoPIssuer+"/oauth/token", ""))
+			if _, err := server.verifyDPoPProof(req, ""); err != nil {
+				t.Fatalf("expected the issuance cache to accept proof %d but got %v", i, err)
+			}
+		}
+		proof := newDPoPProof(t, dpopKey, http.MethodGet, testDPoPIssuer+path, boundToken)
+		if rr := call("DPoP "+boundToken, proof); rr.Code != http.StatusOK {
+			t.Errorf("expected status code %d but got %d", http.StatusOK, rr.Code)
+		}
+	})
+
+	tests := []struct {
+		name          string
+		authorization string
+		proof         string
+	}{
+		{"BoundTokenAsBearer", "Bearer " + boundToken, newDPoPProof(t, dpopKey, http.MethodGet, testDPoPIssuer+path, boundToken)},
+		{"BoundTokenWithoutProof", "DPoP " + boundToken, ""},
+		{"ProofOfAnotherKey", "DPoP " + boundToken, newDPoPProof(t, otherKey, http.MethodGet, testDPoPIssuer+path, boundToken)},
+		{"ProofForAnotherRequest", "DPoP " + boundToken, newDPoPProof(t, dpopKey, http.MethodPost, testDPoPIssuer+path, boundToken)},
+		{"ProofWithoutAccessTokenHash", "DPoP " + boundToken, newDPoPProof(t, dpopKey, http.MethodGet, testDPoPIssuer+path, "")},
+		{"BearerTokenWithDPoPScheme", "DPoP " + bearerToken, newDPoPProof(t, dpopKey, http.MethodGet, testDPoPIssuer+path, bearerToken)},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if rr := call(test.authorization, test.proof); rr.Code != http.StatusUnauthorized {
+				t.Errorf("expected status code %d but got %d", http.StatusUnauthorized, rr.Code)
+			}
+		})
+	}
+
+	t.Run("BearerToken", func(t *testing.T) {
+		if rr := call("Bearer "+bearerToken, ""); rr.Code != http.StatusOK {
+			t.Errorf("expected status code %d but got %d", http.StatusOK, rr.Code)
+		}
+	})
+
+	t.Run("TokenEndpoint", func(t *testing.T) {
+		var jkt string
+		tokenHandler := server.withDPoPProof(func(w http.ResponseWriter, r *http.Request) {
+			jkt = dpopJKTFromContext(r.Context())
+			WriteJSON(w, http.StatusOK, nil)
+		})
+		const tokenPath = "/oauth/token"
+		req := httptest.NewRequest(http.MethodPost, testDPoPIssuer+tokenPath, nil)
+		req.Header.Set("DPoP", newDPoPProof(t, dpopKey, http.MethodPost, testDPoPIssuer+tokenPath, ""))
+		rr := httptest.NewRecorder()
+		tokenHandler.ServeHTTP(rr, req)
+		if rr.Code != http.StatusOK || jkt != dpopThumbprint(t, dpopKey) {
+			t.Errorf("expected the thumbprint of the proof key but got %d %q", rr.Code, jkt)
+		}
+
+		req = httptest.NewRequest(http.MethodPost, testDPoPIssuer+tokenPath, nil)
+		req.Header.Set("DPoP", "not-a-proof")
+		rr = httptest.NewRecorder()
+		tokenHandler.ServeHTTP(rr, req)
+		if rr.Code != http.StatusBadRequest || !strings.Contains(rr.Body.String(), "invalid_dpop_proof") {
+			t.Errorf("expected an invalid_dpop_proof error but got %d %s", rr.Code, rr.Body.String())
+		}
+	})
+}
+
+func TestDPoPReplayCache(t *testing.T) {
+	cache := newDPoPReplayCache(false)
+	cache.maxEntries = 2
+	now := time.Now()
+	if err := cache.use("jkt", "a", now.Add(time.Minute), now); err != nil {
+		t.Fatal(err)
+	}
+	if err := cache.use("jkt", "a", now.Add(time.Minute), now); !errors.Is(err, errDPoPProofReplayed) {
+		t.Errorf("expected %v but got %v", errDPoPProofReplayed, err)
+	}
+	if err := cache.use("jkt", "b", now.Add(time.Second), now); err != nil {
+		t.Fatal(err)
+	}
+	if err := cache.use("jkt", "c", now.Add(time.Minute), now); !errors.Is(err, errDPoPReplayCacheFull) {
+		t.Errorf("expected a full cache to refuse new proofs but got %v", err)
+	}
+	// Once a proof expired its place is taken by a new one
+	later := now.Add(2 * time.Second)
+	if err := cache.use("jkt", "c", later.Add(time.Minute), later); err != nil {
+		t.Errorf("expected the expired proof to be pruned but got %v", err)
+	}
+	if len(cache.seen) != 2 || len(cache.expiries) != 2 {
+		t.Errorf("expected 2 proofs in the cache but got %d seen and %d expiries", len(cache.seen), len(cache.expiries))
+	}
+
+	t.Run("Evict", func(t *testing.T) {
+		cache := newDPoPReplayCache(true)
+		cache.maxEntries = 2
+		if err := cache.use("jkt", "a", now.Add(time.Minute), now); err != nil {
+			t.Fatal(err)
+		}
+		if err := cache.use("jkt", "b", now.Add(time.Second), now); err != nil {
+			t.Fatal(err)
+		}
+		if err := cache.use("jkt", "c", now.Add(time.Minute), now); err != nil {
+			t.Errorf("expected a full cache to evict the oldest proof but got %v", err)
+		}
+		if _, ok := cache.seen["jkt.b"]; ok {
+			t.Error("expected the proof closest to its expiry to be evicted")
+		}
+		if err := cache.use("jkt", "a", now.Add(time.Minute), now); !errors.Is(err, errDPoPProofReplayed) {
+			t.Errorf("expected %v but got %v", errDPoPProofReplayed, err)
+		}
+	})
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/nguyenanhhao221/go-jwt/internal/auth"
+	"github.com/nguyenanhhao221/go-jwt/internal/mail"
+	"github.com/nguyenanhhao221/go-jwt/settings"
+)
+
+// verifiedEmailRoutes The v1 routes only accounts with a verified email can use, configured with VERIFIED_EMAIL_ROUTES
+// as a comma separated list of routes, e.g. "/transfer,/account/{accountId}". Transfers by default, empty for none
+func verifiedEmailRoutes() []string {
+	value, exist := os.LookupEnv("VERIFIED_EMAIL_ROUTES")
+	if !exist {
+		return []string{settings.AppSettings.Transfer_Route}
+	}
+	var routes []string
+	for _, route := range strings.Split(value, ",") {
+		if route = strings.TrimSpace(route); route != "" {
+			routes = append(routes, route)
+		}
+	}
+	return routes
+}
+
+// withVerifiedEmail Middleware to refuse accounts without a verified email on the route when it is one of the
+// verifiedEmailRoutes, must be used after withJWTAuth. Machine principals have no email and are let through
+func (s *APIServer) withVerifiedEmail(route string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		claims, ok := claimsFromContext(r.Context())
+		if !ok {
+			WriteErrorJson(w, http.StatusUnauthorized, "Permission Denied")
+			return
+		}
+		if claims.IsMachine() || !containsString(verifiedEmailRoutes(), route) {
+			next(w, r)
+			return
+		}
+		account, err := s.store.GetAccountById(claims.ID)
+		if err != nil {
+			WriteErrorJson(w, http.StatusUnauthorized, "Permission Denied")
+			return
+		}
+		if !account.EmailVerified {
+			log.Printf("Account %v without a verified email denied access to %v", claims.ID, r.URL.Path)
+			WriteErrorJson(w, http.StatusForbidden, "The email of the account must be verified first")
+			return
+		}
+		next(w, r)
+	}
+}
+
+// emailVerificationURL The page of the frontend that confirms the email with the token, configured with EMAIL_VERIFICATION_URL
+func emailVerificationURL(r *http.Request, token string) string {
+	return frontendLink(r, "EMAIL_VERIFICATION_URL", "/verify-email", token)
+}
+
+// sendEmailVerification Create a verification token for the account and send the link to its email
+func (s *APIServer) sendEmailVerification(r *http.Request, accountId uuid.UUID, email, firstName string) error {
+	token, tokenHash, err := auth.NewEmailVerificationToken()
+	if err != nil {
+		return err
+	}
+	ttl := auth.EmailVerificationTTL()
+	if err := s.store.CreateEmailVerificationToken(NewEmailVerificationToken(accountId, tokenHash, ttl)); err != nil {
+		return err
+	}
+	return s.mailer.Send(mail.Message{
+		To:      email,
+		Subject: "Verify your email",
+		Body: fmt.Sprintf(
+			"Hi %s,\n\nOpen this link in the next %v to verify your email:\n\n%s\n\n"+
+				"If you didn't create an account, you can ignore this email.\n",
+			firstName, ttl, emailVerificationURL(r, token),
+		),
+	})
+}
+
+// handleResendEmailVerification Send a new verification link to the email of the account of the token
+func (s *APIServer) handleResendEmailVerification(w http.ResponseWriter, r *http.Request) {
+	claims, _ := claimsFromContext(r.Context())
+	account, err := s.store.GetAccountById(claims.ID)
+	if err != nil {
+		WriteErrorJson(w, http.StatusNotFound, err.Error())
+		return
+	}
+	if account.EmailVerified {
+		WriteErrorJson(w, http.StatusConflict, "The email is already verified")
+		return
+	}
+	if err := s.sendEmailVerification(r, account.ID, account.Email, account.FirstName); err != nil {
+		log.Printf("Failed to send the verification email of account %v: %v", account.ID, err)
+		WriteErrorJson(w, http.StatusInternalServerError, "Failed to send the verification email")
+		return
+	}
+	WriteJSON(w, http.StatusAccepted, "A verification link was sent to the email of the account")
+}
+
+// handleConfirmEmailVerification Mark the email of the account as verified with the token from the verification link.
+// No access token is needed, the link is often opened on another device than the one signed in
+func (s *APIServer) handleConfirmEmailVerification(w http.ResponseWriter, r *http.Request) {
+	type ConfirmEmailVerificationReqBody struct {
+		Token string `json:"token" validate:"required"`
+	}
+	confirmEmailVerificationReqBody := new(ConfirmEmailVerificationReqBody)
+	if err := json.NewDecoder(r.Body).Decode(confirmEmailVerificationReqBody); err != nil {
+		WriteErrorJson(w, http.StatusForbidden, err.Error())
+		return
+	}
+	if confirmEmailVerificationReqBody.Token == "" {
+		WriteErrorJson(w, http.StatusBadRequest, "A token is required")
+		return
+	}
+
+	accountId, err := s.store.VerifyEmail(auth.HashEmailVerificationToken(confirmEmailVerificationReqBody.Token))
+	if errors.Is(err, sql.ErrNoRows) {
+		WriteErrorJson(w, http.StatusBadRequest, "Invalid or expired email verification token")
+		return
+	} else if err != nil {
+		WriteErrorJson(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	emitAuditEvent(AuditEvent{
+		Type:      auditEmailVerified,
+		AccountID: auditAccount(accountId),
+		IP:        clientIP(r),
+	})
+	WriteJSON(w, http.StatusNoContent, nil)
+}
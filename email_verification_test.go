@@ -0,0 +1,141 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"regexp"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/nguyenanhhao221/go-jwt/internal/auth"
+	"github.com/nguyenanhhao221/go-jwt/internal/mail"
+	"github.com/nguyenanhhao221/go-jwt/settings"
+)
+
+func TestVerifiedEmailRoutes(t *testing.T) {
+	if routes := verifiedEmailRoutes(); !containsString(routes, settings.AppSettings.Transfer_Route) {
+		t.Errorf("expected transfers to need a verified email by default but got %v", routes)
+	}
+	t.Setenv("VERIFIED_EMAIL_ROUTES", " /transfer , /account/{accountId}")
+	if routes := verifiedEmailRoutes(); len(routes) != 2 || routes[1] != settings.AppSettings.Account_Route {
+		t.Errorf("expected the configured routes but got %v", routes)
+	}
+	t.Setenv("VERIFIED_EMAIL_ROUTES", "")
+	if routes := verifiedEmailRoutes(); len(routes) != 0 {
+		t.Errorf("expected no route to need a verified email but got %v", routes)
+	}
+}
+
+func TestEmailVerificationCI(t *testing.T) {
+	store, err := NewPostgresStore()
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := NewAPIServer("", store)
+	mailDir := t.TempDir()
+	server.mailer = &mail.FileSender{Dir: mailDir}
+
+	request := func(handler http.HandlerFunc, route string, body any, claims *auth.CustomJWTClaims) *httptest.ResponseRecorder {
+		reqBodyJSON, err := json.Marshal(body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req, err := http.NewRequest(http.MethodPost, route, bytes.NewBuffer(reqBodyJSON))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if claims != nil {
+			req = req.WithContext(context.WithValue(req.Context(), claimsContextKey, claims))
+		}
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		return rr
+	}
+	// sentTokens The verification tokens in the emails written by the file sender
+	sentTokens := func() []string {
+		files, err := os.ReadDir(mailDir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var tokens []string
+		for _, file := range files {
+			content, err := os.ReadFile(filepath.Join(mailDir, file.Name()))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if match := regexp.MustCompile(`token=([A-Za-z0-9_-]+)`).FindSubmatch(content); match != nil {
+				tokens = append(tokens, string(match[1]))
+			}
+		}
+		return tokens
+	}
+
+	mockUser := &CreateAccountRequest{FirstName: "Verify First Name", LastName: "Verify Last Name", Email: "Verify@email.com", Password: "TestPassword"}
+	rr := request(server.handleCreateAccount, settings.AppSettings.Create_Account_Route, mockUser, nil)
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("expected status code %d but got %d", http.StatusCreated, rr.Code)
+	}
+	var createAccountResponse struct {
+		ID uuid.UUID `json:"id"`
+	}
+	if err := json.Unmarshal(rr.Body.Bytes(), &createAccountResponse); err != nil {
+		t.Fatal(err)
+	}
+	accountId := createAccountResponse.ID
+	defer store.DeleteAccountById(accountId)
+	claims := &auth.CustomJWTClaims{ID: accountId, Role: auth.RoleCustomer}
+
+	transfer := func() *httptest.ResponseRecorder {
+		ok := func(w http.ResponseWriter, r *http.Request) { WriteJSON(w, http.StatusOK, nil) }
+		return request(server.withVerifiedEmail(settings.AppSettings.Transfer_Route, ok), settings.AppSettings.Transfer_Route, nil, claims)
+	}
+
+	t.Run("UnverifiedEmail", func(t *testing.T) {
+		if rr := transfer(); rr.Code != http.StatusForbidden {
+			t.Errorf("expected status code %d but got %d", http.StatusForbidden, rr.Code)
+		}
+		if tokens := sentTokens(); len(tokens) != 1 {
+			t.Fatalf("expected a verification email at creation but got %d", len(tokens))
+		}
+	})
+
+	t.Run("Resend", func(t *testing.T) {
+		rr := request(server.handleResendEmailVerification, settings.AppSettings.Email_Verify_Route, nil, claims)
+		if rr.Code != http.StatusAccepted {
+			t.Errorf("expected status code %d but got %d", http.StatusAccepted, rr.Code)
+		}
+		if tokens := sentTokens(); len(tokens) != 2 {
+			t.Errorf("expected a second verification email but got %d", len(tokens))
+		}
+	})
+
+	t.Run("Confirm", func(t *testing.T) {
+		rr := request(server.handleConfirmEmailVerification, settings.AppSettings.Email_Verify_Confirm_Route, map[string]string{"token": "unknown"}, nil)
+		if rr.Code != http.StatusBadRequest {
+			t.Errorf("expected an unknown token to be refused but got %d", rr.Code)
+		}
+
+		tokens := sentTokens()
+		rr = request(server.handleConfirmEmailVerification, settings.AppSettings.Email_Verify_Confirm_Route, map[string]string{"token": tokens[len(tokens)-1]}, nil)
+		if rr.Code != http.StatusNoContent {
+			t.Fatalf("expected status code %d but got %d", http.StatusNoContent, rr.Code)
+		}
+		if rr := transfer(); rr.Code != http.StatusOK {
+			t.Errorf("expected a verified account to transfer but got %d", rr.Code)
+		}
+		// The other pending link is used up with the verification
+		rr = request(server.handleConfirmEmailVerification, settings.AppSettings.Email_Verify_Confirm_Route, map[string]string{"token": tokens[0]}, nil)
+		if rr.Code != http.StatusBadRequest {
+			t.Errorf("expected the other token to be refused but got %d", rr.Code)
+		}
+		rr = request(server.handleResendEmailVerification, settings.AppSettings.Email_Verify_Route, nil, claims)
+		if rr.Code != http.StatusConflict {
+			t.Errorf("expected status code %d but got %d", http.StatusConflict, rr.Code)
+		}
+	})
+}
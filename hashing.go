@@ -0,0 +1,26 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/nguyenanhhao221/go-jwt/internal/password"
+)
+
+// hashingRetryAfter Seconds the client is asked to wait when password hashing is overloaded
+const hashingRetryAfter = 1
+
+// writeHashingOverloaded Answer 503 when the password couldn't be hashed or checked in time, see password.Pool
+func writeHashingOverloaded(w http.ResponseWriter) {
+	w.Header().Set("Retry-After", strconv.Itoa(hashingRetryAfter))
+	WriteErrorJson(w, http.StatusServiceUnavailable, "The server is busy, try again later")
+}
+
+// MetricsResponse Runtime counters of the server, for admins
+type MetricsResponse struct {
+	PasswordHashing password.Stats `json:"passwordHashing"`
+}
+
+func (s *APIServer) handleMetrics(w http.ResponseWriter, r *http.Request) {
+	WriteJSON(w, http.StatusOK, MetricsResponse{PasswordHashing: s.hashing.Stats()})
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/nguyenanhhao221/go-jwt/internal/password"
+	"github.com/nguyenanhhao221/go-jwt/settings"
+)
+
+// holdHashingSlot Take the only slot of the pool until the test ends
+func holdHashingSlot(t *testing.T, pool *password.Pool) {
+	release := make(chan struct{})
+	started := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		pool.Do(context.Background(), func() error {
+			close(started)
+			<-release
+			return nil
+		})
+	}()
+	<-started
+	t.Cleanup(func() {
+		close(release)
+		<-done
+	})
+}
+
+func TestMetrics(t *testing.T) {
+	hasher, err := password.HasherFromEnv()
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := &APIServer{hashing: password.NewPool(hasher, 1, 0, time.Millisecond)}
+	holdHashingSlot(t, server.hashing)
+	if _, err := server.hashing.Hash(context.Background(), "Correct-Horse-42"); !errors.Is(err, password.ErrOverloaded) {
+		t.Fatalf("expected %v but got %v", password.ErrOverloaded, err)
+	}
+
+	req, err := http.NewRequest(http.MethodGet, settings.AppSettings.Admin_Metrics_Route, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rr := httptest.NewRecorder()
+	http.HandlerFunc(server.handleMetrics).ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected status code %d but got %d", http.StatusOK, rr.Code)
+	}
+	var metrics MetricsResponse
+	if err := json.Unmarshal(rr.Body.Bytes(), &metrics); err != nil {
+		t.Fatal(err)
+	}
+	if metrics.PasswordHashing.InFlight != 1 || metrics.PasswordHashing.Rejected != 1 {
+		t.Errorf("expected one hash in flight and one rejected but got %+v", metrics.PasswordHashing)
+	}
+}
+
+func TestHashingOverloadCI(t *testing.T) {
+	store, err := NewPostgresStore()
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := NewAPIServer("", store)
+	mockUser := NewAccount("Overload First Name", "Overload Last Name", "Overload@email.com", "TestPassword")
+	accountId, err := store.CreateAccount(mockUser)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.DeleteAccountById(accountId)
+
+	hasher, err := password.HasherFromEnv()
+	if err != nil {
+		t.Fatal(err)
+	}
+	server.hashing = password.NewPool(hasher, 1, 0, time.Second)
+	holdHashingSlot(t, server.hashing)
+
+	reqBodyJSON, err := json.Marshal(map[string]string{"email": mockUser.Email, "password": "TestPassword"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	req, err := http.NewRequest(http.MethodPost, settings.AppSettings.SignIn_Account_Route, bytes.NewBuffer(reqBodyJSON))
+	if err != nil {
+		t.Fatal(err)
+	}
+	start := time.Now()
+	rr := httptest.NewRecorder()
+	http.HandlerFunc(server.handleSignIn).ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusServiceUnavailable || rr.Header().Get("Retry-After") == "" {
+		t.Errorf("expected status code %d with Retry-After but got %d", http.StatusServiceUnavailable, rr.Code)
+	}
+	if time.Since(start) > 500*time.Millisecond {
+		t.Error("expected the overloaded sign in to be refused without waiting for the queue timeout")
+	}
+}
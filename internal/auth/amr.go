@@ -0,0 +1,21 @@
+package auth
+
+import "time"
+
+// Authentication method references (RFC 8176 section 2) put in the amr claim
+const (
+	AMRPassword = "pwd"
+	AMROTP      = "otp"
+	// AMREmail The account proved it controls its email with a one-time link or code, for passwordless sign in.
+	// RFC 8176 has no value for it, so it isn't a registered one
+	AMREmail = "email"
+)
+
+// AuthenticatedWithin Report if the account entered its credentials less than maxAge before now.
+// Tokens without auth_time, issued before it was tracked, never are
+func (c *CustomJWTClaims) AuthenticatedWithin(maxAge time.Duration, now time.Time) bool {
+	if c.AuthTime == 0 {
+		return false
+	}
+	return now.Sub(time.Unix(c.AuthTime, 0)) <= maxAge
+}
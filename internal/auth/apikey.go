@@ -0,0 +1,52 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"strings"
+)
+
+// apiKeyPrefix Every API key starts with it, so a key is told apart from a JWT and is easy to spot by secret scanners
+const apiKeyPrefix = "gjk_"
+
+// apiKeyIDBytes Random bytes of the public part of an API key, used to look the key up
+const apiKeyIDBytes = 6
+
+var ErrInvalidAPIKey = errors.New("invalid API key")
+
+// IsAPIKey Report if the credential sent by the client is an API key rather than a JWT
+func IsAPIKey(credential string) bool {
+	return strings.HasPrefix(credential, apiKeyPrefix)
+}
+
+// NewAPIKey Generate an API key in the format gjk_<prefix>_<secret>. The prefix identifies the key and can be shown,
+// the key itself is shown once to its owner and only the hash returned alongside it should be persisted
+func NewAPIKey() (key string, prefix string, keyHash string, err error) {
+	buf := make([]byte, apiKeyIDBytes)
+	if _, err := rand.Read(buf); err != nil {
+		return "", "", "", err
+	}
+	prefix = hex.EncodeToString(buf)
+	secret, _, err := newOpaqueToken()
+	if err != nil {
+		return "", "", "", err
+	}
+	key = apiKeyPrefix + prefix + "_" + secret
+	return key, prefix, hashOpaqueToken(key), nil
+}
+
+// ParseAPIKey Return the prefix identifying the API key
+func ParseAPIKey(key string) (prefix string, err error) {
+	prefix, secret, found := strings.Cut(strings.TrimPrefix(key, apiKeyPrefix), "_")
+	if !IsAPIKey(key) || !found || len(prefix) != apiKeyIDBytes*2 || secret == "" {
+		return "", ErrInvalidAPIKey
+	}
+	return prefix, nil
+}
+
+// CheckAPIKey Compare the API key sent by the client with the stored hash in constant time
+func CheckAPIKey(key, keyHash string) bool {
+	return subtle.ConstantTimeCompare([]byte(hashOpaqueToken(key)), []byte(keyHash)) == 1
+}
@@ -0,0 +1,31 @@
+package auth
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestAPIKey(t *testing.T) {
+	key, prefix, keyHash, err := NewAPIKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !IsAPIKey(key) || !strings.Contains(key, prefix) {
+		t.Errorf("expected an API key with its prefix but got %v", key)
+	}
+	if parsedPrefix, err := ParseAPIKey(key); err != nil || parsedPrefix != prefix {
+		t.Errorf("expected the prefix %v but got %v %v", prefix, parsedPrefix, err)
+	}
+	if !CheckAPIKey(key, keyHash) {
+		t.Error("expected the key to match its hash")
+	}
+	if CheckAPIKey(key+"x", keyHash) {
+		t.Error("expected another key not to match")
+	}
+
+	for _, invalid := range []string{"", "eyJhbGciOi.x.y", "gjk_", "gjk_short_secret", "gjk_" + prefix} {
+		if _, err := ParseAPIKey(invalid); err == nil {
+			t.Errorf("expected %q to be refused", invalid)
+		}
+	}
+}
@@ -4,49 +4,210 @@ import (
 	"fmt"
 	"log"
 	"os"
+	"time"
 
 	"github.com/golang-jwt/jwt"
 	"github.com/google/uuid"
 )
 
+// Default token lifetimes, used when the matching env variable is missing or invalid
+const (
+	defaultAccessTokenTTL       = 15 * time.Minute
+	defaultRefreshTokenTTL      = 30 * 24 * time.Hour
+	defaultStepUpMaxAge         = 5 * time.Minute
+	defaultPasswordResetTTL     = 30 * time.Minute
+	defaultEmailVerificationTTL = 24 * time.Hour
+	defaultPasswordlessTTL      = 10 * time.Minute
+)
+
+// AccessTokenTTL How long an access token created by CreateJWT stays valid.
+// Configured with JWT_ACCESS_TOKEN_TTL using Go duration format, e.g. "15m"
+func AccessTokenTTL() time.Duration {
+	return durationFromEnv("JWT_ACCESS_TOKEN_TTL", defaultAccessTokenTTL)
+}
+
+// RefreshTokenTTL How long a refresh token can be exchanged for a new access token.
+// Configured with JWT_REFRESH_TOKEN_TTL using Go duration format, e.g. "720h"
+func RefreshTokenTTL() time.Duration {
+	return durationFromEnv("JWT_REFRESH_TOKEN_TTL", defaultRefreshTokenTTL)
+}
+
+// StepUpMaxAge How recent the authentication of an account must be for sensitive operations like large transfers.
+// Configured with STEP_UP_MAX_AGE using Go duration format, e.g. "5m"
+func StepUpMaxAge() time.Duration {
+	return durationFromEnv("STEP_UP_MAX_AGE", defaultStepUpMaxAge)
+}
+
+// PasswordResetTTL How long the token sent by email to reset a forgotten password can be used.
+// Configured with PASSWORD_RESET_TTL using Go duration format, e.g. "30m"
+func PasswordResetTTL() time.Duration {
+	return durationFromEnv("PASSWORD_RESET_TTL", defaultPasswordResetTTL)
+}
+
+// EmailVerificationTTL How long the link sent to verify the email of a new account can be used.
+// Configured with EMAIL_VERIFICATION_TTL using Go duration format, e.g. "24h"
+func EmailVerificationTTL() time.Duration {
+	return durationFromEnv("EMAIL_VERIFICATION_TTL", defaultEmailVerificationTTL)
+}
+
+// PasswordlessTTL How long the magic link and code sent by email to sign in without a password can be used.
+// Configured with PASSWORDLESS_TTL using Go duration format, e.g. "10m"
+func PasswordlessTTL() time.Duration {
+	return durationFromEnv("PASSWORDLESS_TTL", defaultPasswordlessTTL)
+}
+
+// durationFromEnv A positive duration from the env variable, a zero TTL or max age would make every token invalid right away
+func durationFromEnv(key string, fallback time.Duration) time.Duration {
+	value, exist := os.LookupEnv(key)
+	if !exist {
+		return fallback
+	}
+	duration, err := time.ParseDuration(value)
+	if err != nil || duration <= 0 {
+		log.Printf("Invalid duration %q for %s, fallback to %v", value, key, fallback)
+		return fallback
+	}
+	return duration
+}
+
+// nonNegativeDurationFromEnv Same as durationFromEnv but zero is allowed, e.g. for no leeway at all
+func nonNegativeDurationFromEnv(key string, fallback time.Duration) time.Duration {
+	value, exist := os.LookupEnv(key)
+	if !exist {
+		return fallback
+	}
+	duration, err := time.ParseDuration(value)
+	if err != nil || duration < 0 {
+		log.Printf("Invalid duration %q for %s, fallback to %v", value, key, fallback)
+		return fallback
+	}
+	return duration
+}
+
 // validateJWT Validate the token string
-// Depends on the signing method we choose for the JWT signing
-// We will parse the token received from the client and using the secret hash to check if it valid
+// The key used to verify the signature is picked with the kid header of the token,
+// tokens without kid were issued before kid was introduced so they are checked against the current signing key.
+// Once the signature is verified the claims are checked by validateClaims, the returned error tells why a token is rejected
 func ValidateJWT(tokenString string) (*jwt.Token, error) {
-	// The secret hash to be used
-	hmacSecret := os.Getenv("JWT_SECRET")
-	return jwt.ParseWithClaims(tokenString, &CustomJWTClaims{}, func(token *jwt.Token) (interface{}, error) {
+	return parseToken(tokenString, Audience())
+}
+
+// parseToken Verify the signature of the token and validate its claims for the audience
+func parseToken(tokenString string, audience string) (*jwt.Token, error) {
+	token, err := verifyJWT(tokenString)
+	if err != nil {
+		return token, err
+	}
+	if err := validateClaimsFor(token.Claims.(*CustomJWTClaims), audience, time.Now()); err != nil {
+		token.Valid = false
+		return token, err
+	}
+	return token, nil
+}
+
+// verifyJWT Verify the signature of the token with the key of its kid, its claims are not validated
+func verifyJWT(tokenString string) (*jwt.Token, error) {
+	keySet, err := CurrentKeySet()
+	if err != nil {
+		return nil, err
+	}
+	// The time based claims are checked by validateClaimsFor instead, to apply the leeway
+	parser := &jwt.Parser{SkipClaimsValidation: true}
+	return parser.ParseWithClaims(tokenString, &CustomJWTClaims{}, func(token *jwt.Token) (interface{}, error) {
+		key := keySet.SigningKey()
+		if kid, ok := token.Header["kid"].(string); ok {
+			if key, ok = keySet.Key(kid); !ok {
+				return nil, fmt.Errorf("Unknown signing key: %v", kid)
+			}
+		}
 		// Don't forget to validate the alg is what you expect:
-		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
+		if token.Method.Alg() != key.Method.Alg() {
 			return nil, fmt.Errorf("Unexpected signing method: %v", token.Header["alg"])
 		}
 
-		return []byte(hmacSecret), nil
+		return key.publicKey, nil
 	})
 }
 
+// CustomJWTClaims The claims of our access tokens, ID is the account id.
+// The jti claim is StandardClaims.Id
 type CustomJWTClaims struct {
-	ID uuid.UUID `json:"id"`
+	ID   uuid.UUID `json:"id"`
+	Role Role      `json:"role,omitempty"`
+	// Scope Space separated scopes, only set on tokens limited to some scopes like the client credentials ones
+	Scope string `json:"scope,omitempty"`
+	// ClientID The OAuth client the token was issued to
+	ClientID string `json:"client_id,omitempty"`
+	// AuthTime When the account last entered its credentials, kept when the token is refreshed
+	AuthTime int64 `json:"auth_time,omitempty"`
+	// AMR How the account authenticated at AuthTime (RFC 8176), e.g. pwd and otp
+	AMR []string `json:"amr,omitempty"`
+	// SessionID The session the token belongs to, set on the tokens of an account that signed in directly
+	SessionID string `json:"sid,omitempty"`
+	// Confirmation The DPoP key the token is bound to, the token must then be sent with a proof signed by that key
+	Confirmation *Confirmation `json:"cnf,omitempty"`
 	jwt.StandardClaims
 }
 
-func CreateJWT(accountId uuid.UUID) (string, error) {
-	// Create a new token object, specifying signing method and the claims
-	// you would like it to contain.
-	// TODO: Expire time
-	claims := &CustomJWTClaims{
-		ID: accountId,
+// CreateJWT Create an access token for an account, in the format of ACCESS_TOKEN_FORMAT
+func CreateJWT(accountId uuid.UUID, role Role) (string, error) {
+	return SignAccessToken(&CustomJWTClaims{
+		ID:   accountId,
+		Role: role,
+	})
+}
+
+// CreateClientJWT Create an access token for an OAuth client acting on its own behalf (client credentials grant),
+// the token has no account and only carries the scopes granted to the client. dpopJKT binds it to a DPoP key when set
+func CreateClientJWT(clientId string, scopes []string, dpopJKT string) (string, error) {
+	return SignAccessToken(&CustomJWTClaims{
+		ClientID:     clientId,
+		Scope:        FormatScopes(scopes),
+		Confirmation: NewConfirmation(dpopJKT),
+	})
+}
+
+// SignJWT Fill the registered claims (jti, sub, iss, aud, iat, nbf, exp) and sign the token with the current signing key.
+// Access tokens are signed with SignAccessToken instead, which honors ACCESS_TOKEN_FORMAT
+func SignJWT(claims *CustomJWTClaims) (string, error) {
+	fillRegisteredClaims(claims, time.Now())
+	return signToken(claims)
+}
+
+// fillRegisteredClaims Set the registered claims of a new access token issued at now.
+// The sub claim is the account id, or the client id for machine principals
+func fillRegisteredClaims(claims *CustomJWTClaims, now time.Time) {
+	subject := claims.ID.String()
+	if claims.ID == uuid.Nil {
+		subject = claims.ClientID
+	}
+	claims.StandardClaims = jwt.StandardClaims{
+		// jti, identify this token so it can be revoked before it expires
+		Id:        uuid.NewString(),
+		Subject:   subject,
+		Issuer:    Issuer(),
+		Audience:  Audience(),
+		IssuedAt:  now.Unix(),
+		NotBefore: now.Unix(),
+		ExpiresAt: now.Add(AccessTokenTTL()).Unix(),
+	}
+}
+
+// signToken Sign the claims with the current signing key, the kid header tells which key verifies the token
+func signToken(claims jwt.Claims) (string, error) {
+	keySet, err := CurrentKeySet()
+	if err != nil {
+		return "", err
 	}
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+	signingKey := keySet.SigningKey()
+	token := jwt.NewWithClaims(signingKey.Method, claims)
+	token.Header["kid"] = signingKey.ID
 
-	// Sign and get the complete encoded token as a string using the secret
-	hmacSecret := os.Getenv("JWT_SECRET")
-	log.Println(hmacSecret)
-	// Here we have to convert the secret into []bytes slice
+	// Sign and get the complete encoded token as a string using the private key, or the secret for HS256.
 	// Check out the signature of the SignedString it expect an interface{} type, however this is just a bait
 	// Because base on different SigningMethod we choose, the value pass in need to be some specific types, so the library just put interface{} for now
 	// Read more: https://github.com/dgrijalva/jwt-go/issues/65
-	if tokenString, err := token.SignedString([]byte(hmacSecret)); err != nil {
+	if tokenString, err := token.SignedString(signingKey.privateKey); err != nil {
 		log.Printf("Error failed to sign token %v", err)
 		return "", err
 	} else {
@@ -0,0 +1,187 @@
+package auth
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt"
+	"github.com/google/uuid"
+)
+
+func useTestKeySet(t *testing.T, ks *KeySet) {
+	SetKeySet(ks)
+	t.Cleanup(func() { SetKeySet(nil) })
+}
+
+func TestCreateJWT(t *testing.T) {
+	useTestKeySet(t, NewKeySet(NewHMACSigningKey("test", []byte("test-secret"))))
+	t.Setenv("JWT_ACCESS_TOKEN_TTL", "5m")
+	accountId := uuid.New()
+
+	tokenString, err := CreateJWT(accountId, RoleAdmin)
+	if err != nil {
+		t.Fatalf("failed to create token %v", err)
+	}
+	token, err := ValidateJWT(tokenString)
+	if err != nil {
+		t.Fatalf("expected token to be valid but got %v", err)
+	}
+	claims := token.Claims.(*CustomJWTClaims)
+	if claims.ID != accountId {
+		t.Errorf("expected id %v but got %v", accountId, claims.ID)
+	}
+	if claims.Role != RoleAdmin {
+		t.Errorf("expected role %v but got %v", RoleAdmin, claims.Role)
+	}
+	if claims.IssuedAt == 0 || claims.NotBefore == 0 {
+		t.Errorf("expected iat and nbf to be set but got iat %d nbf %d", claims.IssuedAt, claims.NotBefore)
+	}
+	if lifetime := claims.ExpiresAt - claims.IssuedAt; lifetime != int64((5 * time.Minute).Seconds()) {
+		t.Errorf("expected token lifetime of 300 seconds but got %d", lifetime)
+	}
+}
+
+func TestValidateJWTExpired(t *testing.T) {
+	useTestKeySet(t, NewKeySet(NewHMACSigningKey("test", []byte("test-secret"))))
+	claims := &CustomJWTClaims{
+		ID: uuid.New(),
+		StandardClaims: jwt.StandardClaims{
+			ExpiresAt: time.Now().Add(-time.Minute).Unix(),
+		},
+	}
+	tokenString, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte("test-secret"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ValidateJWT(tokenString); err == nil {
+		t.Errorf("expected expired token to be rejected")
+	}
+}
+
+func TestAccessTokenTTLFallback(t *testing.T) {
+	t.Setenv("JWT_ACCESS_TOKEN_TTL", "not a duration")
+	if ttl := AccessTokenTTL(); ttl != defaultAccessTokenTTL {
+		t.Errorf("expected fallback ttl %v but got %v", defaultAccessTokenTTL, ttl)
+	}
+	t.Setenv("JWT_ACCESS_TOKEN_TTL", "0s")
+	if ttl := AccessTokenTTL(); ttl != defaultAccessTokenTTL {
+		t.Errorf("expected fallback ttl %v for a zero ttl but got %v", defaultAccessTokenTTL, ttl)
+	}
+}
+
+func TestLeewayFromEnv(t *testing.T) {
+	t.Setenv("JWT_LEEWAY", "0s")
+	if leeway := Leeway(); leeway != 0 {
+		t.Errorf("expected no leeway but got %v", leeway)
+	}
+	t.Setenv("JWT_LEEWAY", "-1s")
+	if leeway := Leeway(); leeway != defaultLeeway {
+		t.Errorf("expected fallback leeway %v but got %v", defaultLeeway, leeway)
+	}
+}
+
+func TestNewRefreshToken(t *testing.T) {
+	token, tokenHash, err := NewRefreshToken()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if token == tokenHash {
+		t.Errorf("expected the refresh token to be hashed")
+	}
+	if HashRefreshToken(token) != tokenHash {
+		t.Errorf("expected hashing the token again to give the same hash")
+	}
+	otherToken, _, err := NewRefreshToken()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if token == otherToken {
+		t.Errorf("expected refresh tokens to be unique")
+	}
+}
+
+func TestEffectiveRole(t *testing.T) {
+	if role := (&CustomJWTClaims{}).EffectiveRole(); role != RoleCustomer {
+		t.Errorf("expected token without role to be treated as %v but got %v", RoleCustomer, role)
+	}
+	if _, err := ParseRole("superuser"); err == nil {
+		t.Errorf("expected unknown role to be rejected")
+	}
+}
+
+func TestCheckClientSecret(t *testing.T) {
+	secret, secretHash, err := NewClientSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !CheckClientSecret(secret, secretHash) {
+		t.Errorf("expected the client secret to match its hash")
+	}
+	if CheckClientSecret("wrong-secret", secretHash) {
+		t.Errorf("expected a wrong client secret to be rejected")
+	}
+}
+
+func TestCreateClientJWT(t *testing.T) {
+	useTestKeySet(t, NewKeySet(NewHMACSigningKey("test", []byte("test-secret"))))
+	tokenString, err := CreateClientJWT("batch-job", []string{ScopeAccountsRead, ScopeTransfersWrite}, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	token, err := ValidateJWT(tokenString)
+	if err != nil {
+		t.Fatal(err)
+	}
+	claims := token.Claims.(*CustomJWTClaims)
+	if !claims.IsMachine() || claims.Subject != "batch-job" {
+		t.Errorf("expected a machine token with subject batch-job but got %v", claims)
+	}
+	if !claims.HasScope(ScopeTransfersWrite) || claims.HasScope(ScopeAccountsWrite) {
+		t.Errorf("expected only the granted scopes but got %q", claims.Scope)
+	}
+	if _, err := ParseScopes("accounts:read admin"); err == nil {
+		t.Errorf("expected unknown scope to be rejected")
+	}
+}
+
+func TestCreateIDToken(t *testing.T) {
+	useTestKeySet(t, NewKeySet(NewHMACSigningKey("test", []byte("test-secret"))))
+	authTime := time.Now().Add(-time.Minute)
+	tokenString, err := CreateIDToken("account-id", "client-id", "nonce", authTime, UserInfo{Email: "test@email.com"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// ID tokens are for the client, they must not be accepted as access tokens by our API
+	if _, err := ValidateJWT(tokenString); !errors.Is(err, ErrInvalidAudience) {
+		t.Errorf("expected the ID token to be rejected as access token but got %v", err)
+	}
+
+	claims := &IDTokenClaims{}
+	if _, err := jwt.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (interface{}, error) {
+		return []byte("test-secret"), nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if claims.Subject != "account-id" || claims.Audience != "client-id" || claims.Issuer != Issuer() {
+		t.Errorf("expected the ID token of account-id for client-id but got %v", claims)
+	}
+	if claims.Nonce != "nonce" || claims.AuthTime != authTime.Unix() || claims.Email != "test@email.com" {
+		t.Errorf("expected the nonce, auth_time and email claims but got %v", claims)
+	}
+}
+
+func TestAuthenticatedWithin(t *testing.T) {
+	now := time.Now()
+	claims := &CustomJWTClaims{AuthTime: now.Add(-2 * time.Minute).Unix()}
+	if !claims.AuthenticatedWithin(5*time.Minute, now) {
+		t.Error("expected an authentication 2 minutes ago to be within 5 minutes")
+	}
+	if claims.AuthenticatedWithin(time.Minute, now) {
+		t.Error("expected an authentication 2 minutes ago not to be within 1 minute")
+	}
+	if (&CustomJWTClaims{}).AuthenticatedWithin(5*time.Minute, now) {
+		t.Error("expected a token without auth_time never to be recent")
+	}
+}
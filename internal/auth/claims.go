@@ -0,0 +1,83 @@
+package auth
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"time"
+)
+
+// Defaults for the claims validation, used when the matching env variable is missing or invalid
+const (
+	defaultIssuer   = "go-jwt"
+	defaultAudience = "go-jwt"
+	defaultLeeway   = 30 * time.Second
+)
+
+// Reasons a token with a valid signature is rejected, wrapped with details by ValidateJWT
+var (
+	ErrTokenExpired          = errors.New("token is expired")
+	ErrTokenNotValidYet      = errors.New("token is not valid yet")
+	ErrTokenUsedBeforeIssued = errors.New("token used before issued")
+	ErrInvalidIssuer         = errors.New("token has an invalid issuer")
+	ErrInvalidAudience       = errors.New("token has an invalid audience")
+)
+
+// Issuer The iss claim stamped on and expected in every token, configured with JWT_ISSUER.
+// Use a different value per environment so tokens can't be replayed from one to another.
+// OpenID Connect needs it to be the public URL of the server, e.g. https://auth.example.com
+func Issuer() string {
+	if issuer := os.Getenv("JWT_ISSUER"); issuer != "" {
+		return issuer
+	}
+	return defaultIssuer
+}
+
+// Audience The aud claim stamped on and expected in every token, configured with JWT_AUDIENCE.
+// Services sharing the signing keys but with a different audience won't accept our tokens
+func Audience() string {
+	if audience := os.Getenv("JWT_AUDIENCE"); audience != "" {
+		return audience
+	}
+	return defaultAudience
+}
+
+// Leeway Clock skew tolerated between servers when checking exp, nbf and iat,
+// configured with JWT_LEEWAY using Go duration format, e.g. "30s"
+func Leeway() time.Duration {
+	return nonNegativeDurationFromEnv("JWT_LEEWAY", defaultLeeway)
+}
+
+// validateClaims Check the registered claims of a token which signature is already verified.
+// Unlike StandardClaims.Valid, exp, iss and aud are required and the leeway is applied to the time based claims
+func validateClaims(claims *CustomJWTClaims, now time.Time) error {
+	return validateClaimsFor(claims, Audience(), now)
+}
+
+// validateClaimsFor Same as validateClaims, for tokens meant for another audience than our API, e.g. MFA tokens
+func validateClaimsFor(claims *CustomJWTClaims, audience string, now time.Time) error {
+	leeway := Leeway()
+	if claims.ExpiresAt == 0 {
+		return fmt.Errorf("%w: missing exp claim", ErrTokenExpired)
+	}
+	if expiresAt := time.Unix(claims.ExpiresAt, 0); now.After(expiresAt.Add(leeway)) {
+		return fmt.Errorf("%w by %v", ErrTokenExpired, now.Sub(expiresAt).Truncate(time.Second))
+	}
+	if claims.NotBefore != 0 {
+		if notBefore := time.Unix(claims.NotBefore, 0); now.Add(leeway).Before(notBefore) {
+			return fmt.Errorf("%w, valid in %v", ErrTokenNotValidYet, notBefore.Sub(now).Truncate(time.Second))
+		}
+	}
+	if claims.IssuedAt != 0 {
+		if issuedAt := time.Unix(claims.IssuedAt, 0); now.Add(leeway).Before(issuedAt) {
+			return fmt.Errorf("%w, issued in %v", ErrTokenUsedBeforeIssued, issuedAt.Sub(now).Truncate(time.Second))
+		}
+	}
+	if claims.Issuer != Issuer() {
+		return fmt.Errorf("%w %q, expected %q", ErrInvalidIssuer, claims.Issuer, Issuer())
+	}
+	if claims.Audience != audience {
+		return fmt.Errorf("%w %q, expected %q", ErrInvalidAudience, claims.Audience, audience)
+	}
+	return nil
+}
@@ -0,0 +1,72 @@
+package auth
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt"
+	"github.com/google/uuid"
+)
+
+func TestValidateClaims(t *testing.T) {
+	t.Setenv("JWT_ISSUER", "test-issuer")
+	t.Setenv("JWT_AUDIENCE", "test-audience")
+	t.Setenv("JWT_LEEWAY", "30s")
+	now := time.Now()
+	validClaims := func() *CustomJWTClaims {
+		return &CustomJWTClaims{
+			ID: uuid.New(),
+			StandardClaims: jwt.StandardClaims{
+				Issuer:    "test-issuer",
+				Audience:  "test-audience",
+				IssuedAt:  now.Unix(),
+				NotBefore: now.Unix(),
+				ExpiresAt: now.Add(time.Minute).Unix(),
+			},
+		}
+	}
+
+	tests := []struct {
+		name          string
+		modify        func(claims *CustomJWTClaims)
+		expectedError error
+	}{
+		{name: "Valid", modify: func(claims *CustomJWTClaims) {}},
+		{name: "ExpiredWithinLeeway", modify: func(claims *CustomJWTClaims) { claims.ExpiresAt = now.Add(-10 * time.Second).Unix() }},
+		{name: "Expired", modify: func(claims *CustomJWTClaims) { claims.ExpiresAt = now.Add(-time.Minute).Unix() }, expectedError: ErrTokenExpired},
+		{name: "MissingExpiry", modify: func(claims *CustomJWTClaims) { claims.ExpiresAt = 0 }, expectedError: ErrTokenExpired},
+		{name: "NotBeforeWithinLeeway", modify: func(claims *CustomJWTClaims) { claims.NotBefore = now.Add(10 * time.Second).Unix() }},
+		{name: "NotValidYet", modify: func(claims *CustomJWTClaims) { claims.NotBefore = now.Add(time.Minute).Unix() }, expectedError: ErrTokenNotValidYet},
+		{name: "IssuedInTheFuture", modify: func(claims *CustomJWTClaims) { claims.IssuedAt = now.Add(time.Minute).Unix() }, expectedError: ErrTokenUsedBeforeIssued},
+		{name: "WrongIssuer", modify: func(claims *CustomJWTClaims) { claims.Issuer = "staging" }, expectedError: ErrInvalidIssuer},
+		{name: "WrongAudience", modify: func(claims *CustomJWTClaims) { claims.Audience = "other-service" }, expectedError: ErrInvalidAudience},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			claims := validClaims()
+			test.modify(claims)
+			err := validateClaims(claims, now)
+			if test.expectedError == nil && err != nil {
+				t.Errorf("expected claims to be valid but got %v", err)
+			}
+			if test.expectedError != nil && !errors.Is(err, test.expectedError) {
+				t.Errorf("expected error %v but got %v", test.expectedError, err)
+			}
+		})
+	}
+}
+
+func TestValidateJWTRejectsOtherAudience(t *testing.T) {
+	useTestKeySet(t, NewKeySet(NewHMACSigningKey("test", []byte("test-secret"))))
+	t.Setenv("JWT_AUDIENCE", "billing")
+	tokenString, err := CreateJWT(uuid.New(), RoleCustomer)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Setenv("JWT_AUDIENCE", "accounts")
+	if _, err := ValidateJWT(tokenString); !errors.Is(err, ErrInvalidAudience) {
+		t.Errorf("expected token minted for another audience to be rejected but got %v", err)
+	}
+}
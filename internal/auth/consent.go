@@ -0,0 +1,49 @@
+package auth
+
+import (
+	"time"
+
+	"github.com/golang-jwt/jwt"
+	"github.com/google/uuid"
+)
+
+// consentTokenTTL How long the account has to approve the scopes asked by a client after signing in on the authorization page
+const consentTokenTTL = 5 * time.Minute
+
+// consentAudience Consent tokens are neither access tokens nor MFA tokens, a different audience makes the others reject them
+func consentAudience() string {
+	return Audience() + "/consent"
+}
+
+// CreateConsentToken Create the short lived token proving the account signed in on the authorization page of the client,
+// posted back with its approval of the scopes. amr tells how the account signed in
+func CreateConsentToken(accountId uuid.UUID, clientId string, amr []string) (string, error) {
+	now := time.Now()
+	return signToken(&CustomJWTClaims{
+		ID:       accountId,
+		ClientID: clientId,
+		AMR:      amr,
+		StandardClaims: jwt.StandardClaims{
+			Id:        uuid.NewString(),
+			Subject:   accountId.String(),
+			Issuer:    Issuer(),
+			Audience:  consentAudience(),
+			IssuedAt:  now.Unix(),
+			NotBefore: now.Unix(),
+			ExpiresAt: now.Add(consentTokenTTL).Unix(),
+		},
+	})
+}
+
+// ValidateConsentToken Validate a token created by CreateConsentToken for the client
+func ValidateConsentToken(tokenString string, clientId string) (*CustomJWTClaims, error) {
+	token, err := parseToken(tokenString, consentAudience())
+	if err != nil {
+		return nil, err
+	}
+	claims := token.Claims.(*CustomJWTClaims)
+	if claims.ClientID != clientId {
+		return nil, ErrInvalidAudience
+	}
+	return claims, nil
+}
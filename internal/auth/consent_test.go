@@ -0,0 +1,31 @@
+package auth
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+func TestConsentToken(t *testing.T) {
+	useTestKeySet(t, NewKeySet(NewHMACSigningKey("test", []byte("test-secret"))))
+	accountId := uuid.New()
+	consentToken, err := CreateConsentToken(accountId, "client", []string{AMRPassword})
+	if err != nil {
+		t.Fatal(err)
+	}
+	claims, err := ValidateConsentToken(consentToken, "client")
+	if err != nil || claims.ID != accountId || len(claims.AMR) != 1 {
+		t.Fatalf("expected a valid consent token for %v but got %v %v", accountId, claims, err)
+	}
+	// The consent token only works for the client it was created for, and not as another token
+	if _, err := ValidateConsentToken(consentToken, "other-client"); !errors.Is(err, ErrInvalidAudience) {
+		t.Errorf("expected the consent token to be rejected for another client but got %v", err)
+	}
+	if _, err := ValidateJWT(consentToken); !errors.Is(err, ErrInvalidAudience) {
+		t.Errorf("expected the consent token to be rejected as access token but got %v", err)
+	}
+	if _, err := ValidateMFAToken(consentToken); !errors.Is(err, ErrInvalidAudience) {
+		t.Errorf("expected the consent token to be rejected as MFA token but got %v", err)
+	}
+}
@@ -0,0 +1,254 @@
+package auth
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt"
+)
+
+// DPoPTokenType The token type of access tokens bound to a DPoP key, also the scheme of the Authorization header sending them
+const DPoPTokenType = "DPoP"
+
+// dpopProofType The typ header every DPoP proof must carry (RFC 9449 section 4.2)
+const dpopProofType = "dpop+jwt"
+
+// defaultDPoPProofMaxAge How long after its iat a proof is accepted, used when DPOP_PROOF_MAX_AGE is missing or invalid
+const defaultDPoPProofMaxAge = time.Minute
+
+// minDPoPRSAKeyBits RSA keys smaller than this can't sign proofs
+const minDPoPRSAKeyBits = 2048
+
+// ErrInvalidDPoPProof Returned, wrapped with the reason, for any proof that can't be accepted
+var ErrInvalidDPoPProof = errors.New("invalid DPoP proof")
+
+// DPoPSigningAlgs The algorithms proofs can be signed with. Only asymmetric ones, the server must not know the key
+func DPoPSigningAlgs() []string {
+	return []string{"ES256", "ES384", "ES512", "RS256", "RS384", "RS512", "PS256", "PS384", "PS512", "EdDSA"}
+}
+
+// DPoPProofMaxAge How long after its iat a proof is accepted, configured with DPOP_PROOF_MAX_AGE using Go duration format, e.g. "1m".
+// The jti of a proof must be remembered for that long to detect replays
+func DPoPProofMaxAge() time.Duration {
+	return durationFromEnv("DPOP_PROOF_MAX_AGE", defaultDPoPProofMaxAge)
+}
+
+// Confirmation The cnf claim of a token bound to a key (RFC 7800)
+type Confirmation struct {
+	// JKT The RFC 7638 thumbprint of the DPoP key the token is bound to
+	JKT string `json:"jkt"`
+}
+
+// NewConfirmation The cnf claim binding a token to the DPoP key of the thumbprint, nil when there is no key
+func NewConfirmation(jkt string) *Confirmation {
+	if jkt == "" {
+		return nil
+	}
+	return &Confirmation{JKT: jkt}
+}
+
+// DPoPJKT The thumbprint of the DPoP key the token is bound to, empty for a bearer token
+func (c *CustomJWTClaims) DPoPJKT() string {
+	if c.Confirmation == nil {
+		return ""
+	}
+	return c.Confirmation.JKT
+}
+
+// DPoPProof A verified DPoP proof
+type DPoPProof struct {
+	// JKT The thumbprint of the public key the proof was signed with
+	JKT string
+	// JTI The unique id of the proof, must only be accepted once
+	JTI      string
+	IssuedAt time.Time
+}
+
+// dpopProofClaims The claims of a DPoP proof (RFC 9449 section 4.2)
+type dpopProofClaims struct {
+	Jti string `json:"jti"`
+	Htm string `json:"htm"`
+	Htu string `json:"htu"`
+	Iat int64  `json:"iat"`
+	// Ath The base64url SHA-256 of the access token, required when the proof is sent with one
+	Ath string `json:"ath,omitempty"`
+}
+
+// Valid The claims are checked by VerifyDPoPProof, which needs the request to do so
+func (c *dpopProofClaims) Valid() error {
+	return nil
+}
+
+// AccessTokenHash The ath claim expected in proofs sent with the access token
+func AccessTokenHash(accessToken string) string {
+	sum := sha256.Sum256([]byte(accessToken))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// VerifyDPoPProof Check the DPoP proof sent with a request (RFC 9449 section 4.3): its signature with the key of its jwk header,
+// that it was made for the method and URI of the request, and recently enough.
+// accessToken is the token sent with the proof, empty when the proof is sent to get one.
+// Checking the jti wasn't used before is left to the caller, which keeps the ones it accepted
+func VerifyDPoPProof(proof, method, uri, accessToken string, now time.Time) (*DPoPProof, error) {
+	var jkt string
+	claims := &dpopProofClaims{}
+	parser := &jwt.Parser{ValidMethods: DPoPSigningAlgs()}
+	_, err := parser.ParseWithClaims(proof, claims, func(token *jwt.Token) (interface{}, error) {
+		if typ, _ := token.Header["typ"].(string); typ != dpopProofType {
+			return nil, fmt.Errorf("typ must be %q", dpopProofType)
+		}
+		jwk, err := proofJWK(token.Header["jwk"])
+		if err != nil {
+			return nil, err
+		}
+		publicKey, err := jwkToPublicKey(jwk)
+		if err != nil {
+			return nil, err
+		}
+		if jkt, err = JWKThumbprint(jwk); err != nil {
+			return nil, err
+		}
+		return publicKey, nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidDPoPProof, err)
+	}
+
+	if claims.Jti == "" {
+		return nil, fmt.Errorf("%w: missing jti claim", ErrInvalidDPoPProof)
+	}
+	if claims.Htm != method {
+		return nil, fmt.Errorf("%w: htm %q, expected %q", ErrInvalidDPoPProof, claims.Htm, method)
+	}
+	if !sameHTU(claims.Htu, uri) {
+		return nil, fmt.Errorf("%w: htu %q, expected %q", ErrInvalidDPoPProof, claims.Htu, uri)
+	}
+	if claims.Iat == 0 {
+		return nil, fmt.Errorf("%w: missing iat claim", ErrInvalidDPoPProof)
+	}
+	issuedAt := time.Unix(claims.Iat, 0)
+	leeway := Leeway()
+	if now.Add(leeway).Before(issuedAt) {
+		return nil, fmt.Errorf("%w: issued in %v", ErrInvalidDPoPProof, issuedAt.Sub(now).Truncate(time.Second))
+	}
+	if now.After(issuedAt.Add(DPoPProofMaxAge() + leeway)) {
+		return nil, fmt.Errorf("%w: issued %v ago", ErrInvalidDPoPProof, now.Sub(issuedAt).Truncate(time.Second))
+	}
+	if accessToken != "" && subtle.ConstantTimeCompare([]byte(claims.Ath), []byte(AccessTokenHash(accessToken))) != 1 {
+		return nil, fmt.Errorf("%w: ath doesn't match the access token", ErrInvalidDPoPProof)
+	}
+	return &DPoPProof{JKT: jkt, JTI: claims.Jti, IssuedAt: issuedAt}, nil
+}
+
+// sameHTU Compare the htu of a proof with the URI of the request, without their query and fragment (RFC 9449 section 4.3).
+// The scheme and host are compared case insensitively
+func sameHTU(htu, uri string) bool {
+	htuURL, err := url.Parse(htu)
+	if err != nil {
+		return false
+	}
+	requestURL, err := url.Parse(uri)
+	if err != nil {
+		return false
+	}
+	return strings.EqualFold(htuURL.Scheme, requestURL.Scheme) &&
+		strings.EqualFold(htuURL.Host, requestURL.Host) &&
+		htuURL.EscapedPath() == requestURL.EscapedPath()
+}
+
+// proofJWK Read the jwk header of a proof, which must be a public key
+func proofJWK(header interface{}) (JWK, error) {
+	members, ok := header.(map[string]interface{})
+	if !ok {
+		return JWK{}, errors.New("missing jwk header")
+	}
+	if _, private := members["d"]; private {
+		return JWK{}, errors.New("the jwk header must not contain a private key")
+	}
+	encoded, err := json.Marshal(members)
+	if err != nil {
+		return JWK{}, err
+	}
+	var jwk JWK
+	if err := json.Unmarshal(encoded, &jwk); err != nil {
+		return JWK{}, fmt.Errorf("invalid jwk header: %w", err)
+	}
+	return jwk, nil
+}
+
+// jwkToPublicKey Convert a public JWK to the key the jwt signing methods verify with
+func jwkToPublicKey(jwk JWK) (interface{}, error) {
+	switch jwk.Kty {
+	case "RSA":
+		n, err := decodeJWKInt(jwk.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decodeJWKInt(jwk.E)
+		if err != nil {
+			return nil, err
+		}
+		if n.BitLen() < minDPoPRSAKeyBits {
+			return nil, fmt.Errorf("RSA keys must have at least %d bits", minDPoPRSAKeyBits)
+		}
+		if !e.IsInt64() || e.Int64() < 3 || e.Int64() > 1<<31-1 {
+			return nil, errors.New("invalid RSA exponent")
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch jwk.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("unsupported curve %q", jwk.Crv)
+		}
+		x, err := decodeJWKInt(jwk.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := decodeJWKInt(jwk.Y)
+		if err != nil {
+			return nil, err
+		}
+		if !curve.IsOnCurve(x, y) {
+			return nil, errors.New("the point is not on the curve")
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+	case "OKP":
+		if jwk.Crv != "Ed25519" {
+			return nil, fmt.Errorf("unsupported curve %q", jwk.Crv)
+		}
+		x, err := base64.RawURLEncoding.DecodeString(jwk.X)
+		if err != nil || len(x) != ed25519.PublicKeySize {
+			return nil, errors.New("invalid Ed25519 key")
+		}
+		return ed25519.PublicKey(x), nil
+	default:
+		return nil, fmt.Errorf("unsupported key type %q", jwk.Kty)
+	}
+}
+
+func decodeJWKInt(value string) (*big.Int, error) {
+	decoded, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil || len(decoded) == 0 {
+		return nil, errors.New("invalid key parameter")
+	}
+	return new(big.Int).SetBytes(decoded), nil
+}
@@ -0,0 +1,124 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt"
+	"github.com/google/uuid"
+)
+
+const testDPoPURI = "https://auth.example.com/v1/account/signin"
+
+func signDPoPProof(t *testing.T, privateKey crypto.PrivateKey, header map[string]interface{}, claims jwt.MapClaims) string {
+	t.Helper()
+	key, err := NewSigningKey("", privateKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	jwk, err := key.PublicJWK()
+	if err != nil {
+		t.Fatal(err)
+	}
+	token := jwt.NewWithClaims(key.Method, claims)
+	token.Header["typ"] = dpopProofType
+	token.Header["jwk"] = jwk
+	for name, value := range header {
+		token.Header[name] = value
+	}
+	proof, err := token.SignedString(privateKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return proof
+}
+
+func TestVerifyDPoPProof(t *testing.T) {
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	proofClaims := func(changes jwt.MapClaims) jwt.MapClaims {
+		claims := jwt.MapClaims{"jti": uuid.NewString(), "htm": "POST", "htu": testDPoPURI, "iat": now.Unix()}
+		for name, value := range changes {
+			claims[name] = value
+		}
+		return claims
+	}
+
+	t.Run("valid", func(t *testing.T) {
+		for name, privateKey := range map[string]crypto.PrivateKey{"ES256": ecKey, "EdDSA": edKey} {
+			proof, err := VerifyDPoPProof(signDPoPProof(t, privateKey, nil, proofClaims(nil)), "POST", testDPoPURI+"?q=1", "", now)
+			if err != nil {
+				t.Fatalf("expected the %s proof to be valid but got %v", name, err)
+			}
+			key, _ := NewSigningKey("", privateKey)
+			if jkt, _ := key.Thumbprint(); proof.JKT != jkt {
+				t.Errorf("expected the thumbprint of the %s key but got %v", name, proof.JKT)
+			}
+		}
+	})
+
+	t.Run("access token hash", func(t *testing.T) {
+		proof := signDPoPProof(t, ecKey, nil, proofClaims(jwt.MapClaims{"ath": AccessTokenHash("access-token")}))
+		if _, err := VerifyDPoPProof(proof, "POST", testDPoPURI, "access-token", now); err != nil {
+			t.Errorf("expected the proof to be valid for its access token but got %v", err)
+		}
+		if _, err := VerifyDPoPProof(proof, "POST", testDPoPURI, "other-token", now); !errors.Is(err, ErrInvalidDPoPProof) {
+			t.Errorf("expected the proof to be refused for another access token but got %v", err)
+		}
+	})
+
+	tests := []struct {
+		name   string
+		proof  string
+		method string
+	}{
+		{"wrong method", signDPoPProof(t, ecKey, nil, proofClaims(nil)), "GET"},
+		{"wrong uri", signDPoPProof(t, ecKey, nil, proofClaims(jwt.MapClaims{"htu": "https://auth.example.com/v1/other"})), "POST"},
+		{"too old", signDPoPProof(t, ecKey, nil, proofClaims(jwt.MapClaims{"iat": now.Add(-time.Hour).Unix()})), "POST"},
+		{"issued in the future", signDPoPProof(t, ecKey, nil, proofClaims(jwt.MapClaims{"iat": now.Add(time.Hour).Unix()})), "POST"},
+		{"missing jti", signDPoPProof(t, ecKey, nil, jwt.MapClaims{"htm": "POST", "htu": testDPoPURI, "iat": now.Unix()}), "POST"},
+		{"wrong typ", signDPoPProof(t, ecKey, map[string]interface{}{"typ": "JWT"}, proofClaims(nil)), "POST"},
+		{"missing jwk", signDPoPProof(t, ecKey, map[string]interface{}{"jwk": nil}, proofClaims(nil)), "POST"},
+		{"private jwk", signDPoPProof(t, ecKey, map[string]interface{}{"jwk": map[string]interface{}{"kty": "EC", "crv": "P-256", "d": "secret"}}, proofClaims(nil)), "POST"},
+		{"key not matching the signature", signDPoPProof(t, ecKey, map[string]interface{}{"jwk": mustPublicJWK(t, edKey)}, proofClaims(nil)), "POST"},
+		{"symmetric", func() string {
+			token := jwt.NewWithClaims(jwt.SigningMethodHS256, proofClaims(nil))
+			token.Header["typ"] = dpopProofType
+			proof, _ := token.SignedString([]byte("secret"))
+			return proof
+		}(), "POST"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if _, err := VerifyDPoPProof(test.proof, test.method, testDPoPURI, "", now); !errors.Is(err, ErrInvalidDPoPProof) {
+				t.Errorf("expected ErrInvalidDPoPProof but got %v", err)
+			}
+		})
+	}
+}
+
+func mustPublicJWK(t *testing.T, privateKey crypto.PrivateKey) JWK {
+	t.Helper()
+	key, err := NewSigningKey("", privateKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	jwk, err := key.PublicJWK()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return jwk
+}
@@ -0,0 +1,291 @@
+package auth
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt"
+)
+
+// Formats access tokens can be issued in, selected with ACCESS_TOKEN_FORMAT
+const (
+	// TokenFormatJWT A JWT signed with the current signing key, the default
+	TokenFormatJWT = "jwt"
+	// TokenFormatPASETOPublic A PASETO v4.public token, signed with the current signing key which must be Ed25519
+	TokenFormatPASETOPublic = "v4.public"
+	// TokenFormatPASETOLocal A PASETO v4.local token, encrypted with the PASETO_LOCAL_KEY secret.
+	// Only the servers knowing the secret can read it, other services must introspect it
+	TokenFormatPASETOLocal = "v4.local"
+)
+
+// TokenFormat How the claims of our access tokens are encoded in a token and read back.
+// The registered claims are filled, and validated, by SignAccessToken and ValidateAccessToken whatever the format
+type TokenFormat interface {
+	// Name The value of ACCESS_TOKEN_FORMAT selecting the format
+	Name() string
+	// Encode Sign, or encrypt, the claims into a token
+	Encode(claims *CustomJWTClaims) (string, error)
+	// Decode Check the token was issued by us and return its claims, which still need to be validated
+	Decode(token string) (*CustomJWTClaims, error)
+}
+
+var (
+	currentTokenFormat   TokenFormat
+	currentTokenFormatMu sync.RWMutex
+)
+
+// TokenFormatFromEnv The access token format configured with ACCESS_TOKEN_FORMAT, jwt when not set.
+// The v4.local format reads its hex encoded 32 bytes key from PASETO_LOCAL_KEY
+func TokenFormatFromEnv() (TokenFormat, error) {
+	switch name := os.Getenv("ACCESS_TOKEN_FORMAT"); name {
+	case "", TokenFormatJWT:
+		return jwtTokenFormat{}, nil
+	case TokenFormatPASETOPublic:
+		return pasetoPublicTokenFormat{}, nil
+	case TokenFormatPASETOLocal:
+		key, err := hex.DecodeString(os.Getenv("PASETO_LOCAL_KEY"))
+		if err != nil || len(key) != PASETOLocalKeySize {
+			return nil, fmt.Errorf("PASETO_LOCAL_KEY must be %d hex encoded bytes", PASETOLocalKeySize)
+		}
+		return NewPASETOLocalTokenFormat(key), nil
+	default:
+		return nil, fmt.Errorf("unsupported ACCESS_TOKEN_FORMAT %q", name)
+	}
+}
+
+// SetTokenFormat Replace the format used by SignAccessToken and ValidateAccessToken
+func SetTokenFormat(format TokenFormat) {
+	currentTokenFormatMu.Lock()
+	defer currentTokenFormatMu.Unlock()
+	currentTokenFormat = format
+}
+
+// CurrentTokenFormat The format used by SignAccessToken and ValidateAccessToken, loaded from the environment on first use
+func CurrentTokenFormat() (TokenFormat, error) {
+	currentTokenFormatMu.RLock()
+	format := currentTokenFormat
+	currentTokenFormatMu.RUnlock()
+	if format != nil {
+		return format, nil
+	}
+
+	currentTokenFormatMu.Lock()
+	defer currentTokenFormatMu.Unlock()
+	if currentTokenFormat == nil {
+		loaded, err := TokenFormatFromEnv()
+		if err != nil {
+			return nil, err
+		}
+		currentTokenFormat = loaded
+	}
+	return currentTokenFormat, nil
+}
+
+// SignAccessToken Fill the registered claims like SignJWT and encode the access token in the current format
+func SignAccessToken(claims *CustomJWTClaims) (string, error) {
+	format, err := CurrentTokenFormat()
+	if err != nil {
+		return "", err
+	}
+	fillRegisteredClaims(claims, time.Now())
+	return format.Encode(claims)
+}
+
+// ValidateAccessToken Decode an access token in the current format and validate its claims.
+// Tokens in another format are rejected, so switching the format ends the access tokens already issued
+// while the refresh tokens keep working
+func ValidateAccessToken(token string) (*CustomJWTClaims, error) {
+	format, err := CurrentTokenFormat()
+	if err != nil {
+		return nil, err
+	}
+	claims, err := format.Decode(token)
+	if err != nil {
+		return nil, err
+	}
+	if err := validateClaims(claims, time.Now()); err != nil {
+		return nil, err
+	}
+	return claims, nil
+}
+
+// jwtTokenFormat Access tokens as JWT, see signToken and verifyJWT
+type jwtTokenFormat struct{}
+
+func (jwtTokenFormat) Name() string {
+	return TokenFormatJWT
+}
+
+func (jwtTokenFormat) Encode(claims *CustomJWTClaims) (string, error) {
+	return signToken(claims)
+}
+
+func (jwtTokenFormat) Decode(token string) (*CustomJWTClaims, error) {
+	parsedToken, err := verifyJWT(token)
+	if err != nil {
+		return nil, err
+	}
+	return parsedToken.Claims.(*CustomJWTClaims), nil
+}
+
+// pasetoKeyFooter The footer of v4.public tokens, telling which key of the key set verifies the token
+type pasetoKeyFooter struct {
+	Kid string `json:"kid"`
+}
+
+// pasetoPublicTokenFormat Access tokens as PASETO v4.public, signed with the Ed25519 signing key of the key set
+type pasetoPublicTokenFormat struct{}
+
+func (pasetoPublicTokenFormat) Name() string {
+	return TokenFormatPASETOPublic
+}
+
+func (pasetoPublicTokenFormat) Encode(claims *CustomJWTClaims) (string, error) {
+	keySet, err := CurrentKeySet()
+	if err != nil {
+		return "", err
+	}
+	signingKey := keySet.SigningKey()
+	privateKey, ok := signingKey.privateKey.(ed25519.PrivateKey)
+	if !ok {
+		return "", fmt.Errorf("%s tokens need an Ed25519 signing key, %v is %s", TokenFormatPASETOPublic, signingKey.ID, signingKey.Method.Alg())
+	}
+	message, err := marshalPASETOClaims(claims)
+	if err != nil {
+		return "", err
+	}
+	footer, err := json.Marshal(pasetoKeyFooter{Kid: signingKey.ID})
+	if err != nil {
+		return "", err
+	}
+	return SignPASETOV4(privateKey, message, footer, nil), nil
+}
+
+func (pasetoPublicTokenFormat) Decode(token string) (*CustomJWTClaims, error) {
+	keySet, err := CurrentKeySet()
+	if err != nil {
+		return nil, err
+	}
+	encodedFooter, err := PASETOFooter(token)
+	if err != nil {
+		return nil, err
+	}
+	var footer pasetoKeyFooter
+	if err := json.Unmarshal(encodedFooter, &footer); err != nil {
+		return nil, fmt.Errorf("%w: invalid footer", ErrInvalidPASETO)
+	}
+	key, ok := keySet.Key(footer.Kid)
+	if !ok {
+		return nil, fmt.Errorf("Unknown signing key: %v", footer.Kid)
+	}
+	publicKey, ok := key.publicKey.(ed25519.PublicKey)
+	if !ok {
+		return nil, fmt.Errorf("%w: key %v is not an Ed25519 key", ErrInvalidPASETO, key.ID)
+	}
+	message, err := VerifyPASETOV4(publicKey, token, nil)
+	if err != nil {
+		return nil, err
+	}
+	return unmarshalPASETOClaims(message)
+}
+
+// pasetoLocalTokenFormat Access tokens as PASETO v4.local, encrypted with a shared secret
+type pasetoLocalTokenFormat struct {
+	key []byte
+}
+
+// NewPASETOLocalTokenFormat The v4.local format encrypting with the 32 bytes key
+func NewPASETOLocalTokenFormat(key []byte) TokenFormat {
+	return pasetoLocalTokenFormat{key: key}
+}
+
+func (pasetoLocalTokenFormat) Name() string {
+	return TokenFormatPASETOLocal
+}
+
+func (f pasetoLocalTokenFormat) Encode(claims *CustomJWTClaims) (string, error) {
+	message, err := marshalPASETOClaims(claims)
+	if err != nil {
+		return "", err
+	}
+	return EncryptPASETOV4(f.key, message, nil, nil)
+}
+
+func (f pasetoLocalTokenFormat) Decode(token string) (*CustomJWTClaims, error) {
+	message, err := DecryptPASETOV4(f.key, token, nil)
+	if err != nil {
+		return nil, err
+	}
+	return unmarshalPASETOClaims(message)
+}
+
+// pasetoTimeClaims The registered claims PASETO holds as RFC 3339 times, where JWT has seconds since the epoch
+var pasetoTimeClaims = []string{"exp", "nbf", "iat"}
+
+// marshalPASETOClaims Encode the claims as the JSON payload of a PASETO, the same as the one of a JWT but for the times
+func marshalPASETOClaims(claims *CustomJWTClaims) ([]byte, error) {
+	members, err := claimsMembers(claims)
+	if err != nil {
+		return nil, err
+	}
+	for _, name := range pasetoTimeClaims {
+		if value, ok := members[name].(json.Number); ok {
+			seconds, err := value.Int64()
+			if err != nil {
+				return nil, err
+			}
+			members[name] = time.Unix(seconds, 0).UTC().Format(time.RFC3339)
+		}
+	}
+	return json.Marshal(members)
+}
+
+// unmarshalPASETOClaims Decode the JSON payload of a PASETO, see marshalPASETOClaims
+func unmarshalPASETOClaims(message []byte) (*CustomJWTClaims, error) {
+	var members map[string]interface{}
+	decoder := json.NewDecoder(bytes.NewReader(message))
+	decoder.UseNumber()
+	if err := decoder.Decode(&members); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidPASETO, err)
+	}
+	for _, name := range pasetoTimeClaims {
+		value, ok := members[name].(string)
+		if !ok {
+			continue
+		}
+		parsedTime, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			return nil, fmt.Errorf("%w: invalid %s claim", ErrInvalidPASETO, name)
+		}
+		members[name] = parsedTime.Unix()
+	}
+	encoded, err := json.Marshal(members)
+	if err != nil {
+		return nil, err
+	}
+	claims := &CustomJWTClaims{}
+	if err := json.Unmarshal(encoded, claims); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidPASETO, err)
+	}
+	return claims, nil
+}
+
+func claimsMembers(claims jwt.Claims) (map[string]interface{}, error) {
+	encoded, err := json.Marshal(claims)
+	if err != nil {
+		return nil, err
+	}
+	var members map[string]interface{}
+	decoder := json.NewDecoder(bytes.NewReader(encoded))
+	decoder.UseNumber()
+	if err := decoder.Decode(&members); err != nil {
+		return nil, err
+	}
+	return members, nil
+}
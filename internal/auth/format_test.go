@@ -0,0 +1,140 @@
+package auth
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+func useTestTokenFormat(t *testing.T, format TokenFormat) {
+	SetTokenFormat(format)
+	t.Cleanup(func() { SetTokenFormat(nil) })
+}
+
+func TestAccessTokenFormats(t *testing.T) {
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	edSigningKey, err := NewSigningKey("ed", edKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	useTestKeySet(t, NewKeySet(edSigningKey))
+	localKey := make([]byte, PASETOLocalKeySize)
+	if _, err := rand.Read(localKey); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		format TokenFormat
+		prefix string
+	}{
+		{jwtTokenFormat{}, "eyJ"},
+		{pasetoPublicTokenFormat{}, "v4.public."},
+		{NewPASETOLocalTokenFormat(localKey), "v4.local."},
+	}
+	for _, test := range tests {
+		t.Run(test.format.Name(), func(t *testing.T) {
+			useTestTokenFormat(t, test.format)
+			accountId := uuid.New()
+			token, err := SignAccessToken(&CustomJWTClaims{ID: accountId, Role: RoleAdmin, AMR: []string{AMRPassword}, Confirmation: NewConfirmation("jkt")})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !strings.HasPrefix(token, test.prefix) {
+				t.Errorf("expected a token starting with %s but got %s", test.prefix, token)
+			}
+			claims, err := ValidateAccessToken(token)
+			if err != nil {
+				t.Fatalf("expected the token to be valid but got %v", err)
+			}
+			if claims.ID != accountId || claims.Role != RoleAdmin || claims.Subject != accountId.String() ||
+				claims.Id == "" || claims.ExpiresAt == 0 || claims.DPoPJKT() != "jkt" {
+				t.Errorf("expected the claims to be kept but got %+v", claims)
+			}
+
+			// Every other format is rejected, a token can't pick how it is verified
+			for _, other := range tests {
+				if other.format.Name() == test.format.Name() {
+					continue
+				}
+				useTestTokenFormat(t, other.format)
+				if _, err := ValidateAccessToken(token); err == nil {
+					t.Errorf("expected the %s format to reject the token", other.format.Name())
+				}
+			}
+		})
+	}
+
+	t.Run("PASETO times", func(t *testing.T) {
+		useTestTokenFormat(t, pasetoPublicTokenFormat{})
+		token, err := SignAccessToken(&CustomJWTClaims{ID: uuid.New()})
+		if err != nil {
+			t.Fatal(err)
+		}
+		payload, err := base64.RawURLEncoding.DecodeString(strings.Split(token, ".")[2])
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !strings.Contains(string(payload), `"exp":"20`) {
+			t.Errorf("expected exp to be a RFC 3339 time but got %s", payload)
+		}
+	})
+
+	t.Run("v4.public needs an Ed25519 key", func(t *testing.T) {
+		useTestKeySet(t, NewKeySet(NewHMACSigningKey("test", []byte("test-secret"))))
+		useTestTokenFormat(t, pasetoPublicTokenFormat{})
+		if _, err := SignAccessToken(&CustomJWTClaims{ID: uuid.New()}); err == nil {
+			t.Errorf("expected signing with a HMAC key to fail")
+		}
+	})
+
+	t.Run("expired", func(t *testing.T) {
+		t.Setenv("JWT_LEEWAY", "0s")
+		useTestTokenFormat(t, NewPASETOLocalTokenFormat(localKey))
+		claims := &CustomJWTClaims{ID: uuid.New()}
+		token, err := SignAccessToken(claims)
+		if err != nil {
+			t.Fatal(err)
+		}
+		claims.ExpiresAt = time.Now().Add(-time.Minute).Unix()
+		if token, err = NewPASETOLocalTokenFormat(localKey).Encode(claims); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := ValidateAccessToken(token); !errors.Is(err, ErrTokenExpired) {
+			t.Errorf("expected ErrTokenExpired but got %v", err)
+		}
+	})
+}
+
+func TestTokenFormatFromEnv(t *testing.T) {
+	tests := []struct {
+		format      string
+		key         string
+		expectError bool
+	}{
+		{"", "", false},
+		{TokenFormatJWT, "", false},
+		{TokenFormatPASETOPublic, "", false},
+		{TokenFormatPASETOLocal, strings.Repeat("ab", PASETOLocalKeySize), false},
+		{TokenFormatPASETOLocal, "", true},
+		{TokenFormatPASETOLocal, "abcd", true},
+		{"v2.local", "", true},
+	}
+	for _, test := range tests {
+		t.Run(test.format, func(t *testing.T) {
+			t.Setenv("ACCESS_TOKEN_FORMAT", test.format)
+			t.Setenv("PASETO_LOCAL_KEY", test.key)
+			if _, err := TokenFormatFromEnv(); test.expectError != (err != nil) {
+				t.Errorf("expected error %v but got %v", test.expectError, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,182 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// KeyStatus Where a key is in its rotation lifecycle: pending -> active -> inactive -> retired (removed)
+type KeyStatus string
+
+const (
+	// KeyStatusPending The key is published and trusted for verification but doesn't sign yet,
+	// this gives every server instance and JWKS cache time to learn about it before it is used
+	KeyStatusPending KeyStatus = "pending"
+	// KeyStatusActive The only key signing new tokens
+	KeyStatusActive KeyStatus = "active"
+	// KeyStatusInactive A previously active key, kept to verify the tokens it signed until they expire
+	KeyStatusInactive KeyStatus = "inactive"
+)
+
+var (
+	ErrKeyNotFound     = errors.New("signing key not found")
+	ErrKeyStillInUse   = errors.New("signing key may still have unexpired tokens")
+	ErrKeyInvalidState = errors.New("signing key is not in a valid state for this operation")
+)
+
+// MaxTokenLifetime The longest time a token signed by a key can stay valid, tokens are accepted until Leeway after they expire.
+// An inactive key can only be retired once this much time has passed since it stopped signing
+func MaxTokenLifetime() time.Duration {
+	return AccessTokenTTL() + Leeway()
+}
+
+// KeyRingEntry A signing key with its rotation state
+type KeyRingEntry struct {
+	Key           *SigningKey
+	Status        KeyStatus
+	CreatedAt     time.Time
+	ActivatedAt   *time.Time
+	DeactivatedAt *time.Time
+}
+
+// KeyRing The signing keys with their rotation state.
+// Several keys can verify tokens at the same time while only the active one signs
+type KeyRing struct {
+	entries []*KeyRingEntry
+}
+
+func NewKeyRing(entries ...*KeyRingEntry) *KeyRing {
+	return &KeyRing{entries: entries}
+}
+
+// Entries The keys in the ring, in the order they were added
+func (r *KeyRing) Entries() []*KeyRingEntry {
+	return r.entries
+}
+
+func (r *KeyRing) entry(kid string) (*KeyRingEntry, error) {
+	for _, entry := range r.entries {
+		if entry.Key.ID == kid {
+			return entry, nil
+		}
+	}
+	return nil, ErrKeyNotFound
+}
+
+// Add Introduce a new key as pending
+func (r *KeyRing) Add(key *SigningKey, now time.Time) (*KeyRingEntry, error) {
+	if _, err := r.entry(key.ID); err == nil {
+		return nil, fmt.Errorf("signing key %v already exists", key.ID)
+	}
+	entry := &KeyRingEntry{Key: key, Status: KeyStatusPending, CreatedAt: now}
+	r.entries = append(r.entries, entry)
+	return entry, nil
+}
+
+// Promote Make a pending key the active one, the previous active key becomes inactive
+func (r *KeyRing) Promote(kid string, now time.Time) error {
+	promoted, err := r.entry(kid)
+	if err != nil {
+		return err
+	}
+	if promoted.Status != KeyStatusPending {
+		return fmt.Errorf("%w: %v is %v, only pending keys can be promoted", ErrKeyInvalidState, kid, promoted.Status)
+	}
+	for _, entry := range r.entries {
+		if entry.Status == KeyStatusActive {
+			entry.Status = KeyStatusInactive
+			deactivatedAt := now
+			entry.DeactivatedAt = &deactivatedAt
+		}
+	}
+	promoted.Status = KeyStatusActive
+	activatedAt := now
+	promoted.ActivatedAt = &activatedAt
+	return nil
+}
+
+// Retire Remove a key from the ring. Pending keys never signed anything and can be removed at any time,
+// inactive keys only once maxTokenLifetime has passed since they stopped signing. The active key can't be retired
+func (r *KeyRing) Retire(kid string, now time.Time, maxTokenLifetime time.Duration) error {
+	retired, err := r.entry(kid)
+	if err != nil {
+		return err
+	}
+	switch retired.Status {
+	case KeyStatusActive:
+		return fmt.Errorf("%w: %v is active, promote another key first", ErrKeyInvalidState, kid)
+	case KeyStatusInactive:
+		if retireAt := retired.DeactivatedAt.Add(maxTokenLifetime); now.Before(retireAt) {
+			return fmt.Errorf("%w: %v can be retired after %v", ErrKeyStillInUse, kid, retireAt.Format(time.RFC3339))
+		}
+	}
+
+	entries := make([]*KeyRingEntry, 0, len(r.entries)-1)
+	for _, entry := range r.entries {
+		if entry != retired {
+			entries = append(entries, entry)
+		}
+	}
+	r.entries = entries
+	return nil
+}
+
+// KeySet Build the key set used to sign and verify tokens.
+// The fallback key, configured from the environment, signs while the ring has no active key
+// and always stays trusted for verification so switching to the ring doesn't invalidate issued tokens
+func (r *KeyRing) KeySet(fallback *SigningKey) *KeySet {
+	signingKey := fallback
+	verificationKeys := []*SigningKey{}
+	for _, entry := range r.entries {
+		if entry.Status == KeyStatusActive {
+			signingKey = entry.Key
+		} else {
+			verificationKeys = append(verificationKeys, entry.Key)
+		}
+	}
+	if signingKey != fallback && fallback != nil {
+		verificationKeys = append(verificationKeys, fallback)
+	}
+	return NewKeySet(signingKey, verificationKeys...)
+}
+
+// GenerateSigningKey Create a new random key for one of the supported asymmetric algorithms: RS256, ES256 or EdDSA
+func GenerateSigningKey(alg string) (*SigningKey, error) {
+	var privateKey crypto.PrivateKey
+	var err error
+	switch alg {
+	case "RS256":
+		privateKey, err = rsa.GenerateKey(rand.Reader, 2048)
+	case "ES256":
+		privateKey, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	case "EdDSA":
+		_, privateKey, err = ed25519.GenerateKey(rand.Reader)
+	default:
+		return nil, fmt.Errorf("unsupported signing algorithm %q", alg)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return NewSigningKey("", privateKey)
+}
+
+// MarshalPEM Encode the private key as a PKCS #8 PEM block, the inverse of ParseSigningKeyPEM
+func (k *SigningKey) MarshalPEM() ([]byte, error) {
+	if k.IsSymmetric() {
+		return nil, errors.New("HMAC keys can't be encoded as PEM")
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(k.privateKey)
+	if err != nil {
+		return nil, err
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), nil
+}
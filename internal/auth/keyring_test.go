@@ -0,0 +1,112 @@
+package auth
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+func TestKeyRingRotation(t *testing.T) {
+	fallbackKey := NewHMACSigningKey("legacy", []byte("test-secret"))
+	oldKey, err := GenerateSigningKey("ES256")
+	if err != nil {
+		t.Fatal(err)
+	}
+	newKey, err := GenerateSigningKey("EdDSA")
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	ring := NewKeyRing()
+
+	// Issue a token with the legacy key, it must survive the whole rotation
+	useTestKeySet(t, ring.KeySet(fallbackKey))
+	legacyToken, err := CreateJWT(uuid.New(), RoleCustomer)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := ring.Add(oldKey, now); err != nil {
+		t.Fatal(err)
+	}
+	if err := ring.Promote(oldKey.ID, now); err != nil {
+		t.Fatal(err)
+	}
+	SetKeySet(ring.KeySet(fallbackKey))
+	oldToken, err := CreateJWT(uuid.New(), RoleCustomer)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := ring.Add(newKey, now); err != nil {
+		t.Fatal(err)
+	}
+	if ks := ring.KeySet(fallbackKey); ks.SigningKey() != oldKey {
+		t.Errorf("expected a pending key not to sign")
+	}
+	if err := ring.Promote(newKey.ID, now); err != nil {
+		t.Fatal(err)
+	}
+	SetKeySet(ring.KeySet(fallbackKey))
+	if signingKey := currentKeySetOrFail(t).SigningKey(); signingKey != newKey {
+		t.Errorf("expected the promoted key %s to sign but got %s", newKey.ID, signingKey.ID)
+	}
+	for _, tokenString := range []string{legacyToken, oldToken} {
+		if _, err := ValidateJWT(tokenString); err != nil {
+			t.Errorf("expected token signed before the rotation to stay valid but got %v", err)
+		}
+	}
+
+	if err := ring.Retire(newKey.ID, now, time.Hour); !errors.Is(err, ErrKeyInvalidState) {
+		t.Errorf("expected the active key not to be retired but got %v", err)
+	}
+	if err := ring.Retire(oldKey.ID, now.Add(time.Minute), time.Hour); !errors.Is(err, ErrKeyStillInUse) {
+		t.Errorf("expected the inactive key not to be retired before the max token lifetime but got %v", err)
+	}
+	if err := ring.Retire(oldKey.ID, now.Add(time.Hour), time.Hour); err != nil {
+		t.Fatalf("expected the inactive key to be retired but got %v", err)
+	}
+	SetKeySet(ring.KeySet(fallbackKey))
+	if _, err := ValidateJWT(oldToken); err == nil {
+		t.Errorf("expected token signed by a retired key to be rejected")
+	}
+}
+
+func TestMaxTokenLifetimeIncludesLeeway(t *testing.T) {
+	t.Setenv("JWT_ACCESS_TOKEN_TTL", "10m")
+	t.Setenv("JWT_LEEWAY", "30s")
+	if lifetime := MaxTokenLifetime(); lifetime != 10*time.Minute+30*time.Second {
+		t.Errorf("expected tokens to live for their ttl and the leeway but got %v", lifetime)
+	}
+}
+
+func TestKeyRingPromoteRequiresPendingKey(t *testing.T) {
+	if err := NewKeyRing().Promote("unknown", time.Now()); !errors.Is(err, ErrKeyNotFound) {
+		t.Errorf("expected ErrKeyNotFound but got %v", err)
+	}
+}
+
+func TestSigningKeyPEMRoundTrip(t *testing.T) {
+	for _, alg := range []string{"RS256", "ES256", "EdDSA"} {
+		key, err := GenerateSigningKey(alg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		pemBytes, err := key.MarshalPEM()
+		if err != nil {
+			t.Fatal(err)
+		}
+		parsed, err := ParseSigningKeyPEM(key.ID, pemBytes)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if parsed.Method.Alg() != alg {
+			t.Errorf("expected alg %s but got %s", alg, parsed.Method.Alg())
+		}
+		if thumbprint, _ := parsed.Thumbprint(); thumbprint != key.ID {
+			t.Errorf("expected parsed key to have thumbprint %s but got %s", key.ID, thumbprint)
+		}
+	}
+}
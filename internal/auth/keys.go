@@ -0,0 +1,327 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"sync"
+
+	"github.com/golang-jwt/jwt"
+)
+
+// defaultHMACKeyID The kid used for the JWT_SECRET key when JWT_SIGNING_KEY_ID is not set
+const defaultHMACKeyID = "default"
+
+// SigningKey A key able to sign and verify JWT, identified by the kid header of the token
+type SigningKey struct {
+	ID     string
+	Method jwt.SigningMethod
+	// privateKey The key passed to the signing method when signing, []byte for HMAC
+	privateKey interface{}
+	// publicKey The key passed to the signing method when verifying, []byte for HMAC
+	publicKey interface{}
+}
+
+// NewHMACSigningKey Create a HS256 key from a shared secret.
+// HMAC keys are never published in the JWKS as the secret is used to verify as well
+func NewHMACSigningKey(kid string, secret []byte) *SigningKey {
+	return &SigningKey{
+		ID:         kid,
+		Method:     jwt.SigningMethodHS256,
+		privateKey: secret,
+		publicKey:  secret,
+	}
+}
+
+// NewSigningKey Create a signing key from a RSA, ECDSA or Ed25519 private key.
+// The signing method is picked from the key type, RSA keys use RS256 and ECDSA keys use the ES algorithm matching their curve.
+// When kid is empty the RFC 7638 thumbprint of the public key is used
+func NewSigningKey(kid string, privateKey crypto.PrivateKey) (*SigningKey, error) {
+	key := &SigningKey{ID: kid, privateKey: privateKey}
+	switch k := privateKey.(type) {
+	case *rsa.PrivateKey:
+		key.Method = jwt.SigningMethodRS256
+		key.publicKey = &k.PublicKey
+	case *ecdsa.PrivateKey:
+		switch k.Curve {
+		case elliptic.P256():
+			key.Method = jwt.SigningMethodES256
+		case elliptic.P384():
+			key.Method = jwt.SigningMethodES384
+		case elliptic.P521():
+			key.Method = jwt.SigningMethodES512
+		default:
+			return nil, fmt.Errorf("unsupported ECDSA curve %v", k.Curve.Params().Name)
+		}
+		key.publicKey = &k.PublicKey
+	case ed25519.PrivateKey:
+		key.Method = jwt.SigningMethodEdDSA
+		key.publicKey = k.Public().(ed25519.PublicKey)
+	default:
+		return nil, fmt.Errorf("unsupported private key type %T", privateKey)
+	}
+
+	if key.ID == "" {
+		thumbprint, err := key.Thumbprint()
+		if err != nil {
+			return nil, err
+		}
+		key.ID = thumbprint
+	}
+	return key, nil
+}
+
+// ParseSigningKeyPEM Parse a PEM encoded RSA, ECDSA or Ed25519 private key.
+// Supports PKCS #1 ("RSA PRIVATE KEY"), SEC 1 ("EC PRIVATE KEY") and PKCS #8 ("PRIVATE KEY") blocks
+func ParseSigningKeyPEM(kid string, pemBytes []byte) (*SigningKey, error) {
+	block, _ := pem.Decode(pemBytes)
+	if block == nil {
+		return nil, errors.New("no PEM block found in signing key")
+	}
+
+	var privateKey crypto.PrivateKey
+	var err error
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		privateKey, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "EC PRIVATE KEY":
+		privateKey, err = x509.ParseECPrivateKey(block.Bytes)
+	case "PRIVATE KEY":
+		privateKey, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	default:
+		return nil, fmt.Errorf("unsupported PEM block type %q", block.Type)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return NewSigningKey(kid, privateKey)
+}
+
+// LoadSigningKeyFile Read and parse a PEM encoded private key from disk
+func LoadSigningKeyFile(kid, path string) (*SigningKey, error) {
+	pemBytes, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return ParseSigningKeyPEM(kid, pemBytes)
+}
+
+// IsSymmetric Report if the key is a shared secret, which must never be published
+func (k *SigningKey) IsSymmetric() bool {
+	_, ok := k.publicKey.([]byte)
+	return ok
+}
+
+// JWK The public part of a signing key as a JSON Web Key (RFC 7517)
+type JWK struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid,omitempty"`
+	Use string `json:"use,omitempty"`
+	Alg string `json:"alg,omitempty"`
+	// RSA
+	N string `json:"n,omitempty"`
+	E string `json:"e,omitempty"`
+	// EC and OKP
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+	Y   string `json:"y,omitempty"`
+}
+
+// JWKS A JSON Web Key Set, the document served on /.well-known/jwks.json
+type JWKS struct {
+	Keys []JWK `json:"keys"`
+}
+
+// PublicJWK Convert the public key to a JWK, an error is returned for HMAC keys
+func (k *SigningKey) PublicJWK() (JWK, error) {
+	jwk, err := publicKeyToJWK(k.publicKey)
+	if err != nil {
+		return JWK{}, err
+	}
+	jwk.Kid = k.ID
+	jwk.Use = "sig"
+	jwk.Alg = k.Method.Alg()
+	return jwk, nil
+}
+
+// Thumbprint The RFC 7638 JWK thumbprint of the public key, base64url encoded
+func (k *SigningKey) Thumbprint() (string, error) {
+	jwk, err := publicKeyToJWK(k.publicKey)
+	if err != nil {
+		return "", err
+	}
+	return JWKThumbprint(jwk)
+}
+
+// JWKThumbprint Compute the RFC 7638 thumbprint of a public JWK.
+// Only the required members are hashed, in lexicographic order
+func JWKThumbprint(jwk JWK) (string, error) {
+	var members interface{}
+	switch jwk.Kty {
+	case "RSA":
+		members = struct {
+			E   string `json:"e"`
+			Kty string `json:"kty"`
+			N   string `json:"n"`
+		}{jwk.E, jwk.Kty, jwk.N}
+	case "EC":
+		members = struct {
+			Crv string `json:"crv"`
+			Kty string `json:"kty"`
+			X   string `json:"x"`
+			Y   string `json:"y"`
+		}{jwk.Crv, jwk.Kty, jwk.X, jwk.Y}
+	case "OKP":
+		members = struct {
+			Crv string `json:"crv"`
+			Kty string `json:"kty"`
+			X   string `json:"x"`
+		}{jwk.Crv, jwk.Kty, jwk.X}
+	default:
+		return "", fmt.Errorf("unsupported key type %q", jwk.Kty)
+	}
+	encoded, err := json.Marshal(members)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(encoded)
+	return base64.RawURLEncoding.EncodeToString(sum[:]), nil
+}
+
+func publicKeyToJWK(publicKey interface{}) (JWK, error) {
+	switch k := publicKey.(type) {
+	case *rsa.PublicKey:
+		return JWK{
+			Kty: "RSA",
+			N:   base64.RawURLEncoding.EncodeToString(k.N.Bytes()),
+			E:   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(k.E)).Bytes()),
+		}, nil
+	case *ecdsa.PublicKey:
+		size := (k.Curve.Params().BitSize + 7) / 8
+		return JWK{
+			Kty: "EC",
+			Crv: k.Curve.Params().Name,
+			X:   base64.RawURLEncoding.EncodeToString(k.X.FillBytes(make([]byte, size))),
+			Y:   base64.RawURLEncoding.EncodeToString(k.Y.FillBytes(make([]byte, size))),
+		}, nil
+	case ed25519.PublicKey:
+		return JWK{
+			Kty: "OKP",
+			Crv: "Ed25519",
+			X:   base64.RawURLEncoding.EncodeToString(k),
+		}, nil
+	default:
+		return JWK{}, fmt.Errorf("key type %T cannot be published as a JWK", publicKey)
+	}
+}
+
+// KeySet The keys known by the server, one of them is used to sign new tokens
+// and all of them can verify tokens carrying their kid
+type KeySet struct {
+	signingKey *SigningKey
+	keys       map[string]*SigningKey
+}
+
+func NewKeySet(signingKey *SigningKey, verificationKeys ...*SigningKey) *KeySet {
+	keys := map[string]*SigningKey{signingKey.ID: signingKey}
+	for _, key := range verificationKeys {
+		keys[key.ID] = key
+	}
+	return &KeySet{signingKey: signingKey, keys: keys}
+}
+
+// SigningKey The key used to sign new tokens
+func (ks *KeySet) SigningKey() *SigningKey {
+	return ks.signingKey
+}
+
+// Key Find the key matching the kid of a token
+func (ks *KeySet) Key(kid string) (*SigningKey, bool) {
+	key, ok := ks.keys[kid]
+	return key, ok
+}
+
+// PublicJWKS The public keys of the set, HMAC keys are left out
+func (ks *KeySet) PublicJWKS() JWKS {
+	jwks := JWKS{Keys: []JWK{}}
+	for _, key := range ks.keys {
+		if key.IsSymmetric() {
+			continue
+		}
+		if jwk, err := key.PublicJWK(); err == nil {
+			jwks.Keys = append(jwks.Keys, jwk)
+		}
+	}
+	return jwks
+}
+
+var (
+	currentKeySet   *KeySet
+	currentKeySetMu sync.RWMutex
+)
+
+// LoadSigningKeyFromEnv Load the signing key configured in the environment.
+// When JWT_SIGNING_KEY_FILE points to a PEM private key it is used to sign with RS256, ES256 or EdDSA,
+// otherwise the HS256 JWT_SECRET is used. JWT_SIGNING_KEY_ID overrides the kid of the key
+func LoadSigningKeyFromEnv() (*SigningKey, error) {
+	kid := os.Getenv("JWT_SIGNING_KEY_ID")
+	if path := os.Getenv("JWT_SIGNING_KEY_FILE"); path != "" {
+		signingKey, err := LoadSigningKeyFile(kid, path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load JWT signing key %v: %w", path, err)
+		}
+		return signingKey, nil
+	}
+	if kid == "" {
+		kid = defaultHMACKeyID
+	}
+	return NewHMACSigningKey(kid, []byte(os.Getenv("JWT_SECRET"))), nil
+}
+
+// LoadKeySetFromEnv Build a key set holding only the signing key configured in the environment
+func LoadKeySetFromEnv() (*KeySet, error) {
+	signingKey, err := LoadSigningKeyFromEnv()
+	if err != nil {
+		return nil, err
+	}
+	return NewKeySet(signingKey), nil
+}
+
+// SetKeySet Replace the keys used by CreateJWT and ValidateJWT
+func SetKeySet(ks *KeySet) {
+	currentKeySetMu.Lock()
+	defer currentKeySetMu.Unlock()
+	currentKeySet = ks
+}
+
+// CurrentKeySet The keys used by CreateJWT and ValidateJWT, loaded from the environment on first use
+func CurrentKeySet() (*KeySet, error) {
+	currentKeySetMu.RLock()
+	ks := currentKeySet
+	currentKeySetMu.RUnlock()
+	if ks != nil {
+		return ks, nil
+	}
+
+	currentKeySetMu.Lock()
+	defer currentKeySetMu.Unlock()
+	if currentKeySet == nil {
+		loaded, err := LoadKeySetFromEnv()
+		if err != nil {
+			return nil, err
+		}
+		currentKeySet = loaded
+	}
+	return currentKeySet, nil
+}
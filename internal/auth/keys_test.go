@@ -0,0 +1,149 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"testing"
+
+	"github.com/golang-jwt/jwt"
+	"github.com/google/uuid"
+)
+
+func generatePEM(t *testing.T, privateKey crypto.PrivateKey) []byte {
+	t.Helper()
+	der, err := x509.MarshalPKCS8PrivateKey(privateKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})
+}
+
+func TestAsymmetricSigningKeys(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name       string
+		privateKey crypto.PrivateKey
+		alg        string
+		kty        string
+	}{
+		{"RSA", rsaKey, "RS256", "RSA"},
+		{"ECDSA", ecKey, "ES256", "EC"},
+		{"Ed25519", edKey, "EdDSA", "OKP"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			signingKey, err := ParseSigningKeyPEM("", generatePEM(t, test.privateKey))
+			if err != nil {
+				t.Fatalf("failed to parse PEM %v", err)
+			}
+			if signingKey.Method.Alg() != test.alg {
+				t.Errorf("expected alg %s but got %s", test.alg, signingKey.Method.Alg())
+			}
+			useTestKeySet(t, NewKeySet(signingKey))
+
+			tokenString, err := CreateJWT(uuid.New(), RoleCustomer)
+			if err != nil {
+				t.Fatalf("failed to create token %v", err)
+			}
+			token, err := ValidateJWT(tokenString)
+			if err != nil {
+				t.Fatalf("expected token to be valid but got %v", err)
+			}
+			if token.Header["kid"] != signingKey.ID {
+				t.Errorf("expected kid %s but got %v", signingKey.ID, token.Header["kid"])
+			}
+
+			jwks := currentKeySetOrFail(t).PublicJWKS()
+			if len(jwks.Keys) != 1 || jwks.Keys[0].Kty != test.kty || jwks.Keys[0].Kid != signingKey.ID {
+				t.Errorf("expected one %s JWK with kid %s but got %v", test.kty, signingKey.ID, jwks.Keys)
+			}
+		})
+	}
+}
+
+func currentKeySetOrFail(t *testing.T) *KeySet {
+	t.Helper()
+	ks, err := CurrentKeySet()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ks
+}
+
+func TestHMACKeyIsNotPublished(t *testing.T) {
+	ks := NewKeySet(NewHMACSigningKey("test", []byte("test-secret")))
+	if jwks := ks.PublicJWKS(); len(jwks.Keys) != 0 {
+		t.Errorf("expected no published keys but got %v", jwks.Keys)
+	}
+}
+
+func TestValidateJWTRejectsUnknownKid(t *testing.T) {
+	useTestKeySet(t, NewKeySet(NewHMACSigningKey("test", []byte("test-secret"))))
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, &CustomJWTClaims{ID: uuid.New()})
+	token.Header["kid"] = "unknown"
+	tokenString, err := token.SignedString([]byte("test-secret"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ValidateJWT(tokenString); err == nil {
+		t.Errorf("expected token with unknown kid to be rejected")
+	}
+}
+
+func TestValidateJWTRejectsAlgorithmConfusion(t *testing.T) {
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signingKey, err := NewSigningKey("ed", edKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	useTestKeySet(t, NewKeySet(signingKey))
+
+	// A HS256 token signed with the public key must not be accepted for an EdDSA kid
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, &CustomJWTClaims{ID: uuid.New()})
+	token.Header["kid"] = "ed"
+	tokenString, err := token.SignedString([]byte(edKey.Public().(ed25519.PublicKey)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ValidateJWT(tokenString); err == nil {
+		t.Errorf("expected HS256 token to be rejected for an EdDSA key")
+	}
+}
+
+func TestJWKThumbprint(t *testing.T) {
+	// Example from RFC 7638 section 3.1
+	jwk := JWK{
+		Kty: "RSA",
+		E:   "AQAB",
+		N:   "0vx7agoebGcQSuuPiLJXZptN9nndrQmbXEps2aiAFbWhM78LhWx4cbbfAAtVT86zwu1RK7aPFFxuhDR1L6tSoc_BJECPebWKRXjBZCiFV4n3oknjhMstn64tZ_2W-5JsGY4Hc5n9yBXArwl93lqt7_RN5w6Cf0h4QyQ5v-65YGjQR0_FDW2QvzqY368QQMicAtaSqzs8KJZgnYb9c7d0zgdAZHzu6qMQvRL5hajrn1n91CbOpbISD08qNLyrdkt-bFTWhAI4vMQFh6WeZu0fM4lFd2NcRwr3XPksINHaQ-G_xBniIqbw0Ls1jF44-csFCur-kEgU8awapJzKnqDKgw",
+	}
+	thumbprint, err := JWKThumbprint(jwk)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := "NzbLsXh8uDCcd-6MNwXF4W_7noWXFZAfHkxZsRGC9Xs"
+	if thumbprint != expected {
+		t.Errorf("expected thumbprint %s but got %s", expected, thumbprint)
+	}
+}
@@ -0,0 +1,49 @@
+package auth
+
+import (
+	"time"
+
+	"github.com/golang-jwt/jwt"
+	"github.com/google/uuid"
+)
+
+// mfaTokenTTL How long the account has to enter its second factor after its password
+const mfaTokenTTL = 5 * time.Minute
+
+// MFATokenTTL How long an MFA token created by CreateMFAToken stays valid
+func MFATokenTTL() time.Duration {
+	return mfaTokenTTL
+}
+
+// mfaAudience MFA tokens are not access tokens, a different audience makes ValidateJWT reject them
+func mfaAudience() string {
+	return Audience() + "/mfa"
+}
+
+// CreateMFAToken Create the short lived token proving the account passed its first factor,
+// exchanged with a second factor for an access token. amr tells how the first factor was passed, the password when not set
+func CreateMFAToken(accountId uuid.UUID, amr ...string) (string, error) {
+	now := time.Now()
+	return signToken(&CustomJWTClaims{
+		ID:  accountId,
+		AMR: amr,
+		StandardClaims: jwt.StandardClaims{
+			Id:        uuid.NewString(),
+			Subject:   accountId.String(),
+			Issuer:    Issuer(),
+			Audience:  mfaAudience(),
+			IssuedAt:  now.Unix(),
+			NotBefore: now.Unix(),
+			ExpiresAt: now.Add(mfaTokenTTL).Unix(),
+		},
+	})
+}
+
+// ValidateMFAToken Validate a token created by CreateMFAToken, access tokens are rejected
+func ValidateMFAToken(tokenString string) (*CustomJWTClaims, error) {
+	token, err := parseToken(tokenString, mfaAudience())
+	if err != nil {
+		return nil, err
+	}
+	return token.Claims.(*CustomJWTClaims), nil
+}
@@ -0,0 +1,45 @@
+package auth
+
+import (
+	"time"
+
+	"github.com/golang-jwt/jwt"
+)
+
+// UserInfo The standard claims about an account (OpenID Connect Core section 5.1),
+// carried by ID tokens and returned by the userinfo endpoint
+type UserInfo struct {
+	Name       string `json:"name,omitempty"`
+	GivenName  string `json:"given_name,omitempty"`
+	FamilyName string `json:"family_name,omitempty"`
+	Email      string `json:"email,omitempty"`
+}
+
+// IDTokenClaims The claims of an OpenID Connect ID token (OpenID Connect Core section 2).
+// The audience is the client the token was issued to, not our API
+type IDTokenClaims struct {
+	// Nonce The nonce of the authorization request, so the client can tie the ID token to it
+	Nonce string `json:"nonce,omitempty"`
+	// AuthTime When the account entered its credentials
+	AuthTime int64 `json:"auth_time,omitempty"`
+	UserInfo
+	jwt.StandardClaims
+}
+
+// CreateIDToken Sign an ID token telling the client who the account is.
+// The account id is the subject, the client id the audience, and it expires with the access token issued alongside
+func CreateIDToken(subject, clientId, nonce string, authTime time.Time, userInfo UserInfo) (string, error) {
+	now := time.Now()
+	return signToken(&IDTokenClaims{
+		Nonce:    nonce,
+		AuthTime: authTime.Unix(),
+		UserInfo: userInfo,
+		StandardClaims: jwt.StandardClaims{
+			Subject:   subject,
+			Issuer:    Issuer(),
+			Audience:  clientId,
+			IssuedAt:  now.Unix(),
+			ExpiresAt: now.Add(AccessTokenTTL()).Unix(),
+		},
+	})
+}
@@ -0,0 +1,89 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+)
+
+// opaqueTokenBytes Amount of random bytes in opaque tokens and secrets, 32 bytes gives 256 bits of entropy
+const opaqueTokenBytes = 32
+
+// newOpaqueToken Generate a random token with its hash, only the hash should be persisted
+func newOpaqueToken() (token string, tokenHash string, err error) {
+	buf := make([]byte, opaqueTokenBytes)
+	if _, err := rand.Read(buf); err != nil {
+		return "", "", err
+	}
+	token = base64.RawURLEncoding.EncodeToString(buf)
+	return token, hashOpaqueToken(token), nil
+}
+
+// hashOpaqueToken Opaque tokens already have high entropy so a fast hash is enough here, unlike passwords
+func hashOpaqueToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// NewRefreshToken Generate an opaque refresh token to hand to the client.
+// The token itself is never stored, only the hash returned alongside it should be persisted
+func NewRefreshToken() (token string, tokenHash string, err error) {
+	return newOpaqueToken()
+}
+
+// HashRefreshToken Hash the refresh token received from the client so it can be looked up in the database
+func HashRefreshToken(token string) string {
+	return hashOpaqueToken(token)
+}
+
+// NewClientSecret Generate the secret of an OAuth client, it is shown once and only its hash is stored
+func NewClientSecret() (secret string, secretHash string, err error) {
+	return newOpaqueToken()
+}
+
+// CheckClientSecret Compare the secret sent by an OAuth client with the stored hash in constant time
+func CheckClientSecret(secret, secretHash string) bool {
+	return subtle.ConstantTimeCompare([]byte(hashOpaqueToken(secret)), []byte(secretHash)) == 1
+}
+
+// NewAuthorizationCode Generate an OAuth authorization code, only the hash returned alongside it should be persisted
+func NewAuthorizationCode() (code string, codeHash string, err error) {
+	return newOpaqueToken()
+}
+
+// HashAuthorizationCode Hash the authorization code received at the token endpoint so it can be looked up in the database
+func HashAuthorizationCode(code string) string {
+	return hashOpaqueToken(code)
+}
+
+// NewPasswordResetToken Generate the token sent by email to reset a password, only the hash returned alongside it should be persisted
+func NewPasswordResetToken() (token string, tokenHash string, err error) {
+	return newOpaqueToken()
+}
+
+// HashPasswordResetToken Hash the password reset token received from the client so it can be looked up in the database
+func HashPasswordResetToken(token string) string {
+	return hashOpaqueToken(token)
+}
+
+// NewEmailVerificationToken Generate the token sent to verify the email of an account, only the hash returned alongside it should be persisted
+func NewEmailVerificationToken() (token string, tokenHash string, err error) {
+	return newOpaqueToken()
+}
+
+// HashEmailVerificationToken Hash the email verification token received from the client so it can be looked up in the database
+func HashEmailVerificationToken(token string) string {
+	return hashOpaqueToken(token)
+}
+
+// NewMagicLinkToken Generate the token of a passwordless sign in link, only the hash returned alongside it should be persisted
+func NewMagicLinkToken() (token string, tokenHash string, err error) {
+	return newOpaqueToken()
+}
+
+// HashMagicLinkToken Hash the magic link token received from the client so it can be looked up in the database
+func HashMagicLinkToken(token string) string {
+	return hashOpaqueToken(token)
+}
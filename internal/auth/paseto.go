@@ -0,0 +1,202 @@
+package auth
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/blake2b"
+	"golang.org/x/crypto/chacha20"
+)
+
+// Headers of the PASETO version 4 tokens, the version and purpose fix the algorithms so there is no alg to confuse
+const (
+	pasetoV4PublicHeader = "v4.public."
+	pasetoV4LocalHeader  = "v4.local."
+)
+
+// PASETOLocalKeySize The size of a v4.local key, 32 random bytes
+const PASETOLocalKeySize = 32
+
+// Sizes of the parts of v4 tokens
+const (
+	pasetoV4NonceSize = 32
+	pasetoV4MACSize   = 32
+)
+
+// ErrInvalidPASETO Returned, wrapped with the reason, for any PASETO that can't be verified or decrypted
+var ErrInvalidPASETO = errors.New("invalid PASETO")
+
+// pae The pre-authentication encoding of PASETO, the length of every piece is encoded
+// so pieces can't be moved from one to another
+func pae(pieces ...[]byte) []byte {
+	encoded := binary.LittleEndian.AppendUint64(nil, uint64(len(pieces)))
+	for _, piece := range pieces {
+		encoded = binary.LittleEndian.AppendUint64(encoded, uint64(len(piece)))
+		encoded = append(encoded, piece...)
+	}
+	return encoded
+}
+
+// pasetoToken Assemble the token from its header, payload and optional footer
+func pasetoToken(header string, payload, footer []byte) string {
+	token := header + base64.RawURLEncoding.EncodeToString(payload)
+	if len(footer) > 0 {
+		token += "." + base64.RawURLEncoding.EncodeToString(footer)
+	}
+	return token
+}
+
+// pasetoEncoding Tokens are encoded without padding, decoding is strict so a token has a single encoding
+var pasetoEncoding = base64.RawURLEncoding.Strict()
+
+// splitPASETO Decode the payload and the footer of a token, which must start with the header
+func splitPASETO(token, header string) ([]byte, []byte, error) {
+	if !strings.HasPrefix(token, header) {
+		return nil, nil, fmt.Errorf("%w: expected a %s token", ErrInvalidPASETO, strings.TrimSuffix(header, "."))
+	}
+	encodedPayload, encodedFooter, _ := strings.Cut(strings.TrimPrefix(token, header), ".")
+	payload, err := pasetoEncoding.DecodeString(encodedPayload)
+	if err != nil {
+		return nil, nil, fmt.Errorf("%w: %v", ErrInvalidPASETO, err)
+	}
+	footer, err := pasetoEncoding.DecodeString(encodedFooter)
+	if err != nil {
+		return nil, nil, fmt.Errorf("%w: %v", ErrInvalidPASETO, err)
+	}
+	return payload, footer, nil
+}
+
+// PASETOFooter The footer of a token, before its signature or MAC is checked.
+// It is only meant to find the key, e.g. by its kid
+func PASETOFooter(token string) ([]byte, error) {
+	for _, header := range []string{pasetoV4PublicHeader, pasetoV4LocalHeader} {
+		if strings.HasPrefix(token, header) {
+			_, footer, err := splitPASETO(token, header)
+			return footer, err
+		}
+	}
+	return nil, fmt.Errorf("%w: unsupported version or purpose", ErrInvalidPASETO)
+}
+
+// SignPASETOV4 Sign the message as a v4.public token. The footer is sent in clear and signed, the implicit assertion
+// is signed but not sent, the same one must be given to VerifyPASETOV4. Both can be empty
+func SignPASETOV4(privateKey ed25519.PrivateKey, message, footer, implicit []byte) string {
+	signature := ed25519.Sign(privateKey, pae([]byte(pasetoV4PublicHeader), message, footer, implicit))
+	return pasetoToken(pasetoV4PublicHeader, append(append([]byte{}, message...), signature...), footer)
+}
+
+// VerifyPASETOV4 Check the signature of a v4.public token with the implicit assertion it was signed with and return its message
+func VerifyPASETOV4(publicKey ed25519.PublicKey, token string, implicit []byte) ([]byte, error) {
+	payload, footer, err := splitPASETO(token, pasetoV4PublicHeader)
+	if err != nil {
+		return nil, err
+	}
+	if len(payload) < ed25519.SignatureSize {
+		return nil, fmt.Errorf("%w: token too short", ErrInvalidPASETO)
+	}
+	message := payload[:len(payload)-ed25519.SignatureSize]
+	signature := payload[len(payload)-ed25519.SignatureSize:]
+	if !ed25519.Verify(publicKey, pae([]byte(pasetoV4PublicHeader), message, footer, implicit), signature) {
+		return nil, fmt.Errorf("%w: invalid signature", ErrInvalidPASETO)
+	}
+	return message, nil
+}
+
+// EncryptPASETOV4 Encrypt the message as a v4.local token with XChaCha20 and a BLAKE2b MAC.
+// The footer and the implicit assertion are authenticated like with SignPASETOV4
+func EncryptPASETOV4(key, message, footer, implicit []byte) (string, error) {
+	nonce := make([]byte, pasetoV4NonceSize)
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	return encryptPASETOV4(key, nonce, message, footer, implicit)
+}
+
+// encryptPASETOV4 Same as EncryptPASETOV4 with the given nonce, which must never be reused with the same key
+func encryptPASETOV4(key, nonce, message, footer, implicit []byte) (string, error) {
+	encryptionKey, counterNonce, authenticationKey, err := pasetoV4LocalKeys(key, nonce)
+	if err != nil {
+		return "", err
+	}
+	cipher, err := chacha20.NewUnauthenticatedCipher(encryptionKey, counterNonce)
+	if err != nil {
+		return "", err
+	}
+	ciphertext := make([]byte, len(message))
+	cipher.XORKeyStream(ciphertext, message)
+	mac, err := pasetoV4MAC(authenticationKey, nonce, ciphertext, footer, implicit)
+	if err != nil {
+		return "", err
+	}
+	payload := append(append(nonce, ciphertext...), mac...)
+	return pasetoToken(pasetoV4LocalHeader, payload, footer), nil
+}
+
+// DecryptPASETOV4 Check the MAC of a v4.local token with the implicit assertion it was encrypted with and return its decrypted message
+func DecryptPASETOV4(key []byte, token string, implicit []byte) ([]byte, error) {
+	payload, footer, err := splitPASETO(token, pasetoV4LocalHeader)
+	if err != nil {
+		return nil, err
+	}
+	if len(payload) < pasetoV4NonceSize+pasetoV4MACSize {
+		return nil, fmt.Errorf("%w: token too short", ErrInvalidPASETO)
+	}
+	nonce := payload[:pasetoV4NonceSize]
+	ciphertext := payload[pasetoV4NonceSize : len(payload)-pasetoV4MACSize]
+	mac := payload[len(payload)-pasetoV4MACSize:]
+	encryptionKey, counterNonce, authenticationKey, err := pasetoV4LocalKeys(key, nonce)
+	if err != nil {
+		return nil, err
+	}
+	expectedMAC, err := pasetoV4MAC(authenticationKey, nonce, ciphertext, footer, implicit)
+	if err != nil {
+		return nil, err
+	}
+	if subtle.ConstantTimeCompare(mac, expectedMAC) != 1 {
+		return nil, fmt.Errorf("%w: invalid MAC", ErrInvalidPASETO)
+	}
+	cipher, err := chacha20.NewUnauthenticatedCipher(encryptionKey, counterNonce)
+	if err != nil {
+		return nil, err
+	}
+	message := make([]byte, len(ciphertext))
+	cipher.XORKeyStream(message, ciphertext)
+	return message, nil
+}
+
+// pasetoV4LocalKeys Derive the encryption key, the XChaCha20 nonce and the authentication key of a v4.local token from its nonce
+func pasetoV4LocalKeys(key, nonce []byte) ([]byte, []byte, []byte, error) {
+	if len(key) != PASETOLocalKeySize {
+		return nil, nil, nil, fmt.Errorf("v4.local keys must be %d bytes", PASETOLocalKeySize)
+	}
+	encryptionHash, err := blake2b.New(chacha20.KeySize+chacha20.NonceSizeX, key)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	encryptionHash.Write([]byte("paseto-encryption-key"))
+	encryptionHash.Write(nonce)
+	derived := encryptionHash.Sum(nil)
+
+	authenticationHash, err := blake2b.New(pasetoV4MACSize, key)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	authenticationHash.Write([]byte("paseto-auth-key-for-aead"))
+	authenticationHash.Write(nonce)
+	return derived[:chacha20.KeySize], derived[chacha20.KeySize:], authenticationHash.Sum(nil), nil
+}
+
+func pasetoV4MAC(authenticationKey, nonce, ciphertext, footer, implicit []byte) ([]byte, error) {
+	mac, err := blake2b.New(pasetoV4MACSize, authenticationKey)
+	if err != nil {
+		return nil, err
+	}
+	mac.Write(pae([]byte(pasetoV4LocalHeader), nonce, ciphertext, footer, implicit))
+	return mac.Sum(nil), nil
+}
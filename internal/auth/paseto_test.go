@@ -0,0 +1,240 @@
+package auth
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func mustDecodeHex(t *testing.T, value string) []byte {
+	t.Helper()
+	decoded, err := hex.DecodeString(value)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return decoded
+}
+
+const (
+	pasetoVectorLocalKey  = "707172737475767778797a7b7c7d7e7f808182838485868788898a8b8c8d8e8f"
+	pasetoVectorNonce     = "df654812bac492663825520ba2f6e67cf5ca5bdc13d4e7507a98cc4c2fcc3ad8"
+	pasetoVectorSecret    = `{"data":"this is a secret message","exp":"2022-01-01T00:00:00+00:00"}`
+	pasetoVectorHidden    = `{"data":"this is a hidden message","exp":"2022-01-01T00:00:00+00:00"}`
+	pasetoVectorSigned    = `{"data":"this is a signed message","exp":"2022-01-01T00:00:00+00:00"}`
+	pasetoVectorKIDFooter = `{"kid":"zVhMiPBP9fRf2snEcT7gFTioeA9COcNy9DfgL1W60haN"}`
+	pasetoVectorSecretKey = "b4cbfb43df4ce210727d953e4a713307fa19bb7d9f85041438d9e11b942a3774" +
+		"1eb9dbbbbc047c03fd70604e0071f0987e16b28b757225c11f00415d0e20b1a2"
+)
+
+// The v4.local test vectors 4-E-1 to 4-E-9 of the PASETO specification
+var pasetoV4LocalVectors = []struct {
+	name     string
+	nonce    string
+	payload  string
+	footer   string
+	implicit string
+	token    string
+}{
+	{"4-E-1", strings.Repeat("00", pasetoV4NonceSize), pasetoVectorSecret, "", "",
+		"v4.local.AAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAQAr68PS4AXe7If_ZgesdkUMvSwscFlAl1pk5HC0e8kApeaqMfGo_7OpBnwJOAbY9V7WU6abu74MmcUE8YWAiaArVI8XJ5hOb_4v9RmDkneN0S92dx0OW4pgy7omxgf3S8c3LlQg"},
+	{"4-E-2", strings.Repeat("00", pasetoV4NonceSize), pasetoVectorHidden, "", "",
+		"v4.local.AAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAQAr68PS4AXe7If_ZgesdkUMvS2csCgglvpk5HC0e8kApeaqMfGo_7OpBnwJOAbY9V7WU6abu74MmcUE8YWAiaArVI8XIemu9chy3WVKvRBfg6t8wwYHK0ArLxxfZP73W_vfwt5A"},
+	{"4-E-3", pasetoVectorNonce, pasetoVectorSecret, "", "",
+		"v4.local.32VIErrEkmY4JVILovbmfPXKW9wT1OdQepjMTC_MOtjA4kiqw7_tcaOM5GNEcnTxl60WkwMsYXw6FSNb_UdJPXjpzm0KW9ojM5f4O2mRvE2IcweP-PRdoHjd5-RHCiExR1IK6t6-tyebyWG6Ov7kKvBdkrrAJ837lKP3iDag2hzUPHuMKA"},
+	{"4-E-4", pasetoVectorNonce, pasetoVectorHidden, "", "",
+		"v4.local.32VIErrEkmY4JVILovbmfPXKW9wT1OdQepjMTC_MOtjA4kiqw7_tcaOM5GNEcnTxl60WiA8rd3wgFSNb_UdJPXjpzm0KW9ojM5f4O2mRvE2IcweP-PRdoHjd5-RHCiExR1IK6t4gt6TiLm55vIH8c_lGxxZpE3AWlH4WTR0v45nsWoU3gQ"},
+	{"4-E-5", pasetoVectorNonce, pasetoVectorSecret, pasetoVectorKIDFooter, "",
+		"v4.local.32VIErrEkmY4JVILovbmfPXKW9wT1OdQepjMTC_MOtjA4kiqw7_tcaOM5GNEcnTxl60WkwMsYXw6FSNb_UdJPXjpzm0KW9ojM5f4O2mRvE2IcweP-PRdoHjd5-RHCiExR1IK6t4x-RMNXtQNbz7FvFZ_G-lFpk5RG3EOrwDL6CgDqcerSQ.eyJraWQiOiJ6VmhNaVBCUDlmUmYyc25FY1Q3Z0ZUaW9lQTlDT2NOeTlEZmdMMVc2MGhhTiJ9"},
+	{"4-E-6", pasetoVectorNonce, pasetoVectorHidden, pasetoVectorKIDFooter, "",
+		"v4.local.32VIErrEkmY4JVILovbmfPXKW9wT1OdQepjMTC_MOtjA4kiqw7_tcaOM5GNEcnTxl60WiA8rd3wgFSNb_UdJPXjpzm0KW9ojM5f4O2mRvE2IcweP-PRdoHjd5-RHCiExR1IK6t6pWSA5HX2wjb3P-xLQg5K5feUCX4P2fpVK3ZLWFbMSxQ.eyJraWQiOiJ6VmhNaVBCUDlmUmYyc25FY1Q3Z0ZUaW9lQTlDT2NOeTlEZmdMMVc2MGhhTiJ9"},
+	{"4-E-7", pasetoVectorNonce, pasetoVectorSecret, pasetoVectorKIDFooter, `{"test-vector":"4-E-7"}`,
+		"v4.local.32VIErrEkmY4JVILovbmfPXKW9wT1OdQepjMTC_MOtjA4kiqw7_tcaOM5GNEcnTxl60WkwMsYXw6FSNb_UdJPXjpzm0KW9ojM5f4O2mRvE2IcweP-PRdoHjd5-RHCiExR1IK6t40KCCWLA7GYL9KFHzKlwY9_RnIfRrMQpueydLEAZGGcA.eyJraWQiOiJ6VmhNaVBCUDlmUmYyc25FY1Q3Z0ZUaW9lQTlDT2NOeTlEZmdMMVc2MGhhTiJ9"},
+	{"4-E-8", pasetoVectorNonce, pasetoVectorHidden, pasetoVectorKIDFooter, `{"test-vector":"4-E-8"}`,
+		"v4.local.32VIErrEkmY4JVILovbmfPXKW9wT1OdQepjMTC_MOtjA4kiqw7_tcaOM5GNEcnTxl60WiA8rd3wgFSNb_UdJPXjpzm0KW9ojM5f4O2mRvE2IcweP-PRdoHjd5-RHCiExR1IK6t5uvqQbMGlLLNYBc7A6_x7oqnpUK5WLvj24eE4DVPDZjw.eyJraWQiOiJ6VmhNaVBCUDlmUmYyc25FY1Q3Z0ZUaW9lQTlDT2NOeTlEZmdMMVc2MGhhTiJ9"},
+	{"4-E-9", pasetoVectorNonce, pasetoVectorHidden, "arbitrary-string-that-isn't-json", `{"test-vector":"4-E-9"}`,
+		"v4.local.32VIErrEkmY4JVILovbmfPXKW9wT1OdQepjMTC_MOtjA4kiqw7_tcaOM5GNEcnTxl60WiA8rd3wgFSNb_UdJPXjpzm0KW9ojM5f4O2mRvE2IcweP-PRdoHjd5-RHCiExR1IK6t6tybdlmnMwcDMw0YxA_gFSE_IUWl78aMtOepFYSWYfQA.YXJiaXRyYXJ5LXN0cmluZy10aGF0LWlzbid0LWpzb24"},
+}
+
+// The v4.public test vectors 4-S-1 to 4-S-3 of the PASETO specification
+var pasetoV4PublicVectors = []struct {
+	name     string
+	footer   string
+	implicit string
+	token    string
+}{
+	{"4-S-1", "", "",
+		"v4.public.eyJkYXRhIjoidGhpcyBpcyBhIHNpZ25lZCBtZXNzYWdlIiwiZXhwIjoiMjAyMi0wMS0wMVQwMDowMDowMCswMDowMCJ9bg_XBBzds8lTZShVlwwKSgeKpLT3yukTw6JUz3W4h_ExsQV-P0V54zemZDcAxFaSeef1QlXEFtkqxT1ciiQEDA"},
+	{"4-S-2", pasetoVectorKIDFooter, "",
+		"v4.public.eyJkYXRhIjoidGhpcyBpcyBhIHNpZ25lZCBtZXNzYWdlIiwiZXhwIjoiMjAyMi0wMS0wMVQwMDowMDowMCswMDowMCJ9v3Jt8mx_TdM2ceTGoqwrh4yDFn0XsHvvV_D0DtwQxVrJEBMl0F2caAdgnpKlt4p7xBnx1HcO-SPo8FPp214HDw.eyJraWQiOiJ6VmhNaVBCUDlmUmYyc25FY1Q3Z0ZUaW9lQTlDT2NOeTlEZmdMMVc2MGhhTiJ9"},
+	{"4-S-3", pasetoVectorKIDFooter, `{"test-vector":"4-S-3"}`,
+		"v4.public.eyJkYXRhIjoidGhpcyBpcyBhIHNpZ25lZCBtZXNzYWdlIiwiZXhwIjoiMjAyMi0wMS0wMVQwMDowMDowMCswMDowMCJ9NPWciuD3d0o5eXJXG5pJy-DiVEoyPYWs1YSTwWHNJq6DZD3je5gf-0M4JR9ipdUSJbIovzmBECeaWmaqcaP0DQ.eyJraWQiOiJ6VmhNaVBCUDlmUmYyc25FY1Q3Z0ZUaW9lQTlDT2NOeTlEZmdMMVc2MGhhTiJ9"},
+}
+
+func TestPASETOV4Vectors(t *testing.T) {
+	key := mustDecodeHex(t, pasetoVectorLocalKey)
+	for _, vector := range pasetoV4LocalVectors {
+		t.Run(vector.name, func(t *testing.T) {
+			token, err := encryptPASETOV4(key, mustDecodeHex(t, vector.nonce), []byte(vector.payload), []byte(vector.footer), []byte(vector.implicit))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if token != vector.token {
+				t.Errorf("expected token %s but got %s", vector.token, token)
+			}
+			decrypted, err := DecryptPASETOV4(key, vector.token, []byte(vector.implicit))
+			if err != nil || string(decrypted) != vector.payload {
+				t.Errorf("expected the payload of the vector but got %s %v", decrypted, err)
+			}
+		})
+	}
+
+	privateKey := ed25519.PrivateKey(mustDecodeHex(t, pasetoVectorSecretKey))
+	publicKey := privateKey.Public().(ed25519.PublicKey)
+	for _, vector := range pasetoV4PublicVectors {
+		t.Run(vector.name, func(t *testing.T) {
+			if token := SignPASETOV4(privateKey, []byte(pasetoVectorSigned), []byte(vector.footer), []byte(vector.implicit)); token != vector.token {
+				t.Errorf("expected token %s but got %s", vector.token, token)
+			}
+			verified, err := VerifyPASETOV4(publicKey, vector.token, []byte(vector.implicit))
+			if err != nil || string(verified) != pasetoVectorSigned {
+				t.Errorf("expected the payload of the vector but got %s %v", verified, err)
+			}
+		})
+	}
+}
+
+// TestPASETOV4VectorFailures The vectors must be refused with anything else than what they were made with,
+// like the 4-F-* vectors of the specification
+func TestPASETOV4VectorFailures(t *testing.T) {
+	key := mustDecodeHex(t, pasetoVectorLocalKey)
+	privateKey := ed25519.PrivateKey(mustDecodeHex(t, pasetoVectorSecretKey))
+	publicKey := privateKey.Public().(ed25519.PublicKey)
+	local := pasetoV4LocalVectors[6]
+	public := pasetoV4PublicVectors[2]
+	decrypt := func(token, implicit string) error {
+		_, err := DecryptPASETOV4(key, token, []byte(implicit))
+		return err
+	}
+	verify := func(token, implicit string) error {
+		_, err := VerifyPASETOV4(publicKey, token, []byte(implicit))
+		return err
+	}
+	withFooter := func(token, footer string) string {
+		return token[:strings.LastIndex(token, ".")+1] + footer
+	}
+	withoutFooter := func(token string) string {
+		return token[:strings.LastIndex(token, ".")]
+	}
+	// truncate Drop the last bytes of the payload, keeping the footer
+	truncate := func(token, header string, size int) string {
+		encodedPayload, encodedFooter, _ := strings.Cut(strings.TrimPrefix(token, header), ".")
+		payload, err := pasetoEncoding.DecodeString(encodedPayload)
+		if err != nil {
+			t.Fatal(err)
+		}
+		footer, err := pasetoEncoding.DecodeString(encodedFooter)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return pasetoToken(header, payload[:size], footer)
+	}
+	tests := []struct {
+		name  string
+		check func() error
+	}{
+		{"v4.local read as v4.public", func() error { return verify(local.token, local.implicit) }},
+		{"v4.public read as v4.local", func() error { return decrypt(public.token, public.implicit) }},
+		{"v4.local of another version", func() error { return decrypt("v3"+strings.TrimPrefix(local.token, "v4"), local.implicit) }},
+		{"v4.public of another version", func() error { return verify("v3"+strings.TrimPrefix(public.token, "v4"), public.implicit) }},
+		{"v4.local with another footer", func() error { return decrypt(withFooter(local.token, "e30"), local.implicit) }},
+		{"v4.public with another footer", func() error { return verify(withFooter(public.token, "e30"), public.implicit) }},
+		{"v4.local without its footer", func() error { return decrypt(withoutFooter(local.token), local.implicit) }},
+		{"v4.public without its footer", func() error { return verify(withoutFooter(public.token), public.implicit) }},
+		{"v4.local with another implicit assertion", func() error { return decrypt(local.token, `{"test-vector":"4-E-8"}`) }},
+		{"v4.public with another implicit assertion", func() error { return verify(public.token, `{"test-vector":"4-S-2"}`) }},
+		{"v4.local without its implicit assertion", func() error { return decrypt(local.token, "") }},
+		{"v4.public without its implicit assertion", func() error { return verify(public.token, "") }},
+		{"v4.local without ciphertext", func() error {
+			return decrypt(truncate(local.token, pasetoV4LocalHeader, pasetoV4NonceSize+pasetoV4MACSize), local.implicit)
+		}},
+		{"v4.local shorter than a nonce and a MAC", func() error {
+			return decrypt(truncate(local.token, pasetoV4LocalHeader, pasetoV4NonceSize+pasetoV4MACSize-1), local.implicit)
+		}},
+		{"v4.public shorter than a signature", func() error {
+			return verify(truncate(public.token, pasetoV4PublicHeader, ed25519.SignatureSize-1), public.implicit)
+		}},
+		{"v4.local missing its last byte", func() error {
+			return decrypt(truncate(local.token, pasetoV4LocalHeader, len(local.payload)+pasetoV4NonceSize+pasetoV4MACSize-1), local.implicit)
+		}},
+		{"v4.public missing its last byte", func() error {
+			return verify(truncate(public.token, pasetoV4PublicHeader, len(pasetoVectorSigned)+ed25519.SignatureSize-1), public.implicit)
+		}},
+		{"v4.local with only its header", func() error { return decrypt(pasetoV4LocalHeader, "") }},
+		{"v4.public with only its header", func() error { return verify(pasetoV4PublicHeader, "") }},
+		{"v4.local with padding", func() error { return decrypt(withoutFooter(local.token)+"==", local.implicit) }},
+		{"v4.local with non canonical base64", func() error {
+			return decrypt(pasetoV4LocalVectors[0].token[:len(pasetoV4LocalVectors[0].token)-1]+"h", "")
+		}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if err := test.check(); !errors.Is(err, ErrInvalidPASETO) {
+				t.Errorf("expected ErrInvalidPASETO but got %v", err)
+			}
+		})
+	}
+}
+
+func TestPASETOV4Tampering(t *testing.T) {
+	publicKey, privateKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherPublicKey, _, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key := make([]byte, PASETOLocalKeySize)
+	if _, err := rand.Read(key); err != nil {
+		t.Fatal(err)
+	}
+	message := []byte(`{"sub":"account"}`)
+	footer := []byte(`{"kid":"key"}`)
+
+	signed := SignPASETOV4(privateKey, message, footer, nil)
+	if _, err := VerifyPASETOV4(publicKey, signed, nil); err != nil {
+		t.Fatalf("expected the signed token to be valid but got %v", err)
+	}
+	encrypted, err := EncryptPASETOV4(key, message, footer, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := DecryptPASETOV4(key, encrypted, nil); err != nil {
+		t.Fatalf("expected the encrypted token to be valid but got %v", err)
+	}
+
+	withFooter := func(token, footer string) string {
+		return token[:strings.LastIndex(token, ".")+1] + footer
+	}
+	tests := []struct {
+		name  string
+		check func() error
+	}{
+		{"v4.public with another key", func() error { _, err := VerifyPASETOV4(otherPublicKey, signed, nil); return err }},
+		{"v4.public with another footer", func() error { _, err := VerifyPASETOV4(publicKey, withFooter(signed, "e30"), nil); return err }},
+		{"v4.public read as v4.local", func() error { _, err := DecryptPASETOV4(key, signed, nil); return err }},
+		{"v4.local with another key", func() error { _, err := DecryptPASETOV4(make([]byte, PASETOLocalKeySize), encrypted, nil); return err }},
+		{"v4.local with another footer", func() error { _, err := DecryptPASETOV4(key, withFooter(encrypted, "e30"), nil); return err }},
+		{"v4.local read as v4.public", func() error { _, err := VerifyPASETOV4(publicKey, encrypted, nil); return err }},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if err := test.check(); !errors.Is(err, ErrInvalidPASETO) {
+				t.Errorf("expected ErrInvalidPASETO but got %v", err)
+			}
+		})
+	}
+}
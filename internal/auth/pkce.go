@@ -0,0 +1,48 @@
+package auth
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"regexp"
+)
+
+// PKCEMethodS256 The only code challenge method accepted, plain would leak the verifier with the challenge
+const PKCEMethodS256 = "S256"
+
+var (
+	ErrInvalidCodeVerifier  = errors.New("invalid code verifier")
+	ErrInvalidCodeChallenge = errors.New("invalid code challenge")
+)
+
+// codeVerifierPattern The code verifier format of RFC 7636 section 4.1, 43 to 128 unreserved characters
+var codeVerifierPattern = regexp.MustCompile(`^[A-Za-z0-9\-._~]{43,128}$`)
+
+// codeChallengePattern A S256 code challenge is the base64url encoded SHA-256 of the verifier, without padding
+var codeChallengePattern = regexp.MustCompile(`^[A-Za-z0-9\-_]{43}$`)
+
+// ValidateCodeChallenge Check the code challenge sent to the authorization endpoint (RFC 7636 section 4.3)
+func ValidateCodeChallenge(challenge, method string) error {
+	if method != PKCEMethodS256 || !codeChallengePattern.MatchString(challenge) {
+		return ErrInvalidCodeChallenge
+	}
+	return nil
+}
+
+// S256CodeChallenge Derive the S256 code challenge of a code verifier
+func S256CodeChallenge(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// VerifyPKCE Check the code verifier sent to the token endpoint matches the challenge of the authorization code (RFC 7636 section 4.6)
+func VerifyPKCE(verifier, challenge string) error {
+	if !codeVerifierPattern.MatchString(verifier) {
+		return ErrInvalidCodeVerifier
+	}
+	if subtle.ConstantTimeCompare([]byte(S256CodeChallenge(verifier)), []byte(challenge)) != 1 {
+		return ErrInvalidCodeVerifier
+	}
+	return nil
+}
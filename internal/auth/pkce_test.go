@@ -0,0 +1,28 @@
+package auth
+
+import "testing"
+
+func TestVerifyPKCE(t *testing.T) {
+	// Example from RFC 7636 appendix B
+	verifier := "dBjftJeZ4CVP-mB92K27uhbUJU1p1r_wW1gFWFOEjXk"
+	challenge := "E9Melhoa2OwvFrEMTJguCHaoeK1t8URWbuGJSstw-cM"
+
+	if got := S256CodeChallenge(verifier); got != challenge {
+		t.Fatalf("expected challenge %v but got %v", challenge, got)
+	}
+	if err := ValidateCodeChallenge(challenge, PKCEMethodS256); err != nil {
+		t.Errorf("expected a valid challenge but got %v", err)
+	}
+	if err := ValidateCodeChallenge(challenge, "plain"); err == nil {
+		t.Error("expected the plain method to be rejected")
+	}
+	if err := VerifyPKCE(verifier, challenge); err != nil {
+		t.Errorf("expected the verifier to match but got %v", err)
+	}
+	if err := VerifyPKCE(verifier[:42]+"A", challenge); err == nil {
+		t.Error("expected a wrong verifier to be rejected")
+	}
+	if err := VerifyPKCE("too-short", S256CodeChallenge("too-short")); err == nil {
+		t.Error("expected a verifier shorter than 43 characters to be rejected")
+	}
+}
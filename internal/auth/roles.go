@@ -0,0 +1,31 @@
+package auth
+
+import "fmt"
+
+// Role What an account is allowed to do, carried in the role claim of the access token
+type Role string
+
+const (
+	RoleCustomer Role = "customer"
+	RoleSupport  Role = "support"
+	RoleAdmin    Role = "admin"
+)
+
+// ParseRole Validate a role received from a client
+func ParseRole(role string) (Role, error) {
+	switch Role(role) {
+	case RoleCustomer, RoleSupport, RoleAdmin:
+		return Role(role), nil
+	default:
+		return "", fmt.Errorf("unknown role %q", role)
+	}
+}
+
+// EffectiveRole The role to enforce for the claims.
+// Tokens issued before roles were introduced have no role claim and are treated as customer
+func (c *CustomJWTClaims) EffectiveRole() Role {
+	if c.Role == "" {
+		return RoleCustomer
+	}
+	return c.Role
+}
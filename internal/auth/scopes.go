@@ -0,0 +1,80 @@
+package auth
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/google/uuid"
+)
+
+// Scopes which can be granted to scoped tokens, e.g. to OAuth clients
+const (
+	ScopeAccountsRead   = "accounts:read"
+	ScopeAccountsWrite  = "accounts:write"
+	ScopeTransfersWrite = "transfers:write"
+)
+
+// OpenID Connect scopes, openid asks for an ID token, profile and email for the matching claims about the account
+const (
+	ScopeOpenID  = "openid"
+	ScopeProfile = "profile"
+	ScopeEmail   = "email"
+)
+
+var knownScopes = map[string]bool{
+	ScopeAccountsRead:   true,
+	ScopeAccountsWrite:  true,
+	ScopeTransfersWrite: true,
+	ScopeOpenID:         true,
+	ScopeProfile:        true,
+	ScopeEmail:          true,
+}
+
+// SupportedScopes Every scope that can be granted, sorted
+func SupportedScopes() []string {
+	scopes := make([]string, 0, len(knownScopes))
+	for scope := range knownScopes {
+		scopes = append(scopes, scope)
+	}
+	sort.Strings(scopes)
+	return scopes
+}
+
+// ParseScopes Split a space separated scope parameter (RFC 6749 section 3.3) and check every scope is known
+func ParseScopes(scope string) ([]string, error) {
+	scopes := strings.Fields(scope)
+	for _, s := range scopes {
+		if !knownScopes[s] {
+			return nil, fmt.Errorf("unknown scope %q", s)
+		}
+	}
+	return scopes, nil
+}
+
+// FormatScopes Join scopes into the space separated format of the scope claim
+func FormatScopes(scopes []string) string {
+	return strings.Join(scopes, " ")
+}
+
+// IsMachine Report if the token was issued to an OAuth client acting on its own behalf rather than to an account
+func (c *CustomJWTClaims) IsMachine() bool {
+	return c.ID == uuid.Nil && c.ClientID != ""
+}
+
+// IsScoped Report if the token is limited to the scopes in its scope claim.
+// Tokens from signing in have no scope claim and can do everything the role of the account allows,
+// tokens issued to an OAuth client are always scoped, even when no scope was granted
+func (c *CustomJWTClaims) IsScoped() bool {
+	return c.Scope != "" || c.ClientID != ""
+}
+
+// HasScope Report if the scope was granted to the token
+func (c *CustomJWTClaims) HasScope(scope string) bool {
+	for _, granted := range strings.Fields(c.Scope) {
+		if granted == scope {
+			return true
+		}
+	}
+	return false
+}
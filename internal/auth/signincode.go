@@ -0,0 +1,27 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"fmt"
+	"math/big"
+)
+
+// signInCodeDigits Length of the codes sent by email to sign in without a password
+const signInCodeDigits = 6
+
+// NewSignInCode Generate a numeric code to sign in without a password, only the hash returned alongside it should be persisted.
+// A code is short enough to type, so it must be short lived and its attempts limited
+func NewSignInCode() (code string, codeHash string, err error) {
+	n, err := rand.Int(rand.Reader, new(big.Int).Exp(big.NewInt(10), big.NewInt(signInCodeDigits), nil))
+	if err != nil {
+		return "", "", err
+	}
+	code = fmt.Sprintf("%0*d", signInCodeDigits, n.Int64())
+	return code, hashOpaqueToken(code), nil
+}
+
+// CheckSignInCode Compare the code sent by the client with the stored hash in constant time
+func CheckSignInCode(code, codeHash string) bool {
+	return subtle.ConstantTimeCompare([]byte(hashOpaqueToken(code)), []byte(codeHash)) == 1
+}
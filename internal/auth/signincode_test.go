@@ -0,0 +1,22 @@
+package auth
+
+import (
+	"regexp"
+	"testing"
+)
+
+func TestSignInCode(t *testing.T) {
+	code, codeHash, err := NewSignInCode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !regexp.MustCompile(`^[0-9]{6}$`).MatchString(code) {
+		t.Errorf("expected a 6 digit code but got %q", code)
+	}
+	if codeHash == code || !CheckSignInCode(code, codeHash) {
+		t.Errorf("expected the hash of the code to match it")
+	}
+	if CheckSignInCode("not-it", codeHash) {
+		t.Error("expected another code not to match")
+	}
+}
@@ -0,0 +1,121 @@
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+// TOTP parameters (RFC 6238), the defaults every authenticator app supports
+const (
+	totpSecretBytes = 20
+	totpDigits      = 6
+	totpPeriod      = 30 * time.Second
+	// totpSkew Steps accepted before and after the current one, for clocks drifting and codes typed slowly
+	totpSkew = 1
+)
+
+const defaultTOTPIssuer = "go-jwt"
+
+var totpEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// TOTPIssuer The name authenticator apps show next to the account, configured with TOTP_ISSUER
+func TOTPIssuer() string {
+	if issuer := os.Getenv("TOTP_ISSUER"); issuer != "" {
+		return issuer
+	}
+	return defaultTOTPIssuer
+}
+
+// NewTOTPSecret Generate the base32 encoded secret shared with the authenticator app
+func NewTOTPSecret() (string, error) {
+	buf := make([]byte, totpSecretBytes)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return totpEncoding.EncodeToString(buf), nil
+}
+
+// TOTPProvisioningURI The otpauth:// URI to show as a QR code, so authenticator apps can add the account
+func TOTPProvisioningURI(secret, accountName string) string {
+	issuer := TOTPIssuer()
+	query := url.Values{
+		"secret":    {secret},
+		"issuer":    {issuer},
+		"algorithm": {"SHA1"},
+		"digits":    {fmt.Sprint(totpDigits)},
+		"period":    {fmt.Sprint(int(totpPeriod.Seconds()))},
+	}
+	label := url.PathEscape(issuer + ":" + accountName)
+	return "otpauth://totp/" + label + "?" + query.Encode()
+}
+
+// ValidateTOTP Check the code against the steps around now.
+// The step of the matching code is returned so the caller can refuse the same code twice
+func ValidateTOTP(secret, code string, now time.Time) (step int64, ok bool) {
+	key, err := totpEncoding.DecodeString(strings.ToUpper(secret))
+	if err != nil || len(code) != totpDigits {
+		return 0, false
+	}
+	current := now.Unix() / int64(totpPeriod.Seconds())
+	for step := current - totpSkew; step <= current+totpSkew; step++ {
+		if subtle.ConstantTimeCompare([]byte(hotp(key, uint64(step))), []byte(code)) == 1 {
+			return step, true
+		}
+	}
+	return 0, false
+}
+
+// TOTPCode The code for the time, what the authenticator app shows
+func TOTPCode(secret string, now time.Time) (string, error) {
+	key, err := totpEncoding.DecodeString(strings.ToUpper(secret))
+	if err != nil {
+		return "", err
+	}
+	return hotp(key, uint64(now.Unix()/int64(totpPeriod.Seconds()))), nil
+}
+
+// hotp The HOTP value of the counter (RFC 4226 section 5.3)
+func hotp(key []byte, counter uint64) string {
+	var msg [8]byte
+	binary.BigEndian.PutUint64(msg[:], counter)
+	mac := hmac.New(sha1.New, key)
+	mac.Write(msg[:])
+	sum := mac.Sum(nil)
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+	return fmt.Sprintf("%0*d", totpDigits, value%uint32(math.Pow10(totpDigits)))
+}
+
+// recoveryCodeCount Amount of recovery codes generated when two-factor authentication is enabled
+const recoveryCodeCount = 10
+
+// NewRecoveryCodes Generate the one time codes to sign in without the authenticator app, only their hashes should be persisted
+func NewRecoveryCodes() (codes []string, codeHashes []string, err error) {
+	for i := 0; i < recoveryCodeCount; i++ {
+		buf := make([]byte, 5)
+		if _, err := rand.Read(buf); err != nil {
+			return nil, nil, err
+		}
+		encoded := strings.ToLower(totpEncoding.EncodeToString(buf))
+		code := encoded[:4] + "-" + encoded[4:]
+		codes = append(codes, code)
+		codeHashes = append(codeHashes, HashRecoveryCode(code))
+	}
+	return codes, codeHashes, nil
+}
+
+// HashRecoveryCode Hash a recovery code typed by the user, ignoring case, spaces and dashes
+func HashRecoveryCode(code string) string {
+	normalized := strings.NewReplacer("-", "", " ", "").Replace(strings.ToLower(code))
+	return hashOpaqueToken(normalized)
+}
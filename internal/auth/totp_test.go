@@ -0,0 +1,77 @@
+package auth
+
+import (
+	"encoding/base32"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+func TestValidateTOTP(t *testing.T) {
+	// Test vector of RFC 6238 appendix B for SHA1, truncated to 6 digits
+	secret := base32.StdEncoding.EncodeToString([]byte("12345678901234567890"))
+	now := time.Unix(59, 0)
+	if code, err := TOTPCode(secret, now); err != nil || code != "287082" {
+		t.Fatalf("expected code 287082 but got %v %v", code, err)
+	}
+
+	if step, ok := ValidateTOTP(secret, "287082", now); !ok || step != 1 {
+		t.Errorf("expected the code to be valid for step 1 but got %v %v", step, ok)
+	}
+	if _, ok := ValidateTOTP(secret, "287082", now.Add(totpPeriod)); !ok {
+		t.Error("expected the code of the previous step to be accepted")
+	}
+	if _, ok := ValidateTOTP(secret, "287082", now.Add(3*totpPeriod)); ok {
+		t.Error("expected an old code to be rejected")
+	}
+	if _, ok := ValidateTOTP(secret, "000000", now); ok {
+		t.Error("expected a wrong code to be rejected")
+	}
+}
+
+func TestTOTPProvisioningURI(t *testing.T) {
+	uri := TOTPProvisioningURI("SECRET", "test@email.com")
+	if !strings.HasPrefix(uri, "otpauth://totp/go-jwt:test@email.com?") || !strings.Contains(uri, "secret=SECRET") {
+		t.Errorf("unexpected provisioning URI %v", uri)
+	}
+}
+
+func TestRecoveryCodes(t *testing.T) {
+	codes, hashes, err := NewRecoveryCodes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(codes) != recoveryCodeCount || len(hashes) != recoveryCodeCount {
+		t.Fatalf("expected %d recovery codes but got %d", recoveryCodeCount, len(codes))
+	}
+	if HashRecoveryCode(strings.ToUpper(strings.ReplaceAll(codes[0], "-", ""))) != hashes[0] {
+		t.Error("expected the recovery code to match regardless of case and dashes")
+	}
+}
+
+func TestMFAToken(t *testing.T) {
+	useTestKeySet(t, NewKeySet(NewHMACSigningKey("test", []byte("test-secret"))))
+	accountId := uuid.New()
+	mfaToken, err := CreateMFAToken(accountId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	claims, err := ValidateMFAToken(mfaToken)
+	if err != nil || claims.ID != accountId {
+		t.Fatalf("expected a valid MFA token for %v but got %v %v", accountId, claims, err)
+	}
+	// The MFA token must not be usable as an access token, and the other way around
+	if _, err := ValidateJWT(mfaToken); !errors.Is(err, ErrInvalidAudience) {
+		t.Errorf("expected the MFA token to be rejected as access token but got %v", err)
+	}
+	accessToken, err := CreateJWT(accountId, RoleCustomer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ValidateMFAToken(accessToken); !errors.Is(err, ErrInvalidAudience) {
+		t.Errorf("expected the access token to be rejected as MFA token but got %v", err)
+	}
+}
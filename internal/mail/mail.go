@@ -0,0 +1,137 @@
+package mail
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"net/smtp"
+	"os"
+	"strings"
+	"time"
+)
+
+// Message A plain text email
+type Message struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+// Sender Deliver emails, picked with MAIL_SENDER by NewSenderFromEnv
+type Sender interface {
+	Send(msg Message) error
+}
+
+// NewSenderFromEnv The sender configured with MAIL_SENDER:
+//   - "log" (default) writes the emails to the log, for local development
+//   - "file" writes each email to a file in MAIL_DIR, for local development and tests
+//   - "smtp" sends them through SMTP_ADDR, authenticated with SMTP_USERNAME and SMTP_PASSWORD when set
+func NewSenderFromEnv() (Sender, error) {
+	switch sender := os.Getenv("MAIL_SENDER"); sender {
+	case "", "log":
+		return LogSender{}, nil
+	case "file":
+		dir := os.Getenv("MAIL_DIR")
+		if dir == "" {
+			dir = "mail"
+		}
+		return &FileSender{Dir: dir}, nil
+	case "smtp":
+		addr := os.Getenv("SMTP_ADDR")
+		if addr == "" {
+			return nil, fmt.Errorf("SMTP_ADDR is required when MAIL_SENDER is smtp")
+		}
+		return &SMTPSender{
+			Addr:     addr,
+			Username: os.Getenv("SMTP_USERNAME"),
+			Password: os.Getenv("SMTP_PASSWORD"),
+			From:     From(),
+		}, nil
+	default:
+		return nil, fmt.Errorf("unknown MAIL_SENDER %q, expected log, file or smtp", sender)
+	}
+}
+
+// From The sender address of the emails, configured with MAIL_FROM
+func From() string {
+	if from := os.Getenv("MAIL_FROM"); from != "" {
+		return from
+	}
+	return "no-reply@localhost"
+}
+
+// format Render the message with its headers, lines end with CRLF as expected by SMTP.
+// Line breaks in the headers are refused so an address can't inject other headers
+func format(msg Message, from string) ([]byte, error) {
+	for _, header := range []string{msg.To, msg.Subject, from} {
+		if strings.ContainsAny(header, "\r\n") {
+			return nil, fmt.Errorf("invalid mail header %q", header)
+		}
+	}
+	var b strings.Builder
+	fmt.Fprintf(&b, "From: %s\r\n", from)
+	fmt.Fprintf(&b, "To: %s\r\n", msg.To)
+	fmt.Fprintf(&b, "Subject: %s\r\n", msg.Subject)
+	fmt.Fprintf(&b, "Date: %s\r\n", time.Now().UTC().Format(time.RFC1123Z))
+	b.WriteString("MIME-Version: 1.0\r\n")
+	b.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+	b.WriteString("\r\n")
+	b.WriteString(strings.ReplaceAll(msg.Body, "\n", "\r\n"))
+	return []byte(b.String()), nil
+}
+
+// LogSender Write the emails to the log instead of sending them
+type LogSender struct{}
+
+func (LogSender) Send(msg Message) error {
+	log.Printf("mail: to %s, subject %q\n%s", msg.To, msg.Subject, msg.Body)
+	return nil
+}
+
+// FileSender Write each email to its own .eml file in Dir instead of sending it
+type FileSender struct {
+	Dir string
+}
+
+func (s *FileSender) Send(msg Message) error {
+	data, err := format(msg, From())
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(s.Dir, 0o700); err != nil {
+		return err
+	}
+	file, err := os.CreateTemp(s.Dir, time.Now().UTC().Format("20060102T150405")+"-*.eml")
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	if _, err := file.Write(data); err != nil {
+		return err
+	}
+	return file.Close()
+}
+
+// SMTPSender Send the emails through a SMTP server, STARTTLS is used when the server supports it
+type SMTPSender struct {
+	Addr     string
+	Username string
+	Password string
+	From     string
+}
+
+func (s *SMTPSender) Send(msg Message) error {
+	data, err := format(msg, s.From)
+	if err != nil {
+		return err
+	}
+	var auth smtp.Auth
+	if s.Username != "" {
+		host, _, err := net.SplitHostPort(s.Addr)
+		if err != nil {
+			return err
+		}
+		auth = smtp.PlainAuth("", s.Username, s.Password, host)
+	}
+	return smtp.SendMail(s.Addr, auth, s.From, []string{msg.To}, data)
+}
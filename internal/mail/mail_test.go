@@ -0,0 +1,61 @@
+package mail
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestFileSender(t *testing.T) {
+	sender := &FileSender{Dir: filepath.Join(t.TempDir(), "mail")}
+	msg := Message{To: "user@example.com", Subject: "Hello", Body: "First line\nSecond line"}
+	if err := sender.Send(msg); err != nil {
+		t.Fatal(err)
+	}
+
+	files, err := os.ReadDir(sender.Dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 1 {
+		t.Fatalf("expected one email file but got %d", len(files))
+	}
+	content, err := os.ReadFile(filepath.Join(sender.Dir, files[0].Name()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, expected := range []string{"To: user@example.com\r\n", "Subject: Hello\r\n", "First line\r\nSecond line"} {
+		if !strings.Contains(string(content), expected) {
+			t.Errorf("expected the email to contain %q but got %q", expected, content)
+		}
+	}
+}
+
+func TestHeaderInjection(t *testing.T) {
+	sender := &FileSender{Dir: t.TempDir()}
+	msg := Message{To: "user@example.com\r\nBcc: other@example.com", Subject: "Hello", Body: "Body"}
+	if err := sender.Send(msg); err == nil {
+		t.Error("expected a line break in a header to be refused")
+	}
+}
+
+func TestNewSenderFromEnv(t *testing.T) {
+	t.Setenv("MAIL_SENDER", "")
+	if sender, err := NewSenderFromEnv(); err != nil {
+		t.Fatal(err)
+	} else if _, ok := sender.(LogSender); !ok {
+		t.Errorf("expected the log sender by default but got %T", sender)
+	}
+
+	t.Setenv("MAIL_SENDER", "smtp")
+	t.Setenv("SMTP_ADDR", "")
+	if _, err := NewSenderFromEnv(); err == nil {
+		t.Error("expected an error without SMTP_ADDR")
+	}
+
+	t.Setenv("MAIL_SENDER", "pigeon")
+	if _, err := NewSenderFromEnv(); err == nil {
+		t.Error("expected an error for an unknown sender")
+	}
+}
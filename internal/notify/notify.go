@@ -0,0 +1,98 @@
+package notify
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/nguyenanhhao221/go-jwt/internal/mail"
+)
+
+// Notification A message to an account about its activity, e.g. a sign in from a new device
+type Notification struct {
+	Type string `json:"type"`
+	// To The email of the account
+	To      string    `json:"to"`
+	Subject string    `json:"subject"`
+	Body    string    `json:"body"`
+	Time    time.Time `json:"time"`
+}
+
+// Notifier Deliver notifications, picked with NOTIFIER by NewNotifierFromEnv
+type Notifier interface {
+	Notify(notification Notification) error
+}
+
+// NewNotifierFromEnv The notifier configured with NOTIFIER:
+//   - "log" (default) writes the notifications to the log, for local development
+//   - "file" appends them as JSON lines to NOTIFICATION_FILE, for local development and tests
+//   - "mail" emails them to the account with mailer
+func NewNotifierFromEnv(mailer mail.Sender) (Notifier, error) {
+	switch notifier := os.Getenv("NOTIFIER"); notifier {
+	case "", "log":
+		return LogNotifier{}, nil
+	case "file":
+		path := os.Getenv("NOTIFICATION_FILE")
+		if path == "" {
+			path = "notifications.jsonl"
+		}
+		return &FileNotifier{Path: path}, nil
+	case "mail":
+		return &MailNotifier{Sender: mailer}, nil
+	default:
+		return nil, fmt.Errorf("unknown NOTIFIER %q, expected log, file or mail", notifier)
+	}
+}
+
+func withTime(notification Notification) Notification {
+	if notification.Time.IsZero() {
+		notification.Time = time.Now().UTC()
+	}
+	return notification
+}
+
+// LogNotifier Write the notifications to the log instead of delivering them
+type LogNotifier struct{}
+
+func (LogNotifier) Notify(notification Notification) error {
+	log.Printf("notify: %s to %s, subject %q\n%s", notification.Type, notification.To, notification.Subject, notification.Body)
+	return nil
+}
+
+// FileNotifier Append each notification to the file at Path as a JSON line
+type FileNotifier struct {
+	Path string
+
+	// mu Keep the lines of concurrent notifications apart
+	mu sync.Mutex
+}
+
+func (n *FileNotifier) Notify(notification Notification) error {
+	line, err := json.Marshal(withTime(notification))
+	if err != nil {
+		return err
+	}
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	file, err := os.OpenFile(n.Path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	if _, err := file.Write(append(line, '\n')); err != nil {
+		return err
+	}
+	return file.Close()
+}
+
+// MailNotifier Email the notifications to the account
+type MailNotifier struct {
+	Sender mail.Sender
+}
+
+func (n *MailNotifier) Notify(notification Notification) error {
+	return n.Sender.Send(mail.Message{To: notification.To, Subject: notification.Subject, Body: notification.Body})
+}
@@ -0,0 +1,71 @@
+package notify
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/nguyenanhhao221/go-jwt/internal/mail"
+)
+
+func TestFileNotifier(t *testing.T) {
+	notifier := &FileNotifier{Path: filepath.Join(t.TempDir(), "notifications.jsonl")}
+	for _, subject := range []string{"First", "Second"} {
+		if err := notifier.Notify(Notification{Type: "test", To: "user@example.com", Subject: subject, Body: "Body"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	file, err := os.Open(notifier.Path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	var notifications []Notification
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var notification Notification
+		if err := json.Unmarshal(scanner.Bytes(), &notification); err != nil {
+			t.Fatal(err)
+		}
+		notifications = append(notifications, notification)
+	}
+	if len(notifications) != 2 || notifications[1].Subject != "Second" || notifications[0].Time.IsZero() {
+		t.Errorf("expected both notifications appended with their time but got %v", notifications)
+	}
+}
+
+func TestMailNotifier(t *testing.T) {
+	sender := &mail.FileSender{Dir: t.TempDir()}
+	notifier := &MailNotifier{Sender: sender}
+	if err := notifier.Notify(Notification{Type: "test", To: "user@example.com", Subject: "Hello", Body: "Body"}); err != nil {
+		t.Fatal(err)
+	}
+	if files, err := os.ReadDir(sender.Dir); err != nil || len(files) != 1 {
+		t.Errorf("expected the notification to be emailed but got %v %v", files, err)
+	}
+}
+
+func TestNewNotifierFromEnv(t *testing.T) {
+	t.Setenv("NOTIFIER", "")
+	if notifier, err := NewNotifierFromEnv(mail.LogSender{}); err != nil {
+		t.Fatal(err)
+	} else if _, ok := notifier.(LogNotifier); !ok {
+		t.Errorf("expected the log notifier by default but got %T", notifier)
+	}
+
+	t.Setenv("NOTIFIER", "file")
+	t.Setenv("NOTIFICATION_FILE", "")
+	if notifier, err := NewNotifierFromEnv(mail.LogSender{}); err != nil {
+		t.Fatal(err)
+	} else if fileNotifier, ok := notifier.(*FileNotifier); !ok || fileNotifier.Path != "notifications.jsonl" {
+		t.Errorf("expected the file notifier with the default path but got %v", notifier)
+	}
+
+	t.Setenv("NOTIFIER", "pigeon")
+	if _, err := NewNotifierFromEnv(mail.LogSender{}); err == nil {
+		t.Error("expected an error for an unknown notifier")
+	}
+}
@@ -0,0 +1,80 @@
+package password
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// hashPrefixLength Length of the SHA-1 prefix the hashes are bucketed by, as in the k-anonymity range API of Have I Been Pwned
+const hashPrefixLength = 5
+
+// BreachedList SHA-1 hashes of passwords known from data breaches, bucketed by their prefix
+// so a lookup only compares the suffixes sharing the prefix of the password hash
+type BreachedList struct {
+	suffixesByPrefix map[string]map[string]struct{}
+	count            int
+}
+
+// LoadBreachedListFile Load the breached password hashes of the file, see LoadBreachedList
+func LoadBreachedListFile(path string) (*BreachedList, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	return LoadBreachedList(file)
+}
+
+// LoadBreachedList Load one SHA-1 hash in hex per line, optionally followed by ":<count>" like the Pwned Passwords downloads.
+// Empty lines and lines starting with # are skipped
+func LoadBreachedList(r io.Reader) (*BreachedList, error) {
+	list := &BreachedList{suffixesByPrefix: map[string]map[string]struct{}{}}
+	scanner := bufio.NewScanner(r)
+	for lineNumber := 1; scanner.Scan(); lineNumber++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		hash, _, _ := strings.Cut(line, ":")
+		hash = strings.ToUpper(hash)
+		if _, err := hex.DecodeString(hash); err != nil || len(hash) != sha1.Size*2 {
+			return nil, fmt.Errorf("line %d is not a SHA-1 hash", lineNumber)
+		}
+		list.add(hash)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return list, nil
+}
+
+func (l *BreachedList) add(hash string) {
+	prefix, suffix := hash[:hashPrefixLength], hash[hashPrefixLength:]
+	suffixes, ok := l.suffixesByPrefix[prefix]
+	if !ok {
+		suffixes = map[string]struct{}{}
+		l.suffixesByPrefix[prefix] = suffixes
+	}
+	if _, exist := suffixes[suffix]; !exist {
+		suffixes[suffix] = struct{}{}
+		l.count++
+	}
+}
+
+// Contains Report if the password is in the list
+func (l *BreachedList) Contains(password string) bool {
+	sum := sha1.Sum([]byte(password))
+	hash := strings.ToUpper(hex.EncodeToString(sum[:]))
+	_, breached := l.suffixesByPrefix[hash[:hashPrefixLength]][hash[hashPrefixLength:]]
+	return breached
+}
+
+// Len The amount of hashes in the list
+func (l *BreachedList) Len() int {
+	return l.count
+}
@@ -0,0 +1,192 @@
+package password
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Algorithms a password can be hashed with
+const (
+	AlgorithmArgon2id = "argon2id"
+	AlgorithmBcrypt   = "bcrypt"
+)
+
+// Default hashing parameters, used when the matching env variable is missing.
+// The argon2id ones follow the recommendation of RFC 9106 for memory constrained environments, scaled down to 64 MiB
+const (
+	defaultAlgorithm         = AlgorithmArgon2id
+	defaultArgon2Memory      = 64 * 1024
+	defaultArgon2Iterations  = 3
+	defaultArgon2Parallelism = 2
+	defaultBcryptCost        = 12
+	argon2SaltLength         = 16
+	argon2KeyLength          = 32
+)
+
+var errUnknownHashFormat = errors.New("unknown password hash format")
+
+// Argon2idParams The cost of argon2id, Memory is in KiB
+type Argon2idParams struct {
+	Memory      uint32
+	Iterations  uint32
+	Parallelism uint8
+}
+
+// Hasher Hash new passwords with the configured algorithm and parameters, and verify hashes of any supported algorithm.
+// Argon2id hashes use the PHC string format, e.g. $argon2id$v=19$m=65536,t=3,p=2$<salt>$<hash>,
+// bcrypt hashes its own $2a$<cost>$ format. Both carry their parameters so NeedsRehash can spot outdated ones
+type Hasher struct {
+	Algorithm  string
+	Argon2id   Argon2idParams
+	BcryptCost int
+}
+
+// HasherFromEnv The hasher configured with PASSWORD_HASH_ALGORITHM (argon2id or bcrypt),
+// ARGON2_MEMORY in KiB, ARGON2_ITERATIONS, ARGON2_PARALLELISM and BCRYPT_COST.
+// It is meant to be built once at startup, an error is returned for any value out of range instead of hashing with something else
+func HasherFromEnv() (Hasher, error) {
+	algorithm := os.Getenv("PASSWORD_HASH_ALGORITHM")
+	if algorithm == "" {
+		algorithm = defaultAlgorithm
+	}
+	if algorithm != AlgorithmArgon2id && algorithm != AlgorithmBcrypt {
+		return Hasher{}, fmt.Errorf("PASSWORD_HASH_ALGORITHM must be %s or %s, got %q", AlgorithmArgon2id, AlgorithmBcrypt, algorithm)
+	}
+	memory, err := uintFromEnv("ARGON2_MEMORY", defaultArgon2Memory, 1, math.MaxUint32)
+	if err != nil {
+		return Hasher{}, err
+	}
+	iterations, err := uintFromEnv("ARGON2_ITERATIONS", defaultArgon2Iterations, 1, math.MaxUint32)
+	if err != nil {
+		return Hasher{}, err
+	}
+	parallelism, err := uintFromEnv("ARGON2_PARALLELISM", defaultArgon2Parallelism, 1, math.MaxUint8)
+	if err != nil {
+		return Hasher{}, err
+	}
+	// argon2 needs at least 8 KiB per lane
+	if memory < 8*parallelism {
+		return Hasher{}, fmt.Errorf("ARGON2_MEMORY must be at least 8 KiB per lane of ARGON2_PARALLELISM, got %d for %d", memory, parallelism)
+	}
+	bcryptCost, err := uintFromEnv("BCRYPT_COST", defaultBcryptCost, uint64(bcrypt.MinCost), uint64(bcrypt.MaxCost))
+	if err != nil {
+		return Hasher{}, err
+	}
+	return Hasher{
+		Algorithm: algorithm,
+		Argon2id: Argon2idParams{
+			Memory:      uint32(memory),
+			Iterations:  uint32(iterations),
+			Parallelism: uint8(parallelism),
+		},
+		BcryptCost: int(bcryptCost),
+	}, nil
+}
+
+// uintFromEnv A number between min and max from the env variable, fallback when it isn't set
+func uintFromEnv(key string, fallback, min, max uint64) (uint64, error) {
+	value, exist := os.LookupEnv(key)
+	if !exist {
+		return fallback, nil
+	}
+	number, err := strconv.ParseUint(value, 10, 64)
+	if err != nil || number < min || number > max {
+		return 0, fmt.Errorf("%s must be a number between %d and %d, got %q", key, min, max, value)
+	}
+	return number, nil
+}
+
+// Hash Hash the password with a new random salt
+func (h Hasher) Hash(password string) (string, error) {
+	if h.Algorithm == AlgorithmBcrypt {
+		hash, err := bcrypt.GenerateFromPassword([]byte(password), h.BcryptCost)
+		return string(hash), err
+	}
+	salt := make([]byte, argon2SaltLength)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+	key := argon2.IDKey([]byte(password), salt, h.Argon2id.Iterations, h.Argon2id.Memory, h.Argon2id.Parallelism, argon2KeyLength)
+	return fmt.Sprintf(
+		"$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s",
+		argon2.Version, h.Argon2id.Memory, h.Argon2id.Iterations, h.Argon2id.Parallelism,
+		base64.RawStdEncoding.EncodeToString(salt), base64.RawStdEncoding.EncodeToString(key),
+	), nil
+}
+
+// Verify Report if the password matches the hash, whatever algorithm and parameters it was created with
+func (h Hasher) Verify(password, encodedHash string) bool {
+	if isBcrypt(encodedHash) {
+		return bcrypt.CompareHashAndPassword([]byte(encodedHash), []byte(password)) == nil
+	}
+	argon2Hash, err := decodeArgon2id(encodedHash)
+	if err != nil {
+		return false
+	}
+	key := argon2.IDKey([]byte(password), argon2Hash.salt, argon2Hash.params.Iterations, argon2Hash.params.Memory, argon2Hash.params.Parallelism, uint32(len(argon2Hash.key)))
+	return subtle.ConstantTimeCompare(key, argon2Hash.key) == 1
+}
+
+// NeedsRehash Report if the hash wasn't created with the configured algorithm and parameters,
+// the password should then be hashed again the next time it is known, e.g. at sign in
+func (h Hasher) NeedsRehash(encodedHash string) bool {
+	if isBcrypt(encodedHash) {
+		if h.Algorithm != AlgorithmBcrypt {
+			return true
+		}
+		cost, err := bcrypt.Cost([]byte(encodedHash))
+		return err != nil || cost != h.BcryptCost
+	}
+	argon2Hash, err := decodeArgon2id(encodedHash)
+	if err != nil || h.Algorithm != AlgorithmArgon2id {
+		return true
+	}
+	return argon2Hash.params != h.Argon2id || len(argon2Hash.salt) != argon2SaltLength || len(argon2Hash.key) != argon2KeyLength
+}
+
+func isBcrypt(encodedHash string) bool {
+	return strings.HasPrefix(encodedHash, "$2a$") || strings.HasPrefix(encodedHash, "$2b$") || strings.HasPrefix(encodedHash, "$2y$")
+}
+
+type argon2idHash struct {
+	params Argon2idParams
+	salt   []byte
+	key    []byte
+}
+
+// decodeArgon2id Parse an argon2id hash in PHC string format
+func decodeArgon2id(encodedHash string) (*argon2idHash, error) {
+	parts := strings.Split(encodedHash, "$")
+	if len(parts) != 6 || parts[0] != "" || parts[1] != AlgorithmArgon2id {
+		return nil, errUnknownHashFormat
+	}
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+		return nil, fmt.Errorf("unsupported argon2 version %q", parts[2])
+	}
+	var decoded argon2idHash
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &decoded.params.Memory, &decoded.params.Iterations, &decoded.params.Parallelism); err != nil {
+		return nil, fmt.Errorf("invalid argon2id parameters %q: %w", parts[3], err)
+	}
+	var err error
+	if decoded.salt, err = base64.RawStdEncoding.DecodeString(parts[4]); err != nil {
+		return nil, err
+	}
+	if decoded.key, err = base64.RawStdEncoding.DecodeString(parts[5]); err != nil {
+		return nil, err
+	}
+	if len(decoded.key) == 0 || decoded.params.Iterations < 1 || decoded.params.Parallelism < 1 {
+		return nil, errUnknownHashFormat
+	}
+	return &decoded, nil
+}
@@ -0,0 +1,117 @@
+package password
+
+import (
+	"strings"
+	"testing"
+)
+
+// Cheap parameters so the tests run fast
+var (
+	testArgon2id = Hasher{Algorithm: AlgorithmArgon2id, Argon2id: Argon2idParams{Memory: 64, Iterations: 1, Parallelism: 1}, BcryptCost: 4}
+	testBcrypt   = Hasher{Algorithm: AlgorithmBcrypt, Argon2id: testArgon2id.Argon2id, BcryptCost: 4}
+)
+
+func TestHasher(t *testing.T) {
+	for _, hasher := range []Hasher{testArgon2id, testBcrypt} {
+		t.Run(hasher.Algorithm, func(t *testing.T) {
+			hash, err := hasher.Hash("Correct-Horse-42")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !hasher.Verify("Correct-Horse-42", hash) {
+				t.Error("expected the password to match its hash")
+			}
+			if hasher.Verify("Correct-Horse-43", hash) {
+				t.Error("expected another password not to match")
+			}
+			if hasher.NeedsRehash(hash) {
+				t.Error("expected a hash with the current parameters not to need a rehash")
+			}
+			other, err := hasher.Hash("Correct-Horse-42")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if other == hash {
+				t.Error("expected every hash to use a new salt")
+			}
+		})
+	}
+}
+
+func TestArgon2idPHCFormat(t *testing.T) {
+	hash, err := testArgon2id.Hash("Correct-Horse-42")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(hash, "$argon2id$v=19$m=64,t=1,p=1$") {
+		t.Errorf("expected a PHC string with the parameters but got %v", hash)
+	}
+	for _, invalid := range []string{"", "plain", "$argon2id$v=18$m=64,t=1,p=1$c2FsdA$a2V5", "$argon2i$v=19$m=64,t=1,p=1$c2FsdA$a2V5"} {
+		if testArgon2id.Verify("Correct-Horse-42", invalid) {
+			t.Errorf("expected the invalid hash %q to never match", invalid)
+		}
+	}
+}
+
+func TestNeedsRehash(t *testing.T) {
+	bcryptHash, err := testBcrypt.Hash("Correct-Horse-42")
+	if err != nil {
+		t.Fatal(err)
+	}
+	argon2idHash, err := testArgon2id.Hash("Correct-Horse-42")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Hashes of the other algorithm still verify, so they can be upgraded at sign in
+	if !testArgon2id.Verify("Correct-Horse-42", bcryptHash) || !testArgon2id.NeedsRehash(bcryptHash) {
+		t.Error("expected a bcrypt hash to verify and need a rehash to argon2id")
+	}
+	if !testBcrypt.Verify("Correct-Horse-42", argon2idHash) || !testBcrypt.NeedsRehash(argon2idHash) {
+		t.Error("expected an argon2id hash to verify and need a rehash to bcrypt")
+	}
+
+	stronger := testArgon2id
+	stronger.Argon2id.Iterations = 2
+	if !stronger.NeedsRehash(argon2idHash) {
+		t.Error("expected a hash with fewer iterations to need a rehash")
+	}
+	costlier := testBcrypt
+	costlier.BcryptCost = 5
+	if !costlier.NeedsRehash(bcryptHash) {
+		t.Error("expected a hash with a lower cost to need a rehash")
+	}
+}
+
+func TestHasherFromEnv(t *testing.T) {
+	t.Setenv("PASSWORD_HASH_ALGORITHM", "")
+	if hasher, err := HasherFromEnv(); err != nil || hasher.Algorithm != AlgorithmArgon2id || hasher.Argon2id.Memory != defaultArgon2Memory {
+		t.Errorf("expected argon2id with the default parameters but got %+v %v", hasher, err)
+	}
+	t.Setenv("PASSWORD_HASH_ALGORITHM", "bcrypt")
+	t.Setenv("BCRYPT_COST", "10")
+	if hasher, err := HasherFromEnv(); err != nil || hasher.Algorithm != AlgorithmBcrypt || hasher.BcryptCost != 10 {
+		t.Errorf("expected bcrypt with a cost of 10 but got %+v %v", hasher, err)
+	}
+
+	tests := []struct {
+		key   string
+		value string
+	}{
+		{"PASSWORD_HASH_ALGORITHM", "md5"},
+		{"BCRYPT_COST", "99"},
+		{"ARGON2_PARALLELISM", "256"},
+		{"ARGON2_PARALLELISM", "0"},
+		{"ARGON2_ITERATIONS", "0"},
+		{"ARGON2_MEMORY", "4294967296"},
+		{"ARGON2_MEMORY", "-1"},
+		{"ARGON2_MEMORY", "8"},
+	}
+	for _, test := range tests {
+		t.Run(test.key+"="+test.value, func(t *testing.T) {
+			t.Setenv(test.key, test.value)
+			if hasher, err := HasherFromEnv(); err == nil {
+				t.Errorf("expected an error but got %+v", hasher)
+			}
+		})
+	}
+}
@@ -0,0 +1,65 @@
+package password
+
+import (
+	"strings"
+	"testing"
+)
+
+func tags(violations []Violation) []string {
+	var tags []string
+	for _, violation := range violations {
+		tags = append(tags, violation.Tag)
+	}
+	return tags
+}
+
+func TestPolicyCheck(t *testing.T) {
+	breached, err := LoadBreachedList(strings.NewReader(
+		"# SHA-1 of Password123\nB2E98AD6F6EB8508DD6A14CFA704BAD7F05F6FB1:42\n\n",
+	))
+	if err != nil {
+		t.Fatal(err)
+	}
+	policy := Policy{MinLength: 8, MaxBytes: 72, MinCharacterClasses: 3, Breached: breached}
+
+	tests := []struct {
+		name         string
+		password     string
+		personalInfo []string
+		expected     []string
+	}{
+		{"Accepted", "Correct-Horse-42", []string{"jane.doe@example.com", "Jane", "Doe"}, nil},
+		{"TooShort", "aB3!", nil, []string{TagMin}},
+		{"TooLong", strings.Repeat("aB3!", 19), nil, []string{TagMax}},
+		{"SingleClass", "correcthorse", nil, []string{TagCharacterClasses}},
+		{"EmailLocalPart", "Janedoe-2024", []string{"janedoe@example.com"}, []string{TagPersonalInfo}},
+		{"NamePart", "Garcia-Lopez-1", []string{"Maria Garcia"}, []string{TagPersonalInfo}},
+		{"ShortNameIgnored", "Lion-King-42", []string{"Li"}, nil},
+		{"Breached", "Password123", nil, []string{TagBreached}},
+		{"Several", "a", nil, []string{TagMin, TagCharacterClasses}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got := tags(policy.Check(test.password, test.personalInfo...))
+			if strings.Join(got, ",") != strings.Join(test.expected, ",") {
+				t.Errorf("expected violations %v but got %v", test.expected, got)
+			}
+		})
+	}
+}
+
+func TestLoadBreachedList(t *testing.T) {
+	if _, err := LoadBreachedList(strings.NewReader("not-a-hash\n")); err == nil {
+		t.Error("expected an invalid line to be refused")
+	}
+	list, err := LoadBreachedList(strings.NewReader("b2e98ad6f6eb8508dd6a14cfa704bad7f05f6fb1\nB2E98AD6F6EB8508DD6A14CFA704BAD7F05F6FB1:3\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if list.Len() != 1 || !list.Contains("Password123") {
+		t.Errorf("expected one hash matching Password123 but got %d", list.Len())
+	}
+	if list.Contains("Password124") {
+		t.Error("expected another password not to be in the list")
+	}
+}
@@ -0,0 +1,147 @@
+package password
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Default policy, used when the matching env variable is missing or invalid
+const (
+	defaultMinLength           = 8
+	defaultMinCharacterClasses = 2
+	// defaultMaxBytes bcrypt ignores everything after the first 72 bytes of a password
+	defaultMaxBytes = 72
+	// minPersonalInfoLength Shorter names or email parts are too common to be refused in a password
+	minPersonalInfoLength = 4
+)
+
+// Tags of the rules a password can break, named like the validator tags of the other fields
+const (
+	TagMin              = "min"
+	TagMax              = "max"
+	TagCharacterClasses = "character_classes"
+	TagPersonalInfo     = "personal_info"
+	TagBreached         = "breached"
+)
+
+// Violation A rule of the policy the password breaks, Param is the setting of the rule when it has one
+type Violation struct {
+	Tag   string
+	Param string
+}
+
+// Policy The rules a new password must follow
+type Policy struct {
+	// MinLength Minimum amount of characters
+	MinLength int
+	// MaxBytes Maximum length in bytes, as the hash can ignore what comes after
+	MaxBytes int
+	// MinCharacterClasses How many of lowercase letters, uppercase letters, digits and symbols the password must mix
+	MinCharacterClasses int
+	// Breached Passwords known from data breaches, nil to skip the check
+	Breached *BreachedList
+}
+
+// PolicyFromEnv The policy configured with PASSWORD_MIN_LENGTH, PASSWORD_MAX_BYTES, PASSWORD_MIN_CHARACTER_CLASSES
+// and BREACHED_PASSWORDS_FILE, the path of a breached password hash list, see LoadBreachedListFile
+func PolicyFromEnv() (Policy, error) {
+	policy := Policy{
+		MinLength:           intFromEnv("PASSWORD_MIN_LENGTH", defaultMinLength),
+		MaxBytes:            intFromEnv("PASSWORD_MAX_BYTES", defaultMaxBytes),
+		MinCharacterClasses: intFromEnv("PASSWORD_MIN_CHARACTER_CLASSES", defaultMinCharacterClasses),
+	}
+	if path := os.Getenv("BREACHED_PASSWORDS_FILE"); path != "" {
+		breached, err := LoadBreachedListFile(path)
+		if err != nil {
+			return Policy{}, fmt.Errorf("failed to load BREACHED_PASSWORDS_FILE: %w", err)
+		}
+		log.Printf("Loaded %d breached password hashes", breached.Len())
+		policy.Breached = breached
+	}
+	return policy, nil
+}
+
+func intFromEnv(key string, fallback int) int {
+	value, exist := os.LookupEnv(key)
+	if !exist {
+		return fallback
+	}
+	number, err := strconv.Atoi(value)
+	if err != nil || number < 0 {
+		log.Printf("Invalid number %q for %s, fallback to %v", value, key, fallback)
+		return fallback
+	}
+	return number
+}
+
+// Check Return every rule the password breaks, none when it is accepted.
+// personalInfo are the email and names of the account, the password can't contain them
+func (p Policy) Check(password string, personalInfo ...string) []Violation {
+	var violations []Violation
+	if utf8.RuneCountInString(password) < p.MinLength {
+		violations = append(violations, Violation{Tag: TagMin, Param: strconv.Itoa(p.MinLength)})
+	}
+	if p.MaxBytes > 0 && len(password) > p.MaxBytes {
+		violations = append(violations, Violation{Tag: TagMax, Param: strconv.Itoa(p.MaxBytes)})
+	}
+	if characterClasses(password) < p.MinCharacterClasses {
+		violations = append(violations, Violation{Tag: TagCharacterClasses, Param: strconv.Itoa(p.MinCharacterClasses)})
+	}
+	if containsPersonalInfo(password, personalInfo) {
+		violations = append(violations, Violation{Tag: TagPersonalInfo})
+	}
+	if p.Breached != nil && p.Breached.Contains(password) {
+		violations = append(violations, Violation{Tag: TagBreached})
+	}
+	return violations
+}
+
+// characterClasses Count the classes among lowercase letters, uppercase letters, digits and symbols used in the password
+func characterClasses(password string) int {
+	var lower, upper, digit, symbol bool
+	for _, r := range password {
+		switch {
+		case unicode.IsLower(r):
+			lower = true
+		case unicode.IsUpper(r):
+			upper = true
+		case unicode.IsDigit(r):
+			digit = true
+		default:
+			symbol = true
+		}
+	}
+	classes := 0
+	for _, used := range []bool{lower, upper, digit, symbol} {
+		if used {
+			classes++
+		}
+	}
+	return classes
+}
+
+// containsPersonalInfo Report if the password contains one of the values, the local part of an email,
+// or one of the words of a name, ignoring case
+func containsPersonalInfo(password string, personalInfo []string) bool {
+	password = strings.ToLower(password)
+	for _, info := range personalInfo {
+		info = strings.ToLower(info)
+		if localPart, _, isEmail := strings.Cut(info, "@"); isEmail {
+			info = localPart
+		}
+		parts := append([]string{info}, strings.FieldsFunc(info, func(r rune) bool {
+			return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+		})...)
+		for _, part := range parts {
+			if utf8.RuneCountInString(part) >= minPersonalInfoLength && strings.Contains(password, part) {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,208 @@
+package password
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"log"
+	"os"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Default limits of the hashing pool, used when the matching env variable is missing or invalid
+const (
+	defaultMaxQueue     = 64
+	defaultQueueTimeout = 2 * time.Second
+)
+
+// ErrOverloaded Returned when a hash waited too long for a slot, or the queue was full
+var ErrOverloaded = errors.New("password hashing is overloaded")
+
+// Pool Bound how many passwords are hashed or verified at once. Hashing is meant to be slow,
+// without a bound a burst of sign ins takes every CPU and stalls the other requests.
+// Callers wait in a queue for a free slot, and get ErrOverloaded once the queue is full or they waited longer than the queue timeout,
+// so an overloaded server answers fast instead of piling up requests
+type Pool struct {
+	hasher       Hasher
+	slots        chan struct{}
+	maxQueue     int64
+	queueTimeout time.Duration
+
+	unknownHashOnce sync.Once
+	unknownHash     string
+
+	queued    atomic.Int64
+	inFlight  atomic.Int64
+	completed atomic.Uint64
+	rejected  atomic.Uint64
+	canceled  atomic.Uint64
+	waitNanos atomic.Int64
+}
+
+// Stats Counters of the pool, to tell if the concurrency limit fits the load
+type Stats struct {
+	Concurrency int   `json:"concurrency"`
+	InFlight    int64 `json:"inFlight"`
+	Queued      int64 `json:"queued"`
+	// Completed Hashes and verifications done since the server started
+	Completed uint64 `json:"completed"`
+	// Rejected Refused with ErrOverloaded
+	Rejected uint64 `json:"rejected"`
+	// Canceled Given up while queued as the request was canceled
+	Canceled uint64 `json:"canceled"`
+	// TotalWaitSeconds Time spent waiting for a slot by the completed ones
+	TotalWaitSeconds float64 `json:"totalWaitSeconds"`
+}
+
+// NewPool A pool running at most concurrency hashes at once with up to maxQueue waiting, passwords are hashed with hasher
+func NewPool(hasher Hasher, concurrency, maxQueue int, queueTimeout time.Duration) *Pool {
+	if concurrency < 1 {
+		concurrency = 1
+	}
+	return &Pool{
+		hasher:       hasher,
+		slots:        make(chan struct{}, concurrency),
+		maxQueue:     int64(maxQueue),
+		queueTimeout: queueTimeout,
+	}
+}
+
+// PoolFromEnv The pool configured with PASSWORD_HASH_CONCURRENCY, by default one less than the CPUs so other requests
+// can still be served, PASSWORD_HASH_MAX_QUEUE and PASSWORD_HASH_QUEUE_TIMEOUT in Go duration format, e.g. "2s".
+// Passwords are hashed with HasherFromEnv, an invalid hashing configuration is returned as error
+func PoolFromEnv() (*Pool, error) {
+	hasher, err := HasherFromEnv()
+	if err != nil {
+		return nil, err
+	}
+	defaultConcurrency := runtime.GOMAXPROCS(0) - 1
+	if defaultConcurrency < 1 {
+		defaultConcurrency = 1
+	}
+	return NewPool(
+		hasher,
+		intFromEnv("PASSWORD_HASH_CONCURRENCY", defaultConcurrency),
+		intFromEnv("PASSWORD_HASH_MAX_QUEUE", defaultMaxQueue),
+		durationFromEnv("PASSWORD_HASH_QUEUE_TIMEOUT", defaultQueueTimeout),
+	), nil
+}
+
+func durationFromEnv(key string, fallback time.Duration) time.Duration {
+	value, exist := os.LookupEnv(key)
+	if !exist {
+		return fallback
+	}
+	duration, err := time.ParseDuration(value)
+	if err != nil || duration < 0 {
+		log.Printf("Invalid duration %q for %s, fallback to %v", value, key, fallback)
+		return fallback
+	}
+	return duration
+}
+
+// Do Run fn once a slot is free. ErrOverloaded is returned without running fn when the queue is full
+// or no slot got free in time, and the error of ctx when it is done first
+func (p *Pool) Do(ctx context.Context, fn func() error) error {
+	start := time.Now()
+	if err := p.acquire(ctx); err != nil {
+		return err
+	}
+	p.waitNanos.Add(int64(time.Since(start)))
+	p.inFlight.Add(1)
+	defer func() {
+		p.inFlight.Add(-1)
+		p.completed.Add(1)
+		<-p.slots
+	}()
+	return fn()
+}
+
+// acquire Take a slot, waiting in the queue when none is free
+func (p *Pool) acquire(ctx context.Context) error {
+	select {
+	case p.slots <- struct{}{}:
+		return nil
+	default:
+	}
+	if p.queued.Add(1) > p.maxQueue {
+		p.queued.Add(-1)
+		p.rejected.Add(1)
+		return ErrOverloaded
+	}
+	defer p.queued.Add(-1)
+	timer := time.NewTimer(p.queueTimeout)
+	defer timer.Stop()
+	select {
+	case p.slots <- struct{}{}:
+		return nil
+	case <-timer.C:
+		p.rejected.Add(1)
+		return ErrOverloaded
+	case <-ctx.Done():
+		p.canceled.Add(1)
+		return ctx.Err()
+	}
+}
+
+// Hash Hash the password in a slot of the pool
+func (p *Pool) Hash(ctx context.Context, password string) (string, error) {
+	var hash string
+	err := p.Do(ctx, func() error {
+		var err error
+		hash, err = p.hasher.Hash(password)
+		return err
+	})
+	return hash, err
+}
+
+// Verify Compare the password with the hash in a slot of the pool, the error is only set when it couldn't be compared
+func (p *Pool) Verify(ctx context.Context, password, encodedHash string) (bool, error) {
+	var match bool
+	err := p.Do(ctx, func() error {
+		match = p.hasher.Verify(password, encodedHash)
+		return nil
+	})
+	return match, err
+}
+
+// VerifyUnknown Verify the password against a hash of the configured algorithm and parameters in a slot of the pool,
+// for the sign in of an unknown account to take as long, and be refused by an overloaded pool the same way, as a wrong password
+func (p *Pool) VerifyUnknown(ctx context.Context, password string) error {
+	return p.Do(ctx, func() error {
+		p.hasher.Verify(password, p.unknownAccountHash())
+		return nil
+	})
+}
+
+// unknownAccountHash A hash of a random password, created on first use
+func (p *Pool) unknownAccountHash() string {
+	p.unknownHashOnce.Do(func() {
+		secret := make([]byte, 16)
+		if _, err := rand.Read(secret); err != nil {
+			return
+		}
+		p.unknownHash, _ = p.hasher.Hash(hex.EncodeToString(secret))
+	})
+	return p.unknownHash
+}
+
+// NeedsRehash See Hasher.NeedsRehash, it only parses the hash so it doesn't take a slot
+func (p *Pool) NeedsRehash(encodedHash string) bool {
+	return p.hasher.NeedsRehash(encodedHash)
+}
+
+func (p *Pool) Stats() Stats {
+	return Stats{
+		Concurrency:      cap(p.slots),
+		InFlight:         p.inFlight.Load(),
+		Queued:           p.queued.Load(),
+		Completed:        p.completed.Load(),
+		Rejected:         p.rejected.Load(),
+		Canceled:         p.canceled.Load(),
+		TotalWaitSeconds: time.Duration(p.waitNanos.Load()).Seconds(),
+	}
+}
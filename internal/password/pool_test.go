@@ -0,0 +1,89 @@
+package password
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestPool(t *testing.T) {
+	pool := NewPool(testArgon2id, 1, 1, 20*time.Millisecond)
+
+	// Hold the only slot until release is closed
+	release := make(chan struct{})
+	started := make(chan struct{})
+	done := make(chan error)
+	go func() {
+		done <- pool.Do(context.Background(), func() error {
+			close(started)
+			<-release
+			return nil
+		})
+	}()
+	<-started
+
+	t.Run("QueueTimeout", func(t *testing.T) {
+		if _, err := pool.Hash(context.Background(), "Correct-Horse-42"); !errors.Is(err, ErrOverloaded) {
+			t.Errorf("expected %v once the queue timeout passed but got %v", ErrOverloaded, err)
+		}
+	})
+
+	t.Run("Canceled", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		if _, err := pool.Verify(ctx, "Correct-Horse-42", ""); !errors.Is(err, context.Canceled) {
+			t.Errorf("expected the canceled context error but got %v", err)
+		}
+	})
+
+	t.Run("QueueFull", func(t *testing.T) {
+		fullPool := NewPool(testArgon2id, 1, 0, time.Second)
+		fullPool.slots <- struct{}{}
+		start := time.Now()
+		if _, err := fullPool.Hash(context.Background(), "Correct-Horse-42"); !errors.Is(err, ErrOverloaded) {
+			t.Errorf("expected %v with a full queue but got %v", ErrOverloaded, err)
+		}
+		if time.Since(start) > 500*time.Millisecond {
+			t.Error("expected a full queue to be refused without waiting")
+		}
+	})
+
+	close(release)
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("HashAndVerify", func(t *testing.T) {
+		hash, err := pool.Hash(context.Background(), "Correct-Horse-42")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if match, err := pool.Verify(context.Background(), "Correct-Horse-42", hash); err != nil || !match {
+			t.Errorf("expected the password to match its hash, got %v %v", match, err)
+		}
+	})
+
+	stats := pool.Stats()
+	if stats.Concurrency != 1 || stats.InFlight != 0 || stats.Queued != 0 {
+		t.Errorf("expected an idle pool of one slot but got %+v", stats)
+	}
+	if stats.Completed != 3 || stats.Rejected != 1 || stats.Canceled != 1 {
+		t.Errorf("expected 3 completed, 1 rejected and 1 canceled but got %+v", stats)
+	}
+}
+
+func TestPoolVerifyUnknown(t *testing.T) {
+	pool := NewPool(testArgon2id, 1, 0, time.Second)
+	if err := pool.VerifyUnknown(context.Background(), "Correct-Horse-42"); err != nil {
+		t.Fatal(err)
+	}
+	if pool.NeedsRehash(pool.unknownHash) {
+		t.Errorf("expected the hash of unknown accounts to have the configured parameters but got %s", pool.unknownHash)
+	}
+
+	pool.slots <- struct{}{}
+	if err := pool.VerifyUnknown(context.Background(), "Correct-Horse-42"); !errors.Is(err, ErrOverloaded) {
+		t.Errorf("expected an unknown account to be refused with %v like a known one but got %v", ErrOverloaded, err)
+	}
+}
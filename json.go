@@ -26,3 +26,27 @@ func WriteErrorJson(w http.ResponseWriter, statusCode int, msg string) {
 	WriteJSON(w, statusCode, ApiError{Error: msg})
 	return
 }
+
+// WriteOAuthError Write an error in the format of RFC 6749 section 5.2, used by the OAuth endpoints
+func WriteOAuthError(w http.ResponseWriter, statusCode int, errorCode, description string) {
+	type OAuthError struct {
+		Error            string `json:"error"`
+		ErrorDescription string `json:"error_description,omitempty"`
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	WriteJSON(w, statusCode, OAuthError{Error: errorCode, ErrorDescription: description})
+}
+
+// IError A validation error of a field of the request body
+type IError struct {
+	Field string `json:"field"`
+	Tag   string `json:"tag"`
+	Value string `json:"value"`
+}
+
+// writeValidationErrors Answer 400 with the list of validation errors as JSON string in the error message
+func writeValidationErrors(w http.ResponseWriter, errors []*IError) {
+	// Convert errors to a JSON string
+	errorsJSON, _ := json.Marshal(errors)
+	WriteErrorJson(w, http.StatusBadRequest, string(errorsJSON))
+}
@@ -0,0 +1,210 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/nguyenanhhao221/go-jwt/internal/auth"
+)
+
+// keyRingRefreshInterval How often every server instance reloads the key ring from the database.
+// Wait at least this long after adding a key before promoting it, so every instance already trusts it
+const keyRingRefreshInterval = time.Minute
+
+// loadKeyRing Load the key ring from the database and use it to sign and verify tokens.
+// The key configured in the environment signs until a key of the ring is promoted
+func loadKeyRing(store Storage) error {
+	fallbackKey, err := auth.LoadSigningKeyFromEnv()
+	if err != nil {
+		return err
+	}
+	ring, err := store.GetKeyRing()
+	if err != nil {
+		return err
+	}
+	auth.SetKeySet(ring.KeySet(fallbackKey))
+	return nil
+}
+
+// watchKeyRing Reload the key ring periodically to pick up rotations done by other instances or the CLI
+func watchKeyRing(store Storage) {
+	ticker := time.NewTicker(keyRingRefreshInterval)
+	defer ticker.Stop()
+	for range ticker.C {
+		if err := loadKeyRing(store); err != nil {
+			log.Printf("Failed to reload key ring %v", err)
+		}
+	}
+}
+
+func addSigningKey(store Storage, alg string) (*auth.KeyRingEntry, error) {
+	key, err := auth.GenerateSigningKey(alg)
+	if err != nil {
+		return nil, err
+	}
+	var added *auth.KeyRingEntry
+	err = store.UpdateKeyRing(func(ring *auth.KeyRing) error {
+		added, err = ring.Add(key, time.Now().UTC())
+		return err
+	})
+	return added, err
+}
+
+func promoteSigningKey(store Storage, kid string) error {
+	return store.UpdateKeyRing(func(ring *auth.KeyRing) error {
+		return ring.Promote(kid, time.Now().UTC())
+	})
+}
+
+func retireSigningKey(store Storage, kid string) error {
+	return store.UpdateKeyRing(func(ring *auth.KeyRing) error {
+		return ring.Retire(kid, time.Now().UTC(), auth.MaxTokenLifetime())
+	})
+}
+
+// runKeysCommand Manage the signing key ring from the command line:
+//
+//	go-jwt keys list
+//	go-jwt keys add [-alg ES256]
+//	go-jwt keys promote <kid>
+//	go-jwt keys retire <kid>
+func runKeysCommand(store Storage, args []string) error {
+	if len(args) == 0 {
+		return errors.New("usage: keys list | add [-alg RS256|ES256|EdDSA] | promote <kid> | retire <kid>")
+	}
+	switch args[0] {
+	case "list":
+		ring, err := store.GetKeyRing()
+		if err != nil {
+			return err
+		}
+		writer := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(writer, "KID\tALG\tSTATUS\tCREATED\tRETIRE AFTER")
+		for _, key := range newSigningKeyResponses(ring) {
+			retireAfter := "-"
+			if key.RetireAfter != nil {
+				retireAfter = key.RetireAfter.Format(time.RFC3339)
+			}
+			fmt.Fprintf(writer, "%s\t%s\t%s\t%s\t%s\n", key.Kid, key.Alg, key.Status, key.CreatedAt.Format(time.RFC3339), retireAfter)
+		}
+		return writer.Flush()
+	case "add":
+		flags := flag.NewFlagSet("keys add", flag.ContinueOnError)
+		alg := flags.String("alg", "ES256", "signing algorithm of the new key: RS256, ES256 or EdDSA")
+		if err := flags.Parse(args[1:]); err != nil {
+			return err
+		}
+		added, err := addSigningKey(store, *alg)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Added pending key %s, promote it after %v once every instance has reloaded the key ring\n", added.Key.ID, keyRingRefreshInterval)
+		return nil
+	case "promote", "retire":
+		if len(args) != 2 {
+			return fmt.Errorf("usage: keys %s <kid>", args[0])
+		}
+		if args[0] == "promote" {
+			return promoteSigningKey(store, args[1])
+		}
+		return retireSigningKey(store, args[1])
+	default:
+		return fmt.Errorf("unknown keys command %q", args[0])
+	}
+}
+
+// SigningKeyResponse A key of the ring as shown to admins, the private key is never exposed
+type SigningKeyResponse struct {
+	Kid           string         `json:"kid"`
+	Alg           string         `json:"alg"`
+	Status        auth.KeyStatus `json:"status"`
+	CreatedAt     time.Time      `json:"createdAt"`
+	ActivatedAt   *time.Time     `json:"activatedAt,omitempty"`
+	DeactivatedAt *time.Time     `json:"deactivatedAt,omitempty"`
+	// RetireAfter When an inactive key can be retired without invalidating any token
+	RetireAfter *time.Time `json:"retireAfter,omitempty"`
+}
+
+func newSigningKeyResponses(ring *auth.KeyRing) []SigningKeyResponse {
+	keys := []SigningKeyResponse{}
+	for _, entry := range ring.Entries() {
+		key := SigningKeyResponse{
+			Kid:           entry.Key.ID,
+			Alg:           entry.Key.Method.Alg(),
+			Status:        entry.Status,
+			CreatedAt:     entry.CreatedAt,
+			ActivatedAt:   entry.ActivatedAt,
+			DeactivatedAt: entry.DeactivatedAt,
+		}
+		if entry.DeactivatedAt != nil {
+			retireAfter := entry.DeactivatedAt.Add(auth.MaxTokenLifetime())
+			key.RetireAfter = &retireAfter
+		}
+		keys = append(keys, key)
+	}
+	return keys
+}
+
+func (s *APIServer) handleGetSigningKeys(w http.ResponseWriter, r *http.Request) {
+	if ring, err := s.store.GetKeyRing(); err != nil {
+		WriteErrorJson(w, http.StatusInternalServerError, err.Error())
+	} else {
+		WriteJSON(w, http.StatusOK, newSigningKeyResponses(ring))
+	}
+}
+
+func (s *APIServer) handleAddSigningKey(w http.ResponseWriter, r *http.Request) {
+	alg := r.URL.Query().Get("alg")
+	if alg == "" {
+		alg = "ES256"
+	}
+	added, err := addSigningKey(s.store, alg)
+	if err != nil {
+		WriteErrorJson(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	s.reloadKeyRing()
+	WriteJSON(w, http.StatusCreated, newSigningKeyResponses(auth.NewKeyRing(added))[0])
+}
+
+func (s *APIServer) handlePromoteSigningKey(w http.ResponseWriter, r *http.Request) {
+	if err := promoteSigningKey(s.store, chi.URLParam(r, "kid")); err != nil {
+		writeKeyRingError(w, err)
+		return
+	}
+	s.reloadKeyRing()
+	WriteJSON(w, http.StatusNoContent, nil)
+}
+
+func (s *APIServer) handleRetireSigningKey(w http.ResponseWriter, r *http.Request) {
+	if err := retireSigningKey(s.store, chi.URLParam(r, "kid")); err != nil {
+		writeKeyRingError(w, err)
+		return
+	}
+	s.reloadKeyRing()
+	WriteJSON(w, http.StatusNoContent, nil)
+}
+
+func (s *APIServer) reloadKeyRing() {
+	if err := loadKeyRing(s.store); err != nil {
+		log.Printf("Failed to reload key ring %v", err)
+	}
+}
+
+func writeKeyRingError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, auth.ErrKeyNotFound):
+		WriteErrorJson(w, http.StatusNotFound, err.Error())
+	case errors.Is(err, auth.ErrKeyInvalidState), errors.Is(err, auth.ErrKeyStillInUse):
+		WriteErrorJson(w, http.StatusConflict, err.Error())
+	default:
+		WriteErrorJson(w, http.StatusInternalServerError, err.Error())
+	}
+}
@@ -1,10 +1,13 @@
 package main
 
 import (
+	"errors"
+	"fmt"
 	"log"
 	"os"
 
 	"github.com/joho/godotenv"
+	"github.com/nguyenanhhao221/go-jwt/internal/auth"
 	"github.com/nguyenanhhao221/go-jwt/util"
 )
 
@@ -27,8 +30,64 @@ func main() {
 	if err := store.Init(); err != nil {
 		log.Fatal(err)
 	}
+
+	// Run a management command instead of starting the server, e.g. `go-jwt keys list`
+	if len(os.Args) > 1 {
+		if err := runCommand(store, os.Args[1], os.Args[2:]); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	if err := loadKeyRing(store); err != nil {
+		log.Fatalf("Failed to load signing keys %v", err)
+	}
+	go watchKeyRing(store)
+	if _, err := auth.CurrentTokenFormat(); err != nil {
+		log.Fatalf("Invalid access token format %v", err)
+	}
+
 	port := os.Getenv("PORT")
 	portAsString := util.GetHostString(port)
 	apiSrv := NewAPIServer(portAsString, store)
 	apiSrv.Run()
 }
+
+func runCommand(store Storage, name string, args []string) error {
+	commands := map[string]func(store Storage, args []string) error{
+		"keys":     runKeysCommand,
+		"accounts": runAccountsCommand,
+		"clients":  runClientsCommand,
+	}
+	command, ok := commands[name]
+	if !ok {
+		return fmt.Errorf("unknown command %q, expected keys, accounts or clients", name)
+	}
+	return command(store, args)
+}
+
+// runAccountsCommand Change the role of an account from the command line, e.g. to create the first admin:
+//
+//	go-jwt accounts set-role <email> <role>
+func runAccountsCommand(store Storage, args []string) error {
+	if len(args) != 3 || args[0] != "set-role" {
+		return errors.New("usage: accounts set-role <email> <customer|support|admin>")
+	}
+	role, err := auth.ParseRole(args[2])
+	if err != nil {
+		return err
+	}
+	account, err := store.GetAccountByEmail(args[1])
+	if err != nil {
+		return fmt.Errorf("failed to find account %v: %w", args[1], err)
+	}
+	if err := store.UpdateAccountRole(account.ID, role); err != nil {
+		return err
+	}
+	// Sign the account out everywhere so the previous role can't be used anymore
+	if err := NewRevocationList(store).RevokeAccount(account.ID); err != nil {
+		return err
+	}
+	fmt.Printf("Account %v is now %v\n", args[1], role)
+	return nil
+}
@@ -0,0 +1,254 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/nguyenanhhao221/go-jwt/internal/auth"
+)
+
+var errInvalidSecondFactor = errors.New("invalid two-factor code")
+
+// MFAChallengeResponse The response of sign in when the password is right but the account has two-factor authentication.
+// The MFA token must be sent with a code to the sign in MFA endpoint to get the access token
+type MFAChallengeResponse struct {
+	MFARequired bool   `json:"mfaRequired"`
+	MFAToken    string `json:"mfaToken"`
+	// ExpiresIn Lifetime of the MFA token in seconds
+	ExpiresIn int64 `json:"expiresIn"`
+}
+
+// TOTPEnrollmentResponse The secret of a new TOTP enrollment, the provisioning URI is meant to be shown as a QR code
+type TOTPEnrollmentResponse struct {
+	Secret          string `json:"secret"`
+	ProvisioningURI string `json:"provisioningUri"`
+}
+
+// RecoveryCodesResponse The recovery codes, only shown once when two-factor authentication is enabled
+type RecoveryCodesResponse struct {
+	RecoveryCodes []string `json:"recoveryCodes"`
+}
+
+// totpEnabled Report if the account must enter a second factor to sign in
+func (s *APIServer) totpEnabled(accountId uuid.UUID) (bool, error) {
+	accountTOTP, err := s.store.GetAccountTOTP(accountId)
+	if errors.Is(err, sql.ErrNoRows) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return accountTOTP.Enabled(), nil
+}
+
+// verifySecondFactor Check the code from the authenticator app, or a recovery code, of the account.
+// Each code can only be used once. Wrong codes are throttled like wrong passwords,
+// a *signInLockedError is returned while locked
+func (s *APIServer) verifySecondFactor(r *http.Request, accountId uuid.UUID, code string) error {
+	// Wrong codes count for the email, like wrong passwords
+	account, err := s.store.GetAccountById(accountId)
+	if err != nil {
+		return errInvalidSecondFactor
+	}
+	throttle, err := s.startSignInThrottle(r, accountId, account.Email)
+	var lockedErr *signInLockedError
+	if errors.As(err, &lockedErr) {
+		s.recordSignInAttempt(r, accountId, signInLocked)
+		return err
+	} else if err != nil {
+		return err
+	}
+	accountTOTP, err := s.store.GetAccountTOTP(accountId)
+	if err != nil || !accountTOTP.Enabled() {
+		throttle.cancel()
+		return errInvalidSecondFactor
+	}
+	if step, ok := auth.ValidateTOTP(accountTOTP.Secret, code, time.Now()); ok {
+		if err := s.store.UseTOTPStep(accountId, step); err != nil {
+			log.Printf("Refused TOTP code of account %v: %v", accountId, err)
+			throttle.fail()
+			s.recordSignInAttempt(r, accountId, signInWrongCode)
+			return errInvalidSecondFactor
+		}
+		throttle.succeed()
+		return nil
+	}
+	if err := s.store.UseRecoveryCode(accountId, auth.HashRecoveryCode(code)); err != nil {
+		throttle.fail()
+		s.recordSignInAttempt(r, accountId, signInWrongCode)
+		return errInvalidSecondFactor
+	}
+	throttle.succeed()
+	log.Printf("Account %v signed in with a recovery code", accountId)
+	return nil
+}
+
+// handleSignInMFA The second phase of signing in with two-factor authentication,
+// exchange the MFA token from handleSignIn, or a passwordless sign in, and a code for the access token
+func (s *APIServer) handleSignInMFA(w http.ResponseWriter, r *http.Request) {
+	type SignInMFAReqBody struct {
+		MFAToken string `json:"mfaToken" validate:"required"`
+		// Code The code from the authenticator app, or a recovery code
+		Code string `json:"code" validate:"required"`
+		// Device A label for the session, see handleSignIn
+		Device string `json:"device" validate:"max=100"`
+	}
+	signInMFAReqBody := new(SignInMFAReqBody)
+	if err := json.NewDecoder(r.Body).Decode(signInMFAReqBody); err != nil {
+		WriteErrorJson(w, http.StatusForbidden, err.Error())
+		return
+	}
+
+	claims, err := auth.ValidateMFAToken(signInMFAReqBody.MFAToken)
+	if err != nil {
+		log.Printf("Invalid MFA token: %v", err)
+		WriteErrorJson(w, http.StatusUnauthorized, "Invalid MFA token")
+		return
+	}
+	var lockedErr *signInLockedError
+	if err := s.verifySecondFactor(r, claims.ID, signInMFAReqBody.Code); errors.As(err, &lockedErr) {
+		writeTooManyAttempts(w, lockedErr)
+		return
+	} else if err != nil {
+		WriteErrorJson(w, http.StatusUnauthorized, "Wrong two-factor code")
+		return
+	}
+	// Read the account again, its role may have changed since the password was checked
+	account, err := s.store.GetAccountById(claims.ID)
+	if err != nil {
+		WriteErrorJson(w, http.StatusUnauthorized, "Invalid MFA token")
+		return
+	}
+	// MFA tokens from before the first factor was recorded were all issued for a password
+	firstFactor := claims.AMR
+	if len(firstFactor) == 0 {
+		firstFactor = []string{auth.AMRPassword}
+	}
+	session, err := s.startSession(r, account.ID, signInMFAReqBody.Device)
+	if err != nil {
+		WriteErrorJson(w, http.StatusInternalServerError, fmt.Sprintf("Failed to start session %v", err))
+		return
+	}
+	s.completeSignIn(r, account.ID)
+	tokenRes, err := s.issueTokens(tokenGrant{
+		accountId: account.ID,
+		role:      account.Role,
+		authTime:  time.Now(),
+		amr:       append(firstFactor, auth.AMROTP),
+		familyId:  session.ID,
+		sessionId: session.ID,
+		dpopJKT:   dpopJKTFromContext(r.Context()),
+	}, nil)
+	if err != nil {
+		WriteErrorJson(w, http.StatusInternalServerError, fmt.Sprintf("Failed to create JWT token %v", err))
+		return
+	}
+	setAccessTokenCookie(w, tokenRes)
+	WriteJSON(w, http.StatusOK, tokenRes)
+}
+
+// handleEnrollTOTP Start enabling TOTP for the account of the token, the app must then send a first code to handleVerifyTOTP.
+// Starting again before the verification replaces the secret
+func (s *APIServer) handleEnrollTOTP(w http.ResponseWriter, r *http.Request) {
+	claims, _ := claimsFromContext(r.Context())
+	account, err := s.store.GetAccountById(claims.ID)
+	if err != nil {
+		WriteErrorJson(w, http.StatusNotFound, err.Error())
+		return
+	}
+	secret, err := auth.NewTOTPSecret()
+	if err != nil {
+		WriteErrorJson(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if err := s.store.SaveTOTPSecret(account.ID, secret); errors.Is(err, sql.ErrNoRows) {
+		WriteErrorJson(w, http.StatusConflict, "Two-factor authentication is already enabled")
+		return
+	} else if err != nil {
+		WriteErrorJson(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	WriteJSON(w, http.StatusOK, TOTPEnrollmentResponse{
+		Secret:          secret,
+		ProvisioningURI: auth.TOTPProvisioningURI(secret, account.Email),
+	})
+}
+
+// handleVerifyTOTP Enable TOTP once the first code from the app is verified, and return the recovery codes
+func (s *APIServer) handleVerifyTOTP(w http.ResponseWriter, r *http.Request) {
+	type VerifyTOTPReqBody struct {
+		Code string `json:"code" validate:"required"`
+	}
+	verifyTOTPReqBody := new(VerifyTOTPReqBody)
+	if err := json.NewDecoder(r.Body).Decode(verifyTOTPReqBody); err != nil {
+		WriteErrorJson(w, http.StatusForbidden, err.Error())
+		return
+	}
+	claims, _ := claimsFromContext(r.Context())
+
+	accountTOTP, err := s.store.GetAccountTOTP(claims.ID)
+	if errors.Is(err, sql.ErrNoRows) {
+		WriteErrorJson(w, http.StatusNotFound, "Two-factor authentication enrollment not started")
+		return
+	} else if err != nil {
+		WriteErrorJson(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if accountTOTP.Enabled() {
+		WriteErrorJson(w, http.StatusConflict, "Two-factor authentication is already enabled")
+		return
+	}
+	step, ok := auth.ValidateTOTP(accountTOTP.Secret, verifyTOTPReqBody.Code, time.Now())
+	if !ok {
+		WriteErrorJson(w, http.StatusBadRequest, "Wrong two-factor code")
+		return
+	}
+	recoveryCodes, recoveryCodeHashes, err := auth.NewRecoveryCodes()
+	if err != nil {
+		WriteErrorJson(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if err := s.store.EnableTOTP(claims.ID, step, recoveryCodeHashes); err != nil {
+		WriteErrorJson(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	log.Printf("Two-factor authentication enabled for account %v", claims.ID)
+	w.Header().Set("Cache-Control", "no-store")
+	WriteJSON(w, http.StatusOK, RecoveryCodesResponse{RecoveryCodes: recoveryCodes})
+}
+
+// handleDisableTOTP Disable TOTP for the account of the token, a current code or a recovery code is required
+// so a stolen access token isn't enough to remove the second factor
+func (s *APIServer) handleDisableTOTP(w http.ResponseWriter, r *http.Request) {
+	type DisableTOTPReqBody struct {
+		Code string `json:"code" validate:"required"`
+	}
+	disableTOTPReqBody := new(DisableTOTPReqBody)
+	if err := json.NewDecoder(r.Body).Decode(disableTOTPReqBody); err != nil {
+		WriteErrorJson(w, http.StatusForbidden, err.Error())
+		return
+	}
+	claims, _ := claimsFromContext(r.Context())
+
+	var lockedErr *signInLockedError
+	if err := s.verifySecondFactor(r, claims.ID, disableTOTPReqBody.Code); errors.As(err, &lockedErr) {
+		writeTooManyAttempts(w, lockedErr)
+		return
+	} else if err != nil {
+		WriteErrorJson(w, http.StatusForbidden, "Wrong two-factor code")
+		return
+	}
+	if err := s.store.DeleteAccountTOTP(claims.ID); err != nil {
+		WriteErrorJson(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	log.Printf("Two-factor authentication disabled for account %v", claims.ID)
+	WriteJSON(w, http.StatusNoContent, nil)
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/nguyenanhhao221/go-jwt/internal/auth"
+	"github.com/nguyenanhhao221/go-jwt/settings"
+)
+
+func TestTOTPCI(t *testing.T) {
+	store, err := NewPostgresStore()
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := NewAPIServer("", store)
+	mockUser := NewAccount("TOTP First Name", "TOTP Last Name", "TOTP@email.com", "TestPassword")
+	accountId, err := store.CreateAccount(mockUser)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.DeleteAccountById(accountId)
+	tokenRes, err := server.issueTokens(tokenGrant{accountId: accountId, role: auth.RoleCustomer}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	request := func(handler http.HandlerFunc, method, route string, body any, authenticated bool) *httptest.ResponseRecorder {
+		reqBodyJSON, err := json.Marshal(body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req, err := http.NewRequest(method, route, bytes.NewBuffer(reqBodyJSON))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if authenticated {
+			req.Header.Set("Authorization", "Bearer "+tokenRes.Token)
+			handler = server.withJWTAuth(withPermission(handler, permission{}))
+		}
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		return rr
+	}
+	signIn := func() MFAChallengeResponse {
+		rr := request(server.handleSignIn, http.MethodPost, settings.AppSettings.SignIn_Account_Route, map[string]string{"email": mockUser.Email, "password": "TestPassword"}, false)
+		if rr.Code != http.StatusOK {
+			t.Fatalf("expected status code %d but got %d", http.StatusOK, rr.Code)
+		}
+		var challenge MFAChallengeResponse
+		if err := json.Unmarshal(rr.Body.Bytes(), &challenge); err != nil {
+			t.Fatal(err)
+		}
+		return challenge
+	}
+
+	var enrollment TOTPEnrollmentResponse
+	var recoveryCodes RecoveryCodesResponse
+	var verifiedCode string
+	t.Run("Enroll", func(t *testing.T) {
+		rr := request(server.handleEnrollTOTP, http.MethodPost, settings.AppSettings.MFA_TOTP_Route, nil, true)
+		if rr.Code != http.StatusOK {
+			t.Fatalf("expected status code %d but got %d", http.StatusOK, rr.Code)
+		}
+		if err := json.Unmarshal(rr.Body.Bytes(), &enrollment); err != nil {
+			t.Fatal(err)
+		}
+
+		// Until the first code is verified the password is still enough to sign in
+		if challenge := signIn(); challenge.MFARequired {
+			t.Error("expected no MFA before the enrollment is verified")
+		}
+
+		if verifiedCode, err = auth.TOTPCode(enrollment.Secret, time.Now()); err != nil {
+			t.Fatal(err)
+		}
+		rr = request(server.handleVerifyTOTP, http.MethodPost, settings.AppSettings.MFA_TOTP_Verify_Route, map[string]string{"code": verifiedCode}, true)
+		if rr.Code != http.StatusOK {
+			t.Fatalf("expected status code %d but got %d", http.StatusOK, rr.Code)
+		}
+		if err := json.Unmarshal(rr.Body.Bytes(), &recoveryCodes); err != nil {
+			t.Fatal(err)
+		}
+		if len(recoveryCodes.RecoveryCodes) == 0 {
+			t.Error("expected recovery codes once TOTP is enabled")
+		}
+	})
+
+	t.Run("SignInWithMFA", func(t *testing.T) {
+		challenge := signIn()
+		if !challenge.MFARequired || challenge.MFAToken == "" {
+			t.Fatalf("expected a MFA challenge but got %v", challenge)
+		}
+		// The MFA token is not an access token
+		if _, err := auth.ValidateJWT(challenge.MFAToken); err == nil {
+			t.Error("expected the MFA token to be rejected as access token")
+		}
+
+		// The code used to enable TOTP can't be replayed
+		rr := request(server.handleSignInMFA, http.MethodPost, settings.AppSettings.SignIn_MFA_Route, map[string]string{"mfaToken": challenge.MFAToken, "code": verifiedCode}, false)
+		if rr.Code != http.StatusUnauthorized {
+			t.Errorf("expected status code %d but got %d", http.StatusUnauthorized, rr.Code)
+		}
+
+		recoveryCode := recoveryCodes.RecoveryCodes[0]
+		rr = request(server.handleSignInMFA, http.MethodPost, settings.AppSettings.SignIn_MFA_Route, map[string]string{"mfaToken": challenge.MFAToken, "code": recoveryCode}, false)
+		if rr.Code != http.StatusOK {
+			t.Fatalf("expected status code %d but got %d", http.StatusOK, rr.Code)
+		}
+		var signInResponse TokenResponse
+		if err := json.Unmarshal(rr.Body.Bytes(), &signInResponse); err != nil {
+			t.Fatal(err)
+		}
+		if signInResponse.Token == "" {
+			t.Error("expected an access token after the second factor")
+		}
+
+		rr = request(server.handleSignInMFA, http.MethodPost, settings.AppSettings.SignIn_MFA_Route, map[string]string{"mfaToken": challenge.MFAToken, "code": recoveryCode}, false)
+		if rr.Code != http.StatusUnauthorized {
+			t.Errorf("expected a used recovery code to be rejected but got %d", rr.Code)
+		}
+	})
+
+	t.Run("Disable", func(t *testing.T) {
+		rr := request(server.handleDisableTOTP, http.MethodDelete, settings.AppSettings.MFA_TOTP_Route, map[string]string{"code": "000000"}, true)
+		if rr.Code != http.StatusForbidden {
+			t.Errorf("expected status code %d but got %d", http.StatusForbidden, rr.Code)
+		}
+		rr = request(server.handleDisableTOTP, http.MethodDelete, settings.AppSettings.MFA_TOTP_Route, map[string]string{"code": recoveryCodes.RecoveryCodes[1]}, true)
+		if rr.Code != http.StatusNoContent {
+			t.Fatalf("expected status code %d but got %d", http.StatusNoContent, rr.Code)
+		}
+		if challenge := signIn(); challenge.MFARequired {
+			t.Error("expected no MFA once TOTP is disabled")
+		}
+	})
+}
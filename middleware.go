@@ -1,49 +1,212 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
+	"os"
+	"strconv"
+	"strings"
 
 	"github.com/nguyenanhhao221/go-jwt/internal/auth"
 	"github.com/nguyenanhhao221/go-jwt/util"
 )
 
-// withJWTAuth Middleware to validate the JWT token in the client request
-func withJWTAuth(next http.HandlerFunc) http.HandlerFunc {
+type contextKey string
+
+const claimsContextKey contextKey = "claims"
+
+// claimsFromContext The claims of the token validated by withJWTAuth
+func claimsFromContext(ctx context.Context) (*auth.CustomJWTClaims, bool) {
+	claims, ok := ctx.Value(claimsContextKey).(*auth.CustomJWTClaims)
+	return claims, ok
+}
+
+// authRealm The realm sent in the WWW-Authenticate challenge
+const authRealm = "go-jwt"
+
+// accessTokenCookieName The HttpOnly cookie holding the access token when JWT_COOKIE_AUTH is enabled
+const accessTokenCookieName = "access_token"
+
+var errMultipleTokens = errors.New("the access token must be sent with only one method")
+
+// cookieAuthEnabled Report if the access token is also accepted from, and set in, an HttpOnly cookie.
+// Enabled with JWT_COOKIE_AUTH=true, for browser clients that shouldn't keep the token in JavaScript
+func cookieAuthEnabled() bool {
+	enabled, _ := strconv.ParseBool(os.Getenv("JWT_COOKIE_AUTH"))
+	return enabled
+}
+
+// tokenFromRequest Extract the access token from the request.
+// The standard `Authorization: Bearer` header is preferred, or `Authorization: DPoP` for tokens bound to a DPoP key, the legacy x-jwt-token header is still accepted,
+// and when cookie auth is enabled the access token cookie is used if no header is sent.
+// An empty token is returned without error when the request has no credentials at all
+func tokenFromRequest(r *http.Request) (string, error) {
+	authorization := r.Header.Get("Authorization")
+	legacyToken := r.Header.Get("x-jwt-token")
+	if authorization != "" && legacyToken != "" {
+		return "", errMultipleTokens
+	}
+	if authorization != "" {
+		scheme, token, found := strings.Cut(authorization, " ")
+		if !found || !(strings.EqualFold(scheme, "Bearer") || strings.EqualFold(scheme, auth.DPoPTokenType)) || strings.TrimSpace(token) == "" {
+			return "", errors.New("the Authorization header must use the Bearer or DPoP scheme")
+		}
+		return strings.TrimSpace(token), nil
+	}
+	if legacyToken != "" {
+		return legacyToken, nil
+	}
+	if cookieAuthEnabled() {
+		if cookie, err := r.Cookie(accessTokenCookieName); err == nil {
+			return cookie.Value, nil
+		}
+	}
+	return "", nil
+}
+
+// writeAuthChallenge Answer with a RFC 6750 WWW-Authenticate challenge.
+// errorCode is empty when the request had no credentials, as the spec asks not to include an error then
+func writeAuthChallenge(w http.ResponseWriter, statusCode int, errorCode, description string) {
+	challenge := fmt.Sprintf("Bearer realm=%q", authRealm)
+	if errorCode != "" {
+		challenge += fmt.Sprintf(", error=%q, error_description=%q", errorCode, description)
+	}
+	w.Header().Set("WWW-Authenticate", challenge)
+	WriteErrorJson(w, statusCode, description)
+}
+
+// withJWTAuth Middleware to validate the access token in the client request, in the format of ACCESS_TOKEN_FORMAT (a JWT or a PASETO).
+// API keys are accepted the same way.
+// A token bound to a DPoP key is only accepted with a proof of that key, see checkDPoPBinding.
+// The claims of a valid token are added to the request context, see claimsFromContext
+func (s *APIServer) withJWTAuth(next http.HandlerFunc) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		log.Println("Checking JWT Auth")
-		tokenString := r.Header.Get("x-jwt-token")
-		token, err := auth.ValidateJWT(tokenString)
+		tokenString, err := tokenFromRequest(r)
+		if err != nil {
+			writeAuthChallenge(w, http.StatusBadRequest, "invalid_request", err.Error())
+			return
+		}
+		if tokenString == "" {
+			writeAuthChallenge(w, http.StatusUnauthorized, "", "Missing token")
+			return
+		}
+		// API keys are revoked on their own, signing out of the sessions of the account doesn't revoke them
+		if auth.IsAPIKey(tokenString) {
+			if usesDPoPScheme(r) {
+				writeDPoPChallenge(w, http.StatusUnauthorized, "invalid_token", "API keys can't be bound to a DPoP key")
+				return
+			}
+			claims, err := s.authenticateAPIKey(tokenString)
+			if err != nil {
+				log.Printf("Invalid API key: %v", err)
+				writeAuthChallenge(w, http.StatusUnauthorized, "invalid_token", "Invalid API key")
+				return
+			}
+			next(w, r.WithContext(context.WithValue(r.Context(), claimsContextKey, claims)))
+			return
+		}
+		claims, err := auth.ValidateAccessToken(tokenString)
 		if err != nil {
 			log.Printf("Invalid token: %v", err)
-			WriteErrorJson(w, http.StatusUnauthorized, "Invalid token")
+			// Only tell the client that the token expired, so it knows to refresh it, the other reasons are only logged
+			description := "Invalid token"
+			if errors.Is(err, auth.ErrTokenExpired) {
+				description = "Token expired"
+			}
+			writeAuthChallenge(w, http.StatusUnauthorized, "invalid_token", description)
+			return
+		}
+
+		if s.revocations.IsRevoked(claims) {
+			log.Printf("Revoked token %v used for account %v", claims.Id, claims.ID)
+			writeAuthChallenge(w, http.StatusUnauthorized, "invalid_token", "Token has been revoked")
 			return
 		}
-		if !token.Valid {
-			WriteErrorJson(w, http.StatusForbidden, "Invalid token")
+		if err := s.checkDPoPBinding(r, tokenString, claims); err != nil {
+			log.Printf("DPoP check failed for token %v: %v", claims.Id, err)
+			if errors.Is(err, auth.ErrInvalidDPoPProof) {
+				writeDPoPChallenge(w, http.StatusUnauthorized, "invalid_dpop_proof", "Invalid DPoP proof")
+			} else {
+				writeDPoPChallenge(w, http.StatusUnauthorized, "invalid_token", err.Error())
+			}
 			return
 		}
 
-		claims, ok := token.Claims.(*auth.CustomJWTClaims)
+		next(w, r.WithContext(context.WithValue(r.Context(), claimsContextKey, claims)))
+	}
+}
 
+// permission Who can access a route, checked by withPermission after withJWTAuth
+type permission struct {
+	// owner Let the owner of the account in the url through
+	owner bool
+	// roles Let accounts with one of the roles through. When neither owner nor roles is set any account is let through
+	roles []auth.Role
+	// scope Required from scoped tokens. Machine principals are only let through when they were granted it,
+	// and scoped tokens can't access routes without a scope
+	scope string
+}
+
+// withPermission Middleware to check the principal of the token can access the route, must be used after withJWTAuth
+func withPermission(next http.HandlerFunc, required permission) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		claims, ok := claimsFromContext(r.Context())
 		if !ok {
-			fmt.Println("Token claims are not of type CustomJWTClaims")
-			WriteErrorJson(w, http.StatusForbidden, "Invalid token claims")
+			WriteErrorJson(w, http.StatusUnauthorized, "Permission Denied")
 			return
 		}
 
-		acocuntIdFromReq, err := util.GetIdFromRequest(r)
-		if err != nil {
-			WriteErrorJson(w, http.StatusBadRequest, fmt.Sprintf("Invalid id in request: %v", err))
+		if claims.IsScoped() && (required.scope == "" || !claims.HasScope(required.scope)) {
+			log.Printf("Token of %v without scope %q denied access to %v", claims.Subject, required.scope, r.URL.Path)
+			writeAuthChallenge(w, http.StatusForbidden, "insufficient_scope", "Permission Denied")
 			return
 		}
-
-		if claims.ID != acocuntIdFromReq {
-			WriteErrorJson(w, http.StatusUnauthorized, "Permission Denied")
-			return
-		} else {
+		// The scope is all a machine principal needs, it has no account to own or role to check
+		if claims.IsMachine() {
 			next(w, r)
+			return
+		}
+
+		if required.owner || len(required.roles) > 0 {
+			isOwner := false
+			if required.owner {
+				acocuntIdFromReq, err := util.GetIdFromRequest(r)
+				if err != nil {
+					WriteErrorJson(w, http.StatusBadRequest, fmt.Sprintf("Invalid id in request: %v", err))
+					return
+				}
+				isOwner = claims.ID == acocuntIdFromReq
+			}
+			if !isOwner && !hasRole(claims, required.roles) {
+				log.Printf("Account %v with role %v denied access to %v", claims.ID, claims.EffectiveRole(), r.URL.Path)
+				writeAuthChallenge(w, http.StatusForbidden, "insufficient_scope", "Permission Denied")
+				return
+			}
+		}
+		next(w, r)
+	}
+}
+
+// withAccountOwner Middleware to only let the owner of the account in the url through, must be used after withJWTAuth.
+// Accounts with one of the given roles can access any account, e.g. admins
+func withAccountOwner(next http.HandlerFunc, roles ...auth.Role) http.HandlerFunc {
+	return withPermission(next, permission{owner: true, roles: roles})
+}
+
+// withRole Middleware to only let accounts with one of the roles through, must be used after withJWTAuth
+func withRole(next http.HandlerFunc, roles ...auth.Role) http.HandlerFunc {
+	return withPermission(next, permission{roles: roles})
+}
+
+func hasRole(claims *auth.CustomJWTClaims, roles []auth.Role) bool {
+	for _, role := range roles {
+		if claims.EffectiveRole() == role {
+			return true
 		}
 	}
+	return false
 }
@@ -0,0 +1,217 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+	"github.com/nguyenanhhao221/go-jwt/internal/auth"
+)
+
+func TestTokenFromRequest(t *testing.T) {
+	t.Setenv("JWT_COOKIE_AUTH", "true")
+	tests := []struct {
+		name          string
+		headers       map[string]string
+		cookie        string
+		expectedToken string
+		expectError   bool
+	}{
+		{name: "Bearer", headers: map[string]string{"Authorization": "Bearer abc"}, expectedToken: "abc"},
+		{name: "BearerCaseInsensitive", headers: map[string]string{"Authorization": "bearer abc"}, expectedToken: "abc"},
+		{name: "LegacyHeader", headers: map[string]string{"x-jwt-token": "abc"}, expectedToken: "abc"},
+		{name: "Cookie", cookie: "abc", expectedToken: "abc"},
+		{name: "HeaderOverCookie", headers: map[string]string{"Authorization": "Bearer abc"}, cookie: "def", expectedToken: "abc"},
+		{name: "NoCredentials", expectedToken: ""},
+		{name: "BasicScheme", headers: map[string]string{"Authorization": "Basic abc"}, expectError: true},
+		{name: "EmptyBearer", headers: map[string]string{"Authorization": "Bearer "}, expectError: true},
+		{name: "MultipleMethods", headers: map[string]string{"Authorization": "Bearer abc", "x-jwt-token": "abc"}, expectError: true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			for key, value := range test.headers {
+				req.Header.Set(key, value)
+			}
+			if test.cookie != "" {
+				req.AddCookie(&http.Cookie{Name: accessTokenCookieName, Value: test.cookie})
+			}
+			token, err := tokenFromRequest(req)
+			if test.expectError != (err != nil) {
+				t.Fatalf("expected error %v but got %v", test.expectError, err)
+			}
+			if token != test.expectedToken {
+				t.Errorf("expected token %q but got %q", test.expectedToken, token)
+			}
+		})
+	}
+}
+
+func TestWithJWTAuthChallenge(t *testing.T) {
+	server := NewAPIServer("", nil)
+	handler := server.withJWTAuth(func(w http.ResponseWriter, r *http.Request) {
+		t.Errorf("expected the handler not to be called")
+	})
+
+	t.Run("MissingToken", func(t *testing.T) {
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/", nil))
+		if rr.Code != http.StatusUnauthorized {
+			t.Errorf("expected status code %d but got %d", http.StatusUnauthorized, rr.Code)
+		}
+		expectedChallenge := `Bearer realm="go-jwt"`
+		if challenge := rr.Header().Get("WWW-Authenticate"); challenge != expectedChallenge {
+			t.Errorf("expected challenge %s but got %s", expectedChallenge, challenge)
+		}
+	})
+
+	t.Run("InvalidToken", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.Header.Set("Authorization", "Bearer not-a-jwt")
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		if rr.Code != http.StatusUnauthorized {
+			t.Errorf("expected status code %d but got %d", http.StatusUnauthorized, rr.Code)
+		}
+		expectedChallenge := `Bearer realm="go-jwt", error="invalid_token", error_description="Invalid token"`
+		if challenge := rr.Header().Get("WWW-Authenticate"); challenge != expectedChallenge {
+			t.Errorf("expected challenge %s but got %s", expectedChallenge, challenge)
+		}
+	})
+}
+
+func TestWithJWTAuthPASETO(t *testing.T) {
+	key := make([]byte, auth.PASETOLocalKeySize)
+	if _, err := rand.Read(key); err != nil {
+		t.Fatal(err)
+	}
+	auth.SetTokenFormat(auth.NewPASETOLocalTokenFormat(key))
+	t.Cleanup(func() { auth.SetTokenFormat(nil) })
+	server := NewAPIServer("", nil)
+
+	accountId := uuid.New()
+	token, err := auth.CreateJWT(accountId, auth.RoleCustomer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(token, "v4.local.") {
+		t.Fatalf("expected a v4.local token but got %s", token)
+	}
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	rr := httptest.NewRecorder()
+	server.withJWTAuth(func(w http.ResponseWriter, r *http.Request) {
+		if claims, _ := claimsFromContext(r.Context()); claims.ID != accountId {
+			t.Errorf("expected the claims of account %v but got %+v", accountId, claims)
+		}
+		WriteJSON(w, http.StatusOK, nil)
+	}).ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Errorf("expected status code %d but got %d", http.StatusOK, rr.Code)
+	}
+}
+
+func TestRoleMiddleware(t *testing.T) {
+	ownerId := uuid.New()
+	tests := []struct {
+		name         string
+		claims       *auth.CustomJWTClaims
+		middleware   func(next http.HandlerFunc) http.HandlerFunc
+		expectedCode int
+	}{
+		{
+			name:         "AdminRoute",
+			claims:       &auth.CustomJWTClaims{ID: uuid.New(), Role: auth.RoleAdmin},
+			middleware:   func(next http.HandlerFunc) http.HandlerFunc { return withRole(next, auth.RoleAdmin) },
+			expectedCode: http.StatusOK,
+		},
+		{
+			name:         "AdminRouteAsCustomer",
+			claims:       &auth.CustomJWTClaims{ID: uuid.New(), Role: auth.RoleCustomer},
+			middleware:   func(next http.HandlerFunc) http.HandlerFunc { return withRole(next, auth.RoleAdmin) },
+			expectedCode: http.StatusForbidden,
+		},
+		{
+			name:         "AdminRouteWithoutRoleClaim",
+			claims:       &auth.CustomJWTClaims{ID: uuid.New()},
+			middleware:   func(next http.HandlerFunc) http.HandlerFunc { return withRole(next, auth.RoleAdmin) },
+			expectedCode: http.StatusForbidden,
+		},
+		{
+			name:         "Owner",
+			claims:       &auth.CustomJWTClaims{ID: ownerId, Role: auth.RoleCustomer},
+			middleware:   func(next http.HandlerFunc) http.HandlerFunc { return withAccountOwner(next, auth.RoleAdmin) },
+			expectedCode: http.StatusOK,
+		},
+		{
+			name:         "OtherCustomer",
+			claims:       &auth.CustomJWTClaims{ID: uuid.New(), Role: auth.RoleCustomer},
+			middleware:   func(next http.HandlerFunc) http.HandlerFunc { return withAccountOwner(next, auth.RoleAdmin) },
+			expectedCode: http.StatusForbidden,
+		},
+		{
+			name:         "AdminOnOtherAccount",
+			claims:       &auth.CustomJWTClaims{ID: uuid.New(), Role: auth.RoleAdmin},
+			middleware:   func(next http.HandlerFunc) http.HandlerFunc { return withAccountOwner(next, auth.RoleAdmin) },
+			expectedCode: http.StatusOK,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			rctx := chi.NewRouteContext()
+			rctx.URLParams.Add("accountId", ownerId.String())
+			ctx := context.WithValue(context.Background(), chi.RouteCtxKey, rctx)
+			ctx = context.WithValue(ctx, claimsContextKey, test.claims)
+			req := httptest.NewRequest(http.MethodGet, "/", nil).WithContext(ctx)
+
+			rr := httptest.NewRecorder()
+			test.middleware(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusOK)
+			}).ServeHTTP(rr, req)
+			if rr.Code != test.expectedCode {
+				t.Errorf("expected status code %d but got %d", test.expectedCode, rr.Code)
+			}
+		})
+	}
+}
+
+func TestPermissionMiddlewareScopes(t *testing.T) {
+	accountId := uuid.New()
+	machine := &auth.CustomJWTClaims{ClientID: "batch-job", Scope: auth.ScopeAccountsRead}
+	scopedAccount := &auth.CustomJWTClaims{ID: accountId, Role: auth.RoleCustomer, Scope: auth.ScopeAccountsRead}
+	tests := []struct {
+		name         string
+		claims       *auth.CustomJWTClaims
+		required     permission
+		expectedCode int
+	}{
+		{name: "MachineWithScope", claims: machine, required: permission{roles: []auth.Role{auth.RoleAdmin}, scope: auth.ScopeAccountsRead}, expectedCode: http.StatusOK},
+		{name: "MachineWithoutScope", claims: machine, required: permission{scope: auth.ScopeTransfersWrite}, expectedCode: http.StatusForbidden},
+		{name: "MachineOnRouteWithoutScope", claims: machine, required: permission{}, expectedCode: http.StatusForbidden},
+		{name: "ScopedAccountOwner", claims: scopedAccount, required: permission{owner: true, scope: auth.ScopeAccountsRead}, expectedCode: http.StatusOK},
+		{name: "ScopedAccountNotAdmin", claims: scopedAccount, required: permission{roles: []auth.Role{auth.RoleAdmin}, scope: auth.ScopeAccountsRead}, expectedCode: http.StatusForbidden},
+		{name: "AccountWithoutScopeClaim", claims: &auth.CustomJWTClaims{ID: accountId}, required: permission{scope: auth.ScopeTransfersWrite}, expectedCode: http.StatusOK},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			rctx := chi.NewRouteContext()
+			rctx.URLParams.Add("accountId", accountId.String())
+			ctx := context.WithValue(context.Background(), chi.RouteCtxKey, rctx)
+			ctx = context.WithValue(ctx, claimsContextKey, test.claims)
+			req := httptest.NewRequest(http.MethodGet, "/", nil).WithContext(ctx)
+
+			rr := httptest.NewRecorder()
+			withPermission(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusOK)
+			}, test.required).ServeHTTP(rr, req)
+			if rr.Code != test.expectedCode {
+				t.Errorf("expected status code %d but got %d", test.expectedCode, rr.Code)
+			}
+		})
+	}
+}
@@ -0,0 +1,440 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/nguyenanhhao221/go-jwt/internal/auth"
+)
+
+var errInvalidClient = errors.New("client authentication failed")
+
+// authenticateClient Authenticate the OAuth client calling the endpoint, with HTTP Basic
+// or with client_id and client_secret in the form body (RFC 6749 section 2.3.1).
+// Public clients have no secret and are only identified by their client_id, the endpoints check what they may do
+func (s *APIServer) authenticateClient(r *http.Request) (*OAuthClient, error) {
+	clientId, clientSecret, ok := r.BasicAuth()
+	if ok {
+		// The credentials are form encoded before being put in the Basic header
+		var err error
+		if clientId, err = url.QueryUnescape(clientId); err != nil {
+			return nil, errInvalidClient
+		}
+		if clientSecret, err = url.QueryUnescape(clientSecret); err != nil {
+			return nil, errInvalidClient
+		}
+	} else {
+		clientId = r.PostFormValue("client_id")
+		clientSecret = r.PostFormValue("client_secret")
+	}
+	if clientId == "" {
+		return nil, errInvalidClient
+	}
+
+	client, err := s.store.GetOAuthClient(clientId)
+	if err != nil {
+		log.Printf("Failed to get OAuth client %v: %v", clientId, err)
+		return nil, errInvalidClient
+	}
+	if client.Public {
+		if clientSecret != "" {
+			return nil, errInvalidClient
+		}
+		return client, nil
+	}
+	if clientSecret == "" || !auth.CheckClientSecret(clientSecret, client.ClientSecretHash) {
+		return nil, errInvalidClient
+	}
+	return client, nil
+}
+
+func writeInvalidClient(w http.ResponseWriter) {
+	w.Header().Set("WWW-Authenticate", fmt.Sprintf("Basic realm=%q", authRealm))
+	WriteOAuthError(w, http.StatusUnauthorized, "invalid_client", "Client authentication failed")
+}
+
+// IntrospectionResponse The response of the introspection endpoint (RFC 7662 section 2.2).
+// Only active is set for a token that is invalid, expired or revoked
+type IntrospectionResponse struct {
+	Active    bool   `json:"active"`
+	Scope     string `json:"scope,omitempty"`
+	ClientID  string `json:"client_id,omitempty"`
+	TokenType string `json:"token_type,omitempty"`
+	Exp       int64  `json:"exp,omitempty"`
+	Iat       int64  `json:"iat,omitempty"`
+	Nbf       int64  `json:"nbf,omitempty"`
+	Sub       string `json:"sub,omitempty"`
+	Aud       string `json:"aud,omitempty"`
+	Iss       string `json:"iss,omitempty"`
+	Jti       string `json:"jti,omitempty"`
+	// Cnf The DPoP key an access token is bound to (RFC 9449 section 6.2)
+	Cnf  *auth.Confirmation `json:"cnf,omitempty"`
+	Role string             `json:"role,omitempty"`
+}
+
+// handleIntrospect Tell an authenticated client if a token is active and what it carries,
+// for services that can't validate our JWT themselves
+func (s *APIServer) handleIntrospect(w http.ResponseWriter, r *http.Request) {
+	client, err := s.authenticateClient(r)
+	// Public clients can't prove who they are, so they can't look at tokens
+	if err != nil || client.Public {
+		writeInvalidClient(w)
+		return
+	}
+	token := r.PostFormValue("token")
+	if token == "" {
+		WriteOAuthError(w, http.StatusBadRequest, "invalid_request", "Missing token")
+		return
+	}
+	log.Printf("Token introspection by client %v", client.ID)
+
+	// The hint only changes which token type is looked up first
+	var introspection IntrospectionResponse
+	if r.PostFormValue("token_type_hint") == "refresh_token" {
+		if introspection = s.introspectRefreshToken(token); !introspection.Active {
+			introspection = s.introspectAccessToken(token)
+		}
+	} else {
+		if introspection = s.introspectAccessToken(token); !introspection.Active {
+			introspection = s.introspectRefreshToken(token)
+		}
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	WriteJSON(w, http.StatusOK, introspection)
+}
+
+func (s *APIServer) introspectAccessToken(tokenString string) IntrospectionResponse {
+	claims, err := auth.ValidateAccessToken(tokenString)
+	if err != nil || s.revocations.IsRevoked(claims) {
+		return IntrospectionResponse{Active: false}
+	}
+	// Machine principals have no account, so no role
+	role := ""
+	if !claims.IsMachine() {
+		role = string(claims.EffectiveRole())
+	}
+	return IntrospectionResponse{
+		Active:    true,
+		TokenType: tokenType(claims),
+		Cnf:       claims.Confirmation,
+		Scope:     claims.Scope,
+		ClientID:  claims.ClientID,
+		Exp:       claims.ExpiresAt,
+		Iat:       claims.IssuedAt,
+		Nbf:       claims.NotBefore,
+		Sub:       claims.Subject,
+		Aud:       claims.Audience,
+		Iss:       claims.Issuer,
+		Jti:       claims.Id,
+		Role:      role,
+	}
+}
+
+func (s *APIServer) introspectRefreshToken(token string) IntrospectionResponse {
+	storedToken, err := s.store.GetRefreshTokenByHash(auth.HashRefreshToken(token))
+	if err != nil || storedToken.UsedAt != nil || storedToken.RevokedAt != nil || time.Now().UTC().After(storedToken.ExpiresAt) {
+		return IntrospectionResponse{Active: false}
+	}
+	return IntrospectionResponse{
+		Active:    true,
+		TokenType: "refresh_token",
+		Exp:       storedToken.ExpiresAt.Unix(),
+		Iat:       storedToken.CreatedAt.Unix(),
+		Sub:       storedToken.AccountID.String(),
+	}
+}
+
+// handleToken The OAuth token endpoint, supporting the grants:
+//   - authorization_code (RFC 6749 section 4.1) with PKCE, to exchange the code from the authorization endpoint
+//   - refresh_token (RFC 6749 section 6), to refresh the tokens issued to the client
+//   - client_credentials (RFC 6749 section 4.4), a batch job authenticates as its client
+//     and gets an access token limited to the scopes it was allowed
+func (s *APIServer) handleToken(w http.ResponseWriter, r *http.Request) {
+	client, err := s.authenticateClient(r)
+	if err != nil {
+		writeInvalidClient(w)
+		return
+	}
+
+	switch grantType := r.PostFormValue("grant_type"); grantType {
+	case "authorization_code":
+		s.handleAuthorizationCodeGrant(w, r, client)
+	case "refresh_token":
+		s.handleRefreshTokenGrant(w, r, client)
+	case "client_credentials":
+		// A public client has no secret, anyone knowing its client_id could act as it
+		if client.Public {
+			WriteOAuthError(w, http.StatusBadRequest, "unauthorized_client", "Public clients can't use the client credentials grant")
+			return
+		}
+		s.handleClientCredentialsGrant(w, r, client)
+	case "":
+		WriteOAuthError(w, http.StatusBadRequest, "invalid_request", "Missing grant_type")
+	default:
+		WriteOAuthError(w, http.StatusBadRequest, "unsupported_grant_type", fmt.Sprintf("Unsupported grant_type %q", grantType))
+	}
+}
+
+// OAuthTokenResponse The successful response of the token endpoint (RFC 6749 section 5.1)
+type OAuthTokenResponse struct {
+	AccessToken  string `json:"access_token"`
+	TokenType    string `json:"token_type"`
+	ExpiresIn    int64  `json:"expires_in"`
+	RefreshToken string `json:"refresh_token,omitempty"`
+	Scope        string `json:"scope,omitempty"`
+	// IDToken The OpenID Connect ID token, when the openid scope was granted with the authorization code flow
+	IDToken string `json:"id_token,omitempty"`
+}
+
+func (s *APIServer) handleClientCredentialsGrant(w http.ResponseWriter, r *http.Request, client *OAuthClient) {
+	requestedScopes, err := client.requestedScopes(r.PostFormValue("scope"))
+	if err != nil {
+		WriteOAuthError(w, http.StatusBadRequest, "invalid_scope", err.Error())
+		return
+	}
+
+	dpopJKT := dpopJKTFromContext(r.Context())
+	accessToken, err := auth.CreateClientJWT(client.ID, requestedScopes, dpopJKT)
+	if err != nil {
+		WriteOAuthError(w, http.StatusInternalServerError, "server_error", "Failed to create the access token")
+		return
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	WriteJSON(w, http.StatusOK, OAuthTokenResponse{
+		AccessToken: accessToken,
+		TokenType:   tokenType(&auth.CustomJWTClaims{Confirmation: auth.NewConfirmation(dpopJKT)}),
+		ExpiresIn:   int64(auth.AccessTokenTTL().Seconds()),
+		Scope:       auth.FormatScopes(requestedScopes),
+	})
+}
+
+// handleAuthorizationCodeGrant Exchange an authorization code for tokens of the account which approved the client.
+// The code can only be used once, by the client it was issued to, with the same redirect_uri
+// and the code_verifier matching the challenge sent to the authorization endpoint
+func (s *APIServer) handleAuthorizationCodeGrant(w http.ResponseWriter, r *http.Request, client *OAuthClient) {
+	code := r.PostFormValue("code")
+	if code == "" {
+		WriteOAuthError(w, http.StatusBadRequest, "invalid_request", "Missing code")
+		return
+	}
+	// The refresh tokens issued for the code start a new family, linked to the code so they are revoked if the code is reused
+	familyId := uuid.New()
+	authorizationCode, err := s.store.ConsumeAuthorizationCode(auth.HashAuthorizationCode(code), familyId)
+	if errors.Is(err, ErrAuthorizationCodeReused) {
+		log.Printf("Authorization code reuse detected for client %v and account %v, revoking the issued tokens", authorizationCode.ClientID, authorizationCode.AccountID)
+		WriteOAuthError(w, http.StatusBadRequest, "invalid_grant", "Invalid authorization code")
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to consume authorization code %v", err)
+		WriteOAuthError(w, http.StatusBadRequest, "invalid_grant", "Invalid authorization code")
+		return
+	}
+	if authorizationCode.ClientID != client.ID ||
+		authorizationCode.RedirectURI != r.PostFormValue("redirect_uri") ||
+		time.Now().UTC().After(authorizationCode.ExpiresAt) {
+		WriteOAuthError(w, http.StatusBadRequest, "invalid_grant", "Invalid authorization code")
+		return
+	}
+	if err := auth.VerifyPKCE(r.PostFormValue("code_verifier"), authorizationCode.CodeChallenge); err != nil {
+		WriteOAuthError(w, http.StatusBadRequest, "invalid_grant", "Invalid code_verifier")
+		return
+	}
+
+	account, err := s.store.GetAccountById(authorizationCode.AccountID)
+	if err != nil {
+		WriteOAuthError(w, http.StatusBadRequest, "invalid_grant", "Invalid authorization code")
+		return
+	}
+	scopes := strings.Fields(authorizationCode.Scope)
+	tokenRes, err := s.issueTokens(tokenGrant{
+		accountId: account.ID,
+		role:      account.Role,
+		clientId:  client.ID,
+		scopes:    scopes,
+		familyId:  familyId,
+		authTime:  authorizationCode.CreatedAt,
+		amr:       authorizationCode.AMR,
+		dpopJKT:   dpopJKTFromContext(r.Context()),
+	}, nil)
+	if err != nil {
+		log.Printf("Failed to issue tokens for authorization code %v", err)
+		WriteOAuthError(w, http.StatusInternalServerError, "server_error", "Failed to create the tokens")
+		return
+	}
+	idToken := ""
+	if containsString(scopes, auth.ScopeOpenID) {
+		userInfo := newUserInfo(account, func(scope string) bool { return containsString(scopes, scope) })
+		idToken, err = auth.CreateIDToken(account.ID.String(), client.ID, authorizationCode.Nonce, authorizationCode.CreatedAt, userInfo)
+		if err != nil {
+			log.Printf("Failed to create the ID token %v", err)
+			WriteOAuthError(w, http.StatusInternalServerError, "server_error", "Failed to create the tokens")
+			return
+		}
+	}
+	writeOAuthTokenResponse(w, tokenRes, idToken)
+}
+
+// handleRefreshTokenGrant Rotate a refresh token issued to the client, like handleRefreshToken does for signed in accounts.
+// The new tokens keep the scopes of the refresh token
+func (s *APIServer) handleRefreshTokenGrant(w http.ResponseWriter, r *http.Request, client *OAuthClient) {
+	refreshToken := r.PostFormValue("refresh_token")
+	if refreshToken == "" {
+		WriteOAuthError(w, http.StatusBadRequest, "invalid_request", "Missing refresh_token")
+		return
+	}
+	storedToken, err := s.store.GetRefreshTokenByHash(auth.HashRefreshToken(refreshToken))
+	if err != nil || storedToken.ClientID != client.ID {
+		WriteOAuthError(w, http.StatusBadRequest, "invalid_grant", "Invalid refresh token")
+		return
+	}
+	if storedToken.UsedAt != nil || storedToken.RevokedAt != nil {
+		s.revokeReusedOAuthRefreshToken(w, storedToken)
+		return
+	}
+	if time.Now().UTC().After(storedToken.ExpiresAt) {
+		WriteOAuthError(w, http.StatusBadRequest, "invalid_grant", "Refresh token expired")
+		return
+	}
+	// Like handleRefreshToken, a bound refresh token needs a proof of its key
+	dpopJKT := dpopJKTFromContext(r.Context())
+	if storedToken.DPoPJKT != "" && storedToken.DPoPJKT != dpopJKT {
+		WriteOAuthError(w, http.StatusBadRequest, "invalid_dpop_proof", "The refresh token is bound to another DPoP key")
+		return
+	}
+
+	account, err := s.store.GetAccountById(storedToken.AccountID)
+	if err != nil {
+		WriteOAuthError(w, http.StatusBadRequest, "invalid_grant", "Invalid refresh token")
+		return
+	}
+	tokenRes, err := s.issueTokens(tokenGrant{
+		accountId: account.ID,
+		role:      account.Role,
+		clientId:  client.ID,
+		scopes:    strings.Fields(storedToken.Scope),
+		authTime:  storedToken.authTime(),
+		amr:       storedToken.AMR,
+		dpopJKT:   dpopJKT,
+	}, storedToken)
+	if errors.Is(err, ErrRefreshTokenReused) {
+		s.revokeReusedOAuthRefreshToken(w, storedToken)
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to refresh tokens %v", err)
+		WriteOAuthError(w, http.StatusInternalServerError, "server_error", "Failed to create the tokens")
+		return
+	}
+	writeOAuthTokenResponse(w, tokenRes, "")
+}
+
+func (s *APIServer) revokeReusedOAuthRefreshToken(w http.ResponseWriter, reusedToken *RefreshToken) {
+	log.Printf("Refresh token reuse detected for client %v and account %v, revoking token family %v", reusedToken.ClientID, reusedToken.AccountID, reusedToken.FamilyID)
+	if err := s.store.RevokeRefreshTokenFamily(reusedToken.FamilyID); err != nil {
+		log.Printf("Failed to revoke refresh token family %v", err)
+	}
+	WriteOAuthError(w, http.StatusBadRequest, "invalid_grant", "Invalid refresh token")
+}
+
+func writeOAuthTokenResponse(w http.ResponseWriter, tokenRes *TokenResponse, idToken string) {
+	w.Header().Set("Cache-Control", "no-store")
+	WriteJSON(w, http.StatusOK, OAuthTokenResponse{
+		AccessToken:  tokenRes.Token,
+		TokenType:    tokenRes.TokenType,
+		ExpiresIn:    tokenRes.ExpiresIn,
+		RefreshToken: tokenRes.RefreshToken,
+		Scope:        tokenRes.Scope,
+		IDToken:      idToken,
+	})
+}
+
+// requestedScopes Parse the scope parameter of a request from the client and check the client is allowed every scope.
+// Without a scope parameter the client gets every scope it is allowed
+func (c *OAuthClient) requestedScopes(scope string) ([]string, error) {
+	requestedScopes, err := auth.ParseScopes(scope)
+	if err != nil {
+		return nil, err
+	}
+	if len(requestedScopes) == 0 {
+		return c.AllowedScopes, nil
+	}
+	for _, scope := range requestedScopes {
+		if !containsString(c.AllowedScopes, scope) {
+			return nil, fmt.Errorf("scope %q is not allowed for this client", scope)
+		}
+	}
+	return requestedScopes, nil
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// runClientsCommand Register OAuth clients from the command line:
+//
+//	go-jwt clients create [-scopes "accounts:read transfers:write"] [-redirect-uris "https://app/callback"] [-public] <name>
+//
+// The client secret is only printed once, only its hash is stored. Public clients have no secret
+func runClientsCommand(store Storage, args []string) error {
+	usage := `usage: clients create [-scopes "accounts:read transfers:write"] [-redirect-uris "https://app/callback"] [-public] <name>`
+	if len(args) == 0 || args[0] != "create" {
+		return errors.New(usage)
+	}
+	flags := flag.NewFlagSet("clients create", flag.ContinueOnError)
+	scope := flags.String("scopes", "", "space separated scopes the client can request")
+	redirectURIs := flags.String("redirect-uris", "", "space separated redirect URIs for the authorization code flow")
+	public := flags.Bool("public", false, "register a public client without secret, e.g. a mobile app or SPA")
+	if err := flags.Parse(args[1:]); err != nil {
+		return err
+	}
+	if flags.NArg() != 1 {
+		return errors.New("the name of the client is required")
+	}
+	allowedScopes, err := auth.ParseScopes(*scope)
+	if err != nil {
+		return err
+	}
+	for _, redirectURI := range strings.Fields(*redirectURIs) {
+		if err := validateRedirectURI(redirectURI); err != nil {
+			return err
+		}
+	}
+	if *public && *redirectURIs == "" {
+		return errors.New("a public client needs at least one redirect URI")
+	}
+	client := &OAuthClient{
+		ID:            uuid.NewString(),
+		Name:          flags.Arg(0),
+		AllowedScopes: allowedScopes,
+		RedirectURIs:  strings.Fields(*redirectURIs),
+		Public:        *public,
+		CreatedAt:     time.Now().UTC(),
+	}
+	secret := ""
+	if !client.Public {
+		if secret, client.ClientSecretHash, err = auth.NewClientSecret(); err != nil {
+			return err
+		}
+	}
+	if err := store.CreateOAuthClient(client); err != nil {
+		return err
+	}
+	fmt.Printf("client_id: %s\n", client.ID)
+	if secret != "" {
+		fmt.Printf("client_secret: %s\n", secret)
+	}
+	return nil
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/nguyenanhhao221/go-jwt/internal/auth"
+	"github.com/nguyenanhhao221/go-jwt/settings"
+)
+
+// createTestClient Register an OAuth client and return it with its plain secret
+func createTestClient(t *testing.T, store Storage, allowedScopes ...string) (*OAuthClient, string) {
+	t.Helper()
+	secret, secretHash, err := auth.NewClientSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := &OAuthClient{
+		ID:               uuid.NewString(),
+		Name:             "Test Client",
+		ClientSecretHash: secretHash,
+		AllowedScopes:    allowedScopes,
+		CreatedAt:        time.Now().UTC(),
+	}
+	if err := store.CreateOAuthClient(client); err != nil {
+		t.Fatal(err)
+	}
+	return client, secret
+}
+
+func TestIntrospectCI(t *testing.T) {
+	store, err := NewPostgresStore()
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := NewAPIServer("", store)
+	client, clientSecret := createTestClient(t, store)
+
+	accountId, err := store.CreateAccount(NewAccount("Introspect First Name", "Introspect Last Name", "Introspect@email.com", "TestPassword"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.DeleteAccountById(accountId)
+	tokenRes, err := server.issueTokens(tokenGrant{accountId: accountId, role: auth.RoleCustomer}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	introspect := func(token, clientSecret string) (*httptest.ResponseRecorder, IntrospectionResponse) {
+		form := url.Values{"token": {token}}
+		req, err := http.NewRequest(http.MethodPost, settings.AppSettings.Introspect_Route, strings.NewReader(form.Encode()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req.SetBasicAuth(client.ID, clientSecret)
+		rr := httptest.NewRecorder()
+		http.HandlerFunc(server.handleIntrospect).ServeHTTP(rr, req)
+
+		var introspection IntrospectionResponse
+		if rr.Code == http.StatusOK {
+			if err := json.Unmarshal(rr.Body.Bytes(), &introspection); err != nil {
+				t.Fatalf("failed to unmarshal response body %v", err)
+			}
+		}
+		return rr, introspection
+	}
+
+	t.Run("ActiveAccessToken", func(t *testing.T) {
+		rr, introspection := introspect(tokenRes.Token, clientSecret)
+		if rr.Code != http.StatusOK {
+			t.Fatalf("expected status code %d but got %d", http.StatusOK, rr.Code)
+		}
+		if !introspection.Active || introspection.Sub != accountId.String() {
+			t.Errorf("expected an active token for %v but got %v", accountId, introspection)
+		}
+	})
+
+	t.Run("ActiveRefreshToken", func(t *testing.T) {
+		_, introspection := introspect(tokenRes.RefreshToken, clientSecret)
+		if !introspection.Active || introspection.TokenType != "refresh_token" {
+			t.Errorf("expected an active refresh token but got %v", introspection)
+		}
+	})
+
+	t.Run("InvalidClient", func(t *testing.T) {
+		if rr, _ := introspect(tokenRes.Token, "wrong-secret"); rr.Code != http.StatusUnauthorized {
+			t.Errorf("expected status code %d but got %d", http.StatusUnauthorized, rr.Code)
+		}
+	})
+
+	t.Run("RevokedAccessToken", func(t *testing.T) {
+		if err := server.revocations.RevokeAccount(accountId); err != nil {
+			t.Fatal(err)
+		}
+		_, introspection := introspect(tokenRes.Token, clientSecret)
+		if introspection.Active {
+			t.Errorf("expected revoked token to be inactive")
+		}
+	})
+}
+
+func TestClientCredentialsCI(t *testing.T) {
+	store, err := NewPostgresStore()
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := NewAPIServer("", store)
+	client, clientSecret := createTestClient(t, store, auth.ScopeAccountsRead)
+
+	requestToken := func(form url.Values) *httptest.ResponseRecorder {
+		req, err := http.NewRequest(http.MethodPost, settings.AppSettings.Token_Route, strings.NewReader(form.Encode()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req.SetBasicAuth(client.ID, clientSecret)
+		rr := httptest.NewRecorder()
+		http.HandlerFunc(server.handleToken).ServeHTTP(rr, req)
+		return rr
+	}
+
+	t.Run("IssueScopedToken", func(t *testing.T) {
+		rr := requestToken(url.Values{"grant_type": {"client_credentials"}})
+		if rr.Code != http.StatusOK {
+			t.Fatalf("expected status code %d but got %d: %s", http.StatusOK, rr.Code, rr.Body.String())
+		}
+		var tokenRes OAuthTokenResponse
+		if err := json.Unmarshal(rr.Body.Bytes(), &tokenRes); err != nil {
+			t.Fatal(err)
+		}
+		token, err := auth.ValidateJWT(tokenRes.AccessToken)
+		if err != nil {
+			t.Fatal(err)
+		}
+		claims := token.Claims.(*auth.CustomJWTClaims)
+		if !claims.IsMachine() || claims.ClientID != client.ID || !claims.HasScope(auth.ScopeAccountsRead) {
+			t.Errorf("expected a machine token for client %v with scope %v but got %v", client.ID, auth.ScopeAccountsRead, claims)
+		}
+	})
+
+	t.Run("ScopeNotAllowed", func(t *testing.T) {
+		rr := requestToken(url.Values{"grant_type": {"client_credentials"}, "scope": {auth.ScopeTransfersWrite}})
+		if rr.Code != http.StatusBadRequest {
+			t.Errorf("expected status code %d but got %d", http.StatusBadRequest, rr.Code)
+		}
+	})
+
+	t.Run("UnsupportedGrant", func(t *testing.T) {
+		rr := requestToken(url.Values{"grant_type": {"password"}})
+		if rr.Code != http.StatusBadRequest {
+			t.Errorf("expected status code %d but got %d", http.StatusBadRequest, rr.Code)
+		}
+	})
+}
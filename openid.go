@@ -0,0 +1,143 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/nguyenanhhao221/go-jwt/internal/auth"
+	"github.com/nguyenanhhao221/go-jwt/settings"
+)
+
+// errOpenIDIssuerNotURL OpenID Connect is turned off until JWT_ISSUER is the public URL of the server, the default issuer isn't one
+var errOpenIDIssuerNotURL = errors.New("OpenID Connect needs JWT_ISSUER to be the public URL of the server")
+
+// OpenIDConfiguration The OpenID provider metadata (OpenID Connect Discovery section 3),
+// lets OIDC libraries find our endpoints and what they support
+type OpenIDConfiguration struct {
+	Issuer                            string   `json:"issuer"`
+	AuthorizationEndpoint             string   `json:"authorization_endpoint"`
+	TokenEndpoint                     string   `json:"token_endpoint"`
+	UserInfoEndpoint                  string   `json:"userinfo_endpoint"`
+	JWKSURI                           string   `json:"jwks_uri"`
+	IntrospectionEndpoint             string   `json:"introspection_endpoint"`
+	ScopesSupported                   []string `json:"scopes_supported"`
+	ResponseTypesSupported            []string `json:"response_types_supported"`
+	GrantTypesSupported               []string `json:"grant_types_supported"`
+	SubjectTypesSupported             []string `json:"subject_types_supported"`
+	IDTokenSigningAlgValuesSupported  []string `json:"id_token_signing_alg_values_supported"`
+	TokenEndpointAuthMethodsSupported []string `json:"token_endpoint_auth_methods_supported"`
+	CodeChallengeMethodsSupported     []string `json:"code_challenge_methods_supported"`
+	ClaimsSupported                   []string `json:"claims_supported"`
+	DPoPSigningAlgValuesSupported     []string `json:"dpop_signing_alg_values_supported"`
+}
+
+// issuerURL The issuer when JWT_ISSUER is set to the public URL of the server, false otherwise.
+// OpenID Connect requires the issuer to be that URL, the discovery document and ID tokens are only served then
+func issuerURL() (string, bool) {
+	issuer, err := url.Parse(auth.Issuer())
+	if err != nil || (issuer.Scheme != "https" && issuer.Scheme != "http") || issuer.Host == "" {
+		return "", false
+	}
+	return strings.TrimSuffix(issuer.String(), "/"), true
+}
+
+// trustProxyHeaders Report if the X-Forwarded-Proto header can be trusted, configured with TRUST_PROXY_HEADERS.
+// Only set it when a reverse proxy in front of the server overwrites the header, otherwise a client could pick
+// the scheme of the URLs built from its request
+func trustProxyHeaders() bool {
+	trust, _ := strconv.ParseBool(os.Getenv("TRUST_PROXY_HEADERS"))
+	return trust
+}
+
+// publicBaseURL The URL the endpoints are reached at, the issuer when it is a URL, see issuerURL.
+// Otherwise the URL is guessed from the request, its scheme from X-Forwarded-Proto only when trustProxyHeaders
+func publicBaseURL(r *http.Request) string {
+	if issuer, ok := issuerURL(); ok {
+		return issuer
+	}
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	} else if forwardedProto := r.Header.Get("X-Forwarded-Proto"); trustProxyHeaders() && (forwardedProto == "https" || forwardedProto == "http") {
+		scheme = forwardedProto
+	}
+	return scheme + "://" + r.Host
+}
+
+// handleOpenIDConfiguration Serve the discovery document at /.well-known/openid-configuration
+func (s *APIServer) handleOpenIDConfiguration(w http.ResponseWriter, r *http.Request) {
+	keySet, err := auth.CurrentKeySet()
+	if err != nil {
+		WriteErrorJson(w, http.StatusInternalServerError, "Failed to load the signing keys")
+		return
+	}
+	if _, ok := issuerURL(); !ok {
+		WriteErrorJson(w, http.StatusNotFound, errOpenIDIssuerNotURL.Error())
+		return
+	}
+	baseURL := publicBaseURL(r)
+	WriteJSON(w, http.StatusOK, OpenIDConfiguration{
+		Issuer:                            auth.Issuer(),
+		AuthorizationEndpoint:             baseURL + settings.AppSettings.Authorize_Route,
+		TokenEndpoint:                     baseURL + settings.AppSettings.Token_Route,
+		UserInfoEndpoint:                  baseURL + settings.AppSettings.UserInfo_Route,
+		JWKSURI:                           baseURL + settings.AppSettings.JWKS_Route,
+		IntrospectionEndpoint:             baseURL + settings.AppSettings.Introspect_Route,
+		ScopesSupported:                   auth.SupportedScopes(),
+		ResponseTypesSupported:            []string{"code"},
+		GrantTypesSupported:               []string{"authorization_code", "refresh_token", "client_credentials"},
+		SubjectTypesSupported:             []string{"public"},
+		IDTokenSigningAlgValuesSupported:  []string{keySet.SigningKey().Method.Alg()},
+		TokenEndpointAuthMethodsSupported: []string{"client_secret_basic", "client_secret_post", "none"},
+		CodeChallengeMethodsSupported:     []string{auth.PKCEMethodS256},
+		ClaimsSupported:                   []string{"sub", "iss", "aud", "exp", "iat", "auth_time", "nonce", "name", "given_name", "family_name", "email"},
+		DPoPSigningAlgValuesSupported:     auth.DPoPSigningAlgs(),
+	})
+}
+
+// newUserInfo The claims about the account the scopes allow, profile gives the names and email the email
+func newUserInfo(account *AccountResponse, hasScope func(scope string) bool) auth.UserInfo {
+	var userInfo auth.UserInfo
+	if hasScope(auth.ScopeProfile) {
+		userInfo.Name = strings.TrimSpace(account.FirstName + " " + account.LastName)
+		userInfo.GivenName = account.FirstName
+		userInfo.FamilyName = account.LastName
+	}
+	if hasScope(auth.ScopeEmail) {
+		userInfo.Email = account.Email
+	}
+	return userInfo
+}
+
+// UserInfoResponse The response of the userinfo endpoint (OpenID Connect Core section 5.3.2)
+type UserInfoResponse struct {
+	Sub string `json:"sub"`
+	auth.UserInfo
+}
+
+// handleUserInfo Return the claims about the account of the access token.
+// Tokens issued to a client need the openid scope, and only get the claims of the scopes they were granted
+func (s *APIServer) handleUserInfo(w http.ResponseWriter, r *http.Request) {
+	claims, ok := claimsFromContext(r.Context())
+	// Machine principals have no account to describe
+	if !ok || claims.IsMachine() {
+		writeAuthChallenge(w, http.StatusForbidden, "insufficient_scope", "Permission Denied")
+		return
+	}
+	account, err := s.store.GetAccountById(claims.ID)
+	if err != nil {
+		writeAuthChallenge(w, http.StatusUnauthorized, "invalid_token", "Invalid token")
+		return
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	WriteJSON(w, http.StatusOK, UserInfoResponse{
+		Sub: account.ID.String(),
+		UserInfo: newUserInfo(account, func(scope string) bool {
+			return !claims.IsScoped() || claims.HasScope(scope)
+		}),
+	})
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/nguyenanhhao221/go-jwt/internal/auth"
+	"github.com/nguyenanhhao221/go-jwt/settings"
+)
+
+func TestOpenIDConfiguration(t *testing.T) {
+	auth.SetKeySet(auth.NewKeySet(auth.NewHMACSigningKey("test", []byte("test-secret"))))
+	t.Cleanup(func() { auth.SetKeySet(nil) })
+	t.Setenv("JWT_ISSUER", "https://auth.example.com/")
+	server := &APIServer{}
+
+	req, err := http.NewRequest(http.MethodGet, settings.AppSettings.OpenID_Configuration_Route, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rr := httptest.NewRecorder()
+	http.HandlerFunc(server.handleOpenIDConfiguration).ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected status code %d but got %d", http.StatusOK, rr.Code)
+	}
+	var configuration OpenIDConfiguration
+	if err := json.Unmarshal(rr.Body.Bytes(), &configuration); err != nil {
+		t.Fatal(err)
+	}
+	if configuration.Issuer != "https://auth.example.com/" {
+		t.Errorf("expected the issuer to be JWT_ISSUER but got %v", configuration.Issuer)
+	}
+	if configuration.JWKSURI != "https://auth.example.com"+settings.AppSettings.JWKS_Route {
+		t.Errorf("expected the jwks_uri to be under the issuer but got %v", configuration.JWKSURI)
+	}
+	if !containsString(configuration.ScopesSupported, auth.ScopeOpenID) {
+		t.Errorf("expected the openid scope to be supported but got %v", configuration.ScopesSupported)
+	}
+}
+
+func TestOpenIDConfigurationWithoutIssuerURL(t *testing.T) {
+	auth.SetKeySet(auth.NewKeySet(auth.NewHMACSigningKey("test", []byte("test-secret"))))
+	t.Cleanup(func() { auth.SetKeySet(nil) })
+	t.Setenv("JWT_ISSUER", "")
+	server := &APIServer{}
+
+	req := httptest.NewRequest(http.MethodGet, settings.AppSettings.OpenID_Configuration_Route, nil)
+	rr := httptest.NewRecorder()
+	http.HandlerFunc(server.handleOpenIDConfiguration).ServeHTTP(rr, req)
+	if rr.Code != http.StatusNotFound {
+		t.Errorf("expected OpenID Connect to be off with the default issuer but got %d", rr.Code)
+	}
+}
+
+func TestPublicBaseURL(t *testing.T) {
+	t.Setenv("JWT_ISSUER", "")
+	tests := []struct {
+		name          string
+		trustProxy    string
+		forwardedFrom string
+		expected      string
+	}{
+		{name: "NoProxy", expected: "http://auth.example.com"},
+		{name: "UntrustedProxy", forwardedFrom: "https", expected: "http://auth.example.com"},
+		{name: "TrustedProxy", trustProxy: "true", forwardedFrom: "https", expected: "https://auth.example.com"},
+		{name: "TrustedProxyInvalidScheme", trustProxy: "true", forwardedFrom: "javascript", expected: "http://auth.example.com"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			t.Setenv("TRUST_PROXY_HEADERS", test.trustProxy)
+			req := httptest.NewRequest(http.MethodGet, "http://auth.example.com/", nil)
+			if test.forwardedFrom != "" {
+				req.Header.Set("X-Forwarded-Proto", test.forwardedFrom)
+			}
+			if baseURL := publicBaseURL(req); baseURL != test.expected {
+				t.Errorf("expected %s but got %s", test.expected, baseURL)
+			}
+		})
+	}
+	t.Setenv("JWT_ISSUER", "https://auth.example.com/")
+	if baseURL := publicBaseURL(httptest.NewRequest(http.MethodGet, "http://internal:8080/", nil)); baseURL != "https://auth.example.com" {
+		t.Errorf("expected the issuer URL but got %s", baseURL)
+	}
+}
+
+func TestUserInfoCI(t *testing.T) {
+	store, err := NewPostgresStore()
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := NewAPIServer("", store)
+	mockUser := NewAccount("UserInfo First Name", "UserInfo Last Name", "UserInfo@email.com", "TestPassword")
+	accountId, err := store.CreateAccount(mockUser)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.DeleteAccountById(accountId)
+
+	userInfo := func(grant tokenGrant) (*httptest.ResponseRecorder, UserInfoResponse) {
+		tokenRes, err := server.issueTokens(grant, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req, err := http.NewRequest(http.MethodGet, settings.AppSettings.UserInfo_Route, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("Authorization", "Bearer "+tokenRes.Token)
+		rr := httptest.NewRecorder()
+		server.withJWTAuth(withPermission(server.handleUserInfo, permission{scope: auth.ScopeOpenID})).ServeHTTP(rr, req)
+
+		var userInfo UserInfoResponse
+		if rr.Code == http.StatusOK {
+			if err := json.Unmarshal(rr.Body.Bytes(), &userInfo); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return rr, userInfo
+	}
+
+	t.Run("SignedInAccount", func(t *testing.T) {
+		rr, userInfo := userInfo(tokenGrant{accountId: accountId, role: auth.RoleCustomer})
+		if rr.Code != http.StatusOK {
+			t.Fatalf("expected status code %d but got %d", http.StatusOK, rr.Code)
+		}
+		if userInfo.Sub != accountId.String() || userInfo.Email != mockUser.Email || userInfo.GivenName != mockUser.FirstName {
+			t.Errorf("expected every claim of the account but got %v", userInfo)
+		}
+	})
+
+	t.Run("ClientScopes", func(t *testing.T) {
+		rr, userInfo := userInfo(tokenGrant{accountId: accountId, role: auth.RoleCustomer, clientId: "client", scopes: []string{auth.ScopeOpenID, auth.ScopeEmail}})
+		if rr.Code != http.StatusOK {
+			t.Fatalf("expected status code %d but got %d", http.StatusOK, rr.Code)
+		}
+		if userInfo.Email != mockUser.Email || userInfo.Name != "" {
+			t.Errorf("expected only the email claim but got %v", userInfo)
+		}
+	})
+
+	t.Run("MissingOpenIDScope", func(t *testing.T) {
+		rr, _ := userInfo(tokenGrant{accountId: accountId, role: auth.RoleCustomer, clientId: "client", scopes: []string{auth.ScopeAccountsRead}})
+		if rr.Code != http.StatusForbidden {
+			t.Errorf("expected status code %d but got %d", http.StatusForbidden, rr.Code)
+		}
+	})
+}
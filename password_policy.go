@@ -0,0 +1,11 @@
+package main
+
+// checkPasswordPolicy The rules of the password policy a new password breaks, as validation errors of the Password field.
+// personalInfo are the email and names of the account
+func (s *APIServer) checkPasswordPolicy(newPassword string, personalInfo ...string) []*IError {
+	var errors []*IError
+	for _, violation := range s.passwordPolicy.Check(newPassword, personalInfo...) {
+		errors = append(errors, &IError{Field: "Password", Tag: violation.Tag, Value: violation.Param})
+	}
+	return errors
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/nguyenanhhao221/go-jwt/internal/password"
+	"github.com/nguyenanhhao221/go-jwt/settings"
+)
+
+func TestCreateAccountPasswordPolicy(t *testing.T) {
+	// The request is refused before the store is used
+	server := &APIServer{passwordPolicy: password.Policy{MinLength: 8, MaxBytes: 72, MinCharacterClasses: 2}}
+	reqBodyJSON, err := json.Marshal(&CreateAccountRequest{
+		FirstName: "Policy",
+		LastName:  "Tester",
+		Email:     "policy.tester@email.com",
+		Password:  "tester",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	req, err := http.NewRequest(http.MethodPost, settings.AppSettings.Create_Account_Route, bytes.NewBuffer(reqBodyJSON))
+	if err != nil {
+		t.Fatal(err)
+	}
+	rr := httptest.NewRecorder()
+	http.HandlerFunc(server.handleCreateAccount).ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusBadRequest {
+		t.Fatalf("expected status code %d but got %d", http.StatusBadRequest, rr.Code)
+	}
+	var apiError struct {
+		Error string `json:"error"`
+	}
+	if err := json.Unmarshal(rr.Body.Bytes(), &apiError); err != nil {
+		t.Fatal(err)
+	}
+	var validationErrors []IError
+	if err := json.Unmarshal([]byte(apiError.Error), &validationErrors); err != nil {
+		t.Fatal(err)
+	}
+	expected := []IError{
+		{Field: "Password", Tag: password.TagMin, Value: "8"},
+		{Field: "Password", Tag: password.TagCharacterClasses, Value: "2"},
+		{Field: "Password", Tag: password.TagPersonalInfo},
+	}
+	if len(validationErrors) != len(expected) {
+		t.Fatalf("expected the errors %v but got %v", expected, validationErrors)
+	}
+	for i := range expected {
+		if validationErrors[i] != expected[i] {
+			t.Errorf("expected the error %v but got %v", expected[i], validationErrors[i])
+		}
+	}
+}
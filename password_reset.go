@@ -0,0 +1,158 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+
+	"github.com/nguyenanhhao221/go-jwt/internal/auth"
+	"github.com/nguyenanhhao221/go-jwt/internal/mail"
+	"github.com/nguyenanhhao221/go-jwt/internal/password"
+)
+
+// frontendLink A link to a page of the frontend with the token as query parameter.
+// The page is configured with the env variable envKey, by default defaultPath under the public URL of the server
+func frontendLink(r *http.Request, envKey, defaultPath, token string) string {
+	pageURL := os.Getenv(envKey)
+	if pageURL == "" {
+		pageURL = publicBaseURL(r) + defaultPath
+	}
+	parsedURL, err := url.Parse(pageURL)
+	if err != nil {
+		log.Printf("Invalid %s %q, fallback to %v: %v", envKey, pageURL, defaultPath, err)
+		parsedURL, _ = url.Parse(publicBaseURL(r) + defaultPath)
+	}
+	query := parsedURL.Query()
+	query.Set("token", token)
+	parsedURL.RawQuery = query.Encode()
+	return parsedURL.String()
+}
+
+// passwordResetURL The page of the frontend where the account enters its new password, configured with PASSWORD_RESET_URL
+func passwordResetURL(r *http.Request, token string) string {
+	return frontendLink(r, "PASSWORD_RESET_URL", "/reset-password", token)
+}
+
+// handleRequestPasswordReset Send an email with a single use link to reset the password of the account.
+// The answer is the same whether the email belongs to an account or not, so the endpoint can't be used to find accounts
+func (s *APIServer) handleRequestPasswordReset(w http.ResponseWriter, r *http.Request) {
+	type RequestPasswordResetReqBody struct {
+		Email string `json:"email" validate:"required"`
+	}
+	requestPasswordResetReqBody := new(RequestPasswordResetReqBody)
+	if err := json.NewDecoder(r.Body).Decode(requestPasswordResetReqBody); err != nil {
+		WriteErrorJson(w, http.StatusForbidden, err.Error())
+		return
+	}
+	if requestPasswordResetReqBody.Email == "" {
+		WriteErrorJson(w, http.StatusBadRequest, "An email is required")
+		return
+	}
+
+	if err := s.sendPasswordReset(r, requestPasswordResetReqBody.Email); err != nil {
+		log.Printf("Failed to send a password reset email: %v", err)
+	}
+	WriteJSON(w, http.StatusAccepted, "If the email belongs to an account, a link to reset the password was sent to it")
+}
+
+// sendPasswordReset Create a reset token for the account of the email and send it, nothing is sent for an unknown email
+func (s *APIServer) sendPasswordReset(r *http.Request, email string) error {
+	account, err := s.store.GetAccountByEmail(email)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	token, tokenHash, err := auth.NewPasswordResetToken()
+	if err != nil {
+		return err
+	}
+	ttl := auth.PasswordResetTTL()
+	if err := s.store.CreatePasswordResetToken(NewPasswordResetToken(account.ID, tokenHash, ttl)); err != nil {
+		return err
+	}
+	return s.mailer.Send(mail.Message{
+		To:      account.Email,
+		Subject: "Reset your password",
+		Body: fmt.Sprintf(
+			"Hi %s,\n\nOpen this link to choose a new password, it can be used once in the next %v:\n\n%s\n\n"+
+				"If you didn't ask to reset your password, you can ignore this email.\n",
+			account.FirstName, ttl, passwordResetURL(r, token),
+		),
+	})
+}
+
+// handleConfirmPasswordReset Set the new password of the account with the token from the reset email.
+// Every session of the account is signed out, as whoever had the old password could still be signed in
+func (s *APIServer) handleConfirmPasswordReset(w http.ResponseWriter, r *http.Request) {
+	type ConfirmPasswordResetReqBody struct {
+		Token    string `json:"token" validate:"required"`
+		Password string `json:"password" validate:"required"`
+	}
+	confirmPasswordResetReqBody := new(ConfirmPasswordResetReqBody)
+	if err := json.NewDecoder(r.Body).Decode(confirmPasswordResetReqBody); err != nil {
+		WriteErrorJson(w, http.StatusForbidden, err.Error())
+		return
+	}
+	if confirmPasswordResetReqBody.Token == "" || confirmPasswordResetReqBody.Password == "" {
+		WriteErrorJson(w, http.StatusBadRequest, "A token and a new password are required")
+		return
+	}
+
+	tokenHash := auth.HashPasswordResetToken(confirmPasswordResetReqBody.Token)
+	resetToken, err := s.store.GetPasswordResetToken(tokenHash)
+	if errors.Is(err, sql.ErrNoRows) {
+		WriteErrorJson(w, http.StatusBadRequest, "Invalid or expired password reset token")
+		return
+	} else if err != nil {
+		WriteErrorJson(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	account, err := s.store.GetAccountById(resetToken.AccountID)
+	if err != nil {
+		WriteErrorJson(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if policyErrors := s.checkPasswordPolicy(confirmPasswordResetReqBody.Password, account.Email, account.FirstName, account.LastName); len(policyErrors) > 0 {
+		writeValidationErrors(w, policyErrors)
+		return
+	}
+
+	passwordHash, err := s.hashing.Hash(r.Context(), confirmPasswordResetReqBody.Password)
+	if errors.Is(err, password.ErrOverloaded) {
+		writeHashingOverloaded(w)
+		return
+	} else if err != nil {
+		WriteErrorJson(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	// The token is checked again while it is consumed, in case it was used meanwhile
+	accountId, err := s.store.ResetPassword(tokenHash, passwordHash)
+	if errors.Is(err, sql.ErrNoRows) {
+		WriteErrorJson(w, http.StatusBadRequest, "Invalid or expired password reset token")
+		return
+	} else if err != nil {
+		WriteErrorJson(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	if err := s.revocations.RevokeAccount(accountId); err != nil {
+		log.Printf("Failed to sign out account %v after its password reset: %v", accountId, err)
+		WriteErrorJson(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	// The account can sign in right away with its new password, even if it was locked by failed attempts
+	s.recordSignInSuccess(accountId)
+	emitAuditEvent(AuditEvent{
+		Type:      auditPasswordReset,
+		AccountID: auditAccount(accountId),
+		IP:        clientIP(r),
+	})
+	WriteJSON(w, http.StatusNoContent, nil)
+}
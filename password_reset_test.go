@@ -0,0 +1,139 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"testing"
+
+	"github.com/nguyenanhhao221/go-jwt/internal/auth"
+	"github.com/nguyenanhhao221/go-jwt/internal/mail"
+	"github.com/nguyenanhhao221/go-jwt/settings"
+)
+
+func TestPasswordResetURL(t *testing.T) {
+	req, err := http.NewRequest(http.MethodPost, settings.AppSettings.Password_Reset_Route, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PASSWORD_RESET_URL", "https://app.example.com/reset?lang=en")
+	resetURL, err := url.Parse(passwordResetURL(req, "a-token"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resetURL.Host != "app.example.com" || resetURL.Query().Get("token") != "a-token" || resetURL.Query().Get("lang") != "en" {
+		t.Errorf("expected the token to be added to PASSWORD_RESET_URL but got %v", resetURL)
+	}
+}
+
+func TestPasswordResetCI(t *testing.T) {
+	store, err := NewPostgresStore()
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := NewAPIServer("", store)
+	mailDir := t.TempDir()
+	server.mailer = &mail.FileSender{Dir: mailDir}
+	mockUser := NewAccount("Reset First Name", "Reset Last Name", "Reset@email.com", "TestPassword")
+	accountId, err := store.CreateAccount(mockUser)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.DeleteAccountById(accountId)
+	tokenRes, err := server.issueTokens(tokenGrant{accountId: accountId, role: auth.RoleCustomer}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	request := func(handler http.HandlerFunc, route string, body any) *httptest.ResponseRecorder {
+		reqBodyJSON, err := json.Marshal(body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req, err := http.NewRequest(http.MethodPost, route, bytes.NewBuffer(reqBodyJSON))
+		if err != nil {
+			t.Fatal(err)
+		}
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		return rr
+	}
+	// sentTokens The reset tokens in the emails written by the file sender
+	sentTokens := func() []string {
+		files, err := os.ReadDir(mailDir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var tokens []string
+		for _, file := range files {
+			content, err := os.ReadFile(filepath.Join(mailDir, file.Name()))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if match := regexp.MustCompile(`token=([A-Za-z0-9_-]+)`).FindSubmatch(content); match != nil {
+				tokens = append(tokens, string(match[1]))
+			}
+		}
+		return tokens
+	}
+
+	t.Run("UnknownEmail", func(t *testing.T) {
+		rr := request(server.handleRequestPasswordReset, settings.AppSettings.Password_Reset_Route, map[string]string{"email": "unknown-reset@email.com"})
+		if rr.Code != http.StatusAccepted {
+			t.Errorf("expected status code %d but got %d", http.StatusAccepted, rr.Code)
+		}
+		if tokens := sentTokens(); len(tokens) != 0 {
+			t.Errorf("expected no email for an unknown account but got %d", len(tokens))
+		}
+	})
+
+	t.Run("Reset", func(t *testing.T) {
+		rr := request(server.handleRequestPasswordReset, settings.AppSettings.Password_Reset_Route, map[string]string{"email": mockUser.Email})
+		if rr.Code != http.StatusAccepted {
+			t.Fatalf("expected status code %d but got %d", http.StatusAccepted, rr.Code)
+		}
+		tokens := sentTokens()
+		if len(tokens) != 1 {
+			t.Fatalf("expected one reset email but got %d", len(tokens))
+		}
+
+		rr = request(server.handleConfirmPasswordReset, settings.AppSettings.Password_Reset_Confirm_Route, map[string]string{"token": tokens[0], "password": "NewPassword"})
+		if rr.Code != http.StatusNoContent {
+			t.Fatalf("expected status code %d but got %d", http.StatusNoContent, rr.Code)
+		}
+
+		// The token can only be used once
+		rr = request(server.handleConfirmPasswordReset, settings.AppSettings.Password_Reset_Confirm_Route, map[string]string{"token": tokens[0], "password": "OtherPassword"})
+		if rr.Code != http.StatusBadRequest {
+			t.Errorf("expected a used token to be refused but got %d", rr.Code)
+		}
+
+		rr = request(server.handleSignIn, settings.AppSettings.SignIn_Account_Route, map[string]string{"email": mockUser.Email, "password": "TestPassword"})
+		if rr.Code != http.StatusUnauthorized {
+			t.Errorf("expected the old password to be refused but got %d", rr.Code)
+		}
+		rr = request(server.handleSignIn, settings.AppSettings.SignIn_Account_Route, map[string]string{"email": mockUser.Email, "password": "NewPassword"})
+		if rr.Code != http.StatusOK {
+			t.Errorf("expected to sign in with the new password but got %d", rr.Code)
+		}
+	})
+
+	t.Run("SessionsRevoked", func(t *testing.T) {
+		token, err := auth.ValidateJWT(tokenRes.Token)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !server.revocations.IsRevoked(token.Claims.(*auth.CustomJWTClaims)) {
+			t.Error("expected the access token issued before the reset to be revoked")
+		}
+		rr := request(server.handleRefreshToken, settings.AppSettings.Refresh_Token_Route, map[string]string{"refreshToken": tokenRes.RefreshToken})
+		if rr.Code == http.StatusOK {
+			t.Error("expected the refresh token issued before the reset to be revoked")
+		}
+	})
+}
@@ -0,0 +1,199 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/nguyenanhhao221/go-jwt/internal/auth"
+	"github.com/nguyenanhhao221/go-jwt/internal/mail"
+)
+
+// Passwordless sign in limits. An account gets at most passwordlessMaxChallenges emails per passwordlessChallengeWindow,
+// and each code can be tried passwordlessMaxAttempts times before it is used up
+const (
+	passwordlessMaxChallenges   = 3
+	passwordlessChallengeWindow = 15 * time.Minute
+	passwordlessMaxAttempts     = 5
+)
+
+// magicLinkURL The page of the frontend that signs in with the token of the link, configured with MAGIC_LINK_URL
+func magicLinkURL(r *http.Request, token string) string {
+	return frontendLink(r, "MAGIC_LINK_URL", "/signin/magic-link", token)
+}
+
+// handleRequestPasswordless Send an email with a magic link and a code to sign in without a password,
+// they are exchanged for the tokens with handleConfirmPasswordless.
+// Like the password reset, the answer is the same whether the email belongs to an account or not
+func (s *APIServer) handleRequestPasswordless(w http.ResponseWriter, r *http.Request) {
+	type RequestPasswordlessReqBody struct {
+		Email string `json:"email" validate:"required"`
+	}
+	requestPasswordlessReqBody := new(RequestPasswordlessReqBody)
+	if err := json.NewDecoder(r.Body).Decode(requestPasswordlessReqBody); err != nil {
+		WriteErrorJson(w, http.StatusForbidden, err.Error())
+		return
+	}
+	if requestPasswordlessReqBody.Email == "" {
+		WriteErrorJson(w, http.StatusBadRequest, "An email is required")
+		return
+	}
+	// Unknown emails are locked like the ones of accounts, a lockout doesn't tell the email exists
+	var lockedErr *signInLockedError
+	if err := s.checkSignInLockout(r, requestPasswordlessReqBody.Email); errors.As(err, &lockedErr) {
+		writeTooManyAttempts(w, lockedErr)
+		return
+	}
+
+	if err := s.sendPasswordless(r, requestPasswordlessReqBody.Email); err != nil {
+		log.Printf("Failed to send a passwordless sign in email: %v", err)
+	}
+	WriteJSON(w, http.StatusAccepted, "If the email belongs to an account, a link and a code to sign in were sent to it")
+}
+
+// sendPasswordless Create a challenge for the account of the email and send it,
+// nothing is sent for an unknown email or when the account got too many already
+func (s *APIServer) sendPasswordless(r *http.Request, email string) error {
+	account, err := s.store.GetAccountByEmail(email)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	sent, err := s.store.CountPasswordlessChallenges(account.ID, time.Now().UTC().Add(-passwordlessChallengeWindow))
+	if err != nil {
+		return err
+	}
+	if sent >= passwordlessMaxChallenges {
+		log.Printf("Passwordless sign in of account %v rate limited after %d emails", account.ID, sent)
+		return nil
+	}
+
+	token, tokenHash, err := auth.NewMagicLinkToken()
+	if err != nil {
+		return err
+	}
+	code, codeHash, err := auth.NewSignInCode()
+	if err != nil {
+		return err
+	}
+	ttl := auth.PasswordlessTTL()
+	if err := s.store.CreatePasswordlessChallenge(NewPasswordlessChallenge(account.ID, tokenHash, codeHash, ttl)); err != nil {
+		return err
+	}
+	return s.mailer.Send(mail.Message{
+		To:      account.Email,
+		Subject: fmt.Sprintf("Your sign in code is %s", code),
+		Body: fmt.Sprintf(
+			"Hi %s,\n\nOpen this link to sign in:\n\n%s\n\nOr enter this code: %s\n\n"+
+				"The link and the code can be used once in the next %v. If you didn't ask to sign in, you can ignore this email.\n",
+			account.FirstName, magicLinkURL(r, token), code, ttl,
+		),
+	})
+}
+
+// handleConfirmPasswordless Sign in with the token of the magic link, or the email and the code.
+// The answer is the same as handleSignIn, including the MFA challenge of accounts with two-factor authentication
+func (s *APIServer) handleConfirmPasswordless(w http.ResponseWriter, r *http.Request) {
+	type ConfirmPasswordlessReqBody struct {
+		// Token The token of the magic link, or Email and Code are sent instead
+		Token string `json:"token"`
+		Email string `json:"email"`
+		Code  string `json:"code"`
+		// Device A label for the session, see handleSignIn
+		Device string `json:"device" validate:"max=100"`
+	}
+	confirmPasswordlessReqBody := new(ConfirmPasswordlessReqBody)
+	if err := json.NewDecoder(r.Body).Decode(confirmPasswordlessReqBody); err != nil {
+		WriteErrorJson(w, http.StatusForbidden, err.Error())
+		return
+	}
+
+	var accountId uuid.UUID
+	var err error
+	switch {
+	case confirmPasswordlessReqBody.Token != "":
+		accountId, err = s.useMagicLink(confirmPasswordlessReqBody.Token)
+	case confirmPasswordlessReqBody.Email != "" && confirmPasswordlessReqBody.Code != "":
+		accountId, err = s.useSignInCode(r, confirmPasswordlessReqBody.Email, confirmPasswordlessReqBody.Code)
+	default:
+		WriteErrorJson(w, http.StatusBadRequest, "Either the token or the email and the code are required")
+		return
+	}
+	var lockedErr *signInLockedError
+	if errors.As(err, &lockedErr) {
+		writeTooManyAttempts(w, lockedErr)
+		return
+	}
+	if errors.Is(err, errWrongCredentials) {
+		WriteErrorJson(w, http.StatusUnauthorized, "Invalid or expired sign in link or code")
+		return
+	}
+	if err != nil {
+		WriteErrorJson(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	account, err := s.store.GetAccountById(accountId)
+	if err != nil {
+		WriteErrorJson(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	s.signInAccount(w, r, account.ID, account.Role, []string{auth.AMREmail}, confirmPasswordlessReqBody.Device)
+}
+
+// useMagicLink The account of the magic link token, which is used up. errWrongCredentials is returned for an invalid token
+func (s *APIServer) useMagicLink(token string) (uuid.UUID, error) {
+	accountId, err := s.store.UseMagicLink(auth.HashMagicLinkToken(token))
+	if errors.Is(err, sql.ErrNoRows) {
+		return uuid.Nil, errWrongCredentials
+	}
+	if err != nil {
+		return uuid.Nil, err
+	}
+	s.recordSignInSuccess(accountId)
+	return accountId, nil
+}
+
+// useSignInCode The account of the email when the code matches its latest challenge, which is used up.
+// Wrong codes are throttled like wrong passwords, errWrongCredentials is returned for them
+// and a *signInLockedError while locked
+func (s *APIServer) useSignInCode(r *http.Request, email, code string) (uuid.UUID, error) {
+	account, err := s.store.GetAccountByEmail(email)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return uuid.Nil, err
+	}
+	accountId := uuid.Nil
+	if err == nil {
+		accountId = account.ID
+	}
+	throttle, err := s.startSignInThrottle(r, accountId, email)
+	var lockedErr *signInLockedError
+	if errors.As(err, &lockedErr) {
+		s.recordSignInAttempt(r, accountId, signInLocked)
+		return uuid.Nil, err
+	} else if err != nil {
+		return uuid.Nil, err
+	}
+	if accountId == uuid.Nil {
+		throttle.fail()
+		return uuid.Nil, errWrongCredentials
+	}
+	err = s.store.UseSignInCode(accountId, code, passwordlessMaxAttempts)
+	if errors.Is(err, ErrWrongSignInCode) || errors.Is(err, sql.ErrNoRows) {
+		throttle.fail()
+		s.recordSignInAttempt(r, accountId, signInWrongCode)
+		return uuid.Nil, errWrongCredentials
+	}
+	if err != nil {
+		throttle.cancel()
+		return uuid.Nil, err
+	}
+	throttle.succeed()
+	return accountId, nil
+}
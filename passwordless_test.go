@@ -0,0 +1,141 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"regexp"
+	"testing"
+
+	"github.com/nguyenanhhao221/go-jwt/internal/auth"
+	"github.com/nguyenanhhao221/go-jwt/internal/mail"
+	"github.com/nguyenanhhao221/go-jwt/settings"
+)
+
+func TestPasswordlessCI(t *testing.T) {
+	store, err := NewPostgresStore()
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := NewAPIServer("", store)
+	mailDir := t.TempDir()
+	server.mailer = &mail.FileSender{Dir: mailDir}
+	mockUser := NewAccount("Passwordless First Name", "Passwordless Last Name", "Passwordless@email.com", "TestPassword")
+	accountId, err := store.CreateAccount(mockUser)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.DeleteAccountById(accountId)
+
+	request := func(handler http.HandlerFunc, route string, body any) *httptest.ResponseRecorder {
+		reqBodyJSON, err := json.Marshal(body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req, err := http.NewRequest(http.MethodPost, route, bytes.NewBuffer(reqBodyJSON))
+		if err != nil {
+			t.Fatal(err)
+		}
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		return rr
+	}
+	// receive Ask for a sign in email and return the token of its link and its code, the email is then deleted
+	receive := func(email string) (token string, code string, sent bool) {
+		rr := request(server.handleRequestPasswordless, settings.AppSettings.Passwordless_Route, map[string]string{"email": email})
+		if rr.Code != http.StatusAccepted {
+			t.Fatalf("expected status code %d but got %d", http.StatusAccepted, rr.Code)
+		}
+		files, err := os.ReadDir(mailDir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(files) == 0 {
+			return "", "", false
+		}
+		content, err := os.ReadFile(filepath.Join(mailDir, files[0].Name()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Remove(filepath.Join(mailDir, files[0].Name())); err != nil {
+			t.Fatal(err)
+		}
+		tokenMatch := regexp.MustCompile(`token=([A-Za-z0-9_-]+)`).FindSubmatch(content)
+		codeMatch := regexp.MustCompile(`code: ([0-9]{6})`).FindSubmatch(content)
+		if tokenMatch == nil || codeMatch == nil {
+			t.Fatalf("expected a link and a code in the email but got %s", content)
+		}
+		return string(tokenMatch[1]), string(codeMatch[1]), true
+	}
+	confirm := func(body map[string]string) *httptest.ResponseRecorder {
+		return request(server.handleConfirmPasswordless, settings.AppSettings.Passwordless_Confirm_Route, body)
+	}
+
+	t.Run("UnknownEmail", func(t *testing.T) {
+		if _, _, sent := receive("unknown-passwordless@email.com"); sent {
+			t.Error("expected no email for an unknown account")
+		}
+	})
+
+	t.Run("Code", func(t *testing.T) {
+		token, code, sent := receive(mockUser.Email)
+		if !sent {
+			t.Fatal("expected a sign in email")
+		}
+		wrongCode := "000000"
+		if code == wrongCode {
+			wrongCode = "111111"
+		}
+		if rr := confirm(map[string]string{"email": mockUser.Email, "code": wrongCode}); rr.Code != http.StatusUnauthorized {
+			t.Errorf("expected a wrong code to be refused but got %d", rr.Code)
+		}
+		rr := confirm(map[string]string{"email": mockUser.Email, "code": code})
+		if rr.Code != http.StatusOK {
+			t.Fatalf("expected status code %d but got %d", http.StatusOK, rr.Code)
+		}
+		var tokenRes TokenResponse
+		if err := json.Unmarshal(rr.Body.Bytes(), &tokenRes); err != nil {
+			t.Fatal(err)
+		}
+		accessToken, err := auth.ValidateJWT(tokenRes.Token)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if claims := accessToken.Claims.(*auth.CustomJWTClaims); claims.ID != accountId || len(claims.AMR) != 1 || claims.AMR[0] != auth.AMREmail {
+			t.Errorf("expected an access token of the account signed in by email but got %v", claims)
+		}
+		// Signing in uses up the link sent with the code, and proves the email
+		if rr := confirm(map[string]string{"token": token}); rr.Code != http.StatusUnauthorized {
+			t.Errorf("expected the link of a used challenge to be refused but got %d", rr.Code)
+		}
+		if account, err := store.GetAccountById(accountId); err != nil || !account.EmailVerified {
+			t.Errorf("expected the email to be verified but got %v %v", account, err)
+		}
+	})
+
+	t.Run("MagicLink", func(t *testing.T) {
+		token, _, sent := receive(mockUser.Email)
+		if !sent {
+			t.Fatal("expected a sign in email")
+		}
+		if rr := confirm(map[string]string{"token": token}); rr.Code != http.StatusOK {
+			t.Fatalf("expected status code %d but got %d", http.StatusOK, rr.Code)
+		}
+		if rr := confirm(map[string]string{"token": token}); rr.Code != http.StatusUnauthorized {
+			t.Errorf("expected the link to be single use but got %d", rr.Code)
+		}
+	})
+
+	t.Run("RateLimit", func(t *testing.T) {
+		// Two emails were already sent in the window
+		if _, _, sent := receive(mockUser.Email); !sent {
+			t.Fatal("expected a sign in email")
+		}
+		if _, _, sent := receive(mockUser.Email); sent {
+			t.Errorf("expected at most %d emails per %v", passwordlessMaxChallenges, passwordlessChallengeWindow)
+		}
+	})
+}
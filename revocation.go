@@ -0,0 +1,141 @@
+package main
+
+import (
+	"log"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/nguyenanhhao221/go-jwt/internal/auth"
+)
+
+// revocationRefreshInterval How often the revocation list is reloaded from the database.
+// A token revoked through another server instance can be accepted here for at most this long
+const revocationRefreshInterval = 30 * time.Second
+
+// RevocationList In memory cache of the revoked tokens stored in Postgres,
+// so checking a token on every request doesn't need a database round trip
+type RevocationList struct {
+	store Storage
+
+	mu sync.RWMutex
+	// revokedTokens jti of the revoked tokens with their expiry
+	revokedTokens map[string]time.Time
+	// revokedBefore Tokens of the account issued before this time are revoked, set by signing out of all sessions
+	revokedBefore map[uuid.UUID]time.Time
+	// revokedSessions Tokens of these sessions are revoked, with the time the session was revoked
+	revokedSessions map[uuid.UUID]time.Time
+}
+
+func NewRevocationList(store Storage) *RevocationList {
+	return &RevocationList{
+		store:           store,
+		revokedTokens:   map[string]time.Time{},
+		revokedBefore:   map[uuid.UUID]time.Time{},
+		revokedSessions: map[uuid.UUID]time.Time{},
+	}
+}
+
+// Load Replace the cache with the revocations stored in the database
+func (l *RevocationList) Load() error {
+	now := time.Now().UTC()
+	// Expired tokens are still accepted during the leeway, they must stay revoked until it passed
+	if err := l.store.DeleteExpiredRevokedTokens(now.Add(-auth.Leeway())); err != nil {
+		return err
+	}
+	storedTokens, err := l.store.GetRevokedTokens()
+	if err != nil {
+		return err
+	}
+	// Account revocations older than the longest token lifetime can't match any valid token anymore
+	revokedBefore, err := l.store.GetAccountRevocations(now.Add(-auth.MaxTokenLifetime()))
+	if err != nil {
+		return err
+	}
+	revokedSessions, err := l.store.GetRevokedSessions(now.Add(-auth.MaxTokenLifetime()))
+	if err != nil {
+		return err
+	}
+
+	revokedTokens := make(map[string]time.Time, len(storedTokens))
+	for _, revokedToken := range storedTokens {
+		revokedTokens[revokedToken.JTI] = revokedToken.ExpiresAt
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.revokedTokens = revokedTokens
+	l.revokedBefore = revokedBefore
+	l.revokedSessions = revokedSessions
+	return nil
+}
+
+// Watch Reload the cache periodically to pick up revocations done by other server instances
+func (l *RevocationList) Watch() {
+	ticker := time.NewTicker(revocationRefreshInterval)
+	defer ticker.Stop()
+	for range ticker.C {
+		if err := l.Load(); err != nil {
+			log.Printf("Failed to reload revocation list %v", err)
+		}
+	}
+}
+
+// IsRevoked Check if the token was revoked by its jti, its session or by signing out of all sessions
+func (l *RevocationList) IsRevoked(claims *auth.CustomJWTClaims) bool {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	if _, revoked := l.revokedTokens[claims.Id]; revoked && claims.Id != "" {
+		return true
+	}
+	if revokedBefore, ok := l.revokedBefore[claims.ID]; ok && claims.IssuedAt < revokedBefore.Unix() {
+		return true
+	}
+	if sessionId, err := uuid.Parse(claims.SessionID); err == nil {
+		if _, revoked := l.revokedSessions[sessionId]; revoked {
+			return true
+		}
+	}
+	return false
+}
+
+// RevokeToken Revoke a single access token until it expires
+func (l *RevocationList) RevokeToken(claims *auth.CustomJWTClaims) error {
+	revokedToken := &RevokedToken{
+		JTI:       claims.Id,
+		AccountID: claims.ID,
+		ExpiresAt: time.Unix(claims.ExpiresAt, 0).UTC(),
+	}
+	if err := l.store.RevokeToken(revokedToken); err != nil {
+		return err
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.revokedTokens[revokedToken.JTI] = revokedToken.ExpiresAt
+	return nil
+}
+
+// RevokeAccount Revoke every access and refresh token issued to the account so far.
+// iat only has a second precision, so the tokens issued in the current second are revoked too
+func (l *RevocationList) RevokeAccount(accountId uuid.UUID) error {
+	revokedBefore := time.Now().UTC().Truncate(time.Second).Add(time.Second)
+	if err := l.store.RevokeAccountTokens(accountId, revokedBefore); err != nil {
+		return err
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.revokedBefore[accountId] = revokedBefore
+	return nil
+}
+
+// RevokeSession Revoke a session of the account, its access and refresh tokens stop working.
+// sql.ErrNoRows is returned when the account has no such active session
+func (l *RevocationList) RevokeSession(accountId uuid.UUID, sessionId uuid.UUID) error {
+	if err := l.store.RevokeSession(accountId, sessionId); err != nil {
+		return err
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.revokedSessions[sessionId] = time.Now().UTC()
+	return nil
+}
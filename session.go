@@ -0,0 +1,148 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+	"github.com/nguyenanhhao221/go-jwt/internal/auth"
+)
+
+// maxDeviceLabelLength Longer device labels are cut, the column holds 100 characters
+const maxDeviceLabelLength = 100
+
+// userAgentProduct A token to look for in a User-Agent and the name shown for it
+type userAgentProduct struct {
+	token string
+	name  string
+}
+
+// Browsers and operating systems recognized in a User-Agent, in the order they are looked for:
+// e.g. the User-Agent of Edge also has Chrome and Safari in it, the one of Android also has Linux
+var (
+	userAgentBrowsers = []userAgentProduct{
+		{"Edg/", "Edge"},
+		{"OPR/", "Opera"},
+		{"Firefox/", "Firefox"},
+		{"Chrome/", "Chrome"},
+		{"Safari/", "Safari"},
+	}
+	userAgentOperatingSystems = []userAgentProduct{
+		{"iPhone", "iOS"},
+		{"iPad", "iPadOS"},
+		{"Android", "Android"},
+		{"Windows", "Windows"},
+		{"Mac OS X", "macOS"},
+		{"CrOS", "ChromeOS"},
+		{"Linux", "Linux"},
+	}
+)
+
+// SessionResponse A session as listed to its account
+type SessionResponse struct {
+	ID          uuid.UUID `json:"id"`
+	DeviceLabel string    `json:"deviceLabel"`
+	UserAgent   string    `json:"userAgent"`
+	IP          string    `json:"ip"`
+	CreatedAt   time.Time `json:"createdAt"`
+	LastSeenAt  time.Time `json:"lastSeenAt"`
+	// Current Set on the session of the token used to list the sessions
+	Current bool `json:"current"`
+}
+
+// deviceLabel A readable label for the device of the User-Agent, e.g. "Firefox on Windows"
+func deviceLabel(userAgent string) string {
+	browser := findUserAgentProduct(userAgent, userAgentBrowsers)
+	operatingSystem := findUserAgentProduct(userAgent, userAgentOperatingSystems)
+	switch {
+	case browser != "" && operatingSystem != "":
+		return fmt.Sprintf("%s on %s", browser, operatingSystem)
+	case browser != "":
+		return browser
+	case operatingSystem != "":
+		return operatingSystem
+	}
+	return "Unknown device"
+}
+
+func findUserAgentProduct(userAgent string, products []userAgentProduct) string {
+	for _, product := range products {
+		if strings.Contains(userAgent, product.token) {
+			return product.name
+		}
+	}
+	return ""
+}
+
+// startSession Record a successful sign in as a new session. device is the label sent by the client,
+// when empty one is derived from the User-Agent
+func (s *APIServer) startSession(r *http.Request, accountId uuid.UUID, device string) (*Session, error) {
+	device = strings.TrimSpace(device)
+	if device == "" {
+		device = deviceLabel(r.UserAgent())
+	}
+	if labelRunes := []rune(device); len(labelRunes) > maxDeviceLabelLength {
+		device = string(labelRunes[:maxDeviceLabelLength])
+	}
+	session := NewSession(accountId, device, r.UserAgent(), clientIP(r))
+	if err := s.store.CreateSession(session); err != nil {
+		return nil, err
+	}
+	return session, nil
+}
+
+// handleGetSessions List the sessions the account of the token is signed in with
+func (s *APIServer) handleGetSessions(w http.ResponseWriter, r *http.Request) {
+	claims, _ := claimsFromContext(r.Context())
+	// A session not refreshed within the lifetime of its refresh token can't be used anymore
+	sessions, err := s.store.GetSessions(claims.ID, time.Now().UTC().Add(-auth.RefreshTokenTTL()))
+	if err != nil {
+		WriteErrorJson(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	sessionResponses := make([]SessionResponse, 0, len(sessions))
+	for _, session := range sessions {
+		sessionResponses = append(sessionResponses, SessionResponse{
+			ID:          session.ID,
+			DeviceLabel: session.DeviceLabel,
+			UserAgent:   session.UserAgent,
+			IP:          session.IP,
+			CreatedAt:   session.CreatedAt,
+			LastSeenAt:  session.LastSeenAt,
+			Current:     session.ID.String() == claims.SessionID,
+		})
+	}
+	WriteJSON(w, http.StatusOK, sessionResponses)
+}
+
+// handleRevokeSession Sign a session of the account of the token out, its tokens stop working right away
+func (s *APIServer) handleRevokeSession(w http.ResponseWriter, r *http.Request) {
+	sessionId, err := uuid.Parse(chi.URLParam(r, "sessionId"))
+	if err != nil {
+		WriteErrorJson(w, http.StatusBadRequest, fmt.Sprintf("Invalid session id in request: %v", err))
+		return
+	}
+	claims, _ := claimsFromContext(r.Context())
+	if err := s.revocations.RevokeSession(claims.ID, sessionId); errors.Is(err, sql.ErrNoRows) {
+		WriteErrorJson(w, http.StatusNotFound, "Session not found")
+		return
+	} else if err != nil {
+		WriteErrorJson(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	emitAuditEvent(AuditEvent{
+		Type:      auditSessionRevoked,
+		AccountID: auditAccount(claims.ID),
+		IP:        clientIP(r),
+		Details:   fmt.Sprintf("Session %v", sessionId),
+	})
+	if sessionId.String() == claims.SessionID {
+		clearAccessTokenCookie(w)
+	}
+	WriteJSON(w, http.StatusNoContent, nil)
+}
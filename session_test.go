@@ -0,0 +1,191 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+	"github.com/nguyenanhhao221/go-jwt/settings"
+)
+
+func TestDeviceLabel(t *testing.T) {
+	tests := []struct {
+		userAgent string
+		expected  string
+	}{
+		{"Mozilla/5.0 (Windows NT 10.0; Win64; x64; rv:121.0) Gecko/20100101 Firefox/121.0", "Firefox on Windows"},
+		{"Mozilla/5.0 (Macintosh; Intel Mac OS X 10_15_7) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/120.0.0.0 Safari/537.36", "Chrome on macOS"},
+		{"Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/120.0.0.0 Safari/537.36 Edg/120.0.0.0", "Edge on Windows"},
+		{"Mozilla/5.0 (iPhone; CPU iPhone OS 17_2 like Mac OS X) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/17.2 Mobile/15E148 Safari/604.1", "Safari on iOS"},
+		{"Mozilla/5.0 (Linux; Android 14; Pixel 8) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/120.0.0.0 Mobile Safari/537.36", "Chrome on Android"},
+		{"curl/8.4.0", "Unknown device"},
+		{"", "Unknown device"},
+	}
+	for _, test := range tests {
+		if label := deviceLabel(test.userAgent); label != test.expected {
+			t.Errorf("expected %q for %q but got %q", test.expected, test.userAgent, label)
+		}
+	}
+}
+
+func TestSessionCI(t *testing.T) {
+	store, err := NewPostgresStore()
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := NewAPIServer("", store)
+	mockUser := NewAccount("Session First Name", "Session Last Name", "Session@email.com", "TestPassword")
+	accountId, err := store.CreateAccount(mockUser)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.DeleteAccountById(accountId)
+
+	signIn := func(device string) TokenResponse {
+		reqBodyJSON, err := json.Marshal(map[string]string{"email": mockUser.Email, "password": "TestPassword", "device": device})
+		if err != nil {
+			t.Fatal(err)
+		}
+		req, err := http.NewRequest(http.MethodPost, settings.AppSettings.SignIn_Account_Route, bytes.NewBuffer(reqBodyJSON))
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("User-Agent", "Mozilla/5.0 (X11; Linux x86_64; rv:121.0) Gecko/20100101 Firefox/121.0")
+		rr := httptest.NewRecorder()
+		http.HandlerFunc(server.handleSignIn).ServeHTTP(rr, req)
+		if rr.Code != http.StatusOK {
+			t.Fatalf("expected status code %d but got %d", http.StatusOK, rr.Code)
+		}
+		var tokenRes TokenResponse
+		if err := json.Unmarshal(rr.Body.Bytes(), &tokenRes); err != nil {
+			t.Fatal(err)
+		}
+		return tokenRes
+	}
+	laptop := signIn("")
+	phone := signIn("My phone")
+
+	getSessions := func(token string) (int, []SessionResponse) {
+		req, err := http.NewRequest(http.MethodGet, settings.AppSettings.Sessions_Route, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("Authorization", "Bearer "+token)
+		rr := httptest.NewRecorder()
+		server.withJWTAuth(withPermission(server.handleGetSessions, permission{})).ServeHTTP(rr, req)
+		var sessions []SessionResponse
+		if rr.Code == http.StatusOK {
+			if err := json.Unmarshal(rr.Body.Bytes(), &sessions); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return rr.Code, sessions
+	}
+	revokeSession := func(token string, sessionId uuid.UUID) int {
+		req, err := http.NewRequest(http.MethodDelete, settings.AppSettings.Session_Route, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		rctx := chi.NewRouteContext()
+		rctx.URLParams.Add("sessionId", sessionId.String())
+		req = req.WithContext(context.WithValue(req.Context(), chi.RouteCtxKey, rctx))
+		req.Header.Set("Authorization", "Bearer "+token)
+		rr := httptest.NewRecorder()
+		server.withJWTAuth(withPermission(server.handleRevokeSession, permission{})).ServeHTTP(rr, req)
+		return rr.Code
+	}
+
+	var laptopSession SessionResponse
+	t.Run("List", func(t *testing.T) {
+		code, sessions := getSessions(laptop.Token)
+		if code != http.StatusOK {
+			t.Fatalf("expected status code %d but got %d", http.StatusOK, code)
+		}
+		if len(sessions) != 2 {
+			t.Fatalf("expected a session per sign in but got %v", sessions)
+		}
+		labels := map[string]SessionResponse{}
+		for _, session := range sessions {
+			labels[session.DeviceLabel] = session
+		}
+		laptopSession = labels["Firefox on Linux"]
+		if !laptopSession.Current || labels["My phone"].Current {
+			t.Errorf("expected the session of the token to be the current one but got %v", sessions)
+		}
+	})
+
+	t.Run("Refresh", func(t *testing.T) {
+		reqBodyJSON, err := json.Marshal(map[string]string{"refreshToken": laptop.RefreshToken})
+		if err != nil {
+			t.Fatal(err)
+		}
+		req, err := http.NewRequest(http.MethodPost, settings.AppSettings.Refresh_Token_Route, bytes.NewBuffer(reqBodyJSON))
+		if err != nil {
+			t.Fatal(err)
+		}
+		rr := httptest.NewRecorder()
+		http.HandlerFunc(server.handleRefreshToken).ServeHTTP(rr, req)
+		if rr.Code != http.StatusOK {
+			t.Fatalf("expected status code %d but got %d", http.StatusOK, rr.Code)
+		}
+		if err := json.Unmarshal(rr.Body.Bytes(), &laptop); err != nil {
+			t.Fatal(err)
+		}
+		// The refreshed token still belongs to the session
+		_, sessions := getSessions(laptop.Token)
+		if len(sessions) != 2 || sessions[0].ID != laptopSession.ID || !sessions[0].Current {
+			t.Errorf("expected the refreshed session to be the current and last seen one but got %v", sessions)
+		}
+	})
+
+	t.Run("StepUp", func(t *testing.T) {
+		reqBodyJSON, err := json.Marshal(map[string]string{"password": "TestPassword"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		req, err := http.NewRequest(http.MethodPost, settings.AppSettings.Reauthenticate_Route, bytes.NewBuffer(reqBodyJSON))
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("Authorization", "Bearer "+laptop.Token)
+		rr := httptest.NewRecorder()
+		server.withJWTAuth(withPermission(server.handleReauthenticate, permission{})).ServeHTTP(rr, req)
+		if rr.Code != http.StatusOK {
+			t.Fatalf("expected status code %d but got %d", http.StatusOK, rr.Code)
+		}
+		var steppedUp TokenResponse
+		if err := json.Unmarshal(rr.Body.Bytes(), &steppedUp); err != nil {
+			t.Fatal(err)
+		}
+		// The replaced token is revoked, the stepped-up one belongs to the same session
+		if code, _ := getSessions(laptop.Token); code != http.StatusUnauthorized {
+			t.Errorf("expected the replaced token to be refused but got %d", code)
+		}
+		_, sessions := getSessions(steppedUp.Token)
+		if len(sessions) != 2 || sessions[0].ID != laptopSession.ID || !sessions[0].Current {
+			t.Errorf("expected the stepped-up token to be in the session it replaced but got %v", sessions)
+		}
+		laptop.Token = steppedUp.Token
+	})
+
+	t.Run("Revoke", func(t *testing.T) {
+		if code := revokeSession(phone.Token, laptopSession.ID); code != http.StatusNoContent {
+			t.Fatalf("expected status code %d but got %d", http.StatusNoContent, code)
+		}
+		if code, _ := getSessions(laptop.Token); code != http.StatusUnauthorized {
+			t.Errorf("expected the stepped-up token of the revoked session to be refused but got %d", code)
+		}
+		if code := revokeSession(phone.Token, laptopSession.ID); code != http.StatusNotFound {
+			t.Errorf("expected a revoked session not to be found but got %d", code)
+		}
+		_, sessions := getSessions(phone.Token)
+		if len(sessions) != 1 || sessions[0].DeviceLabel != "My phone" {
+			t.Errorf("expected only the other session left but got %v", sessions)
+		}
+	})
+}
@@ -1,27 +1,85 @@
 package settings
 
 type Settings struct {
-	PORT                 int
-	API_V1               string
-	Check_Health         string
-	All_Account_Route    string
-	Account_Route        string
-	Create_Account_Route string
-	Transfer_Route       string
-	SignIn_Account_Route string
+	PORT                         int
+	API_V1                       string
+	Check_Health                 string
+	All_Account_Route            string
+	Account_Route                string
+	Account_Role_Route           string
+	Sign_In_History_Route        string
+	Create_Account_Route         string
+	Transfer_Route               string
+	SignIn_Account_Route         string
+	SignIn_MFA_Route             string
+	Passwordless_Route           string
+	Passwordless_Confirm_Route   string
+	MFA_TOTP_Route               string
+	MFA_TOTP_Verify_Route        string
+	Refresh_Token_Route          string
+	SignOut_Route                string
+	SignOut_All_Route            string
+	Reauthenticate_Route         string
+	Password_Reset_Route         string
+	Password_Reset_Confirm_Route string
+	Email_Verify_Route           string
+	Email_Verify_Confirm_Route   string
+	API_Keys_Route               string
+	API_Key_Route                string
+	Sessions_Route               string
+	Session_Route                string
+	JWKS_Route                   string
+	Introspect_Route             string
+	Token_Route                  string
+	Authorize_Route              string
+	UserInfo_Route               string
+	OpenID_Configuration_Route   string
+	Admin_Keys_Route             string
+	Admin_Key_Route              string
+	Admin_Key_Promote_Route      string
+	Admin_Metrics_Route          string
 }
 
 var AppSettings *Settings
 
 func init() {
 	AppSettings = &Settings{
-		PORT:                 8080,
-		API_V1:               "/v1",
-		Check_Health:         "/health",
-		All_Account_Route:    "/accounts",
-		Account_Route:        "/account/{accountId}",
-		Create_Account_Route: "/account/create",
-		SignIn_Account_Route: "/account/signin",
-		Transfer_Route:       "/transfer",
+		PORT:                         8080,
+		API_V1:                       "/v1",
+		Check_Health:                 "/health",
+		All_Account_Route:            "/accounts",
+		Account_Route:                "/account/{accountId}",
+		Account_Role_Route:           "/account/{accountId}/role",
+		Sign_In_History_Route:        "/account/{accountId}/sign-ins",
+		Create_Account_Route:         "/account/create",
+		SignIn_Account_Route:         "/account/signin",
+		SignIn_MFA_Route:             "/account/signin/mfa",
+		Passwordless_Route:           "/account/signin/passwordless",
+		Passwordless_Confirm_Route:   "/account/signin/passwordless/confirm",
+		MFA_TOTP_Route:               "/account/mfa/totp",
+		MFA_TOTP_Verify_Route:        "/account/mfa/totp/verify",
+		Refresh_Token_Route:          "/account/refresh",
+		SignOut_Route:                "/account/signout",
+		SignOut_All_Route:            "/account/signout/all",
+		Reauthenticate_Route:         "/account/reauthenticate",
+		Password_Reset_Route:         "/account/password/reset",
+		Password_Reset_Confirm_Route: "/account/password/reset/confirm",
+		Email_Verify_Route:           "/account/email/verify",
+		Email_Verify_Confirm_Route:   "/account/email/verify/confirm",
+		API_Keys_Route:               "/account/api-keys",
+		API_Key_Route:                "/account/api-keys/{keyId}",
+		Sessions_Route:               "/account/sessions",
+		Session_Route:                "/account/sessions/{sessionId}",
+		JWKS_Route:                   "/.well-known/jwks.json",
+		Introspect_Route:             "/oauth/introspect",
+		Token_Route:                  "/oauth/token",
+		Authorize_Route:              "/oauth/authorize",
+		UserInfo_Route:               "/userinfo",
+		OpenID_Configuration_Route:   "/.well-known/openid-configuration",
+		Admin_Keys_Route:             "/admin/keys",
+		Admin_Key_Route:              "/admin/keys/{kid}",
+		Admin_Key_Promote_Route:      "/admin/keys/{kid}/promote",
+		Admin_Metrics_Route:          "/admin/metrics",
+		Transfer_Route:               "/transfer",
 	}
 }
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/nguyenanhhao221/go-jwt/internal/notify"
+	"github.com/nguyenanhhao221/go-jwt/util"
+)
+
+// Outcomes of a sign in attempt, as kept in the sign in history
+const (
+	signInSucceeded     = "success"
+	signInWrongPassword = "wrong_password"
+	signInWrongCode     = "wrong_code"
+	signInLocked        = "locked"
+)
+
+// Page sizes of the sign in history
+const (
+	defaultSignInHistoryLimit = 50
+	maxSignInHistoryLimit     = 200
+)
+
+// notificationSuspiciousSignIn The type of the notification sent for a suspicious sign in
+const notificationSuspiciousSignIn = "sign_in.suspicious"
+
+// SignInEventResponse A sign in attempt as listed in the history of the account
+type SignInEventResponse struct {
+	ID         uuid.UUID `json:"id"`
+	IP         string    `json:"ip"`
+	UserAgent  string    `json:"userAgent"`
+	Device     string    `json:"device"`
+	Outcome    string    `json:"outcome"`
+	Suspicious bool      `json:"suspicious"`
+	CreatedAt  time.Time `json:"createdAt"`
+}
+
+// ipRange The network the IP belongs to, a /24 for IPv4 and a /48 for IPv6,
+// as the IP of a client often changes within its network. Anything else is returned as is
+func ipRange(ip string) string {
+	parsedIP := net.ParseIP(ip)
+	if parsedIP == nil {
+		return ip
+	}
+	if ipv4 := parsedIP.To4(); ipv4 != nil {
+		return (&net.IPNet{IP: ipv4.Mask(net.CIDRMask(24, 32)), Mask: net.CIDRMask(24, 32)}).String()
+	}
+	return (&net.IPNet{IP: parsedIP.Mask(net.CIDRMask(48, 128)), Mask: net.CIDRMask(48, 128)}).String()
+}
+
+// recordSignInAttempt Add a failed attempt to the sign in history of the account, see completeSignIn for the successful ones.
+// Failures for unknown emails have no account to keep them for, they are only throttled
+func (s *APIServer) recordSignInAttempt(r *http.Request, accountId uuid.UUID, outcome string) {
+	if accountId == uuid.Nil {
+		return
+	}
+	if err := s.store.CreateSignInEvent(NewSignInEvent(accountId, clientIP(r), r.UserAgent(), outcome)); err != nil {
+		log.Printf("Failed to record the sign in attempt of account %v: %v", accountId, err)
+	}
+}
+
+// completeSignIn Add the successful sign in to the history of the account. A sign in from a device or IP range
+// the account never signed in from is flagged as suspicious, and the account is notified.
+// The first sign in of an account has nothing to compare with, it is never suspicious
+func (s *APIServer) completeSignIn(r *http.Request, accountId uuid.UUID) {
+	event := NewSignInEvent(accountId, clientIP(r), r.UserAgent(), signInSucceeded)
+	known, err := s.store.GetKnownSignIns(accountId, event.Device, event.IPRange)
+	if err != nil {
+		log.Printf("Failed to check the previous sign ins of account %v: %v", accountId, err)
+	} else {
+		event.Suspicious = known.Any && (!known.Device || !known.IPRange)
+	}
+	if err := s.store.CreateSignInEvent(event); err != nil {
+		log.Printf("Failed to record the sign in of account %v: %v", accountId, err)
+	}
+	if !event.Suspicious {
+		return
+	}
+	emitAuditEvent(AuditEvent{
+		Type:      auditSignInSuspicious,
+		AccountID: auditAccount(accountId),
+		IP:        event.IP,
+		Details:   fmt.Sprintf("Sign in from %s in %s", event.Device, event.IPRange),
+	})
+	if err := s.notifySuspiciousSignIn(event); err != nil {
+		log.Printf("Failed to notify account %v of a suspicious sign in: %v", accountId, err)
+	}
+}
+
+func (s *APIServer) notifySuspiciousSignIn(event *SignInEvent) error {
+	account, err := s.store.GetAccountById(event.AccountID)
+	if err != nil {
+		return err
+	}
+	body := fmt.Sprintf(
+		"Hi %s,\n\nYour account was just signed in to from a new device or location:\n\nDevice: %s\nIP address: %s\nTime: %s\n\n"+
+			"If this was you, you can ignore this message. Otherwise reset your password and sign out of your other sessions.\n",
+		account.FirstName, event.Device, event.IP, event.CreatedAt.Format(time.RFC1123),
+	)
+	return s.notifier.Notify(notify.Notification{
+		Type:    notificationSuspiciousSignIn,
+		To:      account.Email,
+		Subject: "New sign in to your account",
+		Body:    body,
+	})
+}
+
+// handleGetSignInHistory List the sign in attempts of the account, newest first.
+// The page size is set with limit, and the next page is fetched with before set to the createdAt of the last attempt
+func (s *APIServer) handleGetSignInHistory(w http.ResponseWriter, r *http.Request) {
+	accountId, err := util.GetIdFromRequest(r)
+	if err != nil {
+		WriteErrorJson(w, http.StatusBadRequest, fmt.Sprintf("Invalid id in request: %v", err))
+		return
+	}
+	limit := defaultSignInHistoryLimit
+	if value := r.URL.Query().Get("limit"); value != "" {
+		if limit, err = strconv.Atoi(value); err != nil || limit < 1 || limit > maxSignInHistoryLimit {
+			WriteErrorJson(w, http.StatusBadRequest, fmt.Sprintf("limit must be between 1 and %d", maxSignInHistoryLimit))
+			return
+		}
+	}
+	before := time.Now().UTC().Add(time.Second)
+	if value := r.URL.Query().Get("before"); value != "" {
+		if before, err = time.Parse(time.RFC3339Nano, value); err != nil {
+			WriteErrorJson(w, http.StatusBadRequest, "before must be a RFC 3339 time")
+			return
+		}
+		before = before.UTC()
+	}
+
+	events, err := s.store.GetSignInEvents(accountId, before, limit)
+	if err != nil {
+		WriteErrorJson(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	eventResponses := make([]SignInEventResponse, 0, len(events))
+	for _, event := range events {
+		eventResponses = append(eventResponses, SignInEventResponse{
+			ID:         event.ID,
+			IP:         event.IP,
+			UserAgent:  event.UserAgent,
+			Device:     event.Device,
+			Outcome:    event.Outcome,
+			Suspicious: event.Suspicious,
+			CreatedAt:  event.CreatedAt,
+		})
+	}
+	WriteJSON(w, http.StatusOK, eventResponses)
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/nguyenanhhao221/go-jwt/internal/notify"
+	"github.com/nguyenanhhao221/go-jwt/settings"
+)
+
+func TestIPRange(t *testing.T) {
+	tests := map[string]string{
+		"203.0.113.7":         "203.0.113.0/24",
+		"::ffff:203.0.113.7":  "203.0.113.0/24",
+		"2001:db8:1234:5::10": "2001:db8:1234::/48",
+		"not an ip":           "not an ip",
+	}
+	for ip, expected := range tests {
+		if got := ipRange(ip); got != expected {
+			t.Errorf("expected %q for %q but got %q", expected, ip, got)
+		}
+	}
+}
+
+func TestSignInHistoryCI(t *testing.T) {
+	store, err := NewPostgresStore()
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := NewAPIServer("", store)
+	notifier := &notify.FileNotifier{Path: filepath.Join(t.TempDir(), "notifications.jsonl")}
+	server.notifier = notifier
+	mockUser := NewAccount("History First Name", "History Last Name", "History@email.com", "TestPassword")
+	accountId, err := store.CreateAccount(mockUser)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.DeleteAccountById(accountId)
+
+	const firefoxOnLinux = "Mozilla/5.0 (X11; Linux x86_64; rv:121.0) Gecko/20100101 Firefox/121.0"
+	signIn := func(password, remoteAddr, userAgent string) *httptest.ResponseRecorder {
+		reqBodyJSON, err := json.Marshal(map[string]string{"email": mockUser.Email, "password": password})
+		if err != nil {
+			t.Fatal(err)
+		}
+		req, err := http.NewRequest(http.MethodPost, settings.AppSettings.SignIn_Account_Route, bytes.NewBuffer(reqBodyJSON))
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.RemoteAddr = remoteAddr
+		req.Header.Set("User-Agent", userAgent)
+		rr := httptest.NewRecorder()
+		http.HandlerFunc(server.handleSignIn).ServeHTTP(rr, req)
+		return rr
+	}
+	notifications := func() []string {
+		content, err := os.ReadFile(notifier.Path)
+		if os.IsNotExist(err) {
+			return nil
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		return strings.Split(strings.TrimSpace(string(content)), "\n")
+	}
+
+	var tokenRes TokenResponse
+	t.Run("SignIn", func(t *testing.T) {
+		if rr := signIn("TestPassword", "203.0.113.7:4000", firefoxOnLinux); rr.Code != http.StatusOK {
+			t.Fatalf("expected status code %d but got %d", http.StatusOK, rr.Code)
+		}
+		if rr := signIn("WrongPassword", "203.0.113.7:4000", firefoxOnLinux); rr.Code != http.StatusUnauthorized {
+			t.Fatalf("expected status code %d but got %d", http.StatusUnauthorized, rr.Code)
+		}
+		// Another IP of the same network on the same device is nothing new
+		rr := signIn("TestPassword", "203.0.113.42:4000", firefoxOnLinux)
+		if rr.Code != http.StatusOK {
+			t.Fatalf("expected status code %d but got %d", http.StatusOK, rr.Code)
+		}
+		if err := json.Unmarshal(rr.Body.Bytes(), &tokenRes); err != nil {
+			t.Fatal(err)
+		}
+		if sent := notifications(); len(sent) != 0 {
+			t.Errorf("expected no notification for known devices and IP ranges but got %v", sent)
+		}
+	})
+
+	t.Run("Suspicious", func(t *testing.T) {
+		if rr := signIn("TestPassword", "198.51.100.9:4000", firefoxOnLinux); rr.Code != http.StatusOK {
+			t.Fatalf("expected status code %d but got %d", http.StatusOK, rr.Code)
+		}
+		sent := notifications()
+		if len(sent) != 1 {
+			t.Fatalf("expected a notification for the new IP range but got %v", sent)
+		}
+		var notification notify.Notification
+		if err := json.Unmarshal([]byte(sent[0]), &notification); err != nil {
+			t.Fatal(err)
+		}
+		if notification.To != mockUser.Email || !strings.Contains(notification.Body, "198.51.100.9") {
+			t.Errorf("expected the account to be told about the new IP but got %v", notification)
+		}
+	})
+
+	t.Run("History", func(t *testing.T) {
+		req, err := http.NewRequest(http.MethodGet, settings.AppSettings.Sign_In_History_Route+"?limit=3", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		rctx := chi.NewRouteContext()
+		rctx.URLParams.Add("accountId", accountId.String())
+		req = req.WithContext(context.WithValue(req.Context(), chi.RouteCtxKey, rctx))
+		req.Header.Set("Authorization", "Bearer "+tokenRes.Token)
+		rr := httptest.NewRecorder()
+		server.withJWTAuth(withAccountOwner(server.handleGetSignInHistory)).ServeHTTP(rr, req)
+		if rr.Code != http.StatusOK {
+			t.Fatalf("expected status code %d but got %d", http.StatusOK, rr.Code)
+		}
+		var events []SignInEventResponse
+		if err := json.Unmarshal(rr.Body.Bytes(), &events); err != nil {
+			t.Fatal(err)
+		}
+		if len(events) != 3 {
+			t.Fatalf("expected a page of 3 sign ins but got %v", events)
+		}
+		if !events[0].Suspicious || events[0].IP != "198.51.100.9" || events[1].Suspicious {
+			t.Errorf("expected the newest sign in first and flagged as suspicious but got %v", events)
+		}
+		if events[2].Outcome != signInWrongPassword || events[2].Device != "Firefox on Linux" {
+			t.Errorf("expected the failed attempt with its device but got %v", events[2])
+		}
+	})
+}
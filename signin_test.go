@@ -0,0 +1,163 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/nguyenanhhao221/go-jwt/internal/auth"
+	"github.com/nguyenanhhao221/go-jwt/settings"
+)
+
+func TestSignInCI(t *testing.T) {
+	store, err := NewPostgresStore()
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := NewAPIServer("", store)
+	mockUser := NewAccount("Sign In First Name", "Sign In Last Name", "SignIn@email.com", "TestPassword")
+	accountId, err := store.CreateAccount(mockUser)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.DeleteAccountById(accountId)
+
+	var signInResponse TokenResponse
+	t.Run("SignIn", func(t *testing.T) {
+		reqBodyJSON, err := json.Marshal(map[string]string{"email": mockUser.Email, "password": "TestPassword"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		req, err := http.NewRequest(http.MethodPost, settings.AppSettings.SignIn_Account_Route, bytes.NewBuffer(reqBodyJSON))
+		if err != nil {
+			t.Fatal(err)
+		}
+		rr := httptest.NewRecorder()
+		http.HandlerFunc(server.handleSignIn).ServeHTTP(rr, req)
+
+		if rr.Code != http.StatusOK {
+			t.Fatalf("expected status code %d but got %d", http.StatusOK, rr.Code)
+		}
+		if err := json.Unmarshal(rr.Body.Bytes(), &signInResponse); err != nil {
+			t.Fatalf("failed to unmarshal response body %v", err)
+		}
+		if signInResponse.Token == "" || signInResponse.RefreshToken == "" {
+			t.Errorf("expected both an access token and a refresh token but got %v", signInResponse)
+		}
+	})
+
+	refresh := func(refreshToken string) *httptest.ResponseRecorder {
+		reqBodyJSON, err := json.Marshal(map[string]string{"refreshToken": refreshToken})
+		if err != nil {
+			t.Fatal(err)
+		}
+		req, err := http.NewRequest(http.MethodPost, settings.AppSettings.Refresh_Token_Route, bytes.NewBuffer(reqBodyJSON))
+		if err != nil {
+			t.Fatal(err)
+		}
+		rr := httptest.NewRecorder()
+		http.HandlerFunc(server.handleRefreshToken).ServeHTTP(rr, req)
+		return rr
+	}
+
+	var refreshResponse TokenResponse
+	t.Run("RefreshToken", func(t *testing.T) {
+		rr := refresh(signInResponse.RefreshToken)
+		if rr.Code != http.StatusOK {
+			t.Fatalf("expected status code %d but got %d", http.StatusOK, rr.Code)
+		}
+		if err := json.Unmarshal(rr.Body.Bytes(), &refreshResponse); err != nil {
+			t.Fatalf("failed to unmarshal response body %v", err)
+		}
+		if refreshResponse.RefreshToken == signInResponse.RefreshToken {
+			t.Errorf("expected the refresh token to be rotated")
+		}
+	})
+
+	t.Run("RefreshTokenReuse", func(t *testing.T) {
+		if rr := refresh(signInResponse.RefreshToken); rr.Code != http.StatusUnauthorized {
+			t.Errorf("expected reused refresh token to get status code %d but got %d", http.StatusUnauthorized, rr.Code)
+		}
+		// The reuse revokes the whole family, so the latest refresh token must be rejected too
+		if rr := refresh(refreshResponse.RefreshToken); rr.Code != http.StatusUnauthorized {
+			t.Errorf("expected revoked refresh token to get status code %d but got %d", http.StatusUnauthorized, rr.Code)
+		}
+	})
+
+	signOut := func(route string, handler http.HandlerFunc, token string) int {
+		req, err := http.NewRequest(http.MethodPost, route, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("Authorization", "Bearer "+token)
+		rr := httptest.NewRecorder()
+		server.withJWTAuth(handler).ServeHTTP(rr, req)
+		return rr.Code
+	}
+
+	t.Run("SignOut", func(t *testing.T) {
+		if code := signOut(settings.AppSettings.SignOut_Route, server.handleSignOut, refreshResponse.Token); code != http.StatusNoContent {
+			t.Fatalf("expected status code %d but got %d", http.StatusNoContent, code)
+		}
+		// The token is revoked, it can't be used to sign out again
+		if code := signOut(settings.AppSettings.SignOut_Route, server.handleSignOut, refreshResponse.Token); code != http.StatusUnauthorized {
+			t.Errorf("expected revoked token to get status code %d but got %d", http.StatusUnauthorized, code)
+		}
+	})
+
+	t.Run("SignOutAll", func(t *testing.T) {
+		tokenRes, err := server.issueTokens(tokenGrant{accountId: accountId, role: auth.RoleCustomer}, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if code := signOut(settings.AppSettings.SignOut_All_Route, server.handleSignOutAll, tokenRes.Token); code != http.StatusNoContent {
+			t.Fatalf("expected status code %d but got %d", http.StatusNoContent, code)
+		}
+		if rr := refresh(tokenRes.RefreshToken); rr.Code != http.StatusUnauthorized {
+			t.Errorf("expected refresh token to be revoked after signing out of all sessions but got %d", rr.Code)
+		}
+	})
+}
+
+func TestPasswordRehashCI(t *testing.T) {
+	store, err := NewPostgresStore()
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := NewAPIServer("", store)
+	// The account is created with an outdated bcrypt hash
+	t.Setenv("PASSWORD_HASH_ALGORITHM", "bcrypt")
+	t.Setenv("BCRYPT_COST", "4")
+	mockUser := NewAccount("Rehash First Name", "Rehash Last Name", "Rehash@email.com", "TestPassword")
+	accountId, err := store.CreateAccount(mockUser)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.DeleteAccountById(accountId)
+
+	t.Setenv("PASSWORD_HASH_ALGORITHM", "argon2id")
+	reqBodyJSON, err := json.Marshal(map[string]string{"email": mockUser.Email, "password": "TestPassword"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	req, err := http.NewRequest(http.MethodPost, settings.AppSettings.SignIn_Account_Route, bytes.NewBuffer(reqBodyJSON))
+	if err != nil {
+		t.Fatal(err)
+	}
+	rr := httptest.NewRecorder()
+	http.HandlerFunc(server.handleSignIn).ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected the bcrypt hash to still be accepted but got %d", rr.Code)
+	}
+
+	account, err := store.GetAccountByEmail(mockUser.Email)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(account.Password, "$argon2id$") {
+		t.Errorf("expected the hash to be upgraded to argon2id at sign in but got %v", account.Password)
+	}
+}
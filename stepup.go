@@ -0,0 +1,155 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/nguyenanhhao221/go-jwt/internal/auth"
+	"github.com/nguyenanhhao221/go-jwt/internal/password"
+)
+
+// defaultStepUpTransferThreshold Transfers above this balance need a recent authentication when STEP_UP_TRANSFER_THRESHOLD isn't set
+const defaultStepUpTransferThreshold = 1000
+
+// stepUpTransferThreshold Transfers of a balance above the threshold need a recent authentication,
+// configured with STEP_UP_TRANSFER_THRESHOLD
+func stepUpTransferThreshold() int64 {
+	value, exist := os.LookupEnv("STEP_UP_TRANSFER_THRESHOLD")
+	if !exist {
+		return defaultStepUpTransferThreshold
+	}
+	threshold, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || threshold < 0 {
+		log.Printf("Invalid STEP_UP_TRANSFER_THRESHOLD %q, fallback to %v", value, defaultStepUpTransferThreshold)
+		return defaultStepUpTransferThreshold
+	}
+	return threshold
+}
+
+// transferNeedsStepUp Report if the transfer in the request is above the step-up threshold.
+// The body is put back so the handler can read it again
+func transferNeedsStepUp(r *http.Request) bool {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		return true
+	}
+	r.Body = io.NopCloser(bytes.NewReader(body))
+	transferReq := new(TransferRequest)
+	// An invalid body is refused by the handler anyway
+	if err := json.Unmarshal(body, transferReq); err != nil {
+		return false
+	}
+	return transferReq.Balance > stepUpTransferThreshold()
+}
+
+// withStepUp Middleware to require a recent authentication of the account when needsStepUp reports it,
+// must be used after withJWTAuth. The client is asked to authenticate again with a RFC 9470 challenge,
+// see handleReauthenticate. Machine principals have no account to authenticate again, they only rely on their scope
+func withStepUp(next http.HandlerFunc, needsStepUp func(r *http.Request) bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		claims, ok := claimsFromContext(r.Context())
+		if !ok {
+			WriteErrorJson(w, http.StatusUnauthorized, "Permission Denied")
+			return
+		}
+		maxAge := auth.StepUpMaxAge()
+		if !claims.IsMachine() && needsStepUp(r) && !claims.AuthenticatedWithin(maxAge, time.Now()) {
+			log.Printf("Account %v needs a step-up authentication for %v", claims.ID, r.URL.Path)
+			w.Header().Set("WWW-Authenticate", fmt.Sprintf(
+				"Bearer realm=%q, error=%q, error_description=%q, max_age=%d",
+				authRealm, "insufficient_user_authentication", "A more recent authentication is required", int64(maxAge.Seconds()),
+			))
+			WriteErrorJson(w, http.StatusUnauthorized, "A more recent authentication is required")
+			return
+		}
+		next(w, r)
+	}
+}
+
+// handleReauthenticate Step-up authentication, the account enters its password or a two-factor code again
+// and gets a new access token with a recent auth_time. The refresh token is kept, refreshing doesn't renew auth_time
+func (s *APIServer) handleReauthenticate(w http.ResponseWriter, r *http.Request) {
+	type ReauthenticateReqBody struct {
+		Password string `json:"password"`
+		// Code The code from the authenticator app, or a recovery code
+		Code string `json:"code"`
+	}
+	reauthenticateReqBody := new(ReauthenticateReqBody)
+	if err := json.NewDecoder(r.Body).Decode(reauthenticateReqBody); err != nil {
+		WriteErrorJson(w, http.StatusForbidden, err.Error())
+		return
+	}
+	claims, _ := claimsFromContext(r.Context())
+
+	var amr []string
+	var lockedErr *signInLockedError
+	switch {
+	case reauthenticateReqBody.Code != "":
+		if err := s.verifySecondFactor(r, claims.ID, reauthenticateReqBody.Code); errors.As(err, &lockedErr) {
+			writeTooManyAttempts(w, lockedErr)
+			return
+		} else if err != nil {
+			WriteErrorJson(w, http.StatusForbidden, "Wrong two-factor code")
+			return
+		}
+		amr = []string{auth.AMROTP}
+	case reauthenticateReqBody.Password != "":
+		account, err := s.store.GetAccountById(claims.ID)
+		if err != nil {
+			WriteErrorJson(w, http.StatusNotFound, err.Error())
+			return
+		}
+		if _, err := s.checkPassword(r, account.Email, reauthenticateReqBody.Password); errors.As(err, &lockedErr) {
+			writeTooManyAttempts(w, lockedErr)
+			return
+		} else if errors.Is(err, password.ErrOverloaded) {
+			writeHashingOverloaded(w)
+			return
+		} else if err != nil {
+			WriteErrorJson(w, http.StatusForbidden, "Wrong password")
+			return
+		}
+		amr = []string{auth.AMRPassword}
+	default:
+		WriteErrorJson(w, http.StatusBadRequest, "A password or a two-factor code is required")
+		return
+	}
+
+	// The new token stays in the session of the one it replaces, so revoking the session still ends it
+	newClaims := &auth.CustomJWTClaims{
+		ID:        claims.ID,
+		Role:      claims.Role,
+		SessionID: claims.SessionID,
+		AuthTime:  time.Now().Unix(),
+		AMR:       amr,
+		// The new token is sent with the same key as the one it replaces
+		Confirmation: claims.Confirmation,
+	}
+	jwtToken, err := auth.SignAccessToken(newClaims)
+	if err != nil {
+		WriteErrorJson(w, http.StatusInternalServerError, fmt.Sprintf("Failed to create JWT token %v", err))
+		return
+	}
+	if sessionId, err := uuid.Parse(claims.SessionID); err == nil {
+		if err := s.store.TouchSession(sessionId, newClaims.Id, time.Now().UTC()); err != nil {
+			WriteErrorJson(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+	}
+	// The replaced token, with its old auth_time, is no longer needed
+	if err := s.revocations.RevokeToken(claims); err != nil {
+		log.Printf("Failed to revoke the token replaced by the step-up of account %v: %v", claims.ID, err)
+	}
+	tokenRes := &TokenResponse{Token: jwtToken, TokenType: tokenType(claims), ExpiresIn: int64(auth.AccessTokenTTL().Seconds())}
+	setAccessTokenCookie(w, tokenRes)
+	WriteJSON(w, http.StatusOK, tokenRes)
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/nguyenanhhao221/go-jwt/internal/auth"
+	"github.com/nguyenanhhao221/go-jwt/settings"
+)
+
+func TestStepUpMiddleware(t *testing.T) {
+	t.Setenv("STEP_UP_TRANSFER_THRESHOLD", "1000")
+	t.Setenv("STEP_UP_MAX_AGE", "5m")
+	recentAuth := time.Now().Add(-time.Minute).Unix()
+	oldAuth := time.Now().Add(-time.Hour).Unix()
+	tests := []struct {
+		name         string
+		claims       *auth.CustomJWTClaims
+		balance      int64
+		expectedCode int
+	}{
+		{"SmallTransfer", &auth.CustomJWTClaims{ID: uuid.New(), AuthTime: oldAuth}, 500, http.StatusCreated},
+		{"LargeTransferRecentAuth", &auth.CustomJWTClaims{ID: uuid.New(), AuthTime: recentAuth}, 5000, http.StatusCreated},
+		{"LargeTransferOldAuth", &auth.CustomJWTClaims{ID: uuid.New(), AuthTime: oldAuth}, 5000, http.StatusUnauthorized},
+		{"LargeTransferWithoutAuthTime", &auth.CustomJWTClaims{ID: uuid.New()}, 5000, http.StatusUnauthorized},
+		{"LargeTransferMachine", &auth.CustomJWTClaims{ClientID: "batch", Scope: auth.ScopeTransfersWrite}, 5000, http.StatusCreated},
+	}
+	server := &APIServer{}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			body, err := json.Marshal(TransferRequest{Number: 1, Balance: test.balance})
+			if err != nil {
+				t.Fatal(err)
+			}
+			req, err := http.NewRequest(http.MethodPost, settings.AppSettings.Transfer_Route, strings.NewReader(string(body)))
+			if err != nil {
+				t.Fatal(err)
+			}
+			req = req.WithContext(context.WithValue(req.Context(), claimsContextKey, test.claims))
+			rr := httptest.NewRecorder()
+			withStepUp(server.handleTransfer, transferNeedsStepUp).ServeHTTP(rr, req)
+
+			if rr.Code != test.expectedCode {
+				t.Fatalf("expected status code %d but got %d", test.expectedCode, rr.Code)
+			}
+			if rr.Code == http.StatusUnauthorized && !strings.Contains(rr.Header().Get("WWW-Authenticate"), "insufficient_user_authentication") {
+				t.Errorf("expected an insufficient_user_authentication challenge but got %q", rr.Header().Get("WWW-Authenticate"))
+			}
+			if rr.Code == http.StatusCreated {
+				var transfer TransferRequest
+				if err := json.Unmarshal(rr.Body.Bytes(), &transfer); err != nil || transfer.Balance != test.balance {
+					t.Errorf("expected the handler to read the transfer body but got %v %v", transfer, err)
+				}
+			}
+		})
+	}
+}
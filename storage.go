@@ -8,8 +8,9 @@ import (
 	"time"
 
 	"github.com/google/uuid"
-	_ "github.com/lib/pq"
-	"github.com/nguyenanhhao221/go-jwt/util"
+	"github.com/lib/pq"
+	"github.com/nguyenanhhao221/go-jwt/internal/auth"
+	"github.com/nguyenanhhao221/go-jwt/internal/password"
 )
 
 type Storage interface {
@@ -20,10 +21,92 @@ type Storage interface {
 	GetAccountByEmail(email string) (*Account, error)
 	DeleteAccountById(accountId uuid.UUID) error
 	UpdateAccountById(updateAccount *Account, accountId uuid.UUID) error
+	UpdateAccountRole(accountId uuid.UUID, role auth.Role) error
+	UpdateAccountPassword(accountId uuid.UUID, passwordHash string) error
+	createRefreshTokenTable() error
+	CreateRefreshToken(refreshToken *RefreshToken) error
+	GetRefreshTokenByHash(tokenHash string) (*RefreshToken, error)
+	RotateRefreshToken(oldTokenId uuid.UUID, newToken *RefreshToken) error
+	RevokeRefreshTokenFamily(familyId uuid.UUID) error
+	createSigningKeyTable() error
+	GetKeyRing() (*auth.KeyRing, error)
+	UpdateKeyRing(update func(ring *auth.KeyRing) error) error
+	createRevocationTables() error
+	RevokeToken(revokedToken *RevokedToken) error
+	RevokeAccountTokens(accountId uuid.UUID, revokedBefore time.Time) error
+	GetRevokedTokens() ([]RevokedToken, error)
+	GetAccountRevocations(since time.Time) (map[uuid.UUID]time.Time, error)
+	DeleteExpiredRevokedTokens(expiredBefore time.Time) error
+	createOAuthClientTable() error
+	CreateOAuthClient(client *OAuthClient) error
+	GetOAuthClient(clientId string) (*OAuthClient, error)
+	createAuthorizationTables() error
+	SaveOAuthConsent(consent *OAuthConsent) error
+	GetOAuthConsent(accountId uuid.UUID, clientId string) (*OAuthConsent, error)
+	CreateAuthorizationCode(code *AuthorizationCode) error
+	ConsumeAuthorizationCode(codeHash string, refreshFamilyId uuid.UUID) (*AuthorizationCode, error)
+	createTOTPTables() error
+	GetAccountTOTP(accountId uuid.UUID) (*AccountTOTP, error)
+	SaveTOTPSecret(accountId uuid.UUID, secret string) error
+	EnableTOTP(accountId uuid.UUID, step int64, recoveryCodeHashes []string) error
+	UseTOTPStep(accountId uuid.UUID, step int64) error
+	UseRecoveryCode(accountId uuid.UUID, codeHash string) error
+	DeleteAccountTOTP(accountId uuid.UUID) error
+	createSignInFailureTable() error
+	GetSignInLockout(keys []string) (time.Time, error)
+	CountSignInAttempt(key string, now time.Time, resetBefore time.Time, backoff func(failures int) time.Duration) (int, time.Time, error)
+	ForgiveSignInAttempt(key string, failures int) error
+	ClearSignInFailures(key string) error
+	createPasswordResetTable() error
+	CreatePasswordResetToken(token *PasswordResetToken) error
+	GetPasswordResetToken(tokenHash string) (*PasswordResetToken, error)
+	ResetPassword(tokenHash string, passwordHash string) (uuid.UUID, error)
+	createEmailVerificationTable() error
+	CreateEmailVerificationToken(token *EmailVerificationToken) error
+	VerifyEmail(tokenHash string) (uuid.UUID, error)
+	createAPIKeyTable() error
+	CreateAPIKey(apiKey *APIKey) error
+	GetAPIKeyByPrefix(prefix string) (*APIKey, error)
+	GetAPIKeys(accountId uuid.UUID) ([]APIKey, error)
+	RevokeAPIKey(accountId uuid.UUID, keyId uuid.UUID) error
+	TouchAPIKey(keyId uuid.UUID, now time.Time, notSince time.Time) error
+	createSessionTable() error
+	CreateSession(session *Session) error
+	GetSession(sessionId uuid.UUID) (*Session, error)
+	GetSessions(accountId uuid.UUID, activeSince time.Time) ([]Session, error)
+	TouchSession(sessionId uuid.UUID, jti string, now time.Time) error
+	RevokeSession(accountId uuid.UUID, sessionId uuid.UUID) error
+	GetRevokedSessions(since time.Time) (map[uuid.UUID]time.Time, error)
+	createSignInEventTable() error
+	CreateSignInEvent(event *SignInEvent) error
+	GetSignInEvents(accountId uuid.UUID, before time.Time, limit int) ([]SignInEvent, error)
+	GetKnownSignIns(accountId uuid.UUID, device string, ipRange string) (*KnownSignIns, error)
+	createPasswordlessChallengeTable() error
+	CreatePasswordlessChallenge(challenge *PasswordlessChallenge) error
+	CountPasswordlessChallenges(accountId uuid.UUID, since time.Time) (int, error)
+	UseMagicLink(tokenHash string) (uuid.UUID, error)
+	UseSignInCode(accountId uuid.UUID, code string, maxAttempts int) error
 }
 
+// ErrRefreshTokenReused Returned when rotating a refresh token that was already used or revoked
+var ErrRefreshTokenReused = errors.New("refresh token has already been used")
+
+// ErrWrongSignInCode Returned when a passwordless sign in code doesn't match the pending challenge of the account
+var ErrWrongSignInCode = errors.New("wrong sign in code")
+
+// ErrAuthorizationCodeReused Returned when an authorization code is exchanged a second time
+var ErrAuthorizationCodeReused = errors.New("authorization code has already been used")
+
+// ErrTOTPCodeReused Returned when a TOTP code is used again, or a code older than the last one used
+var ErrTOTPCodeReused = errors.New("TOTP code has already been used")
+
+// ErrSignInLocked Returned when counting a sign in attempt while sign in is locked for the key
+var ErrSignInLocked = errors.New("sign in is locked")
+
 type PostgresStore struct {
 	db *sql.DB
+	// hasher Hash the password of the accounts created
+	hasher password.Hasher
 }
 
 func NewPostgresStore() (*PostgresStore, error) {
@@ -48,14 +131,19 @@ func NewPostgresStore() (*PostgresStore, error) {
 	if err := sqlConnection.Ping(); err != nil {
 		log.Fatal("Failed to ping the database, did you forget to run Docker? Error: ", err)
 	}
+	hasher, err := password.HasherFromEnv()
+	if err != nil {
+		return nil, err
+	}
 	return &PostgresStore{
-		db: sqlConnection,
+		db:     sqlConnection,
+		hasher: hasher,
 	}, nil
 }
 
 func (s *PostgresStore) GetAllAccounts() ([]AccountResponse, error) {
 	query := `
-	SELECT id, first_name, last_name, email, number, balance, created_at
+	SELECT id, first_name, last_name, email, number, balance, created_at, role, email_verified
 	FROM account
 	`
 	rows, err := s.db.Query(query)
@@ -75,6 +163,8 @@ func (s *PostgresStore) GetAllAccounts() ([]AccountResponse, error) {
 			&account.Number,
 			&account.Balance,
 			&account.CreatedAt,
+			&account.Role,
+			&account.EmailVerified,
 		); err != nil {
 			return nil, err
 		}
@@ -87,7 +177,28 @@ func (s *PostgresStore) GetAllAccounts() ([]AccountResponse, error) {
 }
 
 func (s *PostgresStore) Init() error {
-	return s.createAccountTable()
+	createTables := []func() error{
+		s.createAccountTable,
+		s.createRefreshTokenTable,
+		s.createSigningKeyTable,
+		s.createRevocationTables,
+		s.createOAuthClientTable,
+		s.createAuthorizationTables,
+		s.createTOTPTables,
+		s.createSignInFailureTable,
+		s.createPasswordResetTable,
+		s.createEmailVerificationTable,
+		s.createAPIKeyTable,
+		s.createSessionTable,
+		s.createSignInEventTable,
+		s.createPasswordlessChallengeTable,
+	}
+	for _, createTable := range createTables {
+		if err := createTable(); err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
 func (s *PostgresStore) createAccountTable() error {
@@ -102,7 +213,11 @@ func (s *PostgresStore) createAccountTable() error {
 	number INTEGER,
 	balance INTEGER,
 	created_at TIMESTAMP
-	)`
+	);
+	ALTER TABLE ACCOUNT ADD COLUMN IF NOT EXISTS role VARCHAR(20) NOT NULL DEFAULT 'customer';
+	-- The accounts created before email verification are trusted as verified, the new ones start unverified
+	ALTER TABLE ACCOUNT ADD COLUMN IF NOT EXISTS email_verified BOOLEAN NOT NULL DEFAULT TRUE;
+	ALTER TABLE ACCOUNT ALTER COLUMN email_verified SET DEFAULT FALSE`
 	_, err := s.db.Exec(query)
 	return err
 }
@@ -116,11 +231,14 @@ type AccountResponse struct {
 	Number    int64     `json:"number"`
 	Balance   int64     `json:"balance"`
 	CreatedAt time.Time `json:"createdAt"`
+	Role      auth.Role `json:"role"`
+	// EmailVerified Set once the account opened the link sent to its email
+	EmailVerified bool `json:"emailVerified"`
 }
 
 func (s *PostgresStore) GetAccountById(accountId uuid.UUID) (*AccountResponse, error) {
 	query := `
-	SELECT id, first_name, last_name, number, balance, created_at
+	SELECT id, first_name, last_name, email, number, balance, created_at, role, email_verified
 	FROM account
 	WHERE id = $1 
 	`
@@ -130,9 +248,12 @@ func (s *PostgresStore) GetAccountById(accountId uuid.UUID) (*AccountResponse, e
 		&account.ID,
 		&account.FirstName,
 		&account.LastName,
+		&account.Email,
 		&account.Number,
 		&account.Balance,
 		&account.CreatedAt,
+		&account.Role,
+		&account.EmailVerified,
 	)
 	if err != nil {
 		return &account, err
@@ -142,7 +263,7 @@ func (s *PostgresStore) GetAccountById(accountId uuid.UUID) (*AccountResponse, e
 
 func (s *PostgresStore) GetAccountByEmail(email string) (*Account, error) {
 	query := `
-	SELECT *
+	SELECT id, first_name, last_name, email, password, number, balance, created_at, role, email_verified
 	FROM account
 	WHERE email = $1 
 	`
@@ -157,6 +278,8 @@ func (s *PostgresStore) GetAccountByEmail(email string) (*Account, error) {
 		&account.Number,
 		&account.Balance,
 		&account.CreatedAt,
+		&account.Role,
+		&account.EmailVerified,
 	)
 	if err != nil {
 		return &account, err
@@ -180,11 +303,11 @@ func (s *PostgresStore) DeleteAccountById(accountId uuid.UUID) error {
 // CreateAccount Create account in the database, also handle hashing the password
 func (s *PostgresStore) CreateAccount(newAccount *Account) (uuid.UUID, error) {
 	query := `
-	INSERT INTO ACCOUNT (first_name, last_name, number, balance, created_at, email, password)
-	VALUES ($1, $2, $3, $4, $5, $6, $7)
+	INSERT INTO ACCOUNT (first_name, last_name, number, balance, created_at, email, password, role)
+	VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
 	RETURNING ID
 	`
-	hashPassword, hashPasswordErr := util.HashPassword(newAccount.Password)
+	hashPassword, hashPasswordErr := s.hasher.Hash(newAccount.Password)
 	if hashPasswordErr != nil {
 		return uuid.Nil, hashPasswordErr
 	}
@@ -196,7 +319,7 @@ func (s *PostgresStore) CreateAccount(newAccount *Account) (uuid.UUID, error) {
 		newAccount.Number,
 		newAccount.Balance,
 		newAccount.CreatedAt,
-		newAccount.Email, hashPassword).Scan(&id)
+		newAccount.Email, hashPassword, newAccount.Role).Scan(&id)
 	if err != nil {
 		return uuid.Nil, err
 	}
@@ -219,3 +342,1469 @@ func (s *PostgresStore) UpdateAccountById(updateAccount *Account, accountId uuid
 	)
 	return err
 }
+
+func (s *PostgresStore) UpdateAccountRole(accountId uuid.UUID, role auth.Role) error {
+	query := `
+	UPDATE ACCOUNT
+	SET role = $2
+	WHERE id = $1
+	`
+	result, err := s.db.Exec(query, accountId, role)
+	if err != nil {
+		return err
+	}
+	if affected, err := result.RowsAffected(); err != nil {
+		return err
+	} else if affected == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// UpdateAccountPassword Replace the password hash of the account, e.g. when it is upgraded to the current hashing parameters
+func (s *PostgresStore) UpdateAccountPassword(accountId uuid.UUID, passwordHash string) error {
+	query := `
+	UPDATE ACCOUNT
+	SET password = $2
+	WHERE id = $1
+	`
+	_, err := s.db.Exec(query, accountId, passwordHash)
+	return err
+}
+
+func (s *PostgresStore) createRefreshTokenTable() error {
+	query := `
+	CREATE TABLE IF NOT EXISTS REFRESH_TOKEN (
+	id UUID PRIMARY KEY,
+	account_id UUID NOT NULL REFERENCES account(id) ON DELETE CASCADE,
+	family_id UUID NOT NULL,
+	token_hash VARCHAR(64) NOT NULL UNIQUE,
+	expires_at TIMESTAMP NOT NULL,
+	created_at TIMESTAMP NOT NULL,
+	used_at TIMESTAMP,
+	revoked_at TIMESTAMP
+	);
+	CREATE INDEX IF NOT EXISTS refresh_token_family_id_idx ON refresh_token (family_id);
+	ALTER TABLE REFRESH_TOKEN ADD COLUMN IF NOT EXISTS client_id VARCHAR(100) NOT NULL DEFAULT '';
+	ALTER TABLE REFRESH_TOKEN ADD COLUMN IF NOT EXISTS scope TEXT NOT NULL DEFAULT '';
+	ALTER TABLE REFRESH_TOKEN ADD COLUMN IF NOT EXISTS auth_time TIMESTAMP;
+	ALTER TABLE REFRESH_TOKEN ADD COLUMN IF NOT EXISTS amr TEXT[] NOT NULL DEFAULT '{}';
+	ALTER TABLE REFRESH_TOKEN ADD COLUMN IF NOT EXISTS dpop_jkt VARCHAR(43) NOT NULL DEFAULT ''`
+	_, err := s.db.Exec(query)
+	return err
+}
+
+func (s *PostgresStore) CreateRefreshToken(refreshToken *RefreshToken) error {
+	query := `
+	INSERT INTO REFRESH_TOKEN (id, account_id, family_id, token_hash, client_id, scope, auth_time, amr, dpop_jkt, expires_at, created_at)
+	VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)
+	`
+	_, err := s.db.Exec(
+		query,
+		refreshToken.ID,
+		refreshToken.AccountID,
+		refreshToken.FamilyID,
+		refreshToken.TokenHash,
+		refreshToken.ClientID,
+		refreshToken.Scope,
+		refreshToken.AuthTime,
+		pq.Array(refreshToken.AMR),
+		refreshToken.DPoPJKT,
+		refreshToken.ExpiresAt,
+		refreshToken.CreatedAt,
+	)
+	return err
+}
+
+func (s *PostgresStore) GetRefreshTokenByHash(tokenHash string) (*RefreshToken, error) {
+	query := `
+	SELECT id, account_id, family_id, token_hash, client_id, scope, auth_time, amr, dpop_jkt, expires_at, created_at, used_at, revoked_at
+	FROM refresh_token
+	WHERE token_hash = $1
+	`
+	var refreshToken RefreshToken
+	row := s.db.QueryRow(query, tokenHash)
+	err := row.Scan(
+		&refreshToken.ID,
+		&refreshToken.AccountID,
+		&refreshToken.FamilyID,
+		&refreshToken.TokenHash,
+		&refreshToken.ClientID,
+		&refreshToken.Scope,
+		&refreshToken.AuthTime,
+		pq.Array(&refreshToken.AMR),
+		&refreshToken.DPoPJKT,
+		&refreshToken.ExpiresAt,
+		&refreshToken.CreatedAt,
+		&refreshToken.UsedAt,
+		&refreshToken.RevokedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return &refreshToken, nil
+}
+
+// RotateRefreshToken Mark the old refresh token as used and store its replacement in one transaction.
+// If the old token was used or revoked in the meantime ErrRefreshTokenReused is returned and nothing is stored
+func (s *PostgresStore) RotateRefreshToken(oldTokenId uuid.UUID, newToken *RefreshToken) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	markUsedQuery := `
+	UPDATE REFRESH_TOKEN
+	SET used_at = $2
+	WHERE id = $1 AND used_at IS NULL AND revoked_at IS NULL
+	`
+	result, err := tx.Exec(markUsedQuery, oldTokenId, time.Now().UTC())
+	if err != nil {
+		return err
+	}
+	if affected, err := result.RowsAffected(); err != nil {
+		return err
+	} else if affected == 0 {
+		return ErrRefreshTokenReused
+	}
+
+	insertQuery := `
+	INSERT INTO REFRESH_TOKEN (id, account_id, family_id, token_hash, client_id, scope, auth_time, amr, dpop_jkt, expires_at, created_at)
+	VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)
+	`
+	if _, err := tx.Exec(
+		insertQuery,
+		newToken.ID,
+		newToken.AccountID,
+		newToken.FamilyID,
+		newToken.TokenHash,
+		newToken.ClientID,
+		newToken.Scope,
+		newToken.AuthTime,
+		pq.Array(newToken.AMR),
+		newToken.DPoPJKT,
+		newToken.ExpiresAt,
+		newToken.CreatedAt,
+	); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (s *PostgresStore) RevokeRefreshTokenFamily(familyId uuid.UUID) error {
+	query := `
+	UPDATE REFRESH_TOKEN
+	SET revoked_at = $2
+	WHERE family_id = $1 AND revoked_at IS NULL
+	`
+	_, err := s.db.Exec(query, familyId, time.Now().UTC())
+	return err
+}
+
+func (s *PostgresStore) createSigningKeyTable() error {
+	query := `
+	CREATE TABLE IF NOT EXISTS SIGNING_KEY (
+	kid VARCHAR(100) PRIMARY KEY,
+	private_key BYTEA NOT NULL,
+	status VARCHAR(20) NOT NULL,
+	created_at TIMESTAMP NOT NULL,
+	activated_at TIMESTAMP,
+	deactivated_at TIMESTAMP
+	)`
+	_, err := s.db.Exec(query)
+	return err
+}
+
+type queryer interface {
+	Query(query string, args ...interface{}) (*sql.Rows, error)
+}
+
+func getKeyRing(q queryer) (*auth.KeyRing, error) {
+	query := `
+	SELECT kid, private_key, status, created_at, activated_at, deactivated_at
+	FROM signing_key
+	ORDER BY created_at
+	`
+	rows, err := q.Query(query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var entries []*auth.KeyRingEntry
+	for rows.Next() {
+		var kid string
+		var privateKeyPEM []byte
+		var entry auth.KeyRingEntry
+		if err := rows.Scan(
+			&kid,
+			&privateKeyPEM,
+			&entry.Status,
+			&entry.CreatedAt,
+			&entry.ActivatedAt,
+			&entry.DeactivatedAt,
+		); err != nil {
+			return nil, err
+		}
+		if entry.Key, err = auth.ParseSigningKeyPEM(kid, privateKeyPEM); err != nil {
+			return nil, err
+		}
+		entries = append(entries, &entry)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return auth.NewKeyRing(entries...), nil
+}
+
+func (s *PostgresStore) GetKeyRing() (*auth.KeyRing, error) {
+	return getKeyRing(s.db)
+}
+
+// UpdateKeyRing Load the key ring, apply update and save the result in one transaction.
+// The table is locked meanwhile so concurrent rotations from the CLI and the admin API can't overwrite each other
+func (s *PostgresStore) UpdateKeyRing(update func(ring *auth.KeyRing) error) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(`LOCK TABLE signing_key IN EXCLUSIVE MODE`); err != nil {
+		return err
+	}
+	ring, err := getKeyRing(tx)
+	if err != nil {
+		return err
+	}
+	if err := update(ring); err != nil {
+		return err
+	}
+
+	upsertQuery := `
+	INSERT INTO SIGNING_KEY (kid, private_key, status, created_at, activated_at, deactivated_at)
+	VALUES ($1, $2, $3, $4, $5, $6)
+	ON CONFLICT (kid) DO UPDATE
+	SET status = EXCLUDED.status, activated_at = EXCLUDED.activated_at, deactivated_at = EXCLUDED.deactivated_at
+	`
+	kids := []string{}
+	for _, entry := range ring.Entries() {
+		privateKeyPEM, err := entry.Key.MarshalPEM()
+		if err != nil {
+			return err
+		}
+		if _, err := tx.Exec(
+			upsertQuery,
+			entry.Key.ID,
+			privateKeyPEM,
+			entry.Status,
+			entry.CreatedAt,
+			entry.ActivatedAt,
+			entry.DeactivatedAt,
+		); err != nil {
+			return err
+		}
+		kids = append(kids, entry.Key.ID)
+	}
+	if _, err := tx.Exec(`DELETE FROM signing_key WHERE NOT (kid = ANY($1))`, pq.Array(kids)); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (s *PostgresStore) createRevocationTables() error {
+	query := `
+	CREATE TABLE IF NOT EXISTS REVOKED_TOKEN (
+	jti VARCHAR(64) PRIMARY KEY,
+	account_id UUID NOT NULL,
+	expires_at TIMESTAMP NOT NULL,
+	revoked_at TIMESTAMP NOT NULL
+	);
+	CREATE TABLE IF NOT EXISTS ACCOUNT_REVOCATION (
+	account_id UUID PRIMARY KEY,
+	revoked_before TIMESTAMP NOT NULL
+	)`
+	_, err := s.db.Exec(query)
+	return err
+}
+
+func (s *PostgresStore) RevokeToken(revokedToken *RevokedToken) error {
+	query := `
+	INSERT INTO REVOKED_TOKEN (jti, account_id, expires_at, revoked_at)
+	VALUES ($1, $2, $3, $4)
+	ON CONFLICT (jti) DO NOTHING
+	`
+	_, err := s.db.Exec(query, revokedToken.JTI, revokedToken.AccountID, revokedToken.ExpiresAt, time.Now().UTC())
+	return err
+}
+
+// RevokeAccountTokens Revoke every access token of the account issued before revokedBefore,
+// and every refresh token and session of the account so no new access token can be obtained with them
+func (s *PostgresStore) RevokeAccountTokens(accountId uuid.UUID, revokedBefore time.Time) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	accountRevocationQuery := `
+	INSERT INTO ACCOUNT_REVOCATION (account_id, revoked_before)
+	VALUES ($1, $2)
+	ON CONFLICT (account_id) DO UPDATE SET revoked_before = EXCLUDED.revoked_before
+	`
+	if _, err := tx.Exec(accountRevocationQuery, accountId, revokedBefore); err != nil {
+		return err
+	}
+	refreshTokenQuery := `
+	UPDATE REFRESH_TOKEN
+	SET revoked_at = $2
+	WHERE account_id = $1 AND revoked_at IS NULL
+	`
+	if _, err := tx.Exec(refreshTokenQuery, accountId, time.Now().UTC()); err != nil {
+		return err
+	}
+	sessionQuery := `
+	UPDATE ACCOUNT_SESSION
+	SET revoked_at = $2
+	WHERE account_id = $1 AND revoked_at IS NULL
+	`
+	if _, err := tx.Exec(sessionQuery, accountId, time.Now().UTC()); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (s *PostgresStore) GetRevokedTokens() ([]RevokedToken, error) {
+	query := `
+	SELECT jti, account_id, expires_at
+	FROM revoked_token
+	`
+	rows, err := s.db.Query(query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var revokedTokens []RevokedToken
+	for rows.Next() {
+		var revokedToken RevokedToken
+		if err := rows.Scan(&revokedToken.JTI, &revokedToken.AccountID, &revokedToken.ExpiresAt); err != nil {
+			return nil, err
+		}
+		revokedTokens = append(revokedTokens, revokedToken)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return revokedTokens, nil
+}
+
+// GetAccountRevocations The time before which the tokens of an account are revoked, for revocations done after since
+func (s *PostgresStore) GetAccountRevocations(since time.Time) (map[uuid.UUID]time.Time, error) {
+	query := `
+	SELECT account_id, revoked_before
+	FROM account_revocation
+	WHERE revoked_before > $1
+	`
+	rows, err := s.db.Query(query, since)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	revocations := map[uuid.UUID]time.Time{}
+	for rows.Next() {
+		var accountId uuid.UUID
+		var revokedBefore time.Time
+		if err := rows.Scan(&accountId, &revokedBefore); err != nil {
+			return nil, err
+		}
+		revocations[accountId] = revokedBefore
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return revocations, nil
+}
+
+// DeleteExpiredRevokedTokens Tokens which expired before expiredBefore are rejected anyway, so they don't need to stay in the revocation list
+func (s *PostgresStore) DeleteExpiredRevokedTokens(expiredBefore time.Time) error {
+	_, err := s.db.Exec(`DELETE FROM revoked_token WHERE expires_at < $1`, expiredBefore)
+	return err
+}
+
+func (s *PostgresStore) createOAuthClientTable() error {
+	query := `
+	CREATE TABLE IF NOT EXISTS OAUTH_CLIENT (
+	client_id VARCHAR(100) PRIMARY KEY,
+	name VARCHAR(100) NOT NULL,
+	client_secret_hash VARCHAR(64) NOT NULL,
+	created_at TIMESTAMP NOT NULL
+	);
+	ALTER TABLE OAUTH_CLIENT ADD COLUMN IF NOT EXISTS allowed_scopes TEXT[] NOT NULL DEFAULT '{}';
+	ALTER TABLE OAUTH_CLIENT ADD COLUMN IF NOT EXISTS redirect_uris TEXT[] NOT NULL DEFAULT '{}';
+	ALTER TABLE OAUTH_CLIENT ADD COLUMN IF NOT EXISTS public BOOLEAN NOT NULL DEFAULT false`
+	_, err := s.db.Exec(query)
+	return err
+}
+
+func (s *PostgresStore) CreateOAuthClient(client *OAuthClient) error {
+	query := `
+	INSERT INTO OAUTH_CLIENT (client_id, name, client_secret_hash, allowed_scopes, redirect_uris, public, created_at)
+	VALUES ($1, $2, $3, $4, $5, $6, $7)
+	`
+	_, err := s.db.Exec(
+		query,
+		client.ID,
+		client.Name,
+		client.ClientSecretHash,
+		pq.Array(client.AllowedScopes),
+		pq.Array(client.RedirectURIs),
+		client.Public,
+		client.CreatedAt,
+	)
+	return err
+}
+
+func (s *PostgresStore) GetOAuthClient(clientId string) (*OAuthClient, error) {
+	query := `
+	SELECT client_id, name, client_secret_hash, allowed_scopes, redirect_uris, public, created_at
+	FROM oauth_client
+	WHERE client_id = $1
+	`
+	var client OAuthClient
+	row := s.db.QueryRow(query, clientId)
+	err := row.Scan(
+		&client.ID,
+		&client.Name,
+		&client.ClientSecretHash,
+		pq.Array(&client.AllowedScopes),
+		pq.Array(&client.RedirectURIs),
+		&client.Public,
+		&client.CreatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return &client, nil
+}
+
+func (s *PostgresStore) createAuthorizationTables() error {
+	query := `
+	CREATE TABLE IF NOT EXISTS OAUTH_CONSENT (
+	account_id UUID NOT NULL REFERENCES account(id) ON DELETE CASCADE,
+	client_id VARCHAR(100) NOT NULL REFERENCES oauth_client(client_id) ON DELETE CASCADE,
+	scope TEXT NOT NULL,
+	granted_at TIMESTAMP NOT NULL,
+	PRIMARY KEY (account_id, client_id)
+	);
+	CREATE TABLE IF NOT EXISTS AUTHORIZATION_CODE (
+	code_hash VARCHAR(64) PRIMARY KEY,
+	client_id VARCHAR(100) NOT NULL REFERENCES oauth_client(client_id) ON DELETE CASCADE,
+	account_id UUID NOT NULL REFERENCES account(id) ON DELETE CASCADE,
+	redirect_uri TEXT NOT NULL,
+	scope TEXT NOT NULL,
+	code_challenge VARCHAR(128) NOT NULL,
+	expires_at TIMESTAMP NOT NULL,
+	created_at TIMESTAMP NOT NULL,
+	used_at TIMESTAMP,
+	refresh_family_id UUID
+	);
+	ALTER TABLE AUTHORIZATION_CODE ADD COLUMN IF NOT EXISTS nonce TEXT NOT NULL DEFAULT '';
+	ALTER TABLE AUTHORIZATION_CODE ADD COLUMN IF NOT EXISTS amr TEXT[] NOT NULL DEFAULT '{}'`
+	_, err := s.db.Exec(query)
+	return err
+}
+
+// SaveOAuthConsent Record the scopes an account granted to a client, replacing the previous consent
+func (s *PostgresStore) SaveOAuthConsent(consent *OAuthConsent) error {
+	query := `
+	INSERT INTO OAUTH_CONSENT (account_id, client_id, scope, granted_at)
+	VALUES ($1, $2, $3, $4)
+	ON CONFLICT (account_id, client_id) DO UPDATE SET scope = EXCLUDED.scope, granted_at = EXCLUDED.granted_at
+	`
+	_, err := s.db.Exec(query, consent.AccountID, consent.ClientID, consent.Scope, consent.GrantedAt)
+	return err
+}
+
+// GetOAuthConsent The scopes the account granted to the client, sql.ErrNoRows when it never did
+func (s *PostgresStore) GetOAuthConsent(accountId uuid.UUID, clientId string) (*OAuthConsent, error) {
+	query := `
+	SELECT account_id, client_id, scope, granted_at
+	FROM oauth_consent
+	WHERE account_id = $1 AND client_id = $2
+	`
+	consent := &OAuthConsent{}
+	if err := s.db.QueryRow(query, accountId, clientId).Scan(&consent.AccountID, &consent.ClientID, &consent.Scope, &consent.GrantedAt); err != nil {
+		return nil, err
+	}
+	return consent, nil
+}
+
+func (s *PostgresStore) CreateAuthorizationCode(code *AuthorizationCode) error {
+	query := `
+	INSERT INTO AUTHORIZATION_CODE (code_hash, client_id, account_id, redirect_uri, scope, code_challenge, nonce, amr, expires_at, created_at)
+	VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)
+	`
+	_, err := s.db.Exec(
+		query,
+		code.CodeHash,
+		code.ClientID,
+		code.AccountID,
+		code.RedirectURI,
+		code.Scope,
+		code.CodeChallenge,
+		code.Nonce,
+		pq.Array(code.AMR),
+		code.ExpiresAt,
+		code.CreatedAt,
+	)
+	return err
+}
+
+// ConsumeAuthorizationCode Mark the authorization code as used and link it to the refresh token family it is exchanged for.
+// A code can only be consumed once: the second time the refresh token family of the first exchange is revoked,
+// as the code was most likely intercepted, and ErrAuthorizationCodeReused is returned.
+// sql.ErrNoRows is returned for an unknown code
+func (s *PostgresStore) ConsumeAuthorizationCode(codeHash string, refreshFamilyId uuid.UUID) (*AuthorizationCode, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	query := `
+	SELECT code_hash, client_id, account_id, redirect_uri, scope, code_challenge, nonce, amr, expires_at, created_at, used_at, refresh_family_id
+	FROM authorization_code
+	WHERE code_hash = $1
+	FOR UPDATE
+	`
+	var code AuthorizationCode
+	var usedFamilyId uuid.NullUUID
+	row := tx.QueryRow(query, codeHash)
+	if err := row.Scan(
+		&code.CodeHash,
+		&code.ClientID,
+		&code.AccountID,
+		&code.RedirectURI,
+		&code.Scope,
+		&code.CodeChallenge,
+		&code.Nonce,
+		pq.Array(&code.AMR),
+		&code.ExpiresAt,
+		&code.CreatedAt,
+		&code.UsedAt,
+		&usedFamilyId,
+	); err != nil {
+		return nil, err
+	}
+
+	if code.UsedAt != nil {
+		if usedFamilyId.Valid {
+			revokeQuery := `
+			UPDATE REFRESH_TOKEN
+			SET revoked_at = $2
+			WHERE family_id = $1 AND revoked_at IS NULL
+			`
+			if _, err := tx.Exec(revokeQuery, usedFamilyId.UUID, time.Now().UTC()); err != nil {
+				return nil, err
+			}
+		}
+		if err := tx.Commit(); err != nil {
+			return nil, err
+		}
+		return &code, ErrAuthorizationCodeReused
+	}
+
+	now := time.Now().UTC()
+	markUsedQuery := `
+	UPDATE AUTHORIZATION_CODE
+	SET used_at = $2, refresh_family_id = $3
+	WHERE code_hash = $1
+	`
+	if _, err := tx.Exec(markUsedQuery, codeHash, now, refreshFamilyId); err != nil {
+		return nil, err
+	}
+	code.UsedAt = &now
+	return &code, tx.Commit()
+}
+
+func (s *PostgresStore) createTOTPTables() error {
+	query := `
+	CREATE TABLE IF NOT EXISTS ACCOUNT_TOTP (
+	account_id UUID PRIMARY KEY REFERENCES account(id) ON DELETE CASCADE,
+	secret VARCHAR(64) NOT NULL,
+	created_at TIMESTAMP NOT NULL,
+	enabled_at TIMESTAMP,
+	last_used_step BIGINT NOT NULL DEFAULT 0
+	);
+	CREATE TABLE IF NOT EXISTS RECOVERY_CODE (
+	account_id UUID NOT NULL REFERENCES account(id) ON DELETE CASCADE,
+	code_hash VARCHAR(64) NOT NULL,
+	used_at TIMESTAMP,
+	PRIMARY KEY (account_id, code_hash)
+	)`
+	_, err := s.db.Exec(query)
+	return err
+}
+
+// GetAccountTOTP The TOTP enrollment of the account, sql.ErrNoRows when the account never started one
+func (s *PostgresStore) GetAccountTOTP(accountId uuid.UUID) (*AccountTOTP, error) {
+	query := `
+	SELECT account_id, secret, created_at, enabled_at, last_used_step
+	FROM account_totp
+	WHERE account_id = $1
+	`
+	var accountTOTP AccountTOTP
+	row := s.db.QueryRow(query, accountId)
+	err := row.Scan(
+		&accountTOTP.AccountID,
+		&accountTOTP.Secret,
+		&accountTOTP.CreatedAt,
+		&accountTOTP.EnabledAt,
+		&accountTOTP.LastUsedStep,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return &accountTOTP, nil
+}
+
+// SaveTOTPSecret Start a TOTP enrollment, replacing one that wasn't verified yet.
+// sql.ErrNoRows is returned when TOTP is already enabled for the account
+func (s *PostgresStore) SaveTOTPSecret(accountId uuid.UUID, secret string) error {
+	query := `
+	INSERT INTO ACCOUNT_TOTP (account_id, secret, created_at)
+	VALUES ($1, $2, $3)
+	ON CONFLICT (account_id) DO UPDATE SET secret = EXCLUDED.secret, created_at = EXCLUDED.created_at
+	WHERE account_totp.enabled_at IS NULL
+	`
+	result, err := s.db.Exec(query, accountId, secret, time.Now().UTC())
+	if err != nil {
+		return err
+	}
+	if affected, err := result.RowsAffected(); err != nil {
+		return err
+	} else if affected == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// EnableTOTP Enable TOTP once the account proved its app has the secret, the verified step can't be used again.
+// The recovery codes replace any previous ones
+func (s *PostgresStore) EnableTOTP(accountId uuid.UUID, step int64, recoveryCodeHashes []string) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	enableQuery := `
+	UPDATE ACCOUNT_TOTP
+	SET enabled_at = $2, last_used_step = $3
+	WHERE account_id = $1 AND enabled_at IS NULL
+	`
+	result, err := tx.Exec(enableQuery, accountId, time.Now().UTC(), step)
+	if err != nil {
+		return err
+	}
+	if affected, err := result.RowsAffected(); err != nil {
+		return err
+	} else if affected == 0 {
+		return sql.ErrNoRows
+	}
+
+	if _, err := tx.Exec(`DELETE FROM RECOVERY_CODE WHERE account_id = $1`, accountId); err != nil {
+		return err
+	}
+	insertQuery := `
+	INSERT INTO RECOVERY_CODE (account_id, code_hash)
+	SELECT $1, unnest($2::TEXT[])
+	`
+	if _, err := tx.Exec(insertQuery, accountId, pq.Array(recoveryCodeHashes)); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// UseTOTPStep Record the step of a verified TOTP code, ErrTOTPCodeReused is returned
+// if the step, or a later one, was already used so a code can't be replayed
+func (s *PostgresStore) UseTOTPStep(accountId uuid.UUID, step int64) error {
+	query := `
+	UPDATE ACCOUNT_TOTP
+	SET last_used_step = $2
+	WHERE account_id = $1 AND enabled_at IS NOT NULL AND last_used_step < $2
+	`
+	result, err := s.db.Exec(query, accountId, step)
+	if err != nil {
+		return err
+	}
+	if affected, err := result.RowsAffected(); err != nil {
+		return err
+	} else if affected == 0 {
+		return ErrTOTPCodeReused
+	}
+	return nil
+}
+
+// UseRecoveryCode Mark the recovery code as used, sql.ErrNoRows is returned for an unknown or already used code
+func (s *PostgresStore) UseRecoveryCode(accountId uuid.UUID, codeHash string) error {
+	query := `
+	UPDATE RECOVERY_CODE
+	SET used_at = $3
+	WHERE account_id = $1 AND code_hash = $2 AND used_at IS NULL
+	`
+	result, err := s.db.Exec(query, accountId, codeHash, time.Now().UTC())
+	if err != nil {
+		return err
+	}
+	if affected, err := result.RowsAffected(); err != nil {
+		return err
+	} else if affected == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// DeleteAccountTOTP Disable TOTP for the account and delete its recovery codes
+func (s *PostgresStore) DeleteAccountTOTP(accountId uuid.UUID) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(`DELETE FROM ACCOUNT_TOTP WHERE account_id = $1`, accountId); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`DELETE FROM RECOVERY_CODE WHERE account_id = $1`, accountId); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (s *PostgresStore) createSignInFailureTable() error {
+	query := `
+	CREATE TABLE IF NOT EXISTS SIGN_IN_FAILURE (
+	key VARCHAR(100) PRIMARY KEY,
+	failures INTEGER NOT NULL,
+	last_failure_at TIMESTAMP NOT NULL,
+	locked_until TIMESTAMP
+	)`
+	_, err := s.db.Exec(query)
+	return err
+}
+
+// GetSignInLockout The time until which sign in is locked for any of the keys, zero when none is locked
+func (s *PostgresStore) GetSignInLockout(keys []string) (time.Time, error) {
+	query := `
+	SELECT MAX(locked_until)
+	FROM sign_in_failure
+	WHERE key = ANY($1)
+	`
+	var lockedUntil sql.NullTime
+	if err := s.db.QueryRow(query, pq.Array(keys)).Scan(&lockedUntil); err != nil {
+		return time.Time{}, err
+	}
+	return lockedUntil.Time, nil
+}
+
+// CountSignInAttempt Count an attempt as a failure for the key before its credentials are checked,
+// and lock sign in for backoff(failures). The row is locked from the lockout check to the count,
+// so concurrent attempts are counted one after the other and can't all get in before the first one locks.
+// ErrSignInLocked is returned with the end of the lockout, and nothing counted, while the key is locked.
+// The count starts again when the last failure is older than resetBefore
+func (s *PostgresStore) CountSignInAttempt(key string, now time.Time, resetBefore time.Time, backoff func(failures int) time.Duration) (int, time.Time, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return 0, time.Time{}, err
+	}
+	defer tx.Rollback()
+
+	insertQuery := `
+	INSERT INTO SIGN_IN_FAILURE (key, failures, last_failure_at)
+	VALUES ($1, 0, $2)
+	ON CONFLICT (key) DO NOTHING
+	`
+	if _, err := tx.Exec(insertQuery, key, now); err != nil {
+		return 0, time.Time{}, err
+	}
+	selectQuery := `
+	SELECT failures, last_failure_at, locked_until
+	FROM sign_in_failure
+	WHERE key = $1
+	FOR UPDATE
+	`
+	var failures int
+	var lastFailureAt time.Time
+	var lockedUntil sql.NullTime
+	if err := tx.QueryRow(selectQuery, key).Scan(&failures, &lastFailureAt, &lockedUntil); err != nil {
+		return 0, time.Time{}, err
+	}
+	if lockedUntil.Valid && lockedUntil.Time.After(now) {
+		return 0, lockedUntil.Time, ErrSignInLocked
+	}
+	if lastFailureAt.Before(resetBefore) {
+		failures = 0
+	}
+	failures++
+	newLockedUntil := sql.NullTime{}
+	if duration := backoff(failures); duration > 0 {
+		newLockedUntil = sql.NullTime{Time: now.Add(duration), Valid: true}
+	}
+	updateQuery := `
+	UPDATE SIGN_IN_FAILURE
+	SET failures = $2, last_failure_at = $3, locked_until = $4
+	WHERE key = $1
+	`
+	if _, err := tx.Exec(updateQuery, key, failures, now, newLockedUntil); err != nil {
+		return 0, time.Time{}, err
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, time.Time{}, err
+	}
+	return failures, newLockedUntil.Time, nil
+}
+
+// ForgiveSignInAttempt Take back an attempt counted by CountSignInAttempt once its credentials were right.
+// failures is the count the attempt got, the lock it set is only lifted when no other attempt was counted since
+func (s *PostgresStore) ForgiveSignInAttempt(key string, failures int) error {
+	query := `
+	UPDATE SIGN_IN_FAILURE
+	SET locked_until = CASE WHEN failures = $2 THEN NULL ELSE locked_until END,
+	failures = GREATEST(failures - 1, 0)
+	WHERE key = $1
+	`
+	_, err := s.db.Exec(query, key, failures)
+	return err
+}
+
+// ClearSignInFailures Forget the failures of the key after a successful sign in
+func (s *PostgresStore) ClearSignInFailures(key string) error {
+	_, err := s.db.Exec(`DELETE FROM SIGN_IN_FAILURE WHERE key = $1`, key)
+	return err
+}
+
+func (s *PostgresStore) createPasswordResetTable() error {
+	query := `
+	CREATE TABLE IF NOT EXISTS PASSWORD_RESET_TOKEN (
+	token_hash VARCHAR(64) PRIMARY KEY,
+	account_id UUID NOT NULL REFERENCES account(id) ON DELETE CASCADE,
+	expires_at TIMESTAMP NOT NULL,
+	created_at TIMESTAMP NOT NULL,
+	used_at TIMESTAMP
+	)`
+	_, err := s.db.Exec(query)
+	return err
+}
+
+func (s *PostgresStore) CreatePasswordResetToken(token *PasswordResetToken) error {
+	query := `
+	INSERT INTO PASSWORD_RESET_TOKEN (token_hash, account_id, expires_at, created_at)
+	VALUES ($1, $2, $3, $4)
+	`
+	_, err := s.db.Exec(query, token.TokenHash, token.AccountID, token.ExpiresAt, token.CreatedAt)
+	return err
+}
+
+// GetPasswordResetToken The pending password reset token, sql.ErrNoRows is returned for an unknown, expired or already used token
+func (s *PostgresStore) GetPasswordResetToken(tokenHash string) (*PasswordResetToken, error) {
+	query := `
+	SELECT token_hash, account_id, expires_at, created_at, used_at
+	FROM password_reset_token
+	WHERE token_hash = $1 AND used_at IS NULL AND expires_at > $2
+	`
+	var token PasswordResetToken
+	row := s.db.QueryRow(query, tokenHash, time.Now().UTC())
+	if err := row.Scan(&token.TokenHash, &token.AccountID, &token.ExpiresAt, &token.CreatedAt, &token.UsedAt); err != nil {
+		return nil, err
+	}
+	return &token, nil
+}
+
+// ResetPassword Consume the password reset token and replace the password of its account.
+// Every other pending reset token of the account is used up too.
+// sql.ErrNoRows is returned for an unknown, expired or already used token
+func (s *PostgresStore) ResetPassword(tokenHash string, passwordHash string) (uuid.UUID, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return uuid.Nil, err
+	}
+	defer tx.Rollback()
+
+	now := time.Now().UTC()
+	query := `
+	SELECT account_id
+	FROM password_reset_token
+	WHERE token_hash = $1 AND used_at IS NULL AND expires_at > $2
+	FOR UPDATE
+	`
+	var accountId uuid.UUID
+	if err := tx.QueryRow(query, tokenHash, now).Scan(&accountId); err != nil {
+		return uuid.Nil, err
+	}
+	if _, err := tx.Exec(`UPDATE ACCOUNT SET password = $2 WHERE id = $1`, accountId, passwordHash); err != nil {
+		return uuid.Nil, err
+	}
+	markUsedQuery := `
+	UPDATE PASSWORD_RESET_TOKEN
+	SET used_at = $2
+	WHERE account_id = $1 AND used_at IS NULL
+	`
+	if _, err := tx.Exec(markUsedQuery, accountId, now); err != nil {
+		return uuid.Nil, err
+	}
+	return accountId, tx.Commit()
+}
+
+func (s *PostgresStore) createEmailVerificationTable() error {
+	query := `
+	CREATE TABLE IF NOT EXISTS EMAIL_VERIFICATION_TOKEN (
+	token_hash VARCHAR(64) PRIMARY KEY,
+	account_id UUID NOT NULL REFERENCES account(id) ON DELETE CASCADE,
+	expires_at TIMESTAMP NOT NULL,
+	created_at TIMESTAMP NOT NULL,
+	used_at TIMESTAMP
+	)`
+	_, err := s.db.Exec(query)
+	return err
+}
+
+func (s *PostgresStore) CreateEmailVerificationToken(token *EmailVerificationToken) error {
+	query := `
+	INSERT INTO EMAIL_VERIFICATION_TOKEN (token_hash, account_id, expires_at, created_at)
+	VALUES ($1, $2, $3, $4)
+	`
+	_, err := s.db.Exec(query, token.TokenHash, token.AccountID, token.ExpiresAt, token.CreatedAt)
+	return err
+}
+
+// VerifyEmail Consume the email verification token and mark the email of its account as verified.
+// Every other pending verification token of the account is used up too.
+// sql.ErrNoRows is returned for an unknown, expired or already used token
+func (s *PostgresStore) VerifyEmail(tokenHash string) (uuid.UUID, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return uuid.Nil, err
+	}
+	defer tx.Rollback()
+
+	now := time.Now().UTC()
+	query := `
+	SELECT account_id
+	FROM email_verification_token
+	WHERE token_hash = $1 AND used_at IS NULL AND expires_at > $2
+	FOR UPDATE
+	`
+	var accountId uuid.UUID
+	if err := tx.QueryRow(query, tokenHash, now).Scan(&accountId); err != nil {
+		return uuid.Nil, err
+	}
+	if _, err := tx.Exec(`UPDATE ACCOUNT SET email_verified = TRUE WHERE id = $1`, accountId); err != nil {
+		return uuid.Nil, err
+	}
+	markUsedQuery := `
+	UPDATE EMAIL_VERIFICATION_TOKEN
+	SET used_at = $2
+	WHERE account_id = $1 AND used_at IS NULL
+	`
+	if _, err := tx.Exec(markUsedQuery, accountId, now); err != nil {
+		return uuid.Nil, err
+	}
+	return accountId, tx.Commit()
+}
+
+func (s *PostgresStore) createAPIKeyTable() error {
+	query := `
+	CREATE TABLE IF NOT EXISTS API_KEY (
+	id UUID PRIMARY KEY,
+	account_id UUID NOT NULL REFERENCES account(id) ON DELETE CASCADE,
+	name VARCHAR(100) NOT NULL,
+	prefix VARCHAR(32) NOT NULL UNIQUE,
+	key_hash VARCHAR(64) NOT NULL,
+	scope TEXT NOT NULL,
+	created_at TIMESTAMP NOT NULL,
+	expires_at TIMESTAMP,
+	last_used_at TIMESTAMP,
+	revoked_at TIMESTAMP
+	);
+	CREATE INDEX IF NOT EXISTS api_key_account_id_idx ON API_KEY (account_id)`
+	_, err := s.db.Exec(query)
+	return err
+}
+
+func (s *PostgresStore) CreateAPIKey(apiKey *APIKey) error {
+	query := `
+	INSERT INTO API_KEY (id, account_id, name, prefix, key_hash, scope, created_at, expires_at)
+	VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+	`
+	_, err := s.db.Exec(
+		query,
+		apiKey.ID,
+		apiKey.AccountID,
+		apiKey.Name,
+		apiKey.Prefix,
+		apiKey.KeyHash,
+		apiKey.Scope,
+		apiKey.CreatedAt,
+		apiKey.ExpiresAt,
+	)
+	return err
+}
+
+const apiKeyColumns = `id, account_id, name, prefix, key_hash, scope, created_at, expires_at, last_used_at, revoked_at`
+
+// rowScanner Either a *sql.Row or *sql.Rows
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanAPIKey(row rowScanner) (*APIKey, error) {
+	var apiKey APIKey
+	if err := row.Scan(
+		&apiKey.ID,
+		&apiKey.AccountID,
+		&apiKey.Name,
+		&apiKey.Prefix,
+		&apiKey.KeyHash,
+		&apiKey.Scope,
+		&apiKey.CreatedAt,
+		&apiKey.ExpiresAt,
+		&apiKey.LastUsedAt,
+		&apiKey.RevokedAt,
+	); err != nil {
+		return nil, err
+	}
+	return &apiKey, nil
+}
+
+// GetAPIKeyByPrefix The API key with the prefix, revoked or expired ones included
+func (s *PostgresStore) GetAPIKeyByPrefix(prefix string) (*APIKey, error) {
+	query := `SELECT ` + apiKeyColumns + ` FROM api_key WHERE prefix = $1`
+	return scanAPIKey(s.db.QueryRow(query, prefix))
+}
+
+// GetAPIKeys The API keys of the account which aren't revoked, newest first
+func (s *PostgresStore) GetAPIKeys(accountId uuid.UUID) ([]APIKey, error) {
+	query := `SELECT ` + apiKeyColumns + ` FROM api_key WHERE account_id = $1 AND revoked_at IS NULL ORDER BY created_at DESC`
+	rows, err := s.db.Query(query, accountId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var apiKeys []APIKey
+	for rows.Next() {
+		apiKey, err := scanAPIKey(rows)
+		if err != nil {
+			return nil, err
+		}
+		apiKeys = append(apiKeys, *apiKey)
+	}
+	return apiKeys, rows.Err()
+}
+
+// RevokeAPIKey Revoke the API key of the account, sql.ErrNoRows is returned when the account has no such active key
+func (s *PostgresStore) RevokeAPIKey(accountId uuid.UUID, keyId uuid.UUID) error {
+	query := `
+	UPDATE API_KEY
+	SET revoked_at = $3
+	WHERE id = $1 AND account_id = $2 AND revoked_at IS NULL
+	`
+	result, err := s.db.Exec(query, keyId, accountId, time.Now().UTC())
+	if err != nil {
+		return err
+	}
+	if affected, err := result.RowsAffected(); err != nil {
+		return err
+	} else if affected == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// TouchAPIKey Record the use of the API key, unless it was already recorded since notSince to spare a write on every request
+func (s *PostgresStore) TouchAPIKey(keyId uuid.UUID, now time.Time, notSince time.Time) error {
+	query := `
+	UPDATE API_KEY
+	SET last_used_at = $2
+	WHERE id = $1 AND (last_used_at IS NULL OR last_used_at < $3)
+	`
+	_, err := s.db.Exec(query, keyId, now, notSince)
+	return err
+}
+
+func (s *PostgresStore) createSessionTable() error {
+	query := `
+	CREATE TABLE IF NOT EXISTS ACCOUNT_SESSION (
+	id UUID PRIMARY KEY,
+	account_id UUID NOT NULL REFERENCES account(id) ON DELETE CASCADE,
+	device_label VARCHAR(100) NOT NULL,
+	user_agent TEXT NOT NULL,
+	ip VARCHAR(64) NOT NULL,
+	access_token_jti VARCHAR(64) NOT NULL DEFAULT '',
+	created_at TIMESTAMP NOT NULL,
+	last_seen_at TIMESTAMP NOT NULL,
+	revoked_at TIMESTAMP
+	);
+	CREATE INDEX IF NOT EXISTS account_session_account_id_idx ON ACCOUNT_SESSION (account_id)`
+	_, err := s.db.Exec(query)
+	return err
+}
+
+func (s *PostgresStore) CreateSession(session *Session) error {
+	query := `
+	INSERT INTO ACCOUNT_SESSION (id, account_id, device_label, user_agent, ip, access_token_jti, created_at, last_seen_at)
+	VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+	`
+	_, err := s.db.Exec(
+		query,
+		session.ID,
+		session.AccountID,
+		session.DeviceLabel,
+		session.UserAgent,
+		session.IP,
+		session.JTI,
+		session.CreatedAt,
+		session.LastSeenAt,
+	)
+	return err
+}
+
+const sessionColumns = `id, account_id, device_label, user_agent, ip, access_token_jti, created_at, last_seen_at, revoked_at`
+
+func scanSession(row rowScanner) (*Session, error) {
+	var session Session
+	if err := row.Scan(
+		&session.ID,
+		&session.AccountID,
+		&session.DeviceLabel,
+		&session.UserAgent,
+		&session.IP,
+		&session.JTI,
+		&session.CreatedAt,
+		&session.LastSeenAt,
+		&session.RevokedAt,
+	); err != nil {
+		return nil, err
+	}
+	return &session, nil
+}
+
+// GetSession The session with the id, revoked ones included
+func (s *PostgresStore) GetSession(sessionId uuid.UUID) (*Session, error) {
+	query := `SELECT ` + sessionColumns + ` FROM account_session WHERE id = $1`
+	return scanSession(s.db.QueryRow(query, sessionId))
+}
+
+// GetSessions The sessions of the account which aren't revoked and were seen after activeSince, most recently seen first
+func (s *PostgresStore) GetSessions(accountId uuid.UUID, activeSince time.Time) ([]Session, error) {
+	query := `SELECT ` + sessionColumns + ` FROM account_session WHERE account_id = $1 AND revoked_at IS NULL AND last_seen_at > $2 ORDER BY last_seen_at DESC`
+	rows, err := s.db.Query(query, accountId, activeSince)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var sessions []Session
+	for rows.Next() {
+		session, err := scanSession(rows)
+		if err != nil {
+			return nil, err
+		}
+		sessions = append(sessions, *session)
+	}
+	return sessions, rows.Err()
+}
+
+// TouchSession Record the access token just issued to the session
+func (s *PostgresStore) TouchSession(sessionId uuid.UUID, jti string, now time.Time) error {
+	query := `
+	UPDATE ACCOUNT_SESSION
+	SET access_token_jti = $2, last_seen_at = $3
+	WHERE id = $1
+	`
+	_, err := s.db.Exec(query, sessionId, jti, now)
+	return err
+}
+
+// RevokeSession Revoke the session of the account and its refresh tokens in one transaction,
+// sql.ErrNoRows is returned when the account has no such active session
+func (s *PostgresStore) RevokeSession(accountId uuid.UUID, sessionId uuid.UUID) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	now := time.Now().UTC()
+	sessionQuery := `
+	UPDATE ACCOUNT_SESSION
+	SET revoked_at = $3
+	WHERE id = $1 AND account_id = $2 AND revoked_at IS NULL
+	`
+	result, err := tx.Exec(sessionQuery, sessionId, accountId, now)
+	if err != nil {
+		return err
+	}
+	if affected, err := result.RowsAffected(); err != nil {
+		return err
+	} else if affected == 0 {
+		return sql.ErrNoRows
+	}
+	refreshTokenQuery := `
+	UPDATE REFRESH_TOKEN
+	SET revoked_at = $2
+	WHERE family_id = $1 AND revoked_at IS NULL
+	`
+	if _, err := tx.Exec(refreshTokenQuery, sessionId, now); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// GetRevokedSessions The time the sessions revoked after since were revoked
+func (s *PostgresStore) GetRevokedSessions(since time.Time) (map[uuid.UUID]time.Time, error) {
+	query := `
+	SELECT id, revoked_at
+	FROM account_session
+	WHERE revoked_at > $1
+	`
+	rows, err := s.db.Query(query, since)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	revokedSessions := map[uuid.UUID]time.Time{}
+	for rows.Next() {
+		var sessionId uuid.UUID
+		var revokedAt time.Time
+		if err := rows.Scan(&sessionId, &revokedAt); err != nil {
+			return nil, err
+		}
+		revokedSessions[sessionId] = revokedAt
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return revokedSessions, nil
+}
+
+func (s *PostgresStore) createSignInEventTable() error {
+	query := `
+	CREATE TABLE IF NOT EXISTS SIGN_IN_EVENT (
+	id UUID PRIMARY KEY,
+	account_id UUID NOT NULL REFERENCES account(id) ON DELETE CASCADE,
+	ip VARCHAR(64) NOT NULL,
+	ip_range VARCHAR(64) NOT NULL,
+	user_agent TEXT NOT NULL,
+	device VARCHAR(100) NOT NULL,
+	outcome VARCHAR(50) NOT NULL,
+	suspicious BOOLEAN NOT NULL DEFAULT FALSE,
+	created_at TIMESTAMP NOT NULL
+	);
+	CREATE INDEX IF NOT EXISTS sign_in_event_account_id_created_at_idx ON SIGN_IN_EVENT (account_id, created_at)`
+	_, err := s.db.Exec(query)
+	return err
+}
+
+func (s *PostgresStore) CreateSignInEvent(event *SignInEvent) error {
+	query := `
+	INSERT INTO SIGN_IN_EVENT (id, account_id, ip, ip_range, user_agent, device, outcome, suspicious, created_at)
+	VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
+	`
+	_, err := s.db.Exec(
+		query,
+		event.ID,
+		event.AccountID,
+		event.IP,
+		event.IPRange,
+		event.UserAgent,
+		event.Device,
+		event.Outcome,
+		event.Suspicious,
+		event.CreatedAt,
+	)
+	return err
+}
+
+// GetSignInEvents The sign in attempts of the account made before before, newest first
+func (s *PostgresStore) GetSignInEvents(accountId uuid.UUID, before time.Time, limit int) ([]SignInEvent, error) {
+	query := `
+	SELECT id, account_id, ip, ip_range, user_agent, device, outcome, suspicious, created_at
+	FROM sign_in_event
+	WHERE account_id = $1 AND created_at < $2
+	ORDER BY created_at DESC
+	LIMIT $3
+	`
+	rows, err := s.db.Query(query, accountId, before, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var events []SignInEvent
+	for rows.Next() {
+		var event SignInEvent
+		if err := rows.Scan(
+			&event.ID,
+			&event.AccountID,
+			&event.IP,
+			&event.IPRange,
+			&event.UserAgent,
+			&event.Device,
+			&event.Outcome,
+			&event.Suspicious,
+			&event.CreatedAt,
+		); err != nil {
+			return nil, err
+		}
+		events = append(events, event)
+	}
+	return events, rows.Err()
+}
+
+// GetKnownSignIns Check if the account signed in successfully before, and from the device and IP range
+func (s *PostgresStore) GetKnownSignIns(accountId uuid.UUID, device string, ipRange string) (*KnownSignIns, error) {
+	query := `
+	SELECT COUNT(*) > 0, COALESCE(BOOL_OR(device = $2), FALSE), COALESCE(BOOL_OR(ip_range = $3), FALSE)
+	FROM sign_in_event
+	WHERE account_id = $1 AND outcome = $4
+	`
+	var known KnownSignIns
+	if err := s.db.QueryRow(query, accountId, device, ipRange, signInSucceeded).Scan(&known.Any, &known.Device, &known.IPRange); err != nil {
+		return nil, err
+	}
+	return &known, nil
+}
+
+func (s *PostgresStore) createPasswordlessChallengeTable() error {
+	query := `
+	CREATE TABLE IF NOT EXISTS PASSWORDLESS_CHALLENGE (
+	id UUID PRIMARY KEY,
+	account_id UUID NOT NULL REFERENCES account(id) ON DELETE CASCADE,
+	token_hash VARCHAR(64) NOT NULL UNIQUE,
+	code_hash VARCHAR(64) NOT NULL,
+	attempts INTEGER NOT NULL DEFAULT 0,
+	expires_at TIMESTAMP NOT NULL,
+	created_at TIMESTAMP NOT NULL,
+	used_at TIMESTAMP
+	);
+	CREATE INDEX IF NOT EXISTS passwordless_challenge_account_id_idx ON PASSWORDLESS_CHALLENGE (account_id, created_at)`
+	_, err := s.db.Exec(query)
+	return err
+}
+
+func (s *PostgresStore) CreatePasswordlessChallenge(challenge *PasswordlessChallenge) error {
+	query := `
+	INSERT INTO PASSWORDLESS_CHALLENGE (id, account_id, token_hash, code_hash, expires_at, created_at)
+	VALUES ($1, $2, $3, $4, $5, $6)
+	`
+	_, err := s.db.Exec(
+		query,
+		challenge.ID,
+		challenge.AccountID,
+		challenge.TokenHash,
+		challenge.CodeHash,
+		challenge.ExpiresAt,
+		challenge.CreatedAt,
+	)
+	return err
+}
+
+// CountPasswordlessChallenges How many challenges were sent to the account since since, to rate limit them
+func (s *PostgresStore) CountPasswordlessChallenges(accountId uuid.UUID, since time.Time) (int, error) {
+	var count int
+	err := s.db.QueryRow(`SELECT COUNT(*) FROM passwordless_challenge WHERE account_id = $1 AND created_at > $2`, accountId, since).Scan(&count)
+	return count, err
+}
+
+// UseMagicLink Use up the challenge of the magic link token and return its account, sql.ErrNoRows is returned
+// when the token is unknown, used or expired. Signing in uses up the other pending challenges of the account too,
+// and verifies its email as the link was received there
+func (s *PostgresStore) UseMagicLink(tokenHash string) (uuid.UUID, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return uuid.Nil, err
+	}
+	defer tx.Rollback()
+
+	now := time.Now().UTC()
+	query := `
+	SELECT account_id
+	FROM passwordless_challenge
+	WHERE token_hash = $1 AND used_at IS NULL AND expires_at > $2
+	FOR UPDATE
+	`
+	var accountId uuid.UUID
+	if err := tx.QueryRow(query, tokenHash, now).Scan(&accountId); err != nil {
+		return uuid.Nil, err
+	}
+	if err := usePasswordlessChallenges(tx, accountId, now); err != nil {
+		return uuid.Nil, err
+	}
+	return accountId, tx.Commit()
+}
+
+// UseSignInCode Use up the latest pending challenge of the account when the code matches it. A wrong code returns
+// ErrWrongSignInCode and counts as an attempt, the challenge is used up after maxAttempts.
+// sql.ErrNoRows is returned when the account has no pending challenge
+func (s *PostgresStore) UseSignInCode(accountId uuid.UUID, code string, maxAttempts int) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	now := time.Now().UTC()
+	query := `
+	SELECT id, code_hash, attempts
+	FROM passwordless_challenge
+	WHERE account_id = $1 AND used_at IS NULL AND expires_at > $2
+	ORDER BY created_at DESC
+	LIMIT 1
+	FOR UPDATE
+	`
+	var challengeId uuid.UUID
+	var codeHash string
+	var attempts int
+	if err := tx.QueryRow(query, accountId, now).Scan(&challengeId, &codeHash, &attempts); err != nil {
+		return err
+	}
+	if !auth.CheckSignInCode(code, codeHash) {
+		attemptQuery := `
+		UPDATE PASSWORDLESS_CHALLENGE
+		SET attempts = attempts + 1, used_at = CASE WHEN attempts + 1 >= $3 THEN $2 END
+		WHERE id = $1
+		`
+		if _, err := tx.Exec(attemptQuery, challengeId, now, maxAttempts); err != nil {
+			return err
+		}
+		if err := tx.Commit(); err != nil {
+			return err
+		}
+		return ErrWrongSignInCode
+	}
+	if err := usePasswordlessChallenges(tx, accountId, now); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// usePasswordlessChallenges Use up every pending challenge of the account once it signed in with one of them,
+// and mark its email verified as the challenge was received there
+func usePasswordlessChallenges(tx *sql.Tx, accountId uuid.UUID, now time.Time) error {
+	markUsedQuery := `
+	UPDATE PASSWORDLESS_CHALLENGE
+	SET used_at = $2
+	WHERE account_id = $1 AND used_at IS NULL
+	`
+	if _, err := tx.Exec(markUsedQuery, accountId, now); err != nil {
+		return err
+	}
+	_, err := tx.Exec(`UPDATE ACCOUNT SET email_verified = TRUE WHERE id = $1`, accountId)
+	return err
+}
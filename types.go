@@ -5,6 +5,7 @@ import (
 	"time"
 
 	"github.com/google/uuid"
+	"github.com/nguyenanhhao221/go-jwt/internal/auth"
 )
 
 type Account struct {
@@ -16,6 +17,9 @@ type Account struct {
 	CreatedAt time.Time `json:"createdAt"`
 	Email     string    `json:"email"`
 	Password  string    `json:"password"`
+	Role      auth.Role `json:"role"`
+	// EmailVerified Set once the account opened the link sent to its email
+	EmailVerified bool `json:"emailVerified"`
 }
 
 func NewAccount(firstName, lastName, email, password string) *Account {
@@ -29,6 +33,7 @@ func NewAccount(firstName, lastName, email, password string) *Account {
 		Number:    int64(rand.Intn(1000000)),
 		Balance:   0,
 		CreatedAt: time.Now().UTC(),
+		Role:      auth.RoleCustomer,
 	}
 }
 
@@ -38,3 +43,294 @@ type CreateAccountRequest struct {
 	Email     string `json:"email" validate:"required,email"`
 	Password  string `json:"password" validate:"required"`
 }
+
+type TransferRequest struct {
+	Number  int64 `json:"toAccount"`
+	Balance int64 `json:"balance"`
+}
+
+// RefreshToken A refresh token persisted in the database. Only the hash of the token is stored.
+// Every refresh token created from the same sign-in shares the same FamilyID,
+// so when an already used token is presented again the whole family can be revoked
+type RefreshToken struct {
+	ID        uuid.UUID
+	AccountID uuid.UUID
+	FamilyID  uuid.UUID
+	TokenHash string
+	// ClientID and Scope are set for refresh tokens issued to an OAuth client, empty when signing in directly
+	ClientID string
+	Scope    string
+	// AuthTime and AMR When and how the account authenticated for the token family, nil for tokens from before it was tracked
+	AuthTime *time.Time
+	AMR      []string
+	// DPoPJKT The thumbprint of the DPoP key the token is bound to, it can then only be used with a proof of that key
+	DPoPJKT   string
+	ExpiresAt time.Time
+	CreatedAt time.Time
+	UsedAt    *time.Time
+	RevokedAt *time.Time
+}
+
+func NewRefreshToken(grant tokenGrant, familyId uuid.UUID, tokenHash string, ttl time.Duration) *RefreshToken {
+	now := time.Now().UTC()
+	return &RefreshToken{
+		ID:        uuid.New(),
+		AccountID: grant.accountId,
+		FamilyID:  familyId,
+		TokenHash: tokenHash,
+		ClientID:  grant.clientId,
+		Scope:     auth.FormatScopes(grant.scopes),
+		AuthTime:  timeOrNil(grant.authTime),
+		AMR:       grant.amr,
+		DPoPJKT:   grant.dpopJKT,
+		ExpiresAt: now.Add(ttl),
+		CreatedAt: now,
+	}
+}
+
+// authTime The time the account authenticated, zero when unknown
+func (t *RefreshToken) authTime() time.Time {
+	if t.AuthTime == nil {
+		return time.Time{}
+	}
+	return *t.AuthTime
+}
+
+func timeOrNil(t time.Time) *time.Time {
+	if t.IsZero() {
+		return nil
+	}
+	utc := t.UTC()
+	return &utc
+}
+
+func unixOrZero(t time.Time) int64 {
+	if t.IsZero() {
+		return 0
+	}
+	return t.Unix()
+}
+
+type TokenResponse struct {
+	Token string `json:"token"`
+	// TokenType Bearer, or DPoP when the token is bound to the key of the DPoP proof sent to get it
+	TokenType string `json:"tokenType"`
+	// RefreshToken Not sent when only a new access token is issued, e.g. after a step-up authentication
+	RefreshToken string `json:"refreshToken,omitempty"`
+	// ExpiresIn Lifetime of the access token in seconds
+	ExpiresIn int64 `json:"expiresIn"`
+	// Scope The scopes the access token is limited to, empty when it isn't limited
+	Scope string `json:"scope,omitempty"`
+}
+
+// RevokedToken An access token revoked before its expiry, identified by its jti claim
+type RevokedToken struct {
+	JTI       string
+	AccountID uuid.UUID
+	ExpiresAt time.Time
+}
+
+// OAuthClient A service registered to call the OAuth endpoints, e.g. the gateway introspecting tokens
+type OAuthClient struct {
+	ID               string
+	Name             string
+	ClientSecretHash string
+	// AllowedScopes The scopes the client can request, with the client credentials grant or from an account
+	AllowedScopes []string
+	// RedirectURIs Where the authorization endpoint can send the authorization code, compared exactly
+	RedirectURIs []string
+	// Public Clients that can't keep a secret, like mobile apps and SPAs. They have no secret,
+	// can only use the authorization code flow, and must use PKCE
+	Public    bool
+	CreatedAt time.Time
+}
+
+// OAuthConsent The scopes an account granted to an OAuth client on the authorization page
+type OAuthConsent struct {
+	AccountID uuid.UUID
+	ClientID  string
+	Scope     string
+	GrantedAt time.Time
+}
+
+// AuthorizationCode A short lived code issued by the authorization endpoint, exchanged once for tokens at the token endpoint.
+// Only the hash of the code is stored, with the PKCE code challenge the exchange must answer
+type AuthorizationCode struct {
+	CodeHash      string
+	ClientID      string
+	AccountID     uuid.UUID
+	RedirectURI   string
+	Scope         string
+	CodeChallenge string
+	// Nonce The OpenID Connect nonce of the authorization request, put in the ID token
+	Nonce string
+	// AMR How the account authenticated on the authorization page, it did when the code was created
+	AMR       []string
+	ExpiresAt time.Time
+	CreatedAt time.Time
+	UsedAt    *time.Time
+}
+
+// AccountTOTP The TOTP two-factor authentication of an account, enabled once a first code is verified
+type AccountTOTP struct {
+	AccountID uuid.UUID
+	Secret    string
+	CreatedAt time.Time
+	EnabledAt *time.Time
+	// LastUsedStep The time step of the last accepted code, codes of this step or before are refused
+	LastUsedStep int64
+}
+
+// Enabled Report if the account must enter a code to sign in
+func (t *AccountTOTP) Enabled() bool {
+	return t != nil && t.EnabledAt != nil
+}
+
+// PasswordResetToken A single use token sent by email to reset a forgotten password, only its hash is stored
+type PasswordResetToken struct {
+	TokenHash string
+	AccountID uuid.UUID
+	ExpiresAt time.Time
+	CreatedAt time.Time
+	UsedAt    *time.Time
+}
+
+func NewPasswordResetToken(accountId uuid.UUID, tokenHash string, ttl time.Duration) *PasswordResetToken {
+	now := time.Now().UTC()
+	return &PasswordResetToken{
+		TokenHash: tokenHash,
+		AccountID: accountId,
+		ExpiresAt: now.Add(ttl),
+		CreatedAt: now,
+	}
+}
+
+// EmailVerificationToken A single use token sent to the email of an account to verify it, only its hash is stored
+type EmailVerificationToken struct {
+	TokenHash string
+	AccountID uuid.UUID
+	ExpiresAt time.Time
+	CreatedAt time.Time
+	UsedAt    *time.Time
+}
+
+func NewEmailVerificationToken(accountId uuid.UUID, tokenHash string, ttl time.Duration) *EmailVerificationToken {
+	now := time.Now().UTC()
+	return &EmailVerificationToken{
+		TokenHash: tokenHash,
+		AccountID: accountId,
+		ExpiresAt: now.Add(ttl),
+		CreatedAt: now,
+	}
+}
+
+// APIKey A long lived key an account owner creates for its scripts and integrations, limited to some scopes.
+// Only the hash of the key is stored, the prefix identifies it
+type APIKey struct {
+	ID         uuid.UUID
+	AccountID  uuid.UUID
+	Name       string
+	Prefix     string
+	KeyHash    string
+	Scope      string
+	CreatedAt  time.Time
+	ExpiresAt  *time.Time
+	LastUsedAt *time.Time
+	RevokedAt  *time.Time
+}
+
+// Active Report if the key can still be used
+func (k *APIKey) Active(now time.Time) bool {
+	return k.RevokedAt == nil && (k.ExpiresAt == nil || now.Before(*k.ExpiresAt))
+}
+
+// Session A sign in of an account from a device, it lasts as long as the refresh tokens of the sign in are refreshed.
+// Its ID is the family of the refresh tokens and the sid claim of the access tokens, so revoking it revokes both
+type Session struct {
+	ID          uuid.UUID
+	AccountID   uuid.UUID
+	DeviceLabel string
+	UserAgent   string
+	IP          string
+	// JTI The jti of the last access token issued to the session
+	JTI        string
+	CreatedAt  time.Time
+	LastSeenAt time.Time
+	RevokedAt  *time.Time
+}
+
+func NewSession(accountId uuid.UUID, deviceLabel, userAgent, ip string) *Session {
+	now := time.Now().UTC()
+	return &Session{
+		ID:          uuid.New(),
+		AccountID:   accountId,
+		DeviceLabel: deviceLabel,
+		UserAgent:   userAgent,
+		IP:          ip,
+		CreatedAt:   now,
+		LastSeenAt:  now,
+	}
+}
+
+// SignInEvent An attempt to sign in to an account, kept as the sign in history of the account
+type SignInEvent struct {
+	ID        uuid.UUID
+	AccountID uuid.UUID
+	IP        string
+	// IPRange The network of the IP, see ipRange
+	IPRange   string
+	UserAgent string
+	// Device The device label derived from the User-Agent, see deviceLabel
+	Device  string
+	Outcome string
+	// Suspicious Set on successful sign ins from a device or IP range the account never signed in from before
+	Suspicious bool
+	CreatedAt  time.Time
+}
+
+func NewSignInEvent(accountId uuid.UUID, ip, userAgent, outcome string) *SignInEvent {
+	return &SignInEvent{
+		ID:        uuid.New(),
+		AccountID: accountId,
+		IP:        ip,
+		IPRange:   ipRange(ip),
+		UserAgent: userAgent,
+		Device:    deviceLabel(userAgent),
+		Outcome:   outcome,
+		CreatedAt: time.Now().UTC(),
+	}
+}
+
+// KnownSignIns What the previous successful sign ins of an account have in common with a new one
+type KnownSignIns struct {
+	// Any The account signed in before
+	Any     bool
+	Device  bool
+	IPRange bool
+}
+
+// PasswordlessChallenge A magic link and a code sent together by email to sign in without a password,
+// either of them can be used once. Only their hashes are stored
+type PasswordlessChallenge struct {
+	ID        uuid.UUID
+	AccountID uuid.UUID
+	TokenHash string
+	CodeHash  string
+	// Attempts Wrong codes entered for the challenge, it is used up after too many
+	Attempts  int
+	ExpiresAt time.Time
+	CreatedAt time.Time
+	UsedAt    *time.Time
+}
+
+func NewPasswordlessChallenge(accountId uuid.UUID, tokenHash, codeHash string, ttl time.Duration) *PasswordlessChallenge {
+	now := time.Now().UTC()
+	return &PasswordlessChallenge{
+		ID:        uuid.New(),
+		AccountID: accountId,
+		TokenHash: tokenHash,
+		CodeHash:  codeHash,
+		ExpiresAt: now.Add(ttl),
+		CreatedAt: now,
+	}
+}